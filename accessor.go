@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Accessors are the expressions a config key is read with. A missing key
+// gives the default with all three, but a key set to null is passed on as
+// null by lookup() and try(), where coalesce() gives the default. try() also
+// survives the config it reads from being null.
+const (
+	accessorLookup   = "lookup"
+	accessorTry      = "try"
+	accessorCoalesce = "coalesce"
+)
+
+// validateAccessor checks the -accessor option.
+func validateAccessor(accessor string) error {
+	switch accessor {
+	case "", "auto", accessorLookup, accessorTry, accessorCoalesce:
+		return nil
+	}
+	return fmt.Errorf("invalid -accessor %q: must be auto, lookup, try or coalesce", accessor)
+}
+
+// accessorOf returns the accessor main.tf reads v with.
+func accessorOf(opts generateOptions, v moduleVariable) string {
+	if opts.Accessor == "" || opts.Accessor == "auto" {
+		return accessorFor(v)
+	}
+	return opts.Accessor
+}
+
+// fixedAccessor returns the accessor for the keys the wrapper itself
+// defines, like "instances" and "create", whose types are always the same.
+func fixedAccessor(opts generateOptions) string {
+	if opts.Accessor == "" || opts.Accessor == "auto" {
+		return accessorLookup
+	}
+	return opts.Accessor
+}
+
+// typeKind returns the outermost type constructor of a type constraint,
+// e.g. "map" for map(string), or "" for primitive types and any.
+func typeKind(typ string) string {
+	kind, _, ok := strings.Cut(typ, "(")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(kind)
+}
+
+// elementType returns the element type of a collection type constraint,
+// e.g. "string" for map(string).
+func elementType(typ string) string {
+	start, end := strings.Index(typ, "("), strings.LastIndex(typ, ")")
+	if start < 0 || end < start {
+		return ""
+	}
+	return strings.TrimSpace(typ[start+1 : end])
+}
+
+// accessorFor picks the accessor for v from its upstream type, so a config
+// that omits or nulls an optional section doesn't fail the plan:
+//   - collections (map, list, set) of primitives use coalesce(), since an
+//     explicit null would otherwise reach upstream code that iterates over
+//     them
+//   - structural types (object, tuple), and collections of them, use try();
+//     coalesce() would unify the config's value with the default, failing
+//     on objects with optional attributes
+//   - primitives, untyped variables and null defaults use lookup()
+func accessorFor(v moduleVariable) string {
+	if v.Default == "null" {
+		return accessorLookup
+	}
+	switch typeKind(v.Type) {
+	case "map", "list", "set":
+		switch elementType(v.Type) {
+		case "string", "number", "bool":
+			return accessorCoalesce
+		}
+		return accessorTry
+	case "object", "tuple":
+		return accessorTry
+	}
+	return accessorLookup
+}
+
+// accessExpr returns the expression reading key from configSource, falling
+// back to def, with the given accessor.
+func accessExpr(accessor, configSource, key, def string) string {
+	switch accessor {
+	case accessorTry:
+		if hclKey(key) == key {
+			return fmt.Sprintf("try(%s.%s, %s)", configSource, key, def)
+		}
+		return fmt.Sprintf("try(%s[%s], %s)", configSource, hclString(key), def)
+	case accessorCoalesce:
+		if def != "null" {
+			return fmt.Sprintf("coalesce(lookup(%s, %s, null), %s)", configSource, hclString(key), def)
+		}
+	}
+	return fmt.Sprintf("lookup(%s, %s, %s)", configSource, hclString(key), def)
+}
+
+// lookupExpr returns the expression passing v from configSource to the
+// module, reading the former names of a renamed variable too, falling back
+// to the derived value with -derived. With -default-tags, the tags are
+// merged over provider_default_tags from the top of the config and, for
+// iterable wrappers, the instance.
+func lookupExpr(opts generateOptions, configSource string, v moduleVariable) string {
+	accessor, def := accessorOf(opts, v), v.Default
+	if opts.derived != nil && opts.derived.Expressions[v.Name] != "" {
+		def = derivedRef(configSource, v.Name)
+	}
+	// A renamed variable falls back to its former names, most recent
+	// first. coalesce() fails when none is set, unless there's a default
+	olds := renamedFrom(opts, v.Name)
+	if len(olds) > 0 && accessor == accessorCoalesce && def == "null" {
+		accessor = accessorLookup
+	}
+	for i := len(olds) - 1; i >= 0; i-- {
+		def = accessExpr(accessor, configSource, olds[i], def)
+	}
+	expr := accessExpr(accessor, configSource, v.Name, def)
+	if !opts.DefaultTags || v.Name != tagsVariable {
+		return expr
+	}
+	args := []string{fmt.Sprintf("lookup(local.config, %s, {})", hclString(defaultTagsKey))}
+	if configSource != "local.config" {
+		args = append(args, fmt.Sprintf("lookup(%s, %s, {})", configSource, hclString(defaultTagsKey)))
+	}
+	return fmt.Sprintf("merge(%s, %s)", strings.Join(args, ", "), expr)
+}
+
+// rawAccessExpr returns the expression passing the excluded variable v from
+// the raw section of configSource.
+func rawAccessExpr(opts generateOptions, configSource string, v moduleVariable) string {
+	accessor := accessorOf(opts, v)
+	if accessor == accessorTry {
+		return accessExpr(accessor, configSource+"."+rawSection, v.Name, v.Default)
+	}
+	return accessExpr(accessor, accessExpr(fixedAccessor(opts), configSource, rawSection, "{}"), v.Name, v.Default)
+}
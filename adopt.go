@@ -0,0 +1,254 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// moduleMetaArguments are module block arguments that belong to the module
+// call itself rather than to the module's input variables.
+var moduleMetaArguments = map[string]bool{
+	"source":     true,
+	"version":    true,
+	"count":      true,
+	"for_each":   true,
+	"providers":  true,
+	"depends_on": true,
+}
+
+func runAdopt(args []string) {
+	fs := flag.NewFlagSet("adopt", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory of existing Terraform code to rewrite")
+	source := fs.String("source", "", "Upstream module source whose calls should be rewritten (required)")
+	version := fs.String("version", "", "Only rewrite calls pinned to this version (optional)")
+	wrapper := fs.String("wrapper", "", "Path to the generated wrapper directory (required)")
+	excludeDirs := fs.String("exclude-dirs", strings.Join(defaultExcludeDirs, ","), "Comma separated directories to skip, names or paths relative to -dir, besides hidden ones and the .terraformignore patterns")
+	fs.Parse(args)
+
+	if *source == "" || *wrapper == "" {
+		log.Fatal("Error: -source and -wrapper are required")
+	}
+
+	iterable, err := wrapperIsIterable(*wrapper)
+	if err != nil {
+		fatalError("Failed to read wrapper", err)
+	}
+
+	exclude, err := excludePatterns(*dir, *excludeDirs)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	rewritten := 0
+	err = walkTerraformFiles(*dir, exclude, func(path string) error {
+		n, err := adoptFile(path, *source, *version, *wrapper, iterable)
+		rewritten += n
+		return err
+	})
+	if err != nil {
+		fatalError("Failed to adopt module calls", err)
+	}
+
+	fmt.Printf("Rewrote %d module call(s) to use %s\n", rewritten, *wrapper)
+}
+
+// adoptFile rewrites every call to source in path so that it calls the
+// wrapper instead, and appends the matching moved blocks to moved.tf in the
+// same directory. It returns the number of calls rewritten.
+func adoptFile(path, source, version, wrapper string, iterable bool) (int, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	// hclsyntax gives us attribute order and static values; hclwrite lets us
+	// rewrite the file while keeping everything else intact.
+	synFile, diags := hclsyntax.ParseConfig(src, path, hcl.InitialPos)
+	if diags.HasErrors() {
+		return 0, newParseError(diags, map[string]*hcl.File{path: synFile})
+	}
+	wrFile, diags := hclwrite.ParseConfig(src, path, hcl.InitialPos)
+	if diags.HasErrors() {
+		return 0, newParseError(diags, map[string]*hcl.File{path: synFile})
+	}
+
+	wrapperSource, err := relativeModuleSource(filepath.Dir(path), wrapper)
+	if err != nil {
+		return 0, err
+	}
+
+	rewritten := 0
+	var moved []string
+	for _, synBlock := range synFile.Body.(*hclsyntax.Body).Blocks {
+		if synBlock.Type != "module" || len(synBlock.Labels) != 1 {
+			continue
+		}
+		if syntaxAttrString(synBlock.Body, "source") != source {
+			continue
+		}
+		if version != "" && syntaxAttrString(synBlock.Body, "version") != version {
+			continue
+		}
+		rewritten++
+
+		name := synBlock.Labels[0]
+		body := wrFile.Body().FirstMatchingBlock("module", synBlock.Labels).Body()
+
+		// Capture the existing arguments before emptying the block
+		argNames := orderedAttributeNames(synBlock.Body)
+		argTokens := make(map[string]hclwrite.Tokens)
+		for _, argName := range argNames {
+			argTokens[argName] = body.GetAttribute(argName).Expr().BuildTokens(nil)
+		}
+
+		var configAttrs []hclwrite.ObjectAttrTokens
+		for _, argName := range argNames {
+			if moduleMetaArguments[argName] {
+				continue
+			}
+			configAttrs = append(configAttrs, hclwrite.ObjectAttrTokens{
+				Name:  hclwrite.TokensForIdentifier(argName),
+				Value: argTokens[argName],
+			})
+		}
+		config := hclwrite.TokensForObject(configAttrs)
+		if iterable {
+			config = hclwrite.TokensForObject([]hclwrite.ObjectAttrTokens{{
+				Name: hclwrite.TokensForIdentifier("instances"),
+				Value: hclwrite.TokensForObject([]hclwrite.ObjectAttrTokens{{
+					Name:  hclwrite.TokensForIdentifier(name),
+					Value: config,
+				}}),
+			}})
+		}
+
+		for _, argName := range argNames {
+			body.RemoveAttribute(argName)
+		}
+		body.SetAttributeValue("source", cty.StringVal(wrapperSource))
+		for _, argName := range argNames {
+			if moduleMetaArguments[argName] && argName != "source" && argName != "version" {
+				body.SetAttributeRaw(argName, argTokens[argName])
+			}
+		}
+		body.AppendNewline()
+		body.SetAttributeRaw("config", hclwrite.TokensForFunctionCall("jsonencode", config))
+
+		_, counted := synBlock.Body.Attributes["count"]
+		_, forEach := synBlock.Body.Attributes["for_each"]
+		if counted || forEach {
+			fmt.Fprintf(os.Stderr, "Warning: %s: module.%s uses count/for_each; add moved blocks for its instances by hand\n", path, name)
+		} else {
+			to := fmt.Sprintf("module.%s.module.this", name)
+			if iterable {
+				to = fmt.Sprintf("module.%s.module.this[%q]", name, name)
+			}
+			moved = append(moved, fmt.Sprintf("moved {\n  from = module.%s\n  to   = %s\n}\n", name, to))
+		}
+	}
+
+	if rewritten == 0 {
+		return 0, nil
+	}
+	if err := checkWrite(path); err != nil {
+		return 0, err
+	}
+	if err := os.WriteFile(path, hclwrite.Format(wrFile.Bytes()), 0644); err != nil {
+		return 0, err
+	}
+	if len(moved) > 0 {
+		if err := appendMovedBlocks(filepath.Join(filepath.Dir(path), "moved.tf"), moved); err != nil {
+			return 0, err
+		}
+	}
+	return rewritten, nil
+}
+
+// orderedAttributeNames returns the attribute names of body in source order.
+func orderedAttributeNames(body *hclsyntax.Body) []string {
+	names := make([]string, 0, len(body.Attributes))
+	for name := range body.Attributes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return body.Attributes[names[i]].SrcRange.Start.Byte < body.Attributes[names[j]].SrcRange.Start.Byte
+	})
+	return names
+}
+
+// syntaxAttrString returns the literal string value of the named attribute in
+// body, or "" if it is missing or not a literal.
+func syntaxAttrString(body *hclsyntax.Body, name string) string {
+	attr, ok := body.Attributes[name]
+	if !ok {
+		return ""
+	}
+	return staticString(attr.AsHCLAttribute())
+}
+
+func appendMovedBlocks(path string, blocks []string) error {
+	if err := checkWrite(path); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, block := range blocks {
+		if _, err := fmt.Fprintf(f, "\n%s", block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// relativeModuleSource returns a local module source for wrapper as seen from
+// fromDir, always starting with ./ or ../ as Terraform requires.
+func relativeModuleSource(fromDir, wrapper string) (string, error) {
+	absFrom, err := filepath.Abs(fromDir)
+	if err != nil {
+		return "", err
+	}
+	absWrapper, err := filepath.Abs(wrapper)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absFrom, absWrapper)
+	if err != nil {
+		return "", err
+	}
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, "../") {
+		rel = "./" + rel
+	}
+	return rel, nil
+}
+
+// wrapperIsIterable reports whether the generated wrapper in dir uses
+// for_each over config instances.
+func wrapperIsIterable(dir string) (bool, error) {
+	parser := hclparse.NewParser()
+	file, diags := parser.ParseHCLFile(filepath.Join(dir, "main.tf"))
+	if diags.HasErrors() {
+		return false, newParseError(diags, parser.Files())
+	}
+	body := file.Body.(*hclsyntax.Body)
+	for _, block := range body.Blocks {
+		if block.Type == "module" && len(block.Labels) == 1 && block.Labels[0] == "this" {
+			_, ok := block.Body.Attributes["for_each"]
+			return ok, nil
+		}
+	}
+	return false, fmt.Errorf("module \"this\" not found in %s", dir)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+func runAdoptWrapper(args []string) {
+	fs := flag.NewFlagSet("adopt-wrapper", flag.ExitOnError)
+	source := fs.String("source", "", "Upstream module source the wrapper calls (default: the source of its only module block)")
+	version := fs.String("version", "", "Upstream module version the wrapper calls (default: the version of that module block)")
+	force := fs.Bool("force", false, "Replace an existing "+metadataFileName)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tfwrapper adopt-wrapper [flags] <wrapper-dir>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dir := filepath.Clean(fs.Arg(0))
+	if prev, err := readMetadata(dir); err != nil {
+		fatalError("Failed to read wrapper metadata", err)
+	} else if prev != nil && !*force {
+		log.Fatalf("Error: %s already has a %s, use -force to replace it", dir, metadataFileName)
+	}
+
+	call, err := findWrappedCall(dir, *source)
+	if err != nil {
+		fatalError("Failed to analyze wrapper", err)
+	}
+	if *source == "" {
+		*source = call.source
+	}
+	if *version == "" {
+		*version = call.version
+	}
+
+	sb, err := sandboxFor(dir)
+	if err != nil {
+		fatalError("Failed to load workspace", err)
+	}
+	tmpDir, err := os.MkdirTemp("", "tfwrapper-")
+	if err != nil {
+		log.Fatalf("Failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	repoDir, modulePath, err := downloadModule(sb, nil, *source, *version, tmpDir)
+	if err != nil {
+		fatalError("Failed to download module", err)
+	}
+	varFiles, err := variableFiles(os.DirFS(modulePath), "")
+	if err != nil {
+		fatalError("Failed to find upstream variables", err)
+	}
+	vars, err := parseVariables(os.DirFS(modulePath), varFiles...)
+	if err != nil {
+		fatalError("Failed to parse upstream variables", err)
+	}
+
+	// The defaults of the generate flags
+	opts := generateOptions{
+		Source:        *source,
+		Version:       *version,
+		Name:          filepath.Base(dir),
+		Iterable:      call.iterable,
+		Conditional:   call.conditional,
+		ExampleConfig: "config.example.json",
+		Sort:          "upstream",
+		Comments:      "all",
+		Accessor:      "auto",
+		ConfigFormat:  "json",
+		Format:        "module",
+	}
+	if wrapperName(opts) == opts.Name {
+		opts.Name = ""
+	}
+
+	// Reconcile the arguments the wrapper passes with the upstream
+	// variables. Optional variables it doesn't pass stay out of the wrapper
+	// on regeneration; required ones can't be left out
+	var missingRequired []string
+	upstream := map[string]bool{}
+	for _, v := range vars {
+		upstream[v.Name] = true
+		switch {
+		case slices.Contains(call.arguments, v.Name):
+		case v.DefaultKind == "required":
+			missingRequired = append(missingRequired, v.Name)
+		default:
+			opts.Exclude = append(opts.Exclude, v.Name)
+		}
+	}
+	var unknown []string
+	for _, arg := range call.arguments {
+		if !upstream[arg] {
+			unknown = append(unknown, arg)
+		}
+	}
+
+	providers, outputs, err := checkModuleSupport(repoDir, modulePath, opts.Iterable || opts.Conditional, true)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	prov := newProvenance(opts, gitHeadCommit(sb, modulePath), "")
+	prov.Providers = providers
+	prov.Outputs = outputNames(outputs)
+	prov.OutputDescriptions = outputDescriptions(outputs)
+	prov.Variables = variableContract(vars)
+	prov.WrapperVersion = initialWrapperVersion
+	metadata, err := prov.metadata()
+	if err != nil {
+		log.Fatalf("Failed to encode metadata: %v", err)
+	}
+	if err := checkWrite(dir); err != nil {
+		log.Fatalf("Failed to write %s: %v", metadataFileName, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, metadataFileName), []byte(metadata), 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", metadataFileName, err)
+	}
+
+	ws, err := findWorkspace(dir)
+	if err != nil {
+		fatalError("Failed to load workspace", err)
+	}
+	if ws != nil {
+		entry := lockEntry{Source: opts.Source, Version: opts.Version, Commit: prov.Commit, Iterable: opts.Iterable}
+		if err := ws.recordWrapper(dir, entry, &prov); err != nil {
+			log.Fatalf("Failed to update lock file: %v", err)
+		}
+	}
+
+	fmt.Printf("Adopted %s as a wrapper of %s %s\n", dir, opts.Source, displayVersion(opts.Version))
+	fmt.Printf("  Passes %d of %d upstream variables\n", len(call.arguments)-len(unknown), len(vars))
+	if len(opts.Exclude) > 0 {
+		fmt.Printf("  Not exposed, recorded as -exclude: %s\n", strings.Join(opts.Exclude, ", "))
+	}
+	for _, name := range missingRequired {
+		fmt.Fprintf(os.Stderr, "Warning: required upstream variable %q is not passed by the wrapper, regenerating will expose it\n", name)
+	}
+	for _, name := range unknown {
+		fmt.Fprintf(os.Stderr, "Warning: the wrapper passes %q, which upstream doesn't declare\n", name)
+	}
+	fmt.Printf("Equivalent generate command: %s\n", prov.CommandLine)
+}
+
+// wrappedCall is the module block of a hand-written wrapper calling the
+// upstream module.
+type wrappedCall struct {
+	source, version string
+	// arguments are the upstream variables the call sets
+	arguments             []string
+	iterable, conditional bool
+}
+
+// findWrappedCall finds the module block calling source in the .tf files of
+// dir, or the only module block when source is "".
+func findWrappedCall(dir, source string) (*wrappedCall, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	var calls []*wrappedCall
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		file, diags := hclsyntax.ParseConfig(src, path, hcl.InitialPos)
+		if diags.HasErrors() {
+			return nil, newParseError(diags, map[string]*hcl.File{path: file})
+		}
+		for _, block := range file.Body.(*hclsyntax.Body).Blocks {
+			if block.Type != "module" || len(block.Labels) != 1 {
+				continue
+			}
+			call := &wrappedCall{
+				source:  syntaxAttrString(block.Body, "source"),
+				version: syntaxAttrString(block.Body, "version"),
+			}
+			if source != "" && call.source != source {
+				continue
+			}
+			_, call.iterable = block.Body.Attributes["for_each"]
+			_, call.conditional = block.Body.Attributes["count"]
+			for name := range block.Body.Attributes {
+				if !moduleMetaArguments[name] {
+					call.arguments = append(call.arguments, name)
+				}
+			}
+			sort.Strings(call.arguments)
+			calls = append(calls, call)
+		}
+	}
+
+	switch {
+	case len(calls) == 1:
+		return calls[0], nil
+	case len(calls) == 0 && source != "":
+		return nil, fmt.Errorf("no module block in %s calls %s", dir, source)
+	case len(calls) == 0:
+		return nil, fmt.Errorf("no module block found in %s", dir)
+	}
+	return nil, fmt.Errorf("%s has %d module blocks, use -source to pick the upstream one", dir, len(calls))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// archiveModTime is used for every archive entry so that archives of the same
+// wrapper are byte-for-byte reproducible.
+var archiveModTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// writeArchive writes the files in m to an archive at path. The format is
+// chosen from the extension: .tar.gz/.tgz, .tar or .zip.
+func writeArchive(path string, m *memSink) error {
+	if err := checkWrite(path); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	switch {
+	case strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz"):
+		gz := gzip.NewWriter(f)
+		gz.ModTime = archiveModTime
+		if err := writeTar(gz, m); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	case strings.HasSuffix(path, ".tar"):
+		if err := writeTar(f, m); err != nil {
+			return err
+		}
+	case strings.HasSuffix(path, ".zip"):
+		if err := writeZip(f, m); err != nil {
+			return err
+		}
+	default:
+		os.Remove(path)
+		return fmt.Errorf("unsupported archive format %q (expected .tar.gz, .tgz, .tar or .zip)", path)
+	}
+	return f.Close()
+}
+
+func writeTar(w io.Writer, m *memSink) error {
+	tw := tar.NewWriter(w)
+	for _, path := range m.paths() {
+		file := m.files[path]
+		hdr := &tar.Header{
+			Name:    path,
+			Mode:    int64(file.Mode.Perm()),
+			Size:    int64(len(file.Data)),
+			ModTime: archiveModTime,
+			Format:  tar.FormatPAX,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(file.Data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func writeZip(w io.Writer, m *memSink) error {
+	zw := zip.NewWriter(w)
+	for _, path := range m.paths() {
+		file := m.files[path]
+		hdr := &zip.FileHeader{
+			Name:     path,
+			Method:   zip.Deflate,
+			Modified: archiveModTime,
+		}
+		hdr.SetMode(file.Mode.Perm())
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(file.Data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
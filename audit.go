@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+)
+
+// auditLogEnv names the audit log, overriding the workspace audit_log
+// setting. "-" writes the records to stdout.
+const auditLogEnv = "TFWRAPPER_AUDIT_LOG"
+
+// auditEvent is one line of the audit log, recording who wrote or published
+// which wrapper from what, and the digests of the files involved.
+type auditEvent struct {
+	Time        string `json:"time"`
+	Operation   string `json:"operation"`
+	User        string `json:"user"`
+	Host        string `json:"host"`
+	ToolVersion string `json:"tool_version"`
+	Wrapper     string `json:"wrapper"`
+
+	Source         string `json:"source"`
+	Version        string `json:"version,omitempty"`
+	Commit         string `json:"commit,omitempty"`
+	WrapperVersion string `json:"wrapper_version,omitempty"`
+	CommandLine    string `json:"command_line,omitempty"`
+
+	// Target and Tag are where a publish operation released the wrapper
+	Target string `json:"target,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+
+	// Files maps the files written, relative to the wrapper, to the
+	// sha256 digests of their contents
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// newAuditEvent fills in who and when for an operation on the wrapper in dir,
+// described by its provenance.
+func newAuditEvent(operation, dir string, prov *provenance) auditEvent {
+	event := auditEvent{
+		Time:        time.Now().UTC().Format(time.RFC3339),
+		Operation:   operation,
+		ToolVersion: toolVersion,
+		Wrapper:     dir,
+	}
+	if u, err := user.Current(); err == nil {
+		event.User = u.Username
+	} else {
+		event.User = os.Getenv("USER")
+	}
+	event.Host, _ = os.Hostname()
+	if abs, err := filepath.Abs(dir); err == nil {
+		event.Wrapper = abs
+	}
+	if prov != nil {
+		event.Source = prov.Source
+		event.Version = prov.Version
+		event.Commit = prov.Commit
+		event.WrapperVersion = prov.WrapperVersion
+		event.CommandLine = prov.CommandLine
+	}
+	return event
+}
+
+// auditLogPath returns where audit records go: $TFWRAPPER_AUDIT_LOG, else the
+// audit_log of the workspace, relative to its root. It returns "" when
+// nothing is audited.
+func auditLogPath(ws *workspace) string {
+	if path := os.Getenv(auditLogEnv); path != "" {
+		return path
+	}
+	if ws == nil || ws.Config.Workspace.AuditLog == "" {
+		return ""
+	}
+	if filepath.IsAbs(ws.Config.Workspace.AuditLog) {
+		return ws.Config.Workspace.AuditLog
+	}
+	return filepath.Join(ws.Root, ws.Config.Workspace.AuditLog)
+}
+
+// writeAudit appends event to the audit log as a line of JSON. The log is
+// only ever appended to, under an advisory lock of its own (<log>.flock,
+// waiting up to the workspace lock_timeout) so parallel runs don't
+// interleave records.
+func writeAudit(ws *workspace, event auditEvent) error {
+	path := auditLogPath(ws)
+	if path == "" {
+		return nil
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	timeout := defaultLockTimeout
+	if ws != nil {
+		timeout = ws.LockTimeout
+	}
+	fl, err := acquireFileLock(path, timeout)
+	if err != nil {
+		return err
+	}
+	defer fl.release()
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// digest returns the hex sha256 digest of data.
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// digestDir returns the digests of the files in dir, keyed by slash separated
+// path, skipping what copyDir skips.
+func digestDir(dir string) (map[string]string, error) {
+	digests := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" || d.Name() == ".terraform" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		digests[filepath.ToSlash(rel)] = digest(data)
+		return nil
+	})
+	return digests, err
+}
+
+// auditPublish records the release of the wrapper in dir to target.
+func auditPublish(dir, target, tag string) error {
+	ws, err := findWorkspace(dir)
+	if err != nil {
+		return err
+	}
+	if auditLogPath(ws) == "" {
+		return nil
+	}
+	prov, err := readMetadata(dir)
+	if err != nil {
+		return err
+	}
+	event := newAuditEvent("publish", dir, prov)
+	event.Target, event.Tag = target, tag
+	if event.Files, err = digestDir(dir); err != nil {
+		return err
+	}
+	return writeAudit(ws, event)
+}
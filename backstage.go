@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Backstage entities written with -backstage. The scaffolder template and
+// its skeleton live under backstage/, registered through a Location in the
+// wrapper's catalog-info.yaml.
+const (
+	backstageCatalogFile  = "catalog-info.yaml"
+	backstageTemplateFile = "backstage/template.yaml"
+	backstageSkeletonFile = "backstage/skeleton/${{ values.name }}.json"
+)
+
+// generateBackstageFiles returns the catalog entities of the wrapper and a
+// scaffolder template whose form is generated from the config contract. The
+// template writes the filled-in config to a stacks repository and opens a
+// pull request.
+func generateBackstageFiles(opts generateOptions, prov *provenance, name string) ([]generatedFile, error) {
+	description := fmt.Sprintf("Terraform wrapper of %s", opts.Source)
+	if opts.Version != "" {
+		description += " " + opts.Version
+	}
+	annotations := map[string]any{"tfwrapper/source": opts.Source}
+	if opts.Version != "" {
+		annotations["tfwrapper/version"] = opts.Version
+	}
+	if prov.WrapperVersion != "" {
+		annotations["tfwrapper/wrapper-version"] = prov.WrapperVersion
+	}
+	component := map[string]any{
+		"apiVersion": "backstage.io/v1alpha1",
+		"kind":       "Component",
+		"metadata": map[string]any{
+			"name":        name,
+			"description": description,
+			"annotations": annotations,
+			"tags":        []string{"terraform", "tfwrapper"},
+		},
+		"spec": map[string]any{
+			"type":      "terraform-module",
+			"lifecycle": "production",
+			"owner":     opts.Backstage,
+		},
+	}
+	location := map[string]any{
+		"apiVersion": "backstage.io/v1alpha1",
+		"kind":       "Location",
+		"metadata":   map[string]any{"name": name + "-template"},
+		"spec":       map[string]any{"targets": []string{"./" + backstageTemplateFile}},
+	}
+
+	config := jsonSchemaNode(configSchema(prov))
+	config["title"] = "Config"
+	template := map[string]any{
+		"apiVersion": "scaffolder.backstage.io/v1beta3",
+		"kind":       "Template",
+		"metadata": map[string]any{
+			"name":        name,
+			"title":       "New " + name,
+			"description": "Add a config for the " + name + " wrapper to a stacks repository",
+			"tags":        []string{"terraform", "tfwrapper"},
+		},
+		"spec": map[string]any{
+			"owner": opts.Backstage,
+			"type":  "terraform-config",
+			"parameters": []any{
+				map[string]any{
+					"title":    "Config",
+					"required": []string{"name", "config"},
+					"properties": map[string]any{
+						"name": map[string]any{
+							"title":       "Name",
+							"description": "Name of the config file, without .json",
+							"type":        "string",
+							"pattern":     "^[a-z0-9][a-z0-9_-]*$",
+						},
+						"config": config,
+					},
+				},
+				map[string]any{
+					"title":    "Destination",
+					"required": []string{"repoUrl", "configDir"},
+					"properties": map[string]any{
+						"repoUrl": map[string]any{
+							"title":      "Repository",
+							"type":       "string",
+							"ui:field":   "RepoUrlPicker",
+							"ui:options": map[string]any{"allowedHosts": []string{"github.com"}},
+						},
+						"configDir": map[string]any{
+							"title":   "Config directory",
+							"type":    "string",
+							"default": "configs/" + name,
+						},
+					},
+				},
+			},
+			"steps": []any{
+				map[string]any{
+					"id":     "config",
+					"name":   "Write config",
+					"action": "fetch:template",
+					"input": map[string]any{
+						"url":        "./skeleton",
+						"targetPath": "${{ parameters.configDir }}",
+						"values": map[string]any{
+							"name":   "${{ parameters.name }}",
+							"config": "${{ parameters.config }}",
+						},
+					},
+				},
+				map[string]any{
+					"id":     "pull-request",
+					"name":   "Open pull request",
+					"action": "publish:github:pull-request",
+					"input": map[string]any{
+						"repoUrl":     "${{ parameters.repoUrl }}",
+						"branchName":  name + "-${{ parameters.name }}",
+						"title":       "Add " + name + " config ${{ parameters.name }}",
+						"description": "Config for the " + name + " wrapper, created from Backstage.",
+					},
+				},
+			},
+			"output": map[string]any{
+				"links": []any{map[string]any{
+					"title": "Pull request",
+					"url":   "${{ steps['pull-request'].output.remoteUrl }}",
+				}},
+			},
+		},
+	}
+
+	var catalog []string
+	for _, entity := range []map[string]any{component, location} {
+		doc, err := manifestYAML(entity)
+		if err != nil {
+			return nil, err
+		}
+		catalog = append(catalog, doc)
+	}
+	templateDoc, err := manifestYAML(template)
+	if err != nil {
+		return nil, err
+	}
+	return []generatedFile{
+		{backstageCatalogFile, strings.Join(catalog, "---\n")},
+		{backstageTemplateFile, templateDoc},
+		{backstageSkeletonFile, "${{ values.config | dump }}\n"},
+	}, nil
+}
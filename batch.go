@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// batchResult is printed for every spec processed by the batch command.
+type batchResult struct {
+	Source  string `json:"source"`
+	Version string `json:"version,omitempty"`
+	Name    string `json:"name"`
+	Dir     string `json:"dir,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	// ErrorKind categorizes Error: source_not_found, version_not_found,
+	// parse or unsupported_feature. Empty for other failures.
+	ErrorKind string `json:"error_kind,omitempty"`
+}
+
+func runBatch(args []string) {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	outputDir := fs.String("out", ".", "Directory to create the wrappers in")
+	var iterable iterableFlag
+	fs.Var(&iterable, "iterable", "Default -iterable setting (true or force) for specs that don't set it")
+	jsonOutput := fs.Bool("json", false, "Print one JSON result per line instead of text")
+	allowConflicts := fs.Bool("allow-provider-conflicts", false, "Only warn when the wrappers require provider versions that can't be used together")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tfwrapper batch [flags] <spec-file|->")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var in io.Reader = os.Stdin
+	if path := fs.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("Failed to open spec file: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	specs, err := parseBatchSpecs(in)
+	if err != nil {
+		log.Fatalf("Failed to read specs: %v", err)
+	}
+
+	cache, err := newModuleCache()
+	if err != nil {
+		log.Fatalf("Failed to create download cache: %v", err)
+	}
+
+	failed := 0
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetEscapeHTML(false)
+	providers := map[string]map[string]string{}
+	for _, opts := range specs {
+		if !opts.Iterable && !opts.Conditional {
+			opts.Iterable, opts.ForceIterable = iterable.Iterable, iterable.Force
+		}
+		if opts.OutputDir == "" {
+			opts.OutputDir = *outputDir
+		}
+		opts.cache = cache
+		opts.operation = "batch"
+
+		result := batchResult{Source: opts.Source, Version: opts.Version, Name: wrapperName(opts)}
+		dir, err := generateWrapper(opts)
+		if err != nil {
+			failed++
+			result.Error = err.Error()
+			result.ErrorKind = errorKind(err)
+		} else {
+			result.Dir = dir
+			if prov, err := readMetadata(dir); err == nil && prov != nil {
+				providers[result.Name] = prov.Providers
+			}
+		}
+
+		switch {
+		case *jsonOutput:
+			enc.Encode(result)
+		case err != nil:
+			reportError("Failed to wrap "+opts.Source, err)
+		default:
+			fmt.Printf("Wrapper module created in %s\n", dir)
+		}
+	}
+
+	cache.Close()
+
+	// The wrappers are meant to be used together, so catch provider
+	// constraints that would fail terraform init in the same root module
+	conflicts := providerConflicts(providers)
+	for _, conflict := range conflicts {
+		switch {
+		case *jsonOutput:
+			enc.Encode(conflict)
+		case *allowConflicts:
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", conflict)
+		default:
+			fmt.Fprintf(os.Stderr, "Error: %s\n", conflict)
+		}
+	}
+	conflicting := len(conflicts) > 0 && !*allowConflicts
+	if conflicting && !*jsonOutput {
+		fmt.Fprintf(os.Stderr, "%d provider version conflict(s); use -allow-provider-conflicts if the wrappers aren't used in the same root module\n", len(conflicts))
+	}
+	if failed > 0 && !*jsonOutput {
+		fmt.Fprintf(os.Stderr, "%d of %d wrappers failed\n", failed, len(specs))
+	}
+	if failed > 0 || conflicting {
+		os.Exit(1)
+	}
+}
+
+// parseBatchSpecs reads module specs, one per line. A line is either a JSON
+// object using the generateOptions field names, or whitespace separated
+// "source [version] [name]". Blank lines and # comments are ignored.
+func parseBatchSpecs(r io.Reader) ([]generateOptions, error) {
+	var specs []generateOptions
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var opts generateOptions
+		if strings.HasPrefix(line, "{") {
+			if err := json.Unmarshal([]byte(line), &opts); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNum, err)
+			}
+		} else {
+			fields := strings.Fields(line)
+			if len(fields) > 3 {
+				return nil, fmt.Errorf("line %d: expected \"source [version] [name]\"", lineNum)
+			}
+			opts.Source = fields[0]
+			if len(fields) > 1 {
+				opts.Version = fields[1]
+			}
+			if len(fields) > 2 {
+				opts.Name = fields[2]
+			}
+		}
+
+		if opts.Source == "" {
+			return nil, fmt.Errorf("line %d: source is required", lineNum)
+		}
+		specs = append(specs, opts)
+	}
+	return specs, scanner.Err()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// commentedHCL matches comment lines that are commented-out configuration
+// rather than prose: attribute assignments, block headers and lone braces.
+var commentedHCL = []*regexp.Regexp{
+	regexp.MustCompile(`^"?[A-Za-z_][\w.-]*"?\s*=\s*\S`),
+	regexp.MustCompile(`^[A-Za-z_][\w-]*(\s+"[^"]*")*\s*\{$`),
+	regexp.MustCompile(`^[\]})]+,?$`),
+	regexp.MustCompile(`^[\[{(]$`),
+}
+
+func validateComments(mode string) error {
+	switch mode {
+	case "", "all", "description-only", "off":
+		return nil
+	}
+	return fmt.Errorf("invalid -comments %q: must be all, description-only or off", mode)
+}
+
+// variableComment returns the comment written above a lookup for v:
+// the upstream comment without commented-out HCL, the description, or
+// nothing, depending on -comments.
+func variableComment(opts generateOptions, v moduleVariable) string {
+	switch opts.Comments {
+	case "off":
+		return ""
+	case "description-only":
+		if v.Description == "" {
+			return ""
+		}
+		lines := strings.Split(strings.TrimSpace(v.Description), "\n")
+		for i, line := range lines {
+			lines[i] = strings.TrimRight("# "+strings.TrimSpace(line), " ")
+		}
+		return strings.Join(lines, "\n")
+	}
+	comment := stripCommentedHCL(v.Comment)
+	if opts.GroupBy == "section" {
+		comment = stripBanner(comment)
+	}
+	return comment
+}
+
+// stripCommentedHCL drops comment lines that look like commented-out
+// configuration, which modules often keep around as examples, along with
+// the line introducing them.
+func stripCommentedHCL(comment string) string {
+	if comment == "" {
+		return ""
+	}
+	var kept []string
+	for _, line := range strings.Split(comment, "\n") {
+		text := strings.TrimSpace(strings.TrimLeft(strings.TrimSpace(line), "#/"))
+		if isCommentedHCL(text) && !bannerPattern.MatchString(strings.TrimSpace(line)) {
+			// An "Example:" line introducing the code goes with it
+			if n := len(kept); n > 0 && strings.HasSuffix(kept[n-1], ":") {
+				kept = kept[:n-1]
+			}
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+func isCommentedHCL(text string) bool {
+	for _, pattern := range commentedHCL {
+		if pattern.MatchString(text) {
+			return true
+		}
+	}
+	return false
+}
+
+// warnCommentConflicts prints a warning for every variable whose comment
+// and description both exist but say different things.
+func warnCommentConflicts(vars []moduleVariable) {
+	for _, v := range vars {
+		comment := stripBanner(stripCommentedHCL(v.Comment))
+		if comment == "" || v.Description == "" || similarText(comment, v.Description) {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %s: the comment above variable %q disagrees with its description (use -comments=description-only to use the description)\n", v.File, v.Name)
+	}
+}
+
+// similarText reports whether two texts agree: one contains the other, or
+// at least half the words of the shorter one appear in the longer one.
+func similarText(a, b string) bool {
+	wa, wb := words(a), words(b)
+	if len(wa) > len(wb) {
+		wa, wb = wb, wa
+	}
+	if len(wa) == 0 || strings.Contains(strings.Join(wb, " "), strings.Join(wa, " ")) {
+		return true
+	}
+	seen := make(map[string]bool, len(wb))
+	for _, w := range wb {
+		seen[w] = true
+	}
+	shared := 0
+	for _, w := range wa {
+		if seen[w] {
+			shared++
+		}
+	}
+	return shared*2 >= len(wa)
+}
+
+// words splits text into lower case words, ignoring comment markers and
+// punctuation.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_')
+	})
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// compatFileName holds the shape translations written with -compat.
+const compatFileName = "compat.tf"
+
+// compatLayer records the translations of compat.tf: the upstream version
+// the translated configs were written for, and the variables whose type
+// changed since.
+type compatLayer struct {
+	From    string                `json:"from,omitempty"`
+	Retyped map[string]typeChange `json:"retyped"`
+}
+
+type typeChange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// compatFor works out the compat layer of the next generation. A layer lasts
+// one release cycle: it is kept while the wrapper is regenerated from the
+// same upstream version, and replaced by the type changes of the next one.
+// Changes that can't be translated are reported.
+func compatFor(prev *provenance, next provenance) *compatLayer {
+	if prev == nil {
+		return nil
+	}
+	if prev.Source == next.Source && prev.Version == next.Version {
+		return prev.Compat
+	}
+	if prev.Variables == nil {
+		return nil
+	}
+
+	layer := &compatLayer{From: prev.Version, Retyped: map[string]typeChange{}}
+	old := renameContract(prev.Variables, next.Options)
+	for _, name := range sortedKeys(next.Variables) {
+		o, ok := old[name]
+		n := next.Variables[name]
+		if !ok || o.Type == n.Type {
+			continue
+		}
+		if _, ok := compatConversion(o.Type, n.Type); !ok {
+			fmt.Fprintf(os.Stderr, "Warning: %s changed from %s to %s, which %s can't translate. Configs setting it must be migrated now\n", name, displayType(o.Type), displayType(n.Type), compatFileName)
+			continue
+		}
+		layer.Retyped[name] = typeChange{From: o.Type, To: n.Type}
+	}
+	if len(layer.Retyped) == 0 {
+		return nil
+	}
+	return layer
+}
+
+// compatConversion returns the expression converting a value of type from,
+// or already of type to, to type to, with %[1]s for the value. Conversions
+// between primitive types, wrapping a primitive in a list or set, unwrapping
+// a single element and switching between lists and sets are supported.
+func compatConversion(from, to string) (string, bool) {
+	f, t := typeNode(from), typeNode(to)
+	primitive := func(node *schemaNode) bool {
+		return node.Kind == "string" || node.Kind == "number" || node.Kind == "bool"
+	}
+	collection := func(node *schemaNode) bool {
+		return node.Kind == "list" || node.Kind == "set"
+	}
+	switch {
+	case primitive(f) && primitive(t):
+		return "to" + t.Kind + "(%[1]s)", true
+	case primitive(f) && collection(t) && primitive(t.Elem):
+		return "try(to" + t.Kind + "(%[1]s), to" + t.Kind + "([%[1]s]))", true
+	case collection(f) && primitive(t):
+		return "try(one(%[1]s), %[1]s)", true
+	case collection(f) && collection(t) && f.Kind != t.Kind:
+		return "to" + t.Kind + "(%[1]s)", true
+	}
+	return "", false
+}
+
+// splitCompat separates the variables compat.tf translates from the rest.
+func splitCompat(opts generateOptions, layer *compatLayer, vars []moduleVariable) (rest, translated []moduleVariable) {
+	if !opts.Compat || layer == nil {
+		return vars, nil
+	}
+	for _, v := range vars {
+		if _, ok := layer.Retyped[v.Name]; ok {
+			translated = append(translated, v)
+		} else {
+			rest = append(rest, v)
+		}
+	}
+	return rest, translated
+}
+
+// generateCompatTf renders compat.tf, holding the translated arguments in a
+// local like an inputs_*.tf file does.
+func generateCompatTf(opts generateOptions, layer *compatLayer, vars []moduleVariable) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Translates configs written for %s %s to the variable types of\n", opts.Source, displayVersion(layer.From))
+	fmt.Fprintf(&b, "# %s, for one release cycle. tfwrapper validate-config lists the\n", displayVersion(opts.Version))
+	b.WriteString("# configs still to migrate; once they are, regenerate without -compat.\n")
+	b.WriteString("locals {\n")
+
+	// The config may hold either type, which coalesce() and try() in the
+	// accessor of the new type would choke on
+	lookupOpts := opts
+	lookupOpts.Accessor = accessorLookup
+
+	configSource, indent := "local.config", "    "
+	if opts.Iterable {
+		fmt.Fprintf(&b, "  compat = { for name, instance in %s : name => {\n", accessExpr(fixedAccessor(opts), "local.config", "instances", "{}"))
+		configSource = "instance"
+	} else {
+		b.WriteString("  compat = {\n")
+	}
+	for _, v := range vars {
+		change := layer.Retyped[v.Name]
+		conversion, _ := compatConversion(change.From, change.To)
+		fmt.Fprintf(&b, "%s%s = %s # %s -> %s\n", indent, hclKey(v.Name), fmt.Sprintf(conversion, lookupExpr(lookupOpts, configSource, v)), displayType(change.From), displayType(change.To))
+	}
+	if opts.Iterable {
+		b.WriteString("  } }\n")
+	} else {
+		b.WriteString("  }\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// configMigrations lists what an instance config still has to change before
+// the compat layer and the former names of renamed variables go away.
+func configMigrations(prov *provenance, instance map[string]any) []string {
+	var migrations []string
+	deprecated := deprecatedKeys(prov.Options)
+	for _, key := range sortedKeys(instance) {
+		if target, ok := deprecated[key]; ok {
+			migrations = append(migrations, fmt.Sprintf("%s was renamed to %s", key, target))
+			continue
+		}
+		if prov.Compat == nil {
+			continue
+		}
+		if change, ok := prov.Compat.Retyped[key]; ok && !matchesType(typeNode(change.To), instance[key]) {
+			migrations = append(migrations, fmt.Sprintf("%s is set as %s, the type of %s; %s expects %s", key, displayType(change.From), displayVersion(prov.Compat.From), displayVersion(prov.Version), displayType(change.To)))
+		}
+	}
+	return migrations
+}
+
+// matchesType reports whether a decoded JSON value conforms to node.
+func matchesType(node *schemaNode, value any) bool {
+	if value == nil {
+		return true
+	}
+	switch node.Kind {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		switch value.(type) {
+		case float64, json.Number:
+			return true
+		}
+		return false
+	case "bool":
+		_, ok := value.(bool)
+		return ok
+	case "list", "set":
+		items, ok := value.([]any)
+		for _, item := range items {
+			ok = ok && matchesType(node.Elem, item)
+		}
+		return ok
+	case "tuple":
+		items, ok := value.([]any)
+		if !ok || len(items) != len(node.Items) {
+			return false
+		}
+		for i, item := range items {
+			ok = ok && matchesType(node.Items[i], item)
+		}
+		return ok
+	case "map":
+		values, ok := value.(map[string]any)
+		for _, v := range values {
+			ok = ok && matchesType(node.Elem, v)
+		}
+		return ok
+	case "object":
+		values, ok := value.(map[string]any)
+		for key, v := range values {
+			if attr, known := node.Attrs[key]; known {
+				ok = ok && matchesType(attr, v)
+			}
+		}
+		return ok
+	}
+	return true
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// conditionalKey is the config key that decides whether a -conditional
+// wrapper creates the module. It follows the "create" convention of popular
+// module collections, so an upstream variable of the same name receives the
+// same value.
+const conditionalKey = "create"
+
+// legacyOutputName is the output holding the whole module as one object,
+// which wrappers exported before each upstream output got its own.
+const legacyOutputName = "output"
+
+func validateConditional(opts generateOptions) error {
+	if opts.Conditional && opts.Iterable {
+		return errors.New("-conditional can't be combined with -iterable, Terraform doesn't allow count and for_each together")
+	}
+	return nil
+}
+
+// generateOutputsTf re-exports each upstream output under its own name, so
+// consumers read module.<wrapper>.<name>. Unless -legacy-output=false or an
+// outputs contract limits the outputs, the deprecated "output" object of the
+// whole module, which consumers of earlier wrappers read, is kept alongside.
+// A -conditional module is a list of zero or one instances, so its outputs
+// are null rather than an error when it isn't created. Each output keeps its
+// upstream description, noting how the wrapper changes the shape of the
+// value.
+func generateOutputsTf(opts generateOptions, outputs []moduleOutput) string {
+	legacy := legacyOutput(opts)
+	var b strings.Builder
+	for _, output := range outputs {
+		name, description := output.Name, output.Description
+		// The legacy object keeps the name, the output stays readable in it
+		if name == legacyOutputName && legacy {
+			continue
+		}
+		value := "module.this." + name
+		switch {
+		case opts.Iterable:
+			value = fmt.Sprintf("{ for key, instance in %s : key => instance.%s }", moduleRef(opts), name)
+			description += " (by instance key)"
+		case len(opts.FanOut) > 0:
+			value = fmt.Sprintf("{ for target, instance in %s : target => instance.%s }", moduleRef(opts), name)
+			description += " (by target)"
+		case opts.Conditional:
+			value = fmt.Sprintf("try(module.this[0].%s, null)", name)
+			description += " (null when the module isn't created)"
+		}
+		fmt.Fprintf(&b, "output %q {\n", name)
+		fmt.Fprintf(&b, "  description = %s\n", hclString(description))
+		fmt.Fprintf(&b, "  value       = %s\n", value)
+		b.WriteString("}\n\n")
+	}
+	if !legacy {
+		return strings.TrimSuffix(b.String(), "\n")
+	}
+
+	b.WriteString("# Deprecated: every output as a single object, for consumers reading\n")
+	b.WriteString("# module.<wrapper>.output.<name>. Read module.<wrapper>.<name> instead,\n")
+	b.WriteString("# then regenerate with -legacy-output=false to drop it.\n")
+	fmt.Fprintf(&b, "output %q {\n", legacyOutputName)
+	b.WriteString("  description = \"Deprecated: every output of the module as a single object\"\n")
+	if opts.Conditional {
+		b.WriteString("  value       = try(one(module.this[*]), null)\n")
+	} else {
+		fmt.Fprintf(&b, "  value       = %s\n", moduleRef(opts))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// constraintClause matches one clause of a terraform version constraint,
+// e.g. ">= 4.0" or "~> 5.1".
+var constraintClause = regexp.MustCompile(`^(=|!=|>=|<=|>|<|~>)?\s*v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(-[0-9A-Za-z.-]+)?$`)
+
+// semver is a parsed version. Missing minor and patch numbers are zero.
+type semver struct {
+	nums [3]int
+	pre  string
+}
+
+func (v semver) compare(o semver) int {
+	for i := range v.nums {
+		if v.nums[i] != o.nums[i] {
+			return v.nums[i] - o.nums[i]
+		}
+	}
+	switch {
+	case v.pre == o.pre:
+		return 0
+	case v.pre == "":
+		return 1
+	case o.pre == "":
+		return -1
+	}
+	return strings.Compare(v.pre, o.pre)
+}
+
+func (v semver) String() string {
+	return fmt.Sprintf("%d.%d.%d%s", v.nums[0], v.nums[1], v.nums[2], v.pre)
+}
+
+// versionBound is one end of a versionRange; a zero bound is unbounded.
+type versionBound struct {
+	version   semver
+	inclusive bool
+	set       bool
+}
+
+// versionRange is the set of versions a terraform version constraint
+// allows: an interval, less any excluded versions.
+type versionRange struct {
+	lower, upper versionBound
+	excluded     []semver
+}
+
+// parseVersionConstraint parses a comma separated terraform version
+// constraint. An empty constraint allows every version.
+func parseVersionConstraint(constraint string) (versionRange, error) {
+	var r versionRange
+	if strings.TrimSpace(constraint) == "" {
+		return r, nil
+	}
+	for _, clause := range strings.Split(constraint, ",") {
+		m := constraintClause.FindStringSubmatch(strings.TrimSpace(clause))
+		if m == nil {
+			return r, fmt.Errorf("invalid version constraint %q", strings.TrimSpace(clause))
+		}
+		var v semver
+		parts := 1
+		for i := range v.nums {
+			if m[i+2] != "" {
+				v.nums[i], _ = strconv.Atoi(m[i+2])
+				parts = i + 1
+			}
+		}
+		v.pre = m[5]
+
+		var c versionRange
+		switch m[1] {
+		case "", "=":
+			c.lower = versionBound{v, true, true}
+			c.upper = versionBound{v, true, true}
+		case "!=":
+			c.excluded = []semver{v}
+		case ">":
+			c.lower = versionBound{v, false, true}
+		case ">=":
+			c.lower = versionBound{v, true, true}
+		case "<":
+			c.upper = versionBound{v, false, true}
+		case "<=":
+			c.upper = versionBound{v, true, true}
+		case "~>":
+			// Only the rightmost given number may increase: ~> 1.2 allows
+			// 1.x from 1.2, ~> 1.2.3 allows 1.2.x from 1.2.3
+			c.lower = versionBound{v, true, true}
+			var next semver
+			if parts == 1 {
+				next.nums[0] = v.nums[0] + 1
+			} else {
+				copy(next.nums[:], v.nums[:parts-1])
+				next.nums[parts-2]++
+			}
+			c.upper = versionBound{next, false, true}
+		}
+		r = r.intersect(c)
+	}
+	return r, nil
+}
+
+// intersect returns the versions allowed by both r and o.
+func (r versionRange) intersect(o versionRange) versionRange {
+	out := versionRange{lower: r.lower, upper: r.upper}
+	if o.lower.set {
+		if c := o.lower.version.compare(out.lower.version); !out.lower.set || c > 0 || c == 0 && !o.lower.inclusive {
+			out.lower = o.lower
+		}
+	}
+	if o.upper.set {
+		if c := o.upper.version.compare(out.upper.version); !out.upper.set || c < 0 || c == 0 && !o.upper.inclusive {
+			out.upper = o.upper
+		}
+	}
+	for _, v := range append(append([]semver{}, r.excluded...), o.excluded...) {
+		if !slices.Contains(out.excluded, v) {
+			out.excluded = append(out.excluded, v)
+		}
+	}
+	return out
+}
+
+// empty reports whether no version satisfies r.
+func (r versionRange) empty() bool {
+	if !r.lower.set || !r.upper.set {
+		return false
+	}
+	c := r.lower.version.compare(r.upper.version)
+	if c == 0 {
+		return !r.lower.inclusive || !r.upper.inclusive || slices.Contains(r.excluded, r.lower.version)
+	}
+	return c > 0
+}
+
+// String renders r as a terraform version constraint, using ~> where it is
+// equivalent.
+func (r versionRange) String() string {
+	var clauses []string
+	lower, upper := r.lower, r.upper
+	switch {
+	case lower.set && upper.set && lower.version.compare(upper.version) == 0:
+		clauses = append(clauses, lower.version.String())
+	case lower.set && upper.set && lower.inclusive && !upper.inclusive && isPessimisticPair(lower.version, upper.version):
+		v := lower.version
+		if v.nums[2] == 0 && upper.version.nums[0] == v.nums[0]+1 {
+			clauses = append(clauses, fmt.Sprintf("~> %d.%d", v.nums[0], v.nums[1]))
+		} else {
+			clauses = append(clauses, "~> "+v.String())
+		}
+	default:
+		if lower.set {
+			op := ">"
+			if lower.inclusive {
+				op = ">="
+			}
+			clauses = append(clauses, op+" "+lower.version.String())
+		}
+		if upper.set {
+			op := "<"
+			if upper.inclusive {
+				op = "<="
+			}
+			clauses = append(clauses, op+" "+upper.version.String())
+		}
+	}
+	for _, v := range r.excluded {
+		clauses = append(clauses, "!= "+v.String())
+	}
+	return strings.Join(clauses, ", ")
+}
+
+// isPessimisticPair reports whether [lower, upper) is what ~> lower allows.
+func isPessimisticPair(lower, upper semver) bool {
+	if lower.pre != "" || upper.pre != "" || upper.nums[2] != 0 {
+		return false
+	}
+	switch {
+	case upper.nums[0] == lower.nums[0]+1 && upper.nums[1] == 0:
+		return lower.nums[2] == 0
+	case upper.nums[0] == lower.nums[0] && upper.nums[1] == lower.nums[1]+1:
+		return true
+	}
+	return false
+}
+
+// providerSource normalizes a required_providers source address, defaulting
+// to the hashicorp namespace of the public registry like terraform does.
+func providerSource(localName, source string) string {
+	source = strings.ToLower(strings.TrimSpace(source))
+	if source == "" {
+		return "hashicorp/" + strings.ToLower(localName)
+	}
+	return strings.TrimPrefix(source, "registry.terraform.io/")
+}
+
+// requiredProviders reads the required_providers of a terraform block as
+// source address -> version constraint. The legacy string form is a
+// constraint for a hashicorp provider.
+func requiredProviders(terraform *hcl.Block) map[string]string {
+	content, _, _ := terraform.Body.PartialContent(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "required_providers"}},
+	})
+	providers := map[string]string{}
+	for _, block := range content.Blocks {
+		attrs, _ := block.Body.JustAttributes()
+		for name, attr := range attrs {
+			if constraint := staticString(attr); constraint != "" {
+				providers[providerSource(name, "")] = constraint
+				continue
+			}
+			pairs, diags := hcl.ExprMap(attr.Expr)
+			if diags.HasErrors() {
+				continue
+			}
+			var source, constraint string
+			for _, pair := range pairs {
+				key := hcl.ExprAsKeyword(pair.Key)
+				if key == "" {
+					key = staticString(&hcl.Attribute{Expr: pair.Key})
+				}
+				value := staticString(&hcl.Attribute{Expr: pair.Value})
+				switch key {
+				case "source":
+					source = value
+				case "version":
+					constraint = value
+				}
+			}
+			providers[providerSource(name, source)] = constraint
+		}
+	}
+	return providers
+}
+
+// providerConflict is a provider whose version constraints, required by
+// wrappers meant to share a root module, no version satisfies.
+type providerConflict struct {
+	Provider string `json:"provider"`
+	// Constraints maps wrapper names to the constraint each requires
+	Constraints map[string]string `json:"constraints"`
+	ErrorKind   string            `json:"error_kind"`
+}
+
+func (c providerConflict) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "no version of provider %s satisfies every wrapper:", c.Provider)
+	for _, name := range sortedKeys(c.Constraints) {
+		fmt.Fprintf(&b, "\n  %s: %s", name, c.Constraints[name])
+	}
+	return b.String()
+}
+
+// providerConflicts intersects the constraints of every provider across
+// wrappers, given as wrapper name -> provider source -> constraint.
+// Unparseable constraints are warned about and ignored.
+func providerConflicts(required map[string]map[string]string) []providerConflict {
+	ranges := map[string]versionRange{}
+	constraints := map[string]map[string]string{}
+	for _, name := range sortedKeys(required) {
+		for source, constraint := range required[name] {
+			if constraint == "" {
+				continue
+			}
+			r, err := parseVersionConstraint(constraint)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %s: provider %s: %v\n", name, source, err)
+				continue
+			}
+			ranges[source] = ranges[source].intersect(r)
+			if constraints[source] == nil {
+				constraints[source] = map[string]string{}
+			}
+			constraints[source][name] = constraint
+		}
+	}
+
+	var conflicts []providerConflict
+	for _, source := range sortedKeys(ranges) {
+		if ranges[source].empty() {
+			conflicts = append(conflicts, providerConflict{Provider: source, Constraints: constraints[source], ErrorKind: "provider_conflict"})
+		}
+	}
+	return conflicts
+}
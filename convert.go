@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+func runConvertCall(args []string) {
+	fs := flag.NewFlagSet("convert-call", flag.ExitOnError)
+	module := fs.String("module", "", "Name of the module block to convert (required if the file has more than one)")
+	format := fs.String("format", "json", "Output format: json or yaml")
+	output := fs.String("o", "", "Write the config to this file instead of stdout")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tfwrapper convert-call [flags] <file.tf>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	config, err := convertModuleCall(fs.Arg(0), *module)
+	if err != nil {
+		fatalError("Error", err)
+	}
+
+	var out string
+	switch *format {
+	case "json":
+		data, err := ctyjson.Marshal(config, config.Type())
+		if err != nil {
+			log.Fatalf("Failed to encode config: %v", err)
+		}
+		out = string(data) + "\n"
+	case "yaml":
+		out = marshalYAML(config)
+	default:
+		log.Fatalf("Error: unknown format %q (expected json or yaml)", *format)
+	}
+
+	if *output == "" {
+		fmt.Print(out)
+		return
+	}
+	if err := checkWrite(*output); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+	if err := os.WriteFile(*output, []byte(out), 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+}
+
+// convertModuleCall evaluates the input arguments of a module block in path
+// and returns them as a config object. Arguments that reference variables,
+// resources or functions can't be evaluated statically; they are reported
+// on stderr and left out of the config.
+func convertModuleCall(path, name string) (cty.Value, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return cty.NilVal, err
+	}
+	file, diags := hclsyntax.ParseConfig(src, path, hcl.InitialPos)
+	if diags.HasErrors() {
+		return cty.NilVal, newParseError(diags, map[string]*hcl.File{path: file})
+	}
+
+	var candidates []*hclsyntax.Block
+	for _, block := range file.Body.(*hclsyntax.Body).Blocks {
+		if block.Type != "module" || len(block.Labels) != 1 {
+			continue
+		}
+		if name != "" && block.Labels[0] != name {
+			continue
+		}
+		if name == "" && isLocalSource(syntaxAttrString(block.Body, "source")) {
+			continue
+		}
+		candidates = append(candidates, block)
+	}
+
+	switch {
+	case len(candidates) == 0 && name != "":
+		return cty.NilVal, fmt.Errorf("module %q not found in %s", name, path)
+	case len(candidates) == 0:
+		return cty.NilVal, fmt.Errorf("no remote module calls found in %s", path)
+	case len(candidates) > 1:
+		names := make([]string, len(candidates))
+		for i, block := range candidates {
+			names[i] = block.Labels[0]
+		}
+		return cty.NilVal, fmt.Errorf("%s contains several module calls (%s); choose one with -module", path, strings.Join(names, ", "))
+	}
+
+	return moduleCallConfig(candidates[0], src, nil), nil
+}
+
+// moduleCallConfig evaluates the input arguments of a module block parsed
+// from src in ctx, which may be nil. Arguments that can't be evaluated are
+// reported on stderr and left out.
+func moduleCallConfig(block *hclsyntax.Block, src []byte, ctx *hcl.EvalContext) cty.Value {
+	attrs := make(map[string]cty.Value)
+	for _, argName := range orderedAttributeNames(block.Body) {
+		if moduleMetaArguments[argName] {
+			continue
+		}
+		attr := block.Body.Attributes[argName]
+		val, diags := attr.Expr.Value(ctx)
+		if diags.HasErrors() || !val.IsWhollyKnown() {
+			rng := attr.Expr.Range()
+			fmt.Fprintf(os.Stderr, "Warning: %s: skipping %s, it is not a static value (%s)\n", rng.String(), argName, strings.TrimSpace(string(rng.SliceBytes(src))))
+			continue
+		}
+		attrs[argName] = val
+	}
+	return cty.ObjectVal(attrs)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+func runVersions(args []string) {
+	fs := flag.NewFlagSet("versions", flag.ExitOnError)
+	compatibleWith := fs.String("compatible-with", "", "Only list releases whose required_version allows this terraform version, e.g. 1.5.x (optional)")
+	limit := fs.Int("n", 10, "Number of releases to list, highest first")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tfwrapper versions [flags] <module-source>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	source := fs.Arg(0)
+
+	sb, err := sandboxFor(".")
+	if err != nil {
+		fatalError("Failed to load workspace", err)
+	}
+	versions, err := releasedVersions(sb, source)
+	if err != nil {
+		fatalError("Failed to list versions", err)
+	}
+	if *compatibleWith == "" {
+		for i, v := range versions {
+			if i == *limit {
+				break
+			}
+			fmt.Println(v)
+		}
+		return
+	}
+
+	core, err := parseCoreVersion(*compatibleWith)
+	if err != nil {
+		log.Fatalf("Error: -compatible-with: %v", err)
+	}
+	cache, err := newModuleCache()
+	if err != nil {
+		log.Fatalf("Failed to create download cache: %v", err)
+	}
+	defer cache.Close()
+
+	listed := 0
+	for _, v := range versions {
+		if listed == *limit {
+			break
+		}
+		constraint, err := moduleCoreVersion(sb, cache, source, v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", v, err)
+			continue
+		}
+		if ok, err := coreCompatible(core, constraint); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", v, err)
+			continue
+		} else if !ok {
+			continue
+		}
+		if constraint == "" {
+			constraint = "(any)"
+		}
+		fmt.Printf("%s\t%s\n", v, constraint)
+		listed++
+	}
+}
+
+// parseCoreVersion parses the terraform version of our runners, as given to
+// -compatible-with. Missing or "x" parts are wildcards, so 1.5.x (or 1.5) is
+// any 1.5 release and 1.5.7 is exactly that release.
+func parseCoreVersion(version string) (versionRange, error) {
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(version), "v"), ".")
+	for len(parts) > 1 && (parts[len(parts)-1] == "x" || parts[len(parts)-1] == "*") {
+		parts = parts[:len(parts)-1]
+	}
+	if len(parts) > 3 {
+		return versionRange{}, fmt.Errorf("invalid terraform version %q", version)
+	}
+	constraint := strings.Join(parts, ".")
+	if len(parts) < 3 {
+		constraint = "~> " + constraint + ".0"
+	}
+	r, err := parseVersionConstraint(constraint)
+	if err != nil {
+		return versionRange{}, fmt.Errorf("invalid terraform version %q", version)
+	}
+	return r, nil
+}
+
+// coreCompatible reports whether some terraform version in core satisfies a
+// module's required_version constraint.
+func coreCompatible(core versionRange, constraint string) (bool, error) {
+	r, err := parseVersionConstraint(constraint)
+	if err != nil {
+		return false, fmt.Errorf("required_version: %w", err)
+	}
+	return !core.intersect(r).empty(), nil
+}
+
+// moduleCoreVersion downloads source at version and returns the
+// required_version constraints of the module and every local module it
+// calls, joined into one.
+func moduleCoreVersion(sb sandbox, cache *moduleCache, source, version string) (string, error) {
+	repoDir, modulePath, err := downloadModule(sb, cache, source, version, "")
+	if err != nil {
+		return "", err
+	}
+	c := newModuleChecker(repoDir, false, false)
+	c.check(modulePath, nil)
+	return strings.Join(c.coreVersions, ", "), nil
+}
+
+// compatibleVersion returns the highest of versions whose required_version
+// allows a terraform version in core. Releases are downloaded into cache
+// newest first until one fits.
+func compatibleVersion(sb sandbox, cache *moduleCache, source string, versions []string, core versionRange) (string, error) {
+	for _, v := range versions {
+		constraint, err := moduleCoreVersion(sb, cache, source, v)
+		if err != nil {
+			return "", fmt.Errorf("failed to check %s: %w", v, err)
+		}
+		if ok, err := coreCompatible(core, constraint); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s: %v\n", v, err)
+		} else if ok {
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("%w: no release of %s supports terraform %s", ErrVersionNotFound, source, core)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+)
+
+// hostToken finds the API token for host the same way terraform and tofu
+// do: a TF_TOKEN_<host> environment variable, then a credentials block in the
+// CLI config file, then credentials.tfrc.json as written by `terraform login`.
+func hostToken(host string) string {
+	host = strings.ToLower(host)
+	// Environment variable names can't carry a port
+	hostname, _, _ := strings.Cut(host, ":")
+	if token := os.Getenv(tokenEnvName(hostname)); token != "" {
+		return token
+	}
+	if token := cliConfigToken(cliConfigPath(), host); token != "" {
+		return token
+	}
+	return credentialsFileToken(filepath.Join(cliConfigDir(), "credentials.tfrc.json"), host)
+}
+
+// tokenEnvName encodes host as in TF_TOKEN_app_terraform_io: dots become
+// underscores and dashes become double underscores.
+func tokenEnvName(host string) string {
+	name := strings.ReplaceAll(host, "-", "__")
+	return "TF_TOKEN_" + strings.ReplaceAll(name, ".", "_")
+}
+
+func cliConfigDir() string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "terraform.d")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".terraform.d")
+}
+
+func cliConfigPath() string {
+	if path := os.Getenv("TF_CLI_CONFIG_FILE"); path != "" {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "terraform.rc")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".terraformrc")
+}
+
+// cliConfigToken reads `credentials "host" { token = "..." }` from a CLI
+// config file. A missing or unreadable file just means no token.
+func cliConfigToken(path, host string) string {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	file, diags := hclparse.NewParser().ParseHCL(src, path)
+	if diags.HasErrors() {
+		return ""
+	}
+	content, _, _ := file.Body.PartialContent(&hcl.BodySchema{
+		Blocks: []hcl.BlockHeaderSchema{{Type: "credentials", LabelNames: []string{"host"}}},
+	})
+	for _, block := range content.Blocks {
+		if strings.ToLower(block.Labels[0]) != host {
+			continue
+		}
+		attrs, _ := block.Body.JustAttributes()
+		if attr, ok := attrs["token"]; ok {
+			if val, diags := attr.Expr.Value(nil); !diags.HasErrors() && val.Type().FriendlyName() == "string" {
+				return val.AsString()
+			}
+		}
+	}
+	return ""
+}
+
+func credentialsFileToken(path, host string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var creds struct {
+		Credentials map[string]struct {
+			Token string `json:"token"`
+		} `json:"credentials"`
+	}
+	if json.Unmarshal(data, &creds) != nil {
+		return ""
+	}
+	for h, c := range creds.Credentials {
+		if strings.ToLower(h) == host {
+			return c.Token
+		}
+	}
+	return ""
+}
+
+// hostTokenHeader returns a basic auth header carrying the configured token
+// for cloneURL's host, for git over HTTPS. Most git hosts accept a token as
+// the password with any user name.
+func hostTokenHeader(cloneURL string) string {
+	scheme, rest, ok := strings.Cut(cloneURL, "://")
+	if !ok || scheme != "https" {
+		return ""
+	}
+	host, _, _ := strings.Cut(rest, "/")
+	if i := strings.LastIndex(host, "@"); i >= 0 {
+		host = host[i+1:]
+	}
+	token := hostToken(host)
+	if token == "" {
+		return ""
+	}
+	return "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte("x-access-token:"+token))
+}
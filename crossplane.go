@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// crossplaneVersion is the API version of generated composite resources.
+// The mapping is experimental, so it starts out as an alpha version.
+const crossplaneVersion = "v1alpha1"
+
+func runCrossplane(args []string) {
+	fs := flag.NewFlagSet("crossplane", flag.ExitOnError)
+	wrapperDir := fs.String("wrapper", ".", "Wrapper directory whose .tfwrapper.json describes the config")
+	group := fs.String("group", "platform.example.org", "API group of the composite resource")
+	moduleSource := fs.String("module-source", "", "Module source the Workspace fetches the wrapper from, e.g. git::https://github.com/org/wrappers.git//vpc?ref=v1.2.0")
+	providerConfig := fs.String("provider-config", "default", "Terraform provider ProviderConfig the Workspace uses")
+	output := fs.String("o", "", "Write the manifests to this file instead of stdout")
+	fs.Parse(args)
+
+	prov, err := readMetadata(*wrapperDir)
+	if err != nil {
+		fatalError("Failed to read wrapper metadata", err)
+	}
+	if prov == nil {
+		log.Fatalf("Error: %s not found in %s", metadataFileName, *wrapperDir)
+	}
+	if prov.Options.ConfigFrom != "" {
+		log.Fatalf("Error: %s reads its config from %s, which a Composition can't pass", *wrapperDir, prov.Options.ConfigFrom)
+	}
+	name := wrapperName(prov.Options)
+	if *moduleSource == "" {
+		*moduleSource = fmt.Sprintf("git::https://example.com/wrappers.git//%s?ref=%s", name, prov.WrapperVersion)
+		fmt.Fprintf(os.Stderr, "Warning: -module-source not set, edit the placeholder %q in the Composition\n", *moduleSource)
+	}
+
+	kind := exportedName(name)
+	xrd := crossplaneXRD(*group, kind, configSchema(prov), prov.OutputDescriptions)
+	composition := crossplaneComposition(*group, kind, *moduleSource, *providerConfig)
+	var b strings.Builder
+	for i, manifest := range []map[string]any{xrd, composition} {
+		doc, err := manifestYAML(manifest)
+		if err != nil {
+			log.Fatalf("Failed to encode manifests: %v", err)
+		}
+		if i > 0 {
+			b.WriteString("---\n")
+		}
+		b.WriteString(doc)
+	}
+
+	if *output == "" {
+		fmt.Print(b.String())
+		return
+	}
+	if err := checkWrite(*output); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+	if err := os.WriteFile(*output, []byte(b.String()), 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+}
+
+// crossplaneXRD returns a CompositeResourceDefinition of X<kind>, claimable
+// as <kind>, whose spec.parameters is the wrapper's config. The Workspace's
+// outputs are surfaced under status.outputs, documented with outputs, which
+// maps their names to descriptions.
+func crossplaneXRD(group, kind string, config *schemaNode, outputs map[string]string) map[string]any {
+	plural := strings.ToLower(kind) + "s"
+	statusOutputs := map[string]any{
+		"type":                                 "object",
+		"x-kubernetes-preserve-unknown-fields": true,
+	}
+	if len(outputs) > 0 {
+		properties := make(map[string]any, len(outputs))
+		for name, description := range outputs {
+			properties[name] = map[string]any{
+				"description":                          description,
+				"x-kubernetes-preserve-unknown-fields": true,
+			}
+		}
+		statusOutputs["properties"] = properties
+	}
+	return map[string]any{
+		"apiVersion": "apiextensions.crossplane.io/v1",
+		"kind":       "CompositeResourceDefinition",
+		"metadata":   map[string]any{"name": "x" + plural + "." + group},
+		"spec": map[string]any{
+			"group":      group,
+			"names":      map[string]any{"kind": "X" + kind, "plural": "x" + plural},
+			"claimNames": map[string]any{"kind": kind, "plural": plural},
+			"versions": []any{map[string]any{
+				"name":          crossplaneVersion,
+				"served":        true,
+				"referenceable": true,
+				"schema": map[string]any{"openAPIV3Schema": map[string]any{
+					"type": "object",
+					"properties": map[string]any{
+						"spec": map[string]any{
+							"type":       "object",
+							"properties": map[string]any{"parameters": openAPISchema(config)},
+							"required":   []string{"parameters"},
+						},
+						"status": map[string]any{
+							"type":       "object",
+							"properties": map[string]any{"outputs": statusOutputs},
+						},
+					},
+				}},
+			}},
+		},
+	}
+}
+
+// openAPISchema writes node as a structural OpenAPI v3 schema, the subset
+// Kubernetes accepts in custom resource definitions. Unknown keys are pruned
+// by the API server rather than rejected.
+func openAPISchema(node *schemaNode) map[string]any {
+	switch node.Kind {
+	case "string", "number":
+		return map[string]any{"type": node.Kind}
+	case "bool":
+		return map[string]any{"type": "boolean"}
+	case "list", "set":
+		return map[string]any{"type": "array", "items": openAPISchema(node.Elem)}
+	case "map":
+		return map[string]any{"type": "object", "additionalProperties": openAPISchema(node.Elem)}
+	case "object":
+		properties := map[string]any{}
+		for key, attr := range node.Attrs {
+			properties[key] = openAPISchema(attr)
+		}
+		schema := map[string]any{"type": "object", "properties": properties}
+		if len(node.Required) > 0 {
+			schema["required"] = node.Required
+		}
+		return schema
+	case "tuple":
+		return map[string]any{"type": "array", "items": map[string]any{"x-kubernetes-preserve-unknown-fields": true}}
+	}
+	return map[string]any{"x-kubernetes-preserve-unknown-fields": true}
+}
+
+// crossplaneComposition returns a Composition of X<kind> with a single
+// Terraform provider Workspace running the wrapper. spec.parameters is JSON
+// encoded into var.config by function-patch-and-transform.
+func crossplaneComposition(group, kind, moduleSource, providerConfig string) map[string]any {
+	plural := strings.ToLower(kind) + "s"
+	workspace := map[string]any{
+		"apiVersion": "tf.upbound.io/v1beta1",
+		"kind":       "Workspace",
+		"spec": map[string]any{
+			"providerConfigRef": map[string]any{"name": providerConfig},
+			"forProvider": map[string]any{
+				"source": "Remote",
+				"module": moduleSource,
+				"vars":   []any{map[string]any{"key": "config", "value": "{}"}},
+			},
+		},
+	}
+	patches := []any{
+		map[string]any{
+			"type":          "FromCompositeFieldPath",
+			"fromFieldPath": "spec.parameters",
+			"toFieldPath":   "spec.forProvider.vars[0].value",
+			"transforms": []any{map[string]any{
+				"type":   "string",
+				"string": map[string]any{"type": "Convert", "convert": "ToJson"},
+			}},
+		},
+		map[string]any{
+			"type":          "ToCompositeFieldPath",
+			"fromFieldPath": "status.atProvider.outputs",
+			"toFieldPath":   "status.outputs",
+		},
+	}
+	return map[string]any{
+		"apiVersion": "apiextensions.crossplane.io/v1",
+		"kind":       "Composition",
+		"metadata":   map[string]any{"name": "x" + plural + "." + group},
+		"spec": map[string]any{
+			"compositeTypeRef": map[string]any{"apiVersion": group + "/" + crossplaneVersion, "kind": "X" + kind},
+			"mode":             "Pipeline",
+			"pipeline": []any{map[string]any{
+				"step":        "patch-and-transform",
+				"functionRef": map[string]any{"name": "function-patch-and-transform"},
+				"input": map[string]any{
+					"apiVersion": "pt.fn.crossplane.io/v1beta1",
+					"kind":       "Resources",
+					"resources": []any{map[string]any{
+						"name":    "workspace",
+						"base":    workspace,
+						"patches": patches,
+					}},
+				},
+			}},
+		},
+	}
+}
+
+// manifestYAML renders a manifest with marshalYAML, by way of its JSON
+// encoding.
+func manifestYAML(manifest map[string]any) (string, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", err
+	}
+	ty, err := ctyjson.ImpliedType(data)
+	if err != nil {
+		return "", err
+	}
+	val, err := ctyjson.Unmarshal(data, ty)
+	if err != nil {
+		return "", err
+	}
+	return marshalYAML(val), nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// referencesModule reports whether expr refers to var.* or local.*, which a
+// default copied into the wrapper can't, as the wrapper has neither.
+func referencesModule(expr hcl.Expression) bool {
+	for _, traversal := range expr.Variables() {
+		if root := traversal.RootName(); root == "var" || root == "local" {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveDefaultReferences evaluates the defaults in exprs, keyed by variable
+// name, against the static variable defaults and locals of the module's .tf
+// files. Terraform doesn't allow such defaults, but some modules have them.
+// Defaults calling functions keep their source with the references replaced
+// by their values. The ones that still can't be resolved are replaced with
+// null and reported.
+func resolveDefaultReferences(fsys fs.FS, vars []moduleVariable, exprs map[string]hcl.Expression) {
+	if len(exprs) == 0 {
+		return
+	}
+	paths, _ := fs.Glob(fsys, "*.tf")
+	var bodies []*hclsyntax.Body
+	for _, p := range paths {
+		src, err := fs.ReadFile(fsys, p)
+		if err != nil {
+			continue
+		}
+		// Files that don't parse are reported wherever they matter
+		if file, diags := hclsyntax.ParseConfig(src, p, hcl.InitialPos); !diags.HasErrors() {
+			bodies = append(bodies, file.Body.(*hclsyntax.Body))
+		}
+	}
+	ctx := staticEvalContext(bodies)
+
+	for i, v := range vars {
+		expr, ok := exprs[v.Name]
+		if !ok {
+			continue
+		}
+		if val, diags := expr.Value(ctx); !diags.HasErrors() && val.IsWhollyKnown() {
+			vars[i].Default = ctyValueToString(val)
+			vars[i].DefaultKind = "resolved"
+			if collapsedValue(val) {
+				vars[i].Default = "null"
+				vars[i].DefaultKind = "collapsed"
+			}
+			continue
+		}
+		// Function calls are left to Terraform, with the references inlined
+		refs := unresolvedReferences(expr, ctx)
+		if def, ok := inlineReferences(v.Default, expr, ctx); ok && len(refs) == 0 {
+			vars[i].Default = def
+			vars[i].DefaultKind = "resolved"
+			continue
+		}
+		vars[i].Default = "null"
+		vars[i].DefaultKind = "unresolved"
+		reason := "can't be evaluated statically"
+		if len(refs) > 0 {
+			reason = "references " + strings.Join(refs, ", ") + ", which can't be resolved statically"
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %s: the default of variable %q %s. The wrapper passes null unless the config sets %q\n", expr.Range().Filename, v.Name, reason, v.Name)
+	}
+}
+
+// unresolvedReferences returns the var.* and local.* references of expr that
+// ctx has no value for.
+func unresolvedReferences(expr hcl.Expression, ctx *hcl.EvalContext) []string {
+	var refs []string
+	for _, traversal := range expr.Variables() {
+		root := traversal.RootName()
+		if (root != "var" && root != "local") || len(traversal) < 2 {
+			continue
+		}
+		attr, ok := traversal[1].(hcl.TraverseAttr)
+		if !ok || ctx.Variables[root].Type().HasAttribute(attr.Name) {
+			continue
+		}
+		if ref := root + "." + attr.Name; !slices.Contains(refs, ref) {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// inlineReferences replaces every reference in src, the source of expr, with
+// its value in ctx. It fails if any reference has no known value.
+func inlineReferences(src string, expr hcl.Expression, ctx *hcl.EvalContext) (string, bool) {
+	traversals := expr.Variables()
+	// Replace from the end, so earlier offsets stay valid
+	sort.Slice(traversals, func(i, j int) bool {
+		return traversals[i].SourceRange().Start.Byte > traversals[j].SourceRange().Start.Byte
+	})
+	base := expr.Range().Start.Byte
+	for _, traversal := range traversals {
+		val, diags := traversal.TraverseAbs(ctx)
+		if diags.HasErrors() || !val.IsWhollyKnown() {
+			return "", false
+		}
+		rng := traversal.SourceRange()
+		src = src[:rng.Start.Byte-base] + ctyValueToString(val) + src[rng.End.Byte-base:]
+	}
+	return src, true
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// defaultTagsKey is the config key with -default-tags holding the tags every
+// resource of the wrapper gets, like the AWS provider's default_tags.
+const defaultTagsKey = "provider_default_tags"
+
+// tagsVariable is the upstream variable -default-tags merges into.
+const tagsVariable = "tags"
+
+// defaultTagsComment explains the merge above the tags argument.
+const defaultTagsComment = `# provider_default_tags are merged underneath the instance's own tags, which
+# win key by key. The provider's own default_tags still apply to every
+# resource, for the keys these tags don't set.`
+
+// validateDefaultTags checks that -default-tags can be used with the module:
+// its key mustn't clash with an upstream variable, and without a tags
+// variable there is nothing to merge into.
+func validateDefaultTags(opts generateOptions, contract map[string]contractVariable) error {
+	if !opts.DefaultTags {
+		return nil
+	}
+	if _, ok := contract[defaultTagsKey]; ok {
+		return fmt.Errorf("%w: -default-tags: the module has a variable named %s", ErrUnsupportedFeature, defaultTagsKey)
+	}
+	if v, ok := contract[tagsVariable]; !ok {
+		fmt.Fprintf(os.Stderr, "Warning: -default-tags: the module has no %s variable, %s will have no effect\n", tagsVariable, defaultTagsKey)
+	} else if v.Type != "" && !strings.HasPrefix(v.Type, "map(") {
+		fmt.Fprintf(os.Stderr, "Warning: -default-tags: %s is a %s rather than a map, merging %s into it may fail\n", tagsVariable, v.Type, defaultTagsKey)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// derivedFileName holds the derived.tf locals of a -derived wrapper.
+const derivedFileName = "derived.tf"
+
+// derivedKeys are the upstream variables a -derived file computes from other
+// config keys, encoding naming and similar conventions in the wrapper:
+//
+//	bucket_name = "${org}-${env}-${name}"
+//
+// A config setting the variable itself still wins.
+type derivedKeys struct {
+	// Expressions are the expressions deriving each variable, as written
+	// in the -derived file, with bare names referring to config keys
+	Expressions map[string]string `json:"expressions"`
+	// Inputs are the config keys the expressions read that aren't
+	// upstream variables
+	Inputs []string `json:"inputs,omitempty"`
+
+	exprs map[string]hclsyntax.Expression
+}
+
+// loadDerived reads the -derived file, checking that every key is an upstream
+// variable the wrapper passes and that the expressions only refer to config
+// keys. It returns nil without -derived.
+func loadDerived(opts generateOptions, contract map[string]contractVariable) (*derivedKeys, error) {
+	if opts.Derived == "" {
+		return nil, nil
+	}
+	src, err := os.ReadFile(opts.Derived)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read -derived file: %w", err)
+	}
+	file, diags := hclsyntax.ParseConfig(src, opts.Derived, hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, newParseError(diags, map[string]*hcl.File{opts.Derived: file})
+	}
+	body := file.Body.(*hclsyntax.Body)
+	if len(body.Blocks) > 0 {
+		return nil, fmt.Errorf("%s: only attributes are allowed, found a %s block", opts.Derived, body.Blocks[0].Type)
+	}
+
+	derived := &derivedKeys{Expressions: map[string]string{}, exprs: map[string]hclsyntax.Expression{}}
+	inputs := map[string]bool{}
+	for _, name := range sortedKeys(body.Attributes) {
+		attr := body.Attributes[name]
+		if _, ok := contract[name]; !ok {
+			return nil, fmt.Errorf("%s: can't derive %q, the module has no such variable", opts.Derived, name)
+		}
+		if isExcluded(opts, name) {
+			return nil, fmt.Errorf("%s: can't derive %q, it is excluded from the wrapper", opts.Derived, name)
+		}
+		for _, traversal := range attr.Expr.Variables() {
+			root := traversal.RootName()
+			switch root {
+			case "var", "local", "each", "count", "path", "data", "module", "self", "terraform":
+				return nil, fmt.Errorf("%s: %s refers to %s, only config keys can be used", opts.Derived, name, root)
+			}
+			if _, ok := body.Attributes[root]; ok {
+				return nil, fmt.Errorf("%s: %s refers to %s, which is derived too", opts.Derived, name, root)
+			}
+			if _, ok := contract[root]; !ok {
+				inputs[root] = true
+			}
+		}
+		derived.Expressions[name] = string(attr.Expr.Range().SliceBytes(src))
+		derived.exprs[name] = attr.Expr
+	}
+	for input := range inputs {
+		derived.Inputs = append(derived.Inputs, input)
+	}
+	sort.Strings(derived.Inputs)
+	return derived, nil
+}
+
+// derivedExpr renders the expression deriving name, with every config key it
+// refers to read from configSource. Keys that are upstream variables fall
+// back to their upstream default, other keys to null.
+func derivedExpr(derived *derivedKeys, name, configSource string, vars []moduleVariable) string {
+	expr := derived.exprs[name]
+	src := derived.Expressions[name]
+	traversals := expr.Variables()
+	// Replace from the end, so earlier offsets stay valid
+	sort.Slice(traversals, func(i, j int) bool {
+		return traversals[i].SourceRange().Start.Byte > traversals[j].SourceRange().Start.Byte
+	})
+	base := expr.Range().Start.Byte
+	for _, traversal := range traversals {
+		root := traversal.RootName()
+		def := "null"
+		if i := slices.IndexFunc(vars, func(v moduleVariable) bool { return v.Name == root }); i >= 0 {
+			def = vars[i].Default
+		}
+		rng := traversal[0].SourceRange()
+		src = src[:rng.Start.Byte-base] + accessExpr(accessorLookup, configSource, root, def) + src[rng.End.Byte-base:]
+	}
+	return src
+}
+
+// derivedRef returns the derived value of name for the instance configured
+// by configSource: local.config, each.value in the module block, or instance
+// in the locals iterating over the instances by name.
+func derivedRef(configSource, name string) string {
+	switch configSource {
+	case "each.value":
+		return fmt.Sprintf("local.derived[each.key][%s]", hclString(name))
+	case "instance":
+		return fmt.Sprintf("local.derived[name][%s]", hclString(name))
+	}
+	return fmt.Sprintf("local.derived[%s]", hclString(name))
+}
+
+// generateDerivedTf renders derived.tf, holding the derived values in a local
+// like an inputs_*.tf file does. vars are the upstream variables with the
+// defaults they had before deriving.
+func generateDerivedTf(opts generateOptions, derived *derivedKeys, vars []moduleVariable) string {
+	var b strings.Builder
+	b.WriteString("# Config keys derived from other keys by convention, unless the config\n")
+	fmt.Fprintf(&b, "# sets them. Generated from %s\n", opts.Derived)
+	b.WriteString("locals {\n")
+	configSource, indent := "local.config", "    "
+	if opts.Iterable {
+		fmt.Fprintf(&b, "  derived = { for name, instance in %s : name => {\n", accessExpr(fixedAccessor(opts), "local.config", "instances", "{}"))
+		configSource = "instance"
+	} else {
+		b.WriteString("  derived = {\n")
+	}
+	for _, name := range sortedKeys(derived.Expressions) {
+		fmt.Fprintf(&b, "%s%s = %s\n", indent, hclKey(name), derivedExpr(derived, name, configSource, vars))
+	}
+	if opts.Iterable {
+		b.WriteString("  } }\n")
+	} else {
+		b.WriteString("  }\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// writeDiagnostics prints err to stderr as rich HCL diagnostics if it wraps a
+// ErrParse, colorized when stderr is a terminal. It reports whether it
+// printed anything.
+func writeDiagnostics(err error) bool {
+	var pe *ErrParse
+	if !errors.As(err, &pe) {
+		return false
+	}
+	color := isTerminal(os.Stderr) && os.Getenv("NO_COLOR") == ""
+	var width uint
+	if color {
+		width = 100
+	}
+	wr := hcl.NewDiagnosticTextWriter(os.Stderr, pe.Files, width, color)
+	wr.WriteDiagnostics(pe.Diags)
+	return true
+}
+
+// fatalError reports err and exits with the code for its category. Parse
+// errors are printed as rich diagnostics, everything else as a log line.
+func fatalError(prefix string, err error) {
+	if !writeDiagnostics(err) {
+		log.Printf("%s: %v", prefix, err)
+	}
+	os.Exit(exitCode(err))
+}
+
+// reportError is like fatalError but doesn't exit, for commands that carry on
+// after a failure (e.g. batch).
+func reportError(prefix string, err error) {
+	if !writeDiagnostics(err) {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", prefix, err)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// moduleCall is a remote module block found in existing Terraform code.
+type moduleCall struct {
+	Source  string   `json:"source"`
+	Version string   `json:"version,omitempty"`
+	Calls   []string `json:"calls"`
+}
+
+func runDiscover(args []string) {
+	fs := flag.NewFlagSet("discover", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory of existing Terraform code to scan")
+	generate := fs.Bool("generate", false, "Generate a wrapper for every unique source/version found")
+	outputDir := fs.String("out", ".", "Directory to create the wrappers in (with -generate)")
+	jsonOutput := fs.Bool("json", false, "Print the discovered modules as JSON")
+	excludeDirs := fs.String("exclude-dirs", strings.Join(defaultExcludeDirs, ","), "Comma separated directories to skip, names or paths relative to -dir, besides hidden ones and the .terraformignore patterns")
+	fs.Parse(args)
+
+	exclude, err := excludePatterns(*dir, *excludeDirs)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	calls, err := discoverModules(*dir, exclude)
+	if err != nil {
+		fatalError("Failed to scan "+*dir, err)
+	}
+
+	if *generate {
+		failed := 0
+		for _, call := range calls {
+			dir, err := generateWrapper(generateOptions{Source: call.Source, Version: call.Version, OutputDir: *outputDir})
+			if err != nil {
+				failed++
+				reportError("Failed to wrap "+call.Source, err)
+				continue
+			}
+			fmt.Printf("Wrapper module created in %s\n", dir)
+		}
+		if failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(calls)
+		return
+	}
+
+	// Plain output uses the batch spec format so it can be piped into
+	// 'tfwrapper batch -'
+	for _, call := range calls {
+		fmt.Println(strings.TrimSpace(call.Source + " " + call.Version))
+	}
+}
+
+// discoverModules walks dir for .tf files, skipping the exclude patterns, and
+// returns every unique remote module source/version pair, sorted by source.
+func discoverModules(dir string, exclude []string) ([]moduleCall, error) {
+	found := make(map[string]*moduleCall)
+	parser := hclparse.NewParser()
+
+	err := walkTerraformFiles(dir, exclude, func(path string) error {
+		file, diags := parser.ParseHCLFile(path)
+		if diags.HasErrors() {
+			return newParseError(diags, parser.Files())
+		}
+		content, _, _ := file.Body.PartialContent(&hcl.BodySchema{
+			Blocks: []hcl.BlockHeaderSchema{{Type: "module", LabelNames: []string{"name"}}},
+		})
+
+		for _, block := range content.Blocks {
+			attrs, _, _ := block.Body.PartialContent(&hcl.BodySchema{
+				Attributes: []hcl.AttributeSchema{{Name: "source"}, {Name: "version"}},
+			})
+			source := staticString(attrs.Attributes["source"])
+			if source == "" || isLocalSource(source) {
+				continue
+			}
+			version := staticString(attrs.Attributes["version"])
+
+			key := source + "@" + version
+			if found[key] == nil {
+				found[key] = &moduleCall{Source: source, Version: version}
+			}
+			found[key].Calls = append(found[key].Calls, fmt.Sprintf("%s:%d module.%s", path, block.DefRange.Start.Line, block.Labels[0]))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	calls := make([]moduleCall, 0, len(found))
+	for _, call := range found {
+		calls = append(calls, *call)
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		if calls[i].Source != calls[j].Source {
+			return calls[i].Source < calls[j].Source
+		}
+		return calls[i].Version < calls[j].Version
+	})
+	return calls, nil
+}
+
+// defaultExcludeDirs are the directories walkTerraformFiles skips unless
+// -exclude-dirs says otherwise: examples and tests call modules without
+// being part of the code that is deployed.
+var defaultExcludeDirs = []string{"examples", "test", "tests"}
+
+// terraformIgnoreFileName lists paths Terraform leaves out of uploads, which
+// are skipped when walking too.
+const terraformIgnoreFileName = ".terraformignore"
+
+// excludePatterns returns the patterns of the directories to skip below dir:
+// the comma separated -exclude-dirs entries, names or slash separated paths,
+// and the patterns of dir's .terraformignore. Negated .terraformignore
+// patterns aren't supported and are left out.
+func excludePatterns(dir, excludeDirs string) ([]string, error) {
+	var patterns []string
+	for _, entry := range splitList(excludeDirs) {
+		pattern := strings.TrimPrefix(strings.TrimSuffix(filepath.ToSlash(entry), "/"), "/") + "/"
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid -exclude-dirs entry %q", entry)
+		}
+		patterns = append(patterns, pattern)
+	}
+	ignore, err := readPatternFile(filepath.Join(dir, terraformIgnoreFileName))
+	if err != nil {
+		return nil, err
+	}
+	for _, pattern := range ignore {
+		if !strings.HasPrefix(pattern, "!") {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns, nil
+}
+
+// walkTerraformFiles calls fn for every .tf file below dir, skipping hidden
+// directories such as .terraform and .git, and the files and directories
+// matching the .tfwrapperignore style exclude patterns.
+func walkTerraformFiles(dir string, exclude []string, fn func(path string) error) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if p == dir {
+				return nil
+			}
+			if strings.HasPrefix(d.Name(), ".") || ignored(exclude, rel+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(p, ".tf") || ignored(exclude, rel) {
+			return nil
+		}
+		return fn(p)
+	})
+}
+
+// staticString returns the value of attr if it is a literal string, or "" if
+// the attribute is missing or can't be evaluated without context.
+func staticString(attr *hcl.Attribute) string {
+	if attr == nil {
+		return ""
+	}
+	val, diags := attr.Expr.Value(nil)
+	if diags.HasErrors() || val.IsNull() || !val.Type().Equals(cty.String) {
+		return ""
+	}
+	return val.AsString()
+}
+
+// isLocalSource reports whether source refers to a module on the local
+// filesystem rather than a remote location.
+func isLocalSource(source string) bool {
+	return strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../")
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// variableDeclaration is where a variable block was found: the path of its
+// file in the module and the range of its header.
+type variableDeclaration struct {
+	path string
+	rng  hcl.Range
+}
+
+// duplicateVariable reports a variable declared a second time at dup, which
+// Terraform rejects and the wrapper can't pass twice. Two names linking to
+// the same file, like a symlinked variables.tf, are pointed out.
+func duplicateVariable(fsys fs.FS, name string, first, dup variableDeclaration) *hcl.Diagnostic {
+	detail := fmt.Sprintf("Variable %q was already declared at %s:%d. Variable names must be unique within a module.", name, first.path, first.rng.Start.Line)
+	if first.path != dup.path && sameFile(fsys, first.path, dup.path) {
+		detail += fmt.Sprintf(" %s and %s are the same file, probably through a symlink; remove the link or group without -group-by file.", first.path, dup.path)
+	}
+	return &hcl.Diagnostic{
+		Severity: hcl.DiagError,
+		Summary:  "Duplicate variable declaration",
+		Detail:   detail,
+		Subject:  dup.rng.Ptr(),
+	}
+}
+
+// sameFile reports whether two paths of fsys lead to the same file.
+func sameFile(fsys fs.FS, a, b string) bool {
+	ai, err := fs.Stat(fsys, a)
+	if err != nil {
+		return false
+	}
+	bi, err := fs.Stat(fsys, b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
+
+// isOverrideFile reports whether a Terraform file is an override file, whose
+// blocks amend the declarations of the other files rather than adding to
+// them.
+func isOverrideFile(name string) bool {
+	return name == "override.tf" || strings.HasSuffix(name, "_override.tf")
+}
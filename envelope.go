@@ -0,0 +1,153 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// apiGroupPattern matches a Kubernetes API group, a lowercase DNS name.
+var apiGroupPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+// envelopeKeys are the keys of a config document in an envelope.
+var envelopeKeys = []string{"apiVersion", "kind", "metadata", "spec"}
+
+// errOtherKind is returned for an enveloped document of another wrapper.
+var errOtherKind = errors.New("the document is for another wrapper")
+
+// configEnvelope describes the Kubernetes style envelope config documents may
+// come in with -envelope, so a repository can hold the documents of several
+// wrappers:
+//
+//	apiVersion: <group>/v<major wrapper version>
+//	kind: <wrapper name in CamelCase>
+//	metadata: {...} # left to the tools managing the documents
+//	spec: {...}     # the config
+type configEnvelope struct {
+	Group      string
+	APIVersion string
+	Kind       string
+	// Compatible accepts the earlier apiVersions of the group too, while
+	// the wrapper keeps configs written for earlier versions working
+	Compatible bool
+}
+
+// validateEnvelope checks the -envelope group, and that the module has no
+// variable a plain config would set that the wrapper would take for an
+// envelope.
+func validateEnvelope(opts generateOptions, contract map[string]contractVariable) error {
+	if opts.Envelope == "" {
+		return nil
+	}
+	if !apiGroupPattern.MatchString(opts.Envelope) {
+		return fmt.Errorf("invalid -envelope %q: must be a lowercase DNS name, e.g. wrappers.example.com", opts.Envelope)
+	}
+	if _, ok := contract["apiVersion"]; ok {
+		return fmt.Errorf("%w: -envelope: the module has a variable named apiVersion", ErrUnsupportedFeature)
+	}
+	return nil
+}
+
+// envelopeFor returns the envelope of a wrapper's config documents, or nil
+// without -envelope. The apiVersion changes with the major wrapper version,
+// that is with every breaking change to the contract.
+func envelopeFor(prov *provenance) *configEnvelope {
+	opts := prov.Options
+	if opts.Envelope == "" {
+		return nil
+	}
+	version := prov.WrapperVersion
+	if version == "" {
+		version = initialWrapperVersion
+	}
+	major, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), ".")
+	return &configEnvelope{
+		Group:      opts.Envelope,
+		APIVersion: opts.Envelope + "/v" + major,
+		Kind:       exportedName(wrapperName(opts)),
+		Compatible: prov.Compat != nil || len(deprecatedKeys(opts)) > 0,
+	}
+}
+
+// envelopeSpec returns the expression of the config in document, the decoded
+// config, or in its spec when it comes in an envelope. encoded is the JSON
+// encoded document; going through JSON lets either side have any type.
+func envelopeSpec(document, encoded string) string {
+	return fmt.Sprintf("jsondecode(can(%[1]s.apiVersion) ? jsonencode(try(%[1]s.spec, {})) : %[2]s)", document, encoded)
+}
+
+// configExpr returns the expression of the config in document, the decoded
+// config, unwrapping it with -envelope.
+func configExpr(opts generateOptions, document, encoded string) string {
+	if opts.Envelope == "" {
+		return document
+	}
+	return envelopeSpec(document, encoded)
+}
+
+// envelopeCondition returns the condition that an enveloped document, the
+// decoded config, is of the wrapper's kind and apiVersion.
+func envelopeCondition(env *configEnvelope, document string) string {
+	// Both sides of || are evaluated, hence try()
+	apiVersion := fmt.Sprintf("try(%s.apiVersion, \"\") == %s", document, hclString(env.APIVersion))
+	if env.Compatible {
+		apiVersion = fmt.Sprintf("can(regex(%s, %s.apiVersion))", hclString("^"+regexp.QuoteMeta(env.Group)+"/v[0-9]+$"), document)
+	}
+	return fmt.Sprintf("!can(%[1]s.apiVersion) || (%[2]s && try(%[1]s.kind, \"\") == %[3]s)", document, apiVersion, hclString(env.Kind))
+}
+
+func envelopeMessage(env *configEnvelope) string {
+	return hclString(fmt.Sprintf("Config documents in an envelope must have apiVersion %q and kind %q.", env.APIVersion, env.Kind))
+}
+
+// unwrapEnvelope returns the config a document holds: the document itself,
+// or its spec when it comes in the wrapper's envelope. Envelopes that don't
+// match are reported, and an earlier apiVersion the wrapper still accepts is
+// a migration.
+func unwrapEnvelope(prov *provenance, document map[string]any) (config map[string]any, problems, migrations []string) {
+	env := envelopeFor(prov)
+	if _, ok := document["apiVersion"]; env == nil || !ok {
+		return document, nil, nil
+	}
+	for _, key := range sortedKeys(document) {
+		if !slices.Contains(envelopeKeys, key) {
+			problems = append(problems, fmt.Sprintf("unknown key %q, the config goes under \"spec\"", key))
+		}
+	}
+	if kind, _ := document["kind"].(string); kind != env.Kind {
+		problems = append(problems, fmt.Sprintf("kind must be %q", env.Kind))
+	}
+	apiVersion, _ := document["apiVersion"].(string)
+	group, version, _ := strings.Cut(apiVersion, "/")
+	switch {
+	case apiVersion == env.APIVersion:
+	case env.Compatible && group == env.Group && strings.HasPrefix(version, "v"):
+		migrations = append(migrations, fmt.Sprintf("apiVersion %s is outdated, the wrapper is at %s", apiVersion, env.APIVersion))
+	default:
+		problems = append(problems, fmt.Sprintf("apiVersion must be %q", env.APIVersion))
+	}
+	config, ok := document["spec"].(map[string]any)
+	if document["spec"] != nil && !ok {
+		problems = append(problems, "spec must be an object")
+	}
+	if config == nil {
+		config = map[string]any{}
+	}
+	return config, problems, migrations
+}
+
+// otherKind returns errOtherKind when document is in an envelope of another
+// kind than the wrapper's, so repositories mixing the documents of several
+// wrappers can be checked one wrapper at a time.
+func otherKind(prov *provenance, document map[string]any) error {
+	env := envelopeFor(prov)
+	if _, ok := document["apiVersion"]; env == nil || !ok {
+		return nil
+	}
+	if kind, ok := document["kind"].(string); ok && kind != env.Kind {
+		return fmt.Errorf("%w: kind %s", errOtherKind, kind)
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
+)
+
+// configsDir holds the per-environment config skeletons written with
+// -environments.
+const configsDir = "configs"
+
+// commonConfig is the config shared by every environment.
+const commonConfig = "common"
+
+var environmentPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+func validateEnvironments(envs []string, format string) error {
+	if format != "" && format != "json" && format != "yaml" {
+		return fmt.Errorf("invalid -config-format %q: must be json or yaml", format)
+	}
+	seen := map[string]bool{}
+	for _, env := range envs {
+		switch {
+		case !environmentPattern.MatchString(env):
+			return fmt.Errorf("invalid environment %q: must be a letter or digit followed by letters, digits, '_', '.' or '-'", env)
+		case env == commonConfig:
+			return fmt.Errorf("invalid environment %q: %s.%s holds the config shared by every environment", env, commonConfig, format)
+		case seen[env]:
+			return fmt.Errorf("environment %q is listed twice", env)
+		}
+		seen[env] = true
+	}
+	return nil
+}
+
+// generateEnvironmentConfigs returns the config skeletons for opts.Environments:
+// a common config with a placeholder for every required variable, an empty
+// override per environment and a README explaining how they are merged.
+func generateEnvironmentConfigs(opts generateOptions, name string, contract map[string]contractVariable) ([]generatedFile, error) {
+	format := opts.ConfigFormat
+	if format == "" {
+		format = "json"
+	}
+
+	required := map[string]cty.Value{}
+	for key, v := range contract {
+		if v.Required && !slices.Contains(opts.Exclude, key) {
+			required[key] = placeholderValue(v.Type)
+		}
+	}
+	common := cty.ObjectVal(required)
+	if opts.Iterable {
+		common = cty.ObjectVal(map[string]cty.Value{
+			"instances": cty.ObjectVal(map[string]cty.Value{"example": common}),
+		})
+	}
+
+	content, err := encodeConfig(common, format)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	files := []generatedFile{{path.Join(configsDir, commonConfig+"."+format), content}}
+	for _, env := range opts.Environments {
+		content, err := encodeConfig(cty.EmptyObjectVal, format)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode config: %w", err)
+		}
+		files = append(files, generatedFile{path.Join(configsDir, env+"."+format), content})
+	}
+	files = append(files, generatedFile{path.Join(configsDir, "README.md"), environmentsReadme(opts, name, format)})
+	return files, nil
+}
+
+// encodeConfig renders a config object as indented JSON or as YAML.
+func encodeConfig(val cty.Value, format string) (string, error) {
+	if format == "yaml" {
+		return marshalYAML(val), nil
+	}
+	data, err := ctyjson.Marshal(val, val.Type())
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if err := json.Indent(&b, data, "", "  "); err != nil {
+		return "", err
+	}
+	return b.String() + "\n", nil
+}
+
+// placeholderValue is the empty value of a variable's type constraint.
+func placeholderValue(typ string) cty.Value {
+	switch {
+	case typ == "number":
+		return cty.Zero
+	case typ == "bool":
+		return cty.False
+	case strings.HasPrefix(typ, "list(") || strings.HasPrefix(typ, "set(") || strings.HasPrefix(typ, "tuple("):
+		return cty.EmptyTupleVal
+	case strings.HasPrefix(typ, "map(") || strings.HasPrefix(typ, "object("):
+		return cty.EmptyObjectVal
+	}
+	return cty.StringVal("")
+}
+
+func environmentsReadme(opts generateOptions, name, format string) string {
+	decode := "jsondecode"
+	if format == "yaml" {
+		decode = "yamldecode"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s configs\n\n", name)
+	fmt.Fprintf(&b, "Config for the `%s` wrapper, split per environment. Each file overrides the\none before it:\n\n", name)
+	fmt.Fprintf(&b, "```\nupstream module defaults <- %s.%s <- <environment>.%s\n```\n\n", commonConfig, format, format)
+	fmt.Fprintf(&b, "`%s.%s` starts with a placeholder for every required variable. Put the\n", commonConfig, format)
+	b.WriteString("settings shared by all environments there, and only the differences in the\nenvironment files:\n\n")
+	for _, env := range opts.Environments {
+		fmt.Fprintf(&b, "- `%s.%s`\n", env, format)
+	}
+	b.WriteString("\nThe files are merged with `merge()` when calling the wrapper, so top-level keys\n")
+	b.WriteString("in an environment file replace those of the common file entirely")
+	if opts.Iterable {
+		b.WriteString(", including\n`instances`")
+	}
+	b.WriteString(":\n\n")
+	fmt.Fprintf(&b, "```hcl\nmodule %q {\n", name)
+	fmt.Fprintf(&b, "  source = \"./%s\"\n", name)
+	b.WriteString("  config = jsonencode(merge(\n")
+	fmt.Fprintf(&b, "    %s(file(\"${path.module}/%s/%s/%s.%s\")),\n", decode, name, configsDir, commonConfig, format)
+	fmt.Fprintf(&b, "    %s(file(\"${path.module}/%s/%s/${var.environment}.%s\")),\n", decode, name, configsDir, format)
+	b.WriteString("  ))\n}\n```\n")
+	return b.String()
+}
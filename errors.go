@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/hashicorp/hcl/v2"
+)
+
+// Failure categories. Errors returned while generating wrappers wrap one of
+// these where the cause is known, so callers can branch with errors.Is and
+// errors.As rather than matching message text.
+var (
+	// ErrSourceNotFound means the module source (repository or subpath)
+	// doesn't exist or isn't a Terraform module.
+	ErrSourceNotFound = errors.New("module source not found")
+
+	// ErrVersionNotFound means the source exists but the requested version
+	// doesn't.
+	ErrVersionNotFound = errors.New("module version not found")
+
+	// ErrUnsupportedFeature means the upstream module uses something the
+	// wrapper pattern can't support.
+	ErrUnsupportedFeature = errors.New("unsupported module feature")
+
+	// ErrBreakingChange means regenerating would remove something the
+	// wrapper's consumers may rely on, and -allow-breaking wasn't given.
+	ErrBreakingChange = errors.New("breaking change")
+)
+
+// ErrParse carries HCL diagnostics together with the parsed files, so they
+// can be printed with source snippets and line/column information instead of
+// a single flattened line.
+type ErrParse struct {
+	Diags hcl.Diagnostics
+	Files map[string]*hcl.File
+}
+
+func newParseError(diags hcl.Diagnostics, files map[string]*hcl.File) error {
+	return &ErrParse{Diags: diags, Files: files}
+}
+
+func (e *ErrParse) Error() string {
+	return e.Diags.Error()
+}
+
+// Exit codes for each failure category. 1 is any other failure and 2 is a
+// usage error, as reported by the flag package.
+const (
+	exitSourceNotFound  = 3
+	exitVersionNotFound = 4
+	exitParse           = 5
+	exitUnsupported     = 6
+	exitBreakingChange  = 7
+)
+
+// errorKind returns a short, stable name for the category of err, used in
+// JSON output. It returns "" for uncategorized errors.
+func errorKind(err error) string {
+	var pe *ErrParse
+	switch {
+	case errors.Is(err, ErrSourceNotFound):
+		return "source_not_found"
+	case errors.Is(err, ErrVersionNotFound):
+		return "version_not_found"
+	case errors.Is(err, ErrUnsupportedFeature):
+		return "unsupported_feature"
+	case errors.Is(err, ErrBreakingChange):
+		return "breaking_change"
+	case errors.As(err, &pe):
+		return "parse"
+	}
+	return ""
+}
+
+// exitCode maps err to the process exit status for its category.
+func exitCode(err error) int {
+	switch errorKind(err) {
+	case "source_not_found":
+		return exitSourceNotFound
+	case "version_not_found":
+		return exitVersionNotFound
+	case "parse":
+		return exitParse
+	case "unsupported_feature":
+		return exitUnsupported
+	case "breaking_change":
+		return exitBreakingChange
+	}
+	return 1
+}
+
+var (
+	gitMissingRef  = regexp.MustCompile(`(?i)remote branch .* not found|couldn't find remote ref`)
+	gitMissingRepo = regexp.MustCompile(`(?i)repository .*not found|does not appear to be a git repository|does not exist`)
+)
+
+// classifyCloneError wraps a failed git clone in ErrVersionNotFound or
+// ErrSourceNotFound when git's output makes the cause clear.
+func classifyCloneError(err error) error {
+	msg := err.Error()
+	switch {
+	case gitMissingRef.MatchString(msg):
+		return errors.Join(ErrVersionNotFound, err)
+	case gitMissingRepo.MatchString(msg):
+		return errors.Join(ErrSourceNotFound, err)
+	}
+	return err
+}
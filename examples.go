@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// examplesDir is where modules keep runnable examples of calling them.
+const examplesDir = "examples"
+
+// upstreamExamples returns the examples/ directory of the module, or of the
+// repository for submodules, and the examples in it.
+func upstreamExamples(repoDir, modulePath string) (string, []string, error) {
+	for _, dir := range []string{modulePath, repoDir} {
+		root := filepath.Join(dir, examplesDir)
+		entries, err := os.ReadDir(root)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return "", nil, err
+		}
+		var names []string
+		for _, e := range entries {
+			if e.IsDir() && !strings.HasPrefix(e.Name(), ".") {
+				names = append(names, e.Name())
+			}
+		}
+		if len(names) > 0 {
+			return root, names, nil
+		}
+	}
+	return "", nil, nil
+}
+
+// generateExampleConfig translates the call to the module in an upstream
+// example into the wrapper's example config. "auto" picks the "complete"
+// example if there is one, or else the first.
+func generateExampleConfig(opts generateOptions, repoDir, modulePath string) (generatedFile, error) {
+	root, names, err := upstreamExamples(repoDir, modulePath)
+	if err != nil {
+		return generatedFile{}, fmt.Errorf("failed to read examples: %w", err)
+	}
+	if len(names) == 0 {
+		return generatedFile{}, fmt.Errorf("%w: the module has no %s/ directory to use with -from-example", ErrUnsupportedFeature, examplesDir)
+	}
+	name := opts.FromExample
+	if name == "auto" {
+		name = names[0]
+		if slices.Contains(names, "complete") {
+			name = "complete"
+		}
+	}
+	if !slices.Contains(names, name) {
+		return generatedFile{}, fmt.Errorf("example %q not found (available: %s)", name, strings.Join(names, ", "))
+	}
+
+	config, err := exampleModuleCall(opts, repoDir, modulePath, filepath.Join(root, name))
+	if err != nil {
+		return generatedFile{}, err
+	}
+	attrs := config.AsValueMap()
+	if attrs == nil {
+		attrs = map[string]cty.Value{}
+	}
+	raw := map[string]cty.Value{}
+	for _, key := range opts.Exclude {
+		if val, ok := attrs[key]; ok {
+			delete(attrs, key)
+			if opts.AllowRawPassthrough {
+				raw[key] = val
+			} else {
+				fmt.Fprintf(os.Stderr, "Warning: example %s sets %s, which is excluded from the wrapper\n", name, key)
+			}
+		}
+	}
+	if len(raw) > 0 {
+		attrs[rawSection] = cty.ObjectVal(raw)
+	}
+	config = cty.ObjectVal(attrs)
+	if opts.Iterable {
+		config = cty.ObjectVal(map[string]cty.Value{
+			"instances": cty.ObjectVal(map[string]cty.Value{name: config}),
+		})
+	}
+
+	format := "json"
+	if ext := filepath.Ext(opts.ExampleConfig); ext == ".yaml" || ext == ".yml" {
+		format = "yaml"
+	}
+	content, err := encodeConfig(config, format)
+	if err != nil {
+		return generatedFile{}, fmt.Errorf("failed to encode config: %w", err)
+	}
+	return generatedFile{opts.ExampleConfig, content}, nil
+}
+
+// exampleModuleCall finds the call to the module in an example directory and
+// evaluates its arguments with the example's variable defaults and locals.
+func exampleModuleCall(opts generateOptions, repoDir, modulePath, exampleDir string) (cty.Value, error) {
+	var bodies []*hclsyntax.Body
+	sources := map[*hclsyntax.Body][]byte{}
+	err := walkTerraformFiles(exampleDir, nil, func(path string) error {
+		if filepath.Dir(path) != exampleDir {
+			return nil
+		}
+		if err := ensureWithin(repoDir, path); err != nil {
+			return err
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		// Report positions relative to the upstream repository
+		name, _ := filepath.Rel(repoDir, path)
+		file, diags := hclsyntax.ParseConfig(src, filepath.ToSlash(name), hcl.InitialPos)
+		if diags.HasErrors() {
+			return newParseError(diags, map[string]*hcl.File{name: file})
+		}
+		body := file.Body.(*hclsyntax.Body)
+		bodies = append(bodies, body)
+		sources[body] = src
+		return nil
+	})
+	if err != nil {
+		return cty.NilVal, err
+	}
+
+	ctx := staticEvalContext(bodies)
+	for _, body := range bodies {
+		for _, block := range body.Blocks {
+			if block.Type != "module" || len(block.Labels) != 1 {
+				continue
+			}
+			source := syntaxAttrString(block.Body, "source")
+			if (isLocalSource(source) && filepath.Join(exampleDir, source) == filepath.Clean(modulePath)) || source == opts.Source {
+				return moduleCallConfig(block, sources[body], ctx), nil
+			}
+		}
+	}
+	return cty.NilVal, fmt.Errorf("no call to the module found in %s", filepath.Base(exampleDir))
+}
+
+// staticEvalContext makes the static variable defaults and locals of a
+// module available as var.* and local.*. Defaults and locals are evaluated
+// until no more can be, so they may refer to each other in any order.
+func staticEvalContext(bodies []*hclsyntax.Body) *hcl.EvalContext {
+	pendingVars := map[string]hcl.Expression{}
+	pendingLocals := map[string]hcl.Expression{}
+	for _, body := range bodies {
+		for _, block := range body.Blocks {
+			switch {
+			case block.Type == "variable" && len(block.Labels) == 1:
+				if attr, ok := block.Body.Attributes["default"]; ok {
+					pendingVars[block.Labels[0]] = attr.Expr
+				}
+			case block.Type == "locals":
+				for name, attr := range block.Body.Attributes {
+					pendingLocals[name] = attr.Expr
+				}
+			}
+		}
+	}
+
+	vars := map[string]cty.Value{}
+	locals := map[string]cty.Value{}
+	ctx := &hcl.EvalContext{Variables: map[string]cty.Value{
+		"var":   cty.ObjectVal(vars),
+		"local": cty.ObjectVal(locals),
+	}}
+	evaluate := func(pending map[string]hcl.Expression, values map[string]cty.Value) bool {
+		progress := false
+		for name, expr := range pending {
+			if val, diags := expr.Value(ctx); !diags.HasErrors() && val.IsWhollyKnown() {
+				values[name] = val
+				delete(pending, name)
+				progress = true
+			}
+		}
+		ctx.Variables["var"] = cty.ObjectVal(vars)
+		ctx.Variables["local"] = cty.ObjectVal(locals)
+		return progress
+	}
+	for progress := true; progress; {
+		progress = evaluate(pendingVars, vars)
+		progress = evaluate(pendingLocals, locals) || progress
+	}
+	return ctx
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// The config keys of a -fan-out wrapper: the targets to deploy to, all of
+// them by default, and config overrides for each target, merged over the
+// rest of the config.
+const (
+	fanOutTargetsKey   = "targets"
+	fanOutOverridesKey = "target_overrides"
+)
+
+// fanOutProvider is the provider a -fan-out wrapper configures per target.
+const fanOutProvider = "hashicorp/aws"
+
+// defaultFanOutRole is the role assumed in the accounts of -fan-out targets,
+// the one AWS Organizations creates in its member accounts.
+const defaultFanOutRole = "OrganizationAccountAccessRole"
+
+var (
+	awsRegionPattern  = regexp.MustCompile(`^[a-z]{2}(-[a-z]+)+-[0-9]+$`)
+	awsAccountPattern = regexp.MustCompile(`^[0-9]{12}$`)
+)
+
+// fanOutTarget is a region, optionally in another account, a -fan-out
+// wrapper creates the module in.
+type fanOutTarget struct {
+	// Name is the target as given to -fan-out: region or account/region
+	Name    string
+	Account string
+	Region  string
+}
+
+// alias names the provider configuration and module block of the target,
+// e.g. eu_west_1 or account_111111111111_eu_west_1.
+func (t fanOutTarget) alias() string {
+	alias := strings.ReplaceAll(t.Region, "-", "_")
+	if t.Account != "" {
+		alias = "account_" + t.Account + "_" + alias
+	}
+	return alias
+}
+
+// parseFanOut parses the -fan-out targets.
+func parseFanOut(entries []string) ([]fanOutTarget, error) {
+	var targets []fanOutTarget
+	for _, entry := range entries {
+		t := fanOutTarget{Name: entry, Region: entry}
+		if account, region, ok := strings.Cut(entry, "/"); ok {
+			t.Account, t.Region = account, region
+			if !awsAccountPattern.MatchString(account) {
+				return nil, fmt.Errorf("invalid -fan-out target %q: %q isn't a 12 digit AWS account ID", entry, account)
+			}
+		}
+		if !awsRegionPattern.MatchString(t.Region) {
+			return nil, fmt.Errorf("invalid -fan-out target %q: %q isn't an AWS region like eu-west-1", entry, t.Region)
+		}
+		if slices.ContainsFunc(targets, func(other fanOutTarget) bool { return other.Name == entry }) {
+			return nil, fmt.Errorf("-fan-out lists %q twice", entry)
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// fanOutTargetOf returns the target whose alias is alias.
+func fanOutTargetOf(opts generateOptions, alias string) fanOutTarget {
+	targets, _ := parseFanOut(opts.FanOut)
+	for _, t := range targets {
+		if t.alias() == alias {
+			return t
+		}
+	}
+	return fanOutTarget{}
+}
+
+// validateFanOut checks that a -fan-out wrapper can configure the module's
+// AWS provider per target: it creates the module once per target itself, so
+// it can't also be iterable or conditional.
+func validateFanOut(opts generateOptions, contract map[string]contractVariable, providers map[string]string) error {
+	if len(opts.FanOut) == 0 {
+		return nil
+	}
+	if _, err := parseFanOut(opts.FanOut); err != nil {
+		return err
+	}
+	if opts.Iterable || opts.Conditional {
+		return fmt.Errorf("-fan-out can't be combined with -iterable or -conditional, the module is created once per target")
+	}
+	if len(opts.RegionCheck) > 0 {
+		return fmt.Errorf("-fan-out can't be combined with -region-check, the wrapper configures the region of each target")
+	}
+	if _, ok := providers[fanOutProvider]; !ok {
+		return fmt.Errorf("%w: -fan-out configures the %s provider per target, which the module doesn't require", ErrUnsupportedFeature, fanOutProvider)
+	}
+	for _, key := range []string{fanOutTargetsKey, fanOutOverridesKey} {
+		if _, ok := contract[key]; ok {
+			return fmt.Errorf("%w: -fan-out: the module has a variable named %s", ErrUnsupportedFeature, key)
+		}
+	}
+	return nil
+}
+
+// generateFanOutProviders writes a provider configuration per target, in the
+// target's account when it has one. A wrapper configuring its own providers
+// can't be called with count or for_each.
+func generateFanOutProviders(opts generateOptions, local string) string {
+	targets, _ := parseFanOut(opts.FanOut)
+	role := opts.FanOutRole
+	if role == "" {
+		role = defaultFanOutRole
+	}
+	var b strings.Builder
+	for _, t := range targets {
+		fmt.Fprintf(&b, "\nprovider %q {\n  alias  = %s\n  region = %s\n", local, hclString(t.alias()), hclString(t.Region))
+		if t.Account != "" {
+			fmt.Fprintf(&b, "  allowed_account_ids = [%s]\n", hclString(t.Account))
+			fmt.Fprintf(&b, "  assume_role {\n    role_arn = %s\n  }\n", hclString(fmt.Sprintf("arn:aws:iam::%s:role/%s", t.Account, role)))
+		}
+		b.WriteString("}\n")
+	}
+	return b.String()
+}
+
+// generateFanOutLocals writes the targets the config deploys to and the
+// config of each, with its overrides merged over the rest.
+func generateFanOutLocals(opts generateOptions) string {
+	names := make([]string, len(opts.FanOut))
+	for i, name := range opts.FanOut {
+		names[i] = hclString(name)
+	}
+	all := "[" + strings.Join(names, ", ") + "]"
+	overrides := accessExpr(fixedAccessor(opts), "local.config", fanOutOverridesKey, "{}")
+
+	var b strings.Builder
+	b.WriteString("\n# The targets the config deploys to, and the config of each with its\n")
+	fmt.Fprintf(&b, "# %s merged over the rest\n", fanOutOverridesKey)
+	b.WriteString("locals {\n")
+	fmt.Fprintf(&b, "  fan_out_targets = %s\n", accessExpr(fixedAccessor(opts), "local.config", fanOutTargetsKey, all))
+	fmt.Fprintf(&b, "  fan_out = { for target in local.fan_out_targets : target => merge(local.config, lookup(%s, target, {})) }\n", overrides)
+	b.WriteString("}\n")
+
+	b.WriteString("\n# Targets the wrapper has no provider for aren't deployed to\n")
+	b.WriteString("check \"fan_out_targets\" {\n  assert {\n")
+	fmt.Fprintf(&b, "    condition     = alltrue([for target in local.fan_out_targets : contains(%s, target)])\n", all)
+	fmt.Fprintf(&b, "    error_message = %s\n", hclString(fmt.Sprintf("Config key %q may only list %s.", fanOutTargetsKey, strings.Join(opts.FanOut, ", "))))
+	b.WriteString("  }\n}\n")
+	return b.String()
+}
+
+// fanOutForEach returns the for_each expression of the module block of the
+// target with alias: the target's config, when the config deploys to it.
+func fanOutForEach(opts generateOptions, alias string) string {
+	name := hclString(fanOutTargetOf(opts, alias).Name)
+	return fmt.Sprintf("contains(local.fan_out_targets, %s) ? { %s = local.fan_out[%s] } : {}", name, name, name)
+}
+
+// checkFanOutKeys reports targets and target_overrides of config naming
+// targets the wrapper doesn't have, and overrides setting unknown keys.
+func checkFanOutKeys(prov *provenance, config map[string]any) []string {
+	opts := prov.Options
+	var problems []string
+	if value, ok := config[fanOutTargetsKey]; ok {
+		targets, ok := value.([]any)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s must be a list", fanOutTargetsKey))
+		}
+		for _, target := range targets {
+			if name, _ := target.(string); !slices.Contains(opts.FanOut, name) {
+				problems = append(problems, fmt.Sprintf("%s: %v isn't one of %s", fanOutTargetsKey, target, strings.Join(opts.FanOut, ", ")))
+			}
+		}
+	}
+	if value, ok := config[fanOutOverridesKey]; ok {
+		overrides, ok := value.(map[string]any)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s must be an object", fanOutOverridesKey))
+		}
+		for _, target := range sortedKeys(overrides) {
+			if !slices.Contains(opts.FanOut, target) {
+				problems = append(problems, fmt.Sprintf("%s: %q isn't one of %s", fanOutOverridesKey, target, strings.Join(opts.FanOut, ", ")))
+			}
+			override, _ := overrides[target].(map[string]any)
+			for _, key := range sortedKeys(override) {
+				if _, ok := prov.Variables[key]; !ok {
+					problems = append(problems, fmt.Sprintf("%s.%s: unknown key %q", fanOutOverridesKey, target, key))
+				}
+			}
+		}
+	}
+	return problems
+}
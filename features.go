@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+	"github.com/zclconf/go-cty/cty/convert"
+)
+
+// featureDefaultSuffix ends the name of a feature flag setting the default
+// of an upstream variable, e.g. enable_deletion_protection_default.
+const featureDefaultSuffix = "_default"
+
+// featureFlag is a generation-time feature flag, set with -features or in
+// the generate block of tfwrapper.hcl for every wrapper of the workspace.
+// <variable>_default=<value> replaces the default the wrapper passes for an
+// optional upstream variable, in every wrapper whose module has it.
+type featureFlag struct {
+	Name string `json:"name"`
+	// Value is an HCL expression
+	Value string `json:"value"`
+	// From is where the flag was set: "command line" or tfwrapper.hcl
+	From string `json:"from"`
+	// Variable is the upstream variable whose default the flag set, ""
+	// when the module has no such optional variable
+	Variable string `json:"variable,omitempty"`
+}
+
+// parseFeatures parses -features entries, "name=value" each. Values that
+// aren't HCL literals, like prod, are strings.
+func parseFeatures(entries []string) ([]featureFlag, error) {
+	var features []featureFlag
+	for _, entry := range entries {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid -features entry %q: must be name=value", entry)
+		}
+		if err := validateFeatureName(name); err != nil {
+			return nil, err
+		}
+		features = append(features, featureFlag{Name: name, Value: featureValue(value), From: "command line"})
+	}
+	return features, nil
+}
+
+func validateFeatureName(name string) error {
+	variable, ok := strings.CutSuffix(name, featureDefaultSuffix)
+	if !ok || !layerKeyPattern.MatchString(variable) {
+		return fmt.Errorf("invalid feature flag %q: must be <variable>%s", name, featureDefaultSuffix)
+	}
+	return nil
+}
+
+// featureValue returns value as an HCL expression: itself if it's a literal
+// like true, 3 or ["a"], or a quoted string.
+func featureValue(value string) string {
+	expr, diags := hclsyntax.ParseExpression([]byte(value), "feature", hcl.InitialPos)
+	if diags.HasErrors() || len(expr.Variables()) > 0 {
+		return hclString(value)
+	}
+	if _, diags := expr.Value(nil); diags.HasErrors() {
+		return hclString(value)
+	}
+	return strings.TrimSpace(value)
+}
+
+// workspaceFeatures reads the features attribute of the workspace's generate
+// block, an object of flag names to values.
+func workspaceFeatures(ws *workspace) ([]featureFlag, error) {
+	val := ws.Config.Generate.Features
+	if val == cty.NilVal || val.IsNull() {
+		return nil, nil
+	}
+	if !val.Type().IsObjectType() && !val.Type().IsMapType() {
+		return nil, fmt.Errorf("%s: generate.features must be an object of feature flags", workspaceFileName)
+	}
+	var features []featureFlag
+	for it := val.ElementIterator(); it.Next(); {
+		key, value := it.Element()
+		if err := validateFeatureName(key.AsString()); err != nil {
+			return nil, fmt.Errorf("%s: %w", workspaceFileName, err)
+		}
+		features = append(features, featureFlag{Name: key.AsString(), Value: ctyValueToString(value), From: workspaceFileName})
+	}
+	return features, nil
+}
+
+// resolveFeatures combines the workspace's feature flags with the ones of
+// the command line, which win, sorted by name.
+func resolveFeatures(opts generateOptions, ws *workspace) ([]featureFlag, error) {
+	features, err := parseFeatures(opts.Features)
+	if err != nil {
+		return nil, err
+	}
+	if ws != nil {
+		inherited, err := workspaceFeatures(ws)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range inherited {
+			if !slices.ContainsFunc(features, func(set featureFlag) bool { return set.Name == f.Name }) {
+				features = append(features, f)
+			}
+		}
+	}
+	sort.Slice(features, func(i, j int) bool { return features[i].Name < features[j].Name })
+	return features, nil
+}
+
+// applyFeatures sets the defaults of the variables the features name,
+// recording which ones each feature changed. Features of variables the
+// module doesn't have are left unapplied, as a workspace's flags are meant
+// for every wrapper; required variables keep having no default.
+func applyFeatures(features []featureFlag, vars []moduleVariable) error {
+	for i, f := range features {
+		name := strings.TrimSuffix(f.Name, featureDefaultSuffix)
+		idx := slices.IndexFunc(vars, func(v moduleVariable) bool { return v.Name == name })
+		if idx < 0 {
+			continue
+		}
+		v := &vars[idx]
+		if v.DefaultKind == "required" {
+			fmt.Fprintf(os.Stderr, "Warning: feature %s not applied, %s is a required variable\n", f.Name, name)
+			continue
+		}
+		if err := checkFeatureType(f, v.Type); err != nil {
+			return err
+		}
+		v.Default = f.Value
+		v.DefaultKind = "feature"
+		features[i].Variable = name
+	}
+	return nil
+}
+
+// checkFeatureType checks that a feature's value converts to the type of the
+// variable it sets.
+func checkFeatureType(f featureFlag, typ string) error {
+	if typ == "" {
+		return nil
+	}
+	typeExpr, diags := parseTypeExpr(typ)
+	if diags.HasErrors() {
+		return nil
+	}
+	ty, diags := typeexpr.TypeConstraint(typeExpr)
+	if diags.HasErrors() {
+		return nil
+	}
+	expr, _ := hclsyntax.ParseExpression([]byte(f.Value), "feature", hcl.InitialPos)
+	val, _ := expr.Value(nil)
+	if _, err := convert.Convert(val, ty); err != nil {
+		return fmt.Errorf("invalid feature flag %s=%s (from %s): %s is a %s", f.Name, f.Value, f.From, strings.TrimSuffix(f.Name, featureDefaultSuffix), displayType(typ))
+	}
+	return nil
+}
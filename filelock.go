@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// defaultLockTimeout is how long we wait for another tfwrapper process to
+// release a lock before giving up.
+const defaultLockTimeout = 30 * time.Second
+
+const lockRetryInterval = 100 * time.Millisecond
+
+// fileLock is an advisory lock held on a sidecar ".flock" file, so that the
+// protected file itself can be replaced atomically while the lock is held.
+type fileLock struct {
+	f *os.File
+}
+
+// acquireFileLock takes an exclusive advisory lock for path, retrying until
+// timeout elapses.
+func acquireFileLock(path string, timeout time.Duration) (*fileLock, error) {
+	lockPath := path + ".flock"
+	if err := checkWrite(lockPath); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		f, locked, err := tryLockFile(lockPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if locked {
+			return &fileLock{f: f}, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out after %s waiting for lock on %s", timeout, path)
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
+
+func (l *fileLock) release() error {
+	return unlockFile(l.f)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := checkWrite(path); err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.tmp.%d", path, os.Getpid())
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
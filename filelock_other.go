@@ -0,0 +1,23 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// Without flock we fall back to exclusive creation of the lock file. A
+// crashed process leaves the file behind, which has to be removed by hand.
+func tryLockFile(path string) (*os.File, bool, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0644)
+	if os.IsExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return f, true, nil
+}
+
+func unlockFile(f *os.File) error {
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,3 @@
+locals {
+  config = jsondecode(var.config)
+}
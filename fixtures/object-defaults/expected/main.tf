@@ -0,0 +1,15 @@
+# Module source: example/bucket/aws
+# Version: 1.0.0
+# Generated by: tfwrapper selftest
+# Command: tfwrapper -source example/bucket/aws -version 1.0.0 -name object-defaults -annotate
+
+module "this" {
+  source  = "example/bucket/aws"
+  version = "1.0.0"
+
+  name            = lookup(local.config, "name", null)               # type: string; required (no default)
+  logging         = lookup(local.config, "logging", null)            # type: object({ target_prefix = string retention = number enabled = optional(bool, true) }); default too complex to copy, null
+  lifecycle_rules = try(local.config.lifecycle_rules, [])            # type: list(object({ id = string days = number enabled = optional(bool, true) })); default evaluated
+  allowed_methods = lookup(local.config, "allowed_methods", null)    # type: list(string); default too complex to copy, null
+  tags            = coalesce(lookup(local.config, "tags", null), {}) # type: map(string); default evaluated
+}
@@ -0,0 +1,12 @@
+output "id" {
+  description = "ID of the bucket"
+  value       = module.this.id
+}
+
+# Deprecated: every output as a single object, for consumers reading
+# module.<wrapper>.output.<name>. Read module.<wrapper>.<name> instead,
+# then regenerate with -legacy-output=false to drop it.
+output "output" {
+  description = "Deprecated: every output of the module as a single object"
+  value       = module.this
+}
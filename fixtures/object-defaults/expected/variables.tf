@@ -0,0 +1,5 @@
+variable "config" {
+  type        = any
+  description = "A JSON encoded object that contains the full object-defaults config"
+  default     = "{}"
+}
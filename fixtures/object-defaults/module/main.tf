@@ -0,0 +1,9 @@
+resource "terraform_data" "bucket" {
+  input = {
+    name            = var.name
+    logging         = var.logging
+    lifecycle_rules = var.lifecycle_rules
+    allowed_methods = var.allowed_methods
+    tags            = var.tags
+  }
+}
@@ -0,0 +1,4 @@
+output "id" {
+  description = "ID of the bucket"
+  value       = terraform_data.bucket.id
+}
@@ -0,0 +1,41 @@
+variable "name" {
+  description = "Name of the bucket"
+  type        = string
+}
+
+variable "logging" {
+  description = "Access logging, on by default"
+  type = object({
+    target_prefix = string
+    retention     = number
+    enabled       = optional(bool, true)
+  })
+  default = {
+    target_prefix = "logs/"
+    retention     = 30
+  }
+  nullable = false
+}
+
+variable "lifecycle_rules" {
+  description = "Lifecycle rules"
+  type = list(object({
+    id      = string
+    days    = number
+    enabled = optional(bool, true)
+  }))
+  default = []
+}
+
+variable "allowed_methods" {
+  description = "CORS methods"
+  type        = list(string)
+  default     = ["GET", "HEAD"]
+  nullable    = false
+}
+
+variable "tags" {
+  description = "Tags of the bucket"
+  type        = map(string)
+  default     = {}
+}
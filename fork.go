@@ -0,0 +1,255 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// upstreamForkDir is where a wrapper generated with -patch keeps the patched
+// copy of the upstream module it calls, relative to the wrapper directory.
+const upstreamForkDir = ".tfwrapper/fork"
+
+// upstreamPatchesDir is where the -patch files are copied, relative to the
+// wrapper directory, so the wrapper can be regenerated from anywhere.
+const upstreamPatchesDir = ".tfwrapper/patches"
+
+// patchProvenance records a -patch applied to the upstream module: the file,
+// relative to the wrapper directory, its digest and the files of the module
+// it changed.
+type patchProvenance struct {
+	File   string   `json:"file"`
+	SHA256 string   `json:"sha256"`
+	Files  []string `json:"files"`
+
+	data []byte
+}
+
+func runFork(args []string) {
+	fs := flag.NewFlagSet("fork", flag.ExitOnError)
+	patch := fs.String("patch", "", "Comma separated patch files to apply to the upstream module, in addition to the wrapper's current ones, relative to the current directory (or the workspace root); a patch named like a current one replaces it")
+	drop := fs.Bool("drop", false, "Drop every patch, calling the upstream module again")
+	allowBreaking := fs.Bool("allow-breaking", false, "Regenerate even though the patched module no longer has outputs the wrapper exports")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tfwrapper fork -patch <file>[,<file>...] | -drop <wrapper-dir>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || (*patch == "") == !*drop {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dir := filepath.Clean(fs.Arg(0))
+
+	opts, err := readWrapperOptions(dir)
+	if err != nil {
+		fatalError("Failed to read wrapper", err)
+	}
+	opts.operation = "fork"
+	opts.AllowBreaking = *allowBreaking
+	if *drop {
+		if len(opts.Patches) == 0 {
+			log.Fatalf("Error: %s has no patches to drop", dir)
+		}
+		opts.Patches = nil
+		if _, err := generateWrapper(opts); err != nil {
+			fatalError("Error", err)
+		}
+		for _, vendored := range []string{upstreamForkDir, upstreamPatchesDir} {
+			path := filepath.Join(dir, vendored)
+			if err := checkWrite(path); err != nil {
+				fatalError("Failed to remove the patched module", err)
+			}
+			if err := os.RemoveAll(path); err != nil {
+				log.Fatalf("Failed to remove the patched module: %v", err)
+			}
+		}
+		// Only removed when nothing else, like a snapshot, is kept there
+		os.Remove(filepath.Dir(filepath.Join(dir, upstreamForkDir)))
+		fmt.Printf("Dropped the patches of %s, it calls %s %s again\n", dir, opts.Source, displayVersion(opts.Version))
+		return
+	}
+
+	// Patches made against a moving branch wouldn't apply for long
+	if opts.Version == "" {
+		log.Fatalf("Error: %s isn't pinned to a version, pin one with upgrade -version before patching it", dir)
+	}
+	added := map[string]string{}
+	for _, p := range splitList(*patch) {
+		if other, ok := added[filepath.Base(p)]; ok {
+			log.Fatalf("Error: patches %s and %s have the same name, rename one of them", other, p)
+		}
+		added[filepath.Base(p)] = p
+		i := slices.IndexFunc(opts.Patches, func(current string) bool { return filepath.Base(current) == filepath.Base(p) })
+		if i < 0 {
+			opts.Patches = append(opts.Patches, p)
+		} else {
+			opts.Patches[i] = p
+		}
+	}
+	if _, err := generateWrapper(opts); err != nil {
+		fatalError("Error", err)
+	}
+	fmt.Printf("Forked %s at %s %s with %s, vendored in %s\n", dir, opts.Source, displayVersion(opts.Version), strings.Join(opts.Patches, ", "), filepath.Join(dir, upstreamForkDir))
+}
+
+// patchFile returns the path a -patch file is read from. Files copied into
+// the wrapper are relative to wrapperDir, others to the workspace root, or
+// the current directory when root is "".
+func patchFile(file, wrapperDir, root string) string {
+	switch {
+	case filepath.IsAbs(file):
+		return file
+	case strings.HasPrefix(filepath.ToSlash(file), upstreamPatchesDir+"/"):
+		return filepath.Join(wrapperDir, file)
+	case root != "":
+		return filepath.Join(root, file)
+	}
+	return file
+}
+
+// patchModule applies the -patch files, whose paths in the patches are
+// relative to the root of the downloaded repository like the output of git
+// diff, to a copy of repoDir in tmpDir, as a cached download is shared. It
+// returns the repository and module directories of the copy.
+func patchModule(sb sandbox, opts generateOptions, files []string, tmpDir, repoDir, modulePath string) (string, string, []patchProvenance, error) {
+	rel, err := filepath.Rel(repoDir, modulePath)
+	if err != nil {
+		return "", "", nil, err
+	}
+	patched := filepath.Join(tmpDir, "patched")
+	if err := copyDir(repoDir, patched); err != nil {
+		return "", "", nil, fmt.Errorf("failed to copy module: %w", err)
+	}
+	applied, err := applyPatches(sb, opts, files, patched)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return patched, filepath.Join(patched, rel), applied, nil
+}
+
+// applyPatches applies the patch files to repoDir. Each is recorded as the
+// copy vendorPatches writes into the wrapper.
+func applyPatches(sb sandbox, opts generateOptions, files []string, repoDir string) ([]patchProvenance, error) {
+	var applied []patchProvenance
+	for i, file := range files {
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		data, err := os.ReadFile(abs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read patch: %w", err)
+		}
+		vendored := path.Join(upstreamPatchesDir, filepath.Base(file))
+		if j := slices.IndexFunc(applied, func(p patchProvenance) bool { return p.File == vendored }); j >= 0 {
+			return nil, fmt.Errorf("patches %s and %s have the same name, rename one of them", opts.Patches[j], opts.Patches[i])
+		}
+		stat, err := sb.run(repoDir, "git", "apply", "--numstat", abs)
+		if err == nil {
+			_, err = sb.run(repoDir, "git", "apply", "--whitespace=nowarn", abs)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("patch %s doesn't apply to %s %s, drop it if upstream fixed the issue: %w", opts.Patches[i], opts.Source, displayVersion(opts.Version), err)
+		}
+		p := patchProvenance{File: vendored, SHA256: digest(data), data: data}
+		for _, line := range strings.Split(strings.TrimSpace(string(stat)), "\n") {
+			// added, deleted and path, tab separated
+			if fields := strings.SplitN(line, "\t", 3); len(fields) == 3 {
+				p.Files = append(p.Files, fields[2])
+			}
+		}
+		applied = append(applied, p)
+	}
+	return applied, nil
+}
+
+// forkSource returns the source main.tf calls the patched copy of the module
+// at modulePath by, once vendorFork copied repoDir into the wrapper.
+func forkSource(repoDir, modulePath string) string {
+	source := "./" + upstreamForkDir
+	if rel, err := filepath.Rel(repoDir, modulePath); err == nil && rel != "." {
+		source = path.Join(source, filepath.ToSlash(rel))
+	}
+	return source
+}
+
+// vendorFork copies the patched repository in repoDir into the wrapper,
+// leaving out git metadata. Files that are symlinks to somewhere outside
+// repoDir are refused, and files matched by the wrapper's .tfwrapperignore
+// patterns in ignore are left alone, neither written nor removed.
+func vendorFork(out outputSink, repoDir, wrapperDir string, ignore []string) error {
+	forkDir := filepath.Join(wrapperDir, upstreamForkDir)
+	written := map[string]bool{}
+	err := walkTree(repoDir, func(p, rel string, info fs.FileInfo) error {
+		target := filepath.Join(forkDir, rel)
+		if info.IsDir() {
+			if info.Name() == ".git" || info.Name() == ".terraform" {
+				return filepath.SkipDir
+			}
+			return out.MkdirAll(target)
+		}
+		if ignored(ignore, upstreamForkDir+"/"+filepath.ToSlash(rel)) {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		written[rel] = true
+		return out.WriteFile(target, data, info.Mode().Perm())
+	})
+	if err != nil {
+		return err
+	}
+
+	// Files upstream deleted since the last fork mustn't linger
+	existing, err := existingFiles(out, forkDir, ".git", ".terraform")
+	if err != nil {
+		return err
+	}
+	for _, rel := range existing {
+		if !written[rel] && !ignored(ignore, upstreamForkDir+"/"+filepath.ToSlash(rel)) {
+			if err := out.Remove(filepath.Join(forkDir, rel)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// vendorPatches copies the applied patches into the wrapper, removing those
+// of an earlier generation that are no longer applied.
+func vendorPatches(out outputSink, patches []patchProvenance, wrapperDir string) error {
+	patchesDir := filepath.Join(wrapperDir, upstreamPatchesDir)
+	existing, err := existingFiles(out, patchesDir)
+	if err != nil {
+		return err
+	}
+	for _, rel := range existing {
+		applied := slices.ContainsFunc(patches, func(p patchProvenance) bool {
+			return p.File == path.Join(upstreamPatchesDir, filepath.ToSlash(rel))
+		})
+		if !applied {
+			if err := out.Remove(filepath.Join(patchesDir, rel)); err != nil {
+				return err
+			}
+		}
+	}
+	if err := out.MkdirAll(patchesDir); err != nil {
+		return err
+	}
+	for _, p := range patches {
+		if err := out.WriteFile(filepath.Join(wrapperDir, filepath.FromSlash(p.File)), p.data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
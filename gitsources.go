@@ -0,0 +1,151 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var (
+	bitbucketServerPath = regexp.MustCompile(`^(.*)/projects/([^/]+)/repos/([^/]+)`)
+	scpLikeURL          = regexp.MustCompile(`^[\w.-]+@[\w.-]+:[^/]`)
+)
+
+// hostedGitURL recognizes Azure DevOps and Bitbucket Server addresses and
+// returns the URL to clone them with.
+func hostedGitURL(source string) (string, bool) {
+	// git@ssh.dev.azure.com:v3/org/project/repo, git@bitbucket.example.com:proj/repo.git
+	if scpLikeURL.MatchString(source) {
+		return source, true
+	}
+
+	withScheme := source
+	if !strings.Contains(source, "://") {
+		withScheme = "https://" + source
+	}
+	u, err := url.Parse(withScheme)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+
+	switch {
+	// dev.azure.com/org/project/_git/repo and org.visualstudio.com/project/_git/repo
+	case strings.Contains(u.Path, "/_git/"):
+		return withScheme, true
+
+	// Bitbucket Server browse URLs: host/projects/PROJ/repos/repo/browse
+	case bitbucketServerPath.MatchString(u.Path):
+		m := bitbucketServerPath.FindStringSubmatch(u.Path)
+		u.Path = fmt.Sprintf("%s/scm/%s/%s.git", m[1], strings.ToLower(m[2]), m[3])
+		u.RawQuery, u.Fragment = "", ""
+		return u.String(), true
+
+	// Bitbucket Server clone URLs: host/scm/proj/repo.git
+	case strings.Contains(u.Path, "/scm/") && u.Host != "github.com":
+		return withScheme, true
+	}
+	return "", false
+}
+
+// gitAuthHeader returns an HTTP Authorization header for cloning from Azure
+// DevOps (AZURE_DEVOPS_PAT or AZURE_DEVOPS_EXT_PAT) or Bitbucket Server
+// (BITBUCKET_TOKEN), or "" when no token is set.
+func gitAuthHeader(cloneURL string) string {
+	u, err := url.Parse(cloneURL)
+	if err != nil || u.Scheme != "https" {
+		return ""
+	}
+	switch {
+	case strings.Contains(u.Path, "/_git/"):
+		pat := os.Getenv("AZURE_DEVOPS_PAT")
+		if pat == "" {
+			pat = os.Getenv("AZURE_DEVOPS_EXT_PAT")
+		}
+		if pat != "" {
+			return "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(":"+pat))
+		}
+	case strings.Contains(u.Path, "/scm/"):
+		if token := os.Getenv("BITBUCKET_TOKEN"); token != "" {
+			return "Authorization: Bearer " + token
+		}
+	}
+	return ""
+}
+
+// withGitHeader returns a copy of sb whose git commands send header with
+// every HTTP request. It is passed through the environment rather than as a
+// -c argument so the token doesn't show up in process listings or errors.
+func (sb sandbox) withGitHeader(header string) sandbox {
+	if header == "" {
+		return sb
+	}
+	sb.GitConfig = append(append([]string{}, sb.GitConfig...), "http.extraHeader="+header)
+	return sb
+}
+
+// fetchGitHubTarball downloads a GitHub repository at ref through the API
+// (GITHUB_API_URL, with GITHUB_TOKEN or GH_TOKEN if set) into repoDir, for
+// -no-exec, where git can't run. It reports false for other hosts.
+func fetchGitHubTarball(sb sandbox, cloneURL, ref, destDir, repoDir string) (bool, error) {
+	u, err := url.Parse(cloneURL)
+	if err != nil || u.Host != "github.com" && os.Getenv("GITHUB_API_URL") == "" {
+		return false, nil
+	}
+	api := os.Getenv("GITHUB_API_URL")
+	if api == "" {
+		api = "https://api.github.com"
+	}
+	project := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+	tarball := strings.TrimSuffix(api, "/") + "/repos/" + project + "/tarball"
+	if ref != "" {
+		tarball += "/" + url.PathEscape(ref)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, tarball, nil)
+	if err != nil {
+		return true, err
+	}
+	token := os.Getenv("GITHUB_TOKEN")
+	if token == "" {
+		token = os.Getenv("GH_TOKEN")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := (&http.Client{Timeout: sb.Timeout}).Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return true, fmt.Errorf("%w: %s", ErrSourceNotFound, tarball)
+	} else if resp.StatusCode/100 != 2 {
+		return true, fmt.Errorf("failed to download %s: %s", tarball, resp.Status)
+	}
+	gz, err := gzip.NewReader(io.LimitReader(resp.Body, maxArchiveSize))
+	if err != nil {
+		return true, err
+	}
+	defer gz.Close()
+	extractDir := filepath.Join(destDir, "tarball")
+	if err := extractTar(gz, extractDir); err != nil {
+		return true, err
+	}
+
+	// The files are wrapped in an owner-repo-commit directory
+	entries, err := os.ReadDir(extractDir)
+	if err != nil {
+		return true, err
+	}
+	if len(entries) != 1 || !entries[0].IsDir() {
+		return true, fmt.Errorf("unexpected layout of %s", tarball)
+	}
+	return true, os.Rename(filepath.Join(extractDir, entries[0].Name()), repoDir)
+}
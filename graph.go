@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// graphNode is a wrapper, an upstream module or a module call in a stack.
+type graphNode struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	Label   string `json:"label"`
+	Path    string `json:"path,omitempty"`
+	Source  string `json:"source,omitempty"`
+	Version string `json:"version,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+}
+
+// graphEdge kinds are "wraps" (wrapper to upstream), "uses" (module call to
+// the wrapper or module it calls) and "depends_on" (between module calls).
+type graphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Kind string `json:"kind"`
+}
+
+type moduleGraph struct {
+	Nodes []graphNode `json:"nodes"`
+	Edges []graphEdge `json:"edges"`
+
+	seen map[string]bool
+}
+
+func runGraph(args []string) {
+	fs := flag.NewFlagSet("graph", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory to scan for wrappers and the stacks calling them")
+	format := fs.String("format", "dot", "Output format: dot or json")
+	excludeDirs := fs.String("exclude-dirs", strings.Join(defaultExcludeDirs, ","), "Comma separated directories to skip, names or paths relative to -dir, besides hidden ones and the .terraformignore patterns")
+	fs.Parse(args)
+
+	if *format != "dot" && *format != "json" {
+		log.Fatalf("Error: unknown format %q (expected dot or json)", *format)
+	}
+
+	exclude, err := excludePatterns(*dir, *excludeDirs)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	g, err := buildModuleGraph(*dir, exclude)
+	if err != nil {
+		fatalError("Failed to build graph", err)
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(g); err != nil {
+			log.Fatalf("Failed to write graph: %v", err)
+		}
+		return
+	}
+	writeDOT(os.Stdout, g)
+}
+
+// buildModuleGraph finds every wrapper below dir (a directory with a
+// .tfwrapper.json) and every module call in the remaining Terraform code.
+// Calls are linked to the wrappers or upstream modules they use and to the
+// other calls in the same directory they reference. Directories matching the
+// exclude patterns are skipped.
+func buildModuleGraph(dir string, exclude []string) (*moduleGraph, error) {
+	g := &moduleGraph{seen: map[string]bool{}}
+	root, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	rel := func(path string) string {
+		if r, err := filepath.Rel(root, path); err == nil {
+			return filepath.ToSlash(r)
+		}
+		return filepath.ToSlash(path)
+	}
+
+	// Wrappers in the workspace index don't need their metadata read
+	var files []string
+	wrappers := map[string]bool{}
+	indexed, _, err := indexedWrappers(root)
+	if err != nil {
+		return nil, err
+	}
+	for wrapperDir, entry := range indexed {
+		wrappers[wrapperDir] = true
+		g.addWrapper(rel(wrapperDir), entry.Source, entry.Version, entry.Commit, entry.WrapperVersion)
+	}
+	err = walkTerraformFiles(root, exclude, func(path string) error {
+		files = append(files, path)
+		wrapperDir := filepath.Dir(path)
+		if _, ok := wrappers[wrapperDir]; ok {
+			return nil
+		}
+		prov, err := readMetadata(wrapperDir)
+		if err != nil {
+			return err
+		}
+		wrappers[wrapperDir] = prov != nil
+		if prov == nil {
+			return nil
+		}
+		g.addWrapper(rel(wrapperDir), prov.Source, prov.Version, prov.Commit, prov.WrapperVersion)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range files {
+		if wrappers[filepath.Dir(path)] {
+			continue
+		}
+		if err := g.addModuleCalls(path, rel, wrappers); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(g.Nodes, func(i, j int) bool { return g.Nodes[i].ID < g.Nodes[j].ID })
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].From != g.Edges[j].From {
+			return g.Edges[i].From < g.Edges[j].From
+		}
+		return g.Edges[i].To < g.Edges[j].To
+	})
+	return g, nil
+}
+
+// addWrapper adds the wrapper at path, linked to the upstream module it
+// wraps.
+func (g *moduleGraph) addWrapper(path, source, version, commit, wrapperVersion string) {
+	id := "wrapper:" + path
+	label := path
+	if wrapperVersion != "" {
+		label += " " + wrapperVersion
+	}
+	g.addNode(graphNode{ID: id, Kind: "wrapper", Label: label, Path: path, Version: wrapperVersion})
+	g.addEdge(id, g.addUpstream(source, version, commit), "wraps")
+}
+
+// addModuleCalls adds the module blocks of the file at path.
+func (g *moduleGraph) addModuleCalls(path string, rel func(string) string, wrappers map[string]bool) error {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	file, diags := hclsyntax.ParseConfig(src, path, hcl.InitialPos)
+	if diags.HasErrors() {
+		return newParseError(diags, map[string]*hcl.File{path: file})
+	}
+
+	dir := filepath.Dir(path)
+	callID := func(name string) string {
+		return "call:" + rel(dir) + ":module." + name
+	}
+	for _, block := range file.Body.(*hclsyntax.Body).Blocks {
+		if block.Type != "module" || len(block.Labels) != 1 {
+			continue
+		}
+		id := callID(block.Labels[0])
+		g.addNode(graphNode{ID: id, Kind: "call", Label: "module." + block.Labels[0], Path: rel(dir)})
+
+		source := syntaxAttrString(block.Body, "source")
+		switch {
+		case source == "":
+		case strings.HasPrefix(source, "./") || strings.HasPrefix(source, "../"):
+			target := filepath.Join(dir, filepath.FromSlash(source))
+			if wrappers[target] {
+				g.addEdge(id, "wrapper:"+rel(target), "uses")
+			} else {
+				local := "local:" + rel(target)
+				g.addNode(graphNode{ID: local, Kind: "local", Label: rel(target), Path: rel(target)})
+				g.addEdge(id, local, "uses")
+			}
+		default:
+			g.addEdge(id, g.addUpstream(source, syntaxAttrString(block.Body, "version"), ""), "uses")
+		}
+
+		// References to other module calls, including depends_on
+		for _, attr := range block.Body.Attributes {
+			for _, traversal := range attr.Expr.Variables() {
+				if traversal.RootName() != "module" || len(traversal) < 2 {
+					continue
+				}
+				if step, ok := traversal[1].(hcl.TraverseAttr); ok && step.Name != block.Labels[0] {
+					g.addEdge(id, callID(step.Name), "depends_on")
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (g *moduleGraph) addUpstream(source, version, commit string) string {
+	id := "upstream:" + source + "@" + displayVersion(version)
+	label := source + " " + displayVersion(version)
+	if len(commit) >= 7 {
+		label += " (" + commit[:7] + ")"
+	}
+	g.addNode(graphNode{ID: id, Kind: "upstream", Label: label, Source: source, Version: version, Commit: commit})
+	return id
+}
+
+func (g *moduleGraph) addNode(n graphNode) {
+	if !g.seen[n.ID] {
+		g.seen[n.ID] = true
+		g.Nodes = append(g.Nodes, n)
+	}
+}
+
+func (g *moduleGraph) addEdge(from, to, kind string) {
+	key := from + "\x00" + to + "\x00" + kind
+	if !g.seen[key] {
+		g.seen[key] = true
+		g.Edges = append(g.Edges, graphEdge{From: from, To: to, Kind: kind})
+	}
+}
+
+// writeDOT renders g for Graphviz, with the module calls of each directory
+// in a cluster.
+func writeDOT(w io.Writer, g *moduleGraph) {
+	shapes := map[string]string{"wrapper": "box", "upstream": "ellipse", "call": "component", "local": "folder"}
+	fmt.Fprintln(w, "digraph tfwrapper {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+
+	clusters := map[string][]graphNode{}
+	var clusterDirs []string
+	for _, n := range g.Nodes {
+		if n.Kind == "call" {
+			if _, ok := clusters[n.Path]; !ok {
+				clusterDirs = append(clusterDirs, n.Path)
+			}
+			clusters[n.Path] = append(clusters[n.Path], n)
+			continue
+		}
+		fmt.Fprintf(w, "  %q [label=%q, shape=%s];\n", n.ID, n.Label, shapes[n.Kind])
+	}
+	sort.Strings(clusterDirs)
+	for i, dir := range clusterDirs {
+		fmt.Fprintf(w, "  subgraph cluster_%d {\n    label=%q;\n", i, dir)
+		for _, n := range clusters[dir] {
+			fmt.Fprintf(w, "    %q [label=%q, shape=%s];\n", n.ID, n.Label, shapes[n.Kind])
+		}
+		fmt.Fprintln(w, "  }")
+	}
+	for _, e := range g.Edges {
+		style := ""
+		if e.Kind == "depends_on" {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(w, "  %q -> %q [label=%q%s];\n", e.From, e.To, e.Kind, style)
+	}
+	fmt.Fprintln(w, "}")
+}
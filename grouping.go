@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// bannerPattern matches section banner comments such as "#### Logging ####"
+// or "# ---- Logging ----".
+var bannerPattern = regexp.MustCompile(`^#+\s*[#=*-]{2,}\s*(\S.*?)\s*[#=*-]{2,}\s*$`)
+
+// bannerTitle returns the title of the last banner in comment, if any.
+func bannerTitle(comment string) string {
+	title := ""
+	for _, line := range strings.Split(comment, "\n") {
+		if m := bannerPattern.FindStringSubmatch(strings.TrimSpace(line)); m != nil {
+			title = m[1]
+		}
+	}
+	return title
+}
+
+// stripBanner removes banner lines, which are replaced by group headings,
+// from a variable's comment.
+func stripBanner(comment string) string {
+	var kept []string
+	for _, line := range strings.Split(comment, "\n") {
+		if bannerPattern.MatchString(strings.TrimSpace(line)) {
+			continue
+		}
+		kept = append(kept, line)
+	}
+	return strings.TrimSpace(strings.Join(kept, "\n"))
+}
+
+func validateGroupBy(groupBy string) error {
+	switch groupBy {
+	case "", "file", "section":
+		return nil
+	}
+	return fmt.Errorf("invalid -group-by %q: must be file or section", groupBy)
+}
+
+// variableFiles lists the upstream files to read variables from. Grouping
+// by file reads every top-level .tf file but override and hidden files,
+// variables.tf first.
+func variableFiles(fsys fs.FS, groupBy string) ([]string, error) {
+	if err := validateGroupBy(groupBy); err != nil {
+		return nil, err
+	}
+	if groupBy != "file" {
+		return []string{"variables.tf"}, nil
+	}
+	files, err := fs.Glob(fsys, "*.tf")
+	if err != nil {
+		return nil, err
+	}
+	// Variables in override files redeclare ones of the other files, and
+	// Terraform ignores hidden files, like editor backups
+	files = slices.DeleteFunc(files, func(name string) bool {
+		return isOverrideFile(name) || strings.HasPrefix(name, ".")
+	})
+	sort.Slice(files, func(i, j int) bool {
+		if (files[i] == "variables.tf") != (files[j] == "variables.tf") {
+			return files[i] == "variables.tf"
+		}
+		return files[i] < files[j]
+	})
+	return files, nil
+}
+
+func groupTitle(v moduleVariable, groupBy string) string {
+	switch groupBy {
+	case "file":
+		return v.File
+	case "section":
+		return v.Section
+	}
+	return ""
+}
+
+// groupVariables orders vars so each group is contiguous, groups in order of
+// first appearance and upstream order within a group.
+func groupVariables(vars []moduleVariable, groupBy string) []moduleVariable {
+	if groupBy == "" {
+		return vars
+	}
+	first := map[string]int{}
+	for i, v := range vars {
+		if _, ok := first[groupTitle(v, groupBy)]; !ok {
+			first[groupTitle(v, groupBy)] = i
+		}
+	}
+	grouped := append([]moduleVariable(nil), vars...)
+	sort.SliceStable(grouped, func(i, j int) bool {
+		return first[groupTitle(grouped[i], groupBy)] < first[groupTitle(grouped[j], groupBy)]
+	})
+	return grouped
+}
+
+func validateSort(order string) error {
+	switch order {
+	case "", "upstream", "alpha", "required-first":
+		return nil
+	}
+	return fmt.Errorf("invalid -sort %q: must be upstream, alpha or required-first", order)
+}
+
+// sortVariables orders vars for main.tf. Banner comments only make sense in
+// upstream order, so they are dropped from any other order.
+func sortVariables(vars []moduleVariable, order string) []moduleVariable {
+	if order == "" || order == "upstream" {
+		return vars
+	}
+	sorted := append([]moduleVariable(nil), vars...)
+	for i := range sorted {
+		sorted[i].Comment = stripBanner(sorted[i].Comment)
+	}
+	switch order {
+	case "alpha":
+		sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	case "required-first":
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].DefaultKind == "required" && sorted[j].DefaultKind != "required"
+		})
+	}
+	return sorted
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+	"github.com/zclconf/go-cty/cty"
+)
+
+// hclKeywords can't be used as bare object keys: they would be read as
+// literals or as the start of a for expression.
+var hclKeywords = map[string]bool{
+	"true": true, "false": true, "null": true, "for": true, "in": true, "if": true,
+}
+
+// hclString returns s as an HCL string literal, escaping quotes,
+// backslashes, control characters and ${ / %{ template sequences.
+func hclString(s string) string {
+	return string(hclwrite.TokensForValue(cty.StringVal(s)).Bytes())
+}
+
+// hclKey returns name as an object constructor key, quoted unless it is an
+// identifier that can't be mistaken for a keyword.
+func hclKey(name string) string {
+	if hclsyntax.ValidIdentifier(name) && !hclKeywords[name] {
+		return name
+	}
+	return hclString(name)
+}
+
+// validateVariableNames rejects upstream variables whose names can't be
+// written as module arguments.
+func validateVariableNames(vars []moduleVariable) error {
+	for _, v := range vars {
+		if !hclsyntax.ValidIdentifier(v.Name) {
+			return fmt.Errorf("%w: variable %s in %s is not a valid identifier and can't be passed as a module argument", ErrUnsupportedFeature, hclString(v.Name), v.File)
+		}
+	}
+	return nil
+}
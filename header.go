@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// toolVersion is set at build time with -ldflags "-X main.toolVersion=...".
+var toolVersion = "dev"
+
+// bannerData is available to header and footer templates.
+type bannerData struct {
+	ToolVersion string
+	Source      string
+	Version     string
+	Name        string
+	File        string
+}
+
+// loadBanner returns the header/footer template text for value, which is
+// either the template itself or "@path" to read it from a file.
+func loadBanner(value string) (string, error) {
+	if path, ok := strings.CutPrefix(value, "@"); ok {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return string(src), nil
+	}
+	return value, nil
+}
+
+// renderBanner executes a header/footer template and turns the result into
+// comment lines. Every file we generate (HCL, Makefile, YAML) uses # comments.
+func renderBanner(text string, data bannerData) (string, error) {
+	tmpl, err := template.New("banner").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid header/footer template: %w", err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render header/footer: %w", err)
+	}
+
+	var b strings.Builder
+	for _, line := range strings.Split(strings.TrimRight(buf.String(), "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "#"):
+			b.WriteString(line)
+		case strings.TrimSpace(line) == "":
+			b.WriteString("#")
+		default:
+			b.WriteString("# " + line)
+		}
+		b.WriteString("\n")
+	}
+	return b.String(), nil
+}
+
+// applyBanners adds the rendered header and footer to each file that can
+// hold # comments.
+func applyBanners(files []generatedFile, header, footer string, data bannerData) ([]generatedFile, error) {
+	if header == "" && footer == "" {
+		return files, nil
+	}
+	for i, f := range files {
+		if ext := filepath.Ext(f.Name); ext == ".json" || ext == ".md" {
+			continue
+		}
+		data.File = f.Name
+		content := f.Content
+		if header != "" {
+			h, err := renderBanner(header, data)
+			if err != nil {
+				return nil, err
+			}
+			content = h + "\n" + content
+		}
+		if footer != "" {
+			ft, err := renderBanner(footer, data)
+			if err != nil {
+				return nil, err
+			}
+			content = strings.TrimRight(content, "\n") + "\n\n" + ft
+		}
+		files[i].Content = content
+	}
+	return files, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// staleAfter is how long a repository may go without a commit before
+// -fail-if stale refuses it.
+const staleAfter = 365 * 24 * time.Hour
+
+// healthConditions are the -fail-if conditions of inspect.
+var healthConditions = []string{"archived", "stale", "unlicensed"}
+
+// moduleHealth are the maintenance signals of the repository hosting a
+// module, read from the GitHub or GitLab API.
+type moduleHealth struct {
+	Repository string `json:"repository"`
+	// License is the SPDX identifier (GitHub) or license key (GitLab) of
+	// the license, "" if the host detected none
+	License    string    `json:"license,omitempty"`
+	LastCommit time.Time `json:"last_commit"`
+	OpenIssues int       `json:"open_issues"`
+	Archived   bool      `json:"archived"`
+}
+
+// validateFailIf checks the -fail-if conditions.
+func validateFailIf(conditions []string) error {
+	for _, condition := range conditions {
+		if !slices.Contains(healthConditions, condition) {
+			return fmt.Errorf("invalid -fail-if %q: must be %s", condition, strings.Join(healthConditions, ", "))
+		}
+	}
+	return nil
+}
+
+// healthFailures returns the -fail-if conditions health meets.
+func healthFailures(health *moduleHealth, conditions []string) []string {
+	var failures []string
+	for _, condition := range conditions {
+		switch {
+		case condition == "archived" && health.Archived:
+			failures = append(failures, "the repository is archived")
+		case condition == "stale" && time.Since(health.LastCommit) > staleAfter:
+			failures = append(failures, fmt.Sprintf("the last commit was on %s, over a year ago", health.LastCommit.Format(time.DateOnly)))
+		case condition == "unlicensed" && health.License == "":
+			failures = append(failures, "the repository has no license")
+		}
+	}
+	return failures
+}
+
+// fetchHealth reads the health signals of the GitHub or GitLab repository
+// source is cloned from. Registry addresses of private registries and
+// archives have no repository to ask about.
+func fetchHealth(sb sandbox, source string) (*moduleHealth, error) {
+	moduleSource, _ := splitSourceSubPath(source)
+	if isRegistryAddress(moduleSource) || archiveFormat(moduleSource) != "" || strings.Contains(moduleSource, "::") && !strings.HasPrefix(moduleSource, "git::") {
+		return nil, fmt.Errorf("%w: health signals need a GitHub or GitLab repository, not %s", ErrUnsupportedFeature, moduleSource)
+	}
+	cloneURL, _ := gitCloneURL(strings.TrimPrefix(moduleSource, "git::"))
+	host, project, err := parseRemote(cloneURL)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := defaultResolver().health(host+"/"+project, func() (string, error) {
+		health, err := lookupHealth(&http.Client{Timeout: sb.Timeout}, host, project)
+		if err != nil {
+			return "", err
+		}
+		data, err := json.Marshal(health)
+		return string(data), err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var health moduleHealth
+	if err := json.Unmarshal([]byte(encoded), &health); err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// lookupHealth asks the API of host about project. github.com (or
+// GITHUB_API_URL) is reached with GITHUB_TOKEN or GH_TOKEN if set, hosts
+// with "gitlab" in their name (or GITLAB_API_URL) with GITLAB_TOKEN, which
+// private repositories and higher rate limits need.
+func lookupHealth(client *http.Client, host, project string) (*moduleHealth, error) {
+	health := &moduleHealth{Repository: host + "/" + project}
+	switch {
+	case host == "github.com" || os.Getenv("GITHUB_API_URL") != "":
+		api := os.Getenv("GITHUB_API_URL")
+		if api == "" {
+			api = "https://api.github.com"
+		}
+		headers := map[string]string{"Accept": "application/vnd.github+json"}
+		token := os.Getenv("GITHUB_TOKEN")
+		if token == "" {
+			token = os.Getenv("GH_TOKEN")
+		}
+		if token != "" {
+			headers["Authorization"] = "Bearer " + token
+		}
+		repoURL := strings.TrimSuffix(api, "/") + "/repos/" + project
+		var repo struct {
+			Archived   bool `json:"archived"`
+			OpenIssues int  `json:"open_issues_count"`
+			License    *struct {
+				SPDXID string `json:"spdx_id"`
+			} `json:"license"`
+		}
+		if err := getJSON(client, repoURL, headers, &repo); err != nil {
+			return nil, err
+		}
+		var commits []struct {
+			Commit struct {
+				Committer struct {
+					Date time.Time `json:"date"`
+				} `json:"committer"`
+			} `json:"commit"`
+		}
+		if err := getJSON(client, repoURL+"/commits?per_page=1", headers, &commits); err != nil {
+			return nil, err
+		}
+		health.Archived, health.OpenIssues = repo.Archived, repo.OpenIssues
+		// GitHub reports licenses it can't identify as NOASSERTION
+		if repo.License != nil && repo.License.SPDXID != "NOASSERTION" {
+			health.License = repo.License.SPDXID
+		}
+		if len(commits) > 0 {
+			health.LastCommit = commits[0].Commit.Committer.Date
+		}
+		return health, nil
+
+	case strings.Contains(host, "gitlab") || os.Getenv("GITLAB_API_URL") != "":
+		api := os.Getenv("GITLAB_API_URL")
+		if api == "" {
+			api = "https://" + host + "/api/v4"
+		}
+		headers := map[string]string{}
+		if token := os.Getenv("GITLAB_TOKEN"); token != "" {
+			headers["PRIVATE-TOKEN"] = token
+		}
+		projectURL := strings.TrimSuffix(api, "/") + "/projects/" + url.PathEscape(project)
+		var repo struct {
+			Archived   bool `json:"archived"`
+			OpenIssues int  `json:"open_issues_count"`
+			License    *struct {
+				Key string `json:"key"`
+			} `json:"license"`
+		}
+		if err := getJSON(client, projectURL+"?license=true", headers, &repo); err != nil {
+			return nil, err
+		}
+		var commits []struct {
+			CommittedDate time.Time `json:"committed_date"`
+		}
+		if err := getJSON(client, projectURL+"/repository/commits?per_page=1", headers, &commits); err != nil {
+			return nil, err
+		}
+		health.Archived, health.OpenIssues = repo.Archived, repo.OpenIssues
+		if repo.License != nil {
+			health.License = repo.License.Key
+		}
+		if len(commits) > 0 {
+			health.LastCommit = commits[0].CommittedDate
+		}
+		return health, nil
+	}
+	return nil, fmt.Errorf("%w: health signals need a GitHub or GitLab repository, %s is neither (set GITHUB_API_URL or GITLAB_API_URL)", ErrUnsupportedFeature, host)
+}
+
+// getJSON fetches url and decodes the JSON response into v.
+func getJSON(client *http.Client, url string, headers map[string]string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s responded %s", url, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", url, err)
+	}
+	return nil
+}
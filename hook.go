@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// generatedAtLine matches the -timestamp line of the provenance comment,
+// which changes on every generation.
+var generatedAtLine = regexp.MustCompile(`(?m)^# Generated at: .*\n`)
+
+func runHook(args []string) {
+	fs := flag.NewFlagSet("hook", flag.ExitOnError)
+	check := fs.Bool("check", false, "Fail with a diff when a wrapper differs from a fresh generation from its recorded source and version")
+	dir := fs.String("dir", ".", "Directory to look for wrappers in")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tfwrapper hook -check [flags] [<file>...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if !*check {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// pre-commit and lefthook pass the staged files; only the wrappers
+	// containing them are checked
+	var wrappers []string
+	if fs.NArg() > 0 {
+		for _, file := range fs.Args() {
+			if w := wrapperOf(file); w != "" && !slices.Contains(wrappers, w) {
+				wrappers = append(wrappers, w)
+			}
+		}
+	} else {
+		indexed, ok, err := indexedWrappers(*dir)
+		if err != nil {
+			log.Fatalf("Failed to read %s: %v", indexPath, err)
+		}
+		if wrappers = sortedKeys(indexed); !ok {
+			if wrappers, err = findWrapperDirs(*dir); err != nil {
+				log.Fatalf("Failed to find wrappers: %v", err)
+			}
+		}
+	}
+
+	cache, err := newModuleCache()
+	if err != nil {
+		log.Fatalf("Failed to create download cache: %v", err)
+	}
+	defer cache.Close()
+
+	color := isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == ""
+	var stale, moved []string
+	for _, w := range wrappers {
+		changes, err := checkWrapper(w, cache)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", w, err)
+			if errors.Is(err, errPinMoved) {
+				moved = append(moved, w)
+			} else {
+				stale = append(stale, w)
+			}
+			continue
+		}
+		if changes == "" {
+			continue
+		}
+		if color {
+			changes = colorizeDiff(changes)
+		}
+		fmt.Print(changes)
+		stale = append(stale, w)
+	}
+	if len(moved) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: %d of %d wrappers are pinned to upstream versions that no longer match their locked commit (%s)\n", len(moved), len(wrappers), strings.Join(moved, ", "))
+	}
+	if len(stale) > 0 {
+		log.Fatalf("Error: %d of %d wrappers differ from a fresh generation (%s). Generated files must not be edited by hand: regenerate with tfwrapper upgrade, or list hand-maintained files in %s", len(stale), len(wrappers), strings.Join(stale, ", "), ignoreFileName)
+	}
+	if len(moved) > 0 {
+		os.Exit(1)
+	}
+}
+
+// checkWrapper regenerates the wrapper in dir in memory from the options in
+// its metadata, verifies that its pinned version still resolves to the
+// locked commit and returns a diff of the generated files against disk. The
+// metadata itself is left out, as it records the tool version that did the
+// check.
+func checkWrapper(dir string, cache *moduleCache) (string, error) {
+	prev, err := readMetadata(dir)
+	if err != nil {
+		return "", err
+	}
+	if prev == nil {
+		return "", fmt.Errorf("%s not found", metadataFileName)
+	}
+	opts := prev.Options
+	opts.OutputDir = filepath.Dir(dir)
+	if wrapperName(opts) != filepath.Base(dir) {
+		opts.Name = filepath.Base(dir)
+	}
+	opts.cache = cache
+
+	out := newDiffSink()
+	if _, err := generateWrapperTo(out, opts); err != nil {
+		return "", err
+	}
+	// A moved tag would show up as a diff of the provenance comment only
+	if err := verifyPin(dir, prev, out); err != nil {
+		return "", err
+	}
+	return generatedDiff(out)
+}
+
+// generatedDiff returns a diff of the files generated into out against disk,
+// leaving out the metadata and the -timestamp line, which differ from one
+// generation to the next.
+func generatedDiff(out *diffSink) (string, error) {
+	var b strings.Builder
+	for _, p := range out.paths() {
+		if path.Base(p) == metadataFileName {
+			continue
+		}
+		aName := "a/" + p
+		old, err := os.ReadFile(filepath.FromSlash(p))
+		if os.IsNotExist(err) {
+			aName = "/dev/null"
+		} else if err != nil {
+			return "", err
+		}
+		fresh := generatedAtLine.ReplaceAllString(string(out.files[p].Data), "")
+		b.WriteString(unifiedDiff(aName, "b/"+p, generatedAtLine.ReplaceAllString(string(old), ""), fresh))
+	}
+	return b.String(), nil
+}
+
+// findWrapperDirs returns every directory below root with a metadata file.
+func findWrapperDirs(root string) ([]string, error) {
+	var dirs []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && p != root && (d.Name() == ".git" || d.Name() == ".terraform") {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() && d.Name() == metadataFileName {
+			dirs = append(dirs, filepath.Dir(p))
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// wrapperOf returns the closest directory containing file with a metadata
+// file, or "" if file isn't part of a wrapper.
+func wrapperOf(file string) string {
+	dir := filepath.Dir(filepath.Clean(file))
+	for {
+		if _, err := os.Stat(filepath.Join(dir, metadataFileName)); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
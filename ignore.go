@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFileName lists files in a wrapper that generation must never create
+// or overwrite, e.g. hand written custom-outputs.tf.
+const ignoreFileName = ".tfwrapperignore"
+
+// readIgnoreFile returns the patterns in the .tfwrapperignore of wrapperDir,
+// if there is one. Blank lines and lines starting with # are skipped.
+func readIgnoreFile(wrapperDir string) ([]string, error) {
+	all, err := readPatternFile(filepath.Join(wrapperDir, ignoreFileName))
+	if err != nil {
+		return nil, err
+	}
+	var patterns []string
+	for _, pattern := range all {
+		if pattern == metadataFileName {
+			fmt.Fprintf(os.Stderr, "Warning: %s lists %s, which is always written\n", ignoreFileName, metadataFileName)
+			continue
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, nil
+}
+
+// readPatternFile returns the patterns of a .gitignore style file, or none
+// if it doesn't exist. Blank lines and lines starting with # are skipped.
+func readPatternFile(name string) ([]string, error) {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		pattern := strings.TrimSpace(scanner.Text())
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		pattern = strings.TrimPrefix(pattern, "/")
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/"), ""); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid pattern %q", filepath.Base(name), line, pattern)
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, scanner.Err()
+}
+
+// ignored reports whether the slash separated path name, relative to the
+// wrapper, matches one of patterns. Like .gitignore, a pattern without a
+// slash matches the base name at any depth, and a trailing slash matches a
+// directory and everything in it.
+func ignored(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if dir, ok := strings.CutSuffix(pattern, "/"); ok {
+			for d := path.Dir(name); d != "."; d = path.Dir(d) {
+				if matchIgnorePattern(dir, d) {
+					return true
+				}
+			}
+			continue
+		}
+		if matchIgnorePattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchIgnorePattern(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
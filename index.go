@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// indexPath is the workspace index, relative to the workspace root.
+const indexPath = ".tfwrapper/index.json"
+
+// workspaceIndex summarizes every wrapper of a workspace, keyed by the
+// wrapper directory relative to the workspace root, so tooling can list
+// wrappers without walking the tree and parsing each .tfwrapper.json.
+type workspaceIndex struct {
+	Wrappers map[string]indexEntry `json:"wrappers"`
+}
+
+type indexEntry struct {
+	Source         string `json:"source"`
+	Version        string `json:"version,omitempty"`
+	Commit         string `json:"commit,omitempty"`
+	WrapperVersion string `json:"wrapper_version,omitempty"`
+	Iterable       bool   `json:"iterable,omitempty"`
+	// SchemaHash identifies the variable contract, so consumers can tell
+	// whether the config schema of a wrapper changed
+	SchemaHash string `json:"schema_hash,omitempty"`
+}
+
+func runIndex(args []string) {
+	fs := flag.NewFlagSet("index", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory inside the workspace to index")
+	fs.Parse(args)
+
+	ws, err := findWorkspace(*dir)
+	if err != nil {
+		fatalError("Failed to load workspace", err)
+	}
+	if ws == nil {
+		log.Fatalf("Error: %s is not inside a workspace (no %s found)", *dir, workspaceFileName)
+	}
+	wrappers, err := findWrapperDirs(ws.Root)
+	if err != nil {
+		log.Fatalf("Failed to find wrappers: %v", err)
+	}
+
+	entries := map[string]indexEntry{}
+	for _, w := range wrappers {
+		prov, err := readMetadata(w)
+		if err != nil {
+			fatalError("Failed to read "+w, err)
+		}
+		key, err := ws.relPath(w)
+		if err != nil {
+			log.Fatalf("Failed to index %s: %v", w, err)
+		}
+		entries[key] = newIndexEntry(prov)
+	}
+	err = ws.updateLock(func(*lockFile) error {
+		return ws.writeIndex(&workspaceIndex{Wrappers: entries})
+	})
+	if err != nil {
+		log.Fatalf("Failed to write %s: %v", indexPath, err)
+	}
+	fmt.Printf("Indexed %d wrappers in %s\n", len(entries), filepath.Join(ws.Root, indexPath))
+}
+
+func newIndexEntry(prov *provenance) indexEntry {
+	return indexEntry{
+		Source:         prov.Source,
+		Version:        prov.Version,
+		Commit:         prov.Commit,
+		WrapperVersion: prov.WrapperVersion,
+		Iterable:       prov.Options.Iterable,
+		SchemaHash:     schemaHash(prov.Variables),
+	}
+}
+
+// schemaHash returns the SHA-256 of the variable contract's JSON encoding,
+// whose keys encoding/json sorts.
+func schemaHash(contract map[string]contractVariable) string {
+	data, err := json.Marshal(contract)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func (ws *workspace) readIndex() (*workspaceIndex, error) {
+	index := &workspaceIndex{Wrappers: map[string]indexEntry{}}
+	path := filepath.Join(ws.Root, indexPath)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return index, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if index.Wrappers == nil {
+		index.Wrappers = map[string]indexEntry{}
+	}
+	return index, nil
+}
+
+// writeIndex replaces the index. Callers hold the lock file's lock, which
+// serializes index updates too.
+func (ws *workspace) writeIndex(index *workspaceIndex) error {
+	path := filepath.Join(ws.Root, indexPath)
+	if err := checkWrite(path); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0644)
+}
+
+// indexedWrappers returns the index entries of the wrappers below dir, keyed
+// by their directory relative to the current directory like dir. ok is false
+// outside a workspace or without an index, when the caller has to walk dir
+// instead.
+func indexedWrappers(dir string) (wrappers map[string]indexEntry, ok bool, err error) {
+	ws, err := findWorkspace(dir)
+	if err != nil || ws == nil {
+		return nil, false, err
+	}
+	if _, err := os.Stat(filepath.Join(ws.Root, indexPath)); err != nil {
+		return nil, false, nil
+	}
+	index, err := ws.readIndex()
+	if err != nil {
+		return nil, false, err
+	}
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, false, err
+	}
+	wrappers = map[string]indexEntry{}
+	for key, entry := range index.Wrappers {
+		wrapperDir := filepath.Join(ws.Root, filepath.FromSlash(key))
+		rel, err := filepath.Rel(abs, wrapperDir)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		wrappers[filepath.Join(dir, rel)] = entry
+	}
+	return wrappers, true, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// printCostEstimate prints the infracost breakdown of a wrapper written to
+// disk. Cost estimation is informational, so failures are only warnings.
+func printCostEstimate(wrapperDir, exampleConfig string) {
+	sb, err := sandboxFor(wrapperDir)
+	if err == nil {
+		var estimate string
+		if estimate, err = infracostBreakdown(sb, wrapperDir, exampleConfig); err == nil {
+			fmt.Printf("\nCost estimate:\n%s\n", estimate)
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Warning: failed to estimate cost: %v\n", err)
+}
+
+// costSection returns the cost estimate of a wrapper written to a git branch
+// as a pull request description section.
+func costSection(sb sandbox, out *gitSink, wrapperDir, exampleConfig string) string {
+	target, err := out.target(wrapperDir)
+	if err == nil {
+		var estimate string
+		if estimate, err = infracostBreakdown(sb, target, exampleConfig); err == nil {
+			return "\n### Cost estimate\n\n```\n" + estimate + "\n```\n"
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Warning: failed to estimate cost: %v\n", err)
+	return ""
+}
+
+// infracostBreakdown estimates the cost of the wrapper in wrapperDir by
+// running infracost against a throwaway root module that calls it with the
+// wrapper's example config, or an empty config when there is none. It
+// returns infracost's table output.
+func infracostBreakdown(sb sandbox, wrapperDir, exampleConfig string) (string, error) {
+	wrapperDir, err := filepath.Abs(wrapperDir)
+	if err != nil {
+		return "", err
+	}
+	rootDir, err := os.MkdirTemp("", "tfwrapper-infracost-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(rootDir)
+
+	source, err := filepath.Rel(rootDir, wrapperDir)
+	if err != nil {
+		return "", err
+	}
+	if exampleConfig == "" {
+		exampleConfig = "config.example.json"
+	}
+	config := `jsonencode({})`
+	configPath := filepath.Join(wrapperDir, exampleConfig)
+	if _, err := os.Stat(configPath); err == nil {
+		config = fmt.Sprintf("file(%s)", hclString(filepath.ToSlash(configPath)))
+		if ext := filepath.Ext(configPath); ext == ".yaml" || ext == ".yml" {
+			config = fmt.Sprintf("jsonencode(yamldecode(%s))", config)
+		}
+	} else {
+		fmt.Fprintf(os.Stderr, "Warning: %s not found, estimating the cost of an empty config\n", configPath)
+	}
+	root := fmt.Sprintf("module \"wrapper\" {\n  source = %s\n  config = %s\n}\n", hclString(filepath.ToSlash(source)), config)
+	if err := os.WriteFile(filepath.Join(rootDir, "main.tf"), []byte(root), 0644); err != nil {
+		return "", err
+	}
+
+	// infracost needs its API key and configuration
+	sb.AllowedEnv = append(append([]string{}, sb.AllowedEnv...), "INFRACOST_*")
+	out, err := sb.run(rootDir, "infracost", "breakdown", "--path", ".", "--format", "table", "--no-color")
+	if err != nil {
+		return "", fmt.Errorf("infracost failed: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var nonIdentChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// inputFile is an inputs_*.tf file holding the lookups for some of the
+// wrapper's arguments in a local, so main.tf stays short for very large
+// modules.
+type inputFile struct {
+	Name  string
+	Local string
+	Title string
+	Vars  []moduleVariable
+}
+
+// splitInputs spreads the arguments over inputs_*.tf files of at most
+// opts.SplitInputs arguments each, one or more files per group. Nothing is
+// split unless the module has more arguments than that.
+func splitInputs(opts generateOptions, vars []moduleVariable) []inputFile {
+	if opts.SplitInputs <= 0 || len(vars) <= opts.SplitInputs {
+		return nil
+	}
+
+	var files []inputFile
+	used := map[string]int{}
+	add := func(title string, chunk []moduleVariable) {
+		slug := strings.Trim(nonIdentChars.ReplaceAllString(strings.ToLower(strings.TrimSuffix(title, ".tf")), "_"), "_")
+		if slug == "" {
+			slug = "general"
+		}
+		used[slug]++
+		if used[slug] > 1 {
+			slug = fmt.Sprintf("%s_%d", slug, used[slug])
+		}
+		files = append(files, inputFile{
+			Name:  "inputs_" + slug + ".tf",
+			Local: "inputs_" + slug,
+			Title: title,
+			Vars:  chunk,
+		})
+	}
+
+	start := 0
+	for i := 1; i <= len(vars); i++ {
+		endOfGroup := i == len(vars) || groupTitle(vars[i], opts.GroupBy) != groupTitle(vars[start], opts.GroupBy)
+		if endOfGroup || i-start == opts.SplitInputs {
+			title := groupTitle(vars[start], opts.GroupBy)
+			if opts.GroupBy == "" {
+				title = fmt.Sprint(len(files) + 1)
+			}
+			add(title, vars[start:i])
+			start = i
+		}
+	}
+	return files
+}
+
+// inputLocals maps each split argument to the local holding it.
+func inputLocals(inputs []inputFile) map[string]string {
+	locals := map[string]string{}
+	for _, f := range inputs {
+		for _, v := range f.Vars {
+			locals[v.Name] = f.Local
+		}
+	}
+	return locals
+}
+
+// generateInputsTf renders one inputs_*.tf file. In iterable mode the local
+// is keyed by instance like the module's for_each.
+func generateInputsTf(opts generateOptions, f inputFile) string {
+	var b strings.Builder
+	if f.Title != "" && opts.GroupBy != "" {
+		fmt.Fprintf(&b, "# Inputs for module.this: %s\n", f.Title)
+	} else {
+		b.WriteString("# Inputs for module.this\n")
+	}
+	b.WriteString("locals {\n")
+
+	configSource, indent := "local.config", "    "
+	if opts.Iterable {
+		fmt.Fprintf(&b, "  %s = { for name, instance in %s : name => {\n", f.Local, accessExpr(fixedAccessor(opts), "local.config", "instances", "{}"))
+		configSource = "instance"
+	} else {
+		fmt.Fprintf(&b, "  %s = {\n", f.Local)
+	}
+	for _, v := range f.Vars {
+		writeVariableComment(&b, indent, variableComment(opts, v))
+		if opts.DefaultTags && v.Name == tagsVariable {
+			writeVariableComment(&b, indent, defaultTagsComment)
+		}
+		fmt.Fprintf(&b, "%s%s = %s%s\n", indent, hclKey(v.Name), lookupExpr(opts, configSource, v), annotation(opts, v))
+	}
+	if opts.Iterable {
+		b.WriteString("  } }\n")
+	} else {
+		b.WriteString("  }\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// writeVariableComment copies an upstream comment block at the given
+// indentation.
+func writeVariableComment(b *strings.Builder, indent, comment string) {
+	if comment == "" {
+		return
+	}
+	for _, line := range strings.Split(comment, "\n") {
+		if strings.TrimSpace(line) == "" {
+			b.WriteString("\n")
+		} else {
+			fmt.Fprintf(b, "%s%s\n", indent, line)
+		}
+	}
+}
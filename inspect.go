@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// moduleInventory describes an upstream module before it is wrapped.
+type moduleInventory struct {
+	Source    string                      `json:"source"`
+	Version   string                      `json:"version,omitempty"`
+	Commit    string                      `json:"commit,omitempty"`
+	Variables map[string]contractVariable `json:"variables"`
+	Outputs   []string                    `json:"outputs"`
+	// OutputDescriptions are upstream's, or synthesized from the values
+	OutputDescriptions map[string]string `json:"output_descriptions,omitempty"`
+	// Resources and DataSources count the blocks of each type declared by
+	// the module and the local modules it calls.
+	Resources   map[string]int `json:"resources"`
+	DataSources map[string]int `json:"data_sources"`
+	Issues      []moduleIssue  `json:"issues,omitempty"`
+	// Health are the maintenance signals of the repository, with -health
+	Health *moduleHealth `json:"health,omitempty"`
+}
+
+func runInspect(args []string) {
+	fs := flag.NewFlagSet("inspect", flag.ExitOnError)
+	source := fs.String("source", "", "Module source to inspect (required)")
+	version := fs.String("version", "", "Module version (optional)")
+	jsonOutput := fs.Bool("json", false, "Print the inventory as JSON")
+	health := fs.Bool("health", false, "Also report the license, last commit, open issues and archived status of the module's GitHub or GitLab repository")
+	failIf := fs.String("fail-if", "", "Comma separated health conditions to exit non-zero on, implying -health: "+strings.Join(healthConditions, ", ")+" (optional)")
+	fs.Parse(args)
+
+	if *source == "" {
+		log.Fatal("Error: -source is required")
+	}
+	conditions := splitList(*failIf)
+	if err := validateFailIf(conditions); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	sb, err := sandboxFor(".")
+	if err != nil {
+		fatalError("Failed to load workspace", err)
+	}
+	inv, err := inspectModule(sb, *source, *version)
+	if err != nil {
+		fatalError("Failed to inspect module", err)
+	}
+	if *health || len(conditions) > 0 {
+		inv.Health, err = fetchHealth(sb, *source)
+		// Without -fail-if the rest of the inventory is still worth having
+		if err != nil && len(conditions) > 0 {
+			fatalError("Failed to read module health", err)
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to read module health: %v\n", err)
+		}
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(inv); err != nil {
+			log.Fatalf("Failed to write inventory: %v", err)
+		}
+	} else {
+		printInventory(inv)
+	}
+
+	if inv.Health != nil {
+		if failures := healthFailures(inv.Health, conditions); len(failures) > 0 {
+			log.Fatalf("Error: %s fails -fail-if: %s", inv.Health.Repository, strings.Join(failures, "; "))
+		}
+	}
+}
+
+// inspectModule downloads source and takes its inventory.
+func inspectModule(sb sandbox, source, version string) (*moduleInventory, error) {
+	tmpDir, err := os.MkdirTemp("", "tfwrapper-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	repoDir, modulePath, err := downloadModule(sb, nil, source, version, tmpDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download module: %w", err)
+	}
+	vars, err := parseVariables(os.DirFS(modulePath), "variables.tf")
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse variables.tf: %w", err)
+	}
+
+	c := newModuleChecker(repoDir, false, false)
+	c.check(modulePath, nil)
+	outputs := c.sortedOutputs()
+	return &moduleInventory{
+		Source:             source,
+		Version:            version,
+		Commit:             gitHeadCommit(sb, modulePath),
+		Variables:          variableContract(vars),
+		Outputs:            outputNames(outputs),
+		OutputDescriptions: outputDescriptions(outputs),
+		Resources:          c.resources,
+		DataSources:        c.dataSources,
+		Issues:             c.issues,
+	}, nil
+}
+
+func printInventory(inv *moduleInventory) {
+	fmt.Printf("Module: %s %s\n", inv.Source, displayVersion(inv.Version))
+	if inv.Commit != "" {
+		fmt.Printf("Commit: %s\n", inv.Commit)
+	}
+
+	names := sortedKeys(inv.Variables)
+	required := 0
+	for _, name := range names {
+		if inv.Variables[name].Required {
+			required++
+		}
+	}
+	fmt.Printf("\nVariables (%d, %d required):\n", len(names), required)
+	for _, name := range names {
+		v := inv.Variables[name]
+		suffix := ""
+		if v.Required {
+			suffix = " (required)"
+		}
+		fmt.Printf("  %s: %s%s\n", name, displayType(v.Type), suffix)
+	}
+
+	fmt.Printf("\nOutputs (%d):\n", len(inv.Outputs))
+	for _, name := range inv.Outputs {
+		if description := inv.OutputDescriptions[name]; description != "" {
+			fmt.Printf("  %s: %s\n", name, description)
+		} else {
+			fmt.Printf("  %s\n", name)
+		}
+	}
+
+	for _, section := range []struct {
+		title  string
+		counts map[string]int
+	}{
+		{"Resources", inv.Resources},
+		{"Data sources", inv.DataSources},
+	} {
+		fmt.Printf("\n%s (%d types):\n", section.title, len(section.counts))
+		for _, typ := range sortedKeys(section.counts) {
+			fmt.Printf("  %s x%d\n", typ, section.counts[typ])
+		}
+	}
+
+	if len(inv.Issues) > 0 {
+		fmt.Printf("\nIssues:\n")
+		for _, issue := range inv.Issues {
+			fmt.Printf("  %s: %s\n", issue.Where, issue.Message)
+		}
+	}
+
+	if h := inv.Health; h != nil {
+		license := h.License
+		if license == "" {
+			license = "none detected"
+		}
+		fmt.Printf("\nHealth (%s):\n", h.Repository)
+		fmt.Printf("  License: %s\n", license)
+		if !h.LastCommit.IsZero() {
+			fmt.Printf("  Last commit: %s\n", h.LastCommit.Format(time.DateOnly))
+		}
+		fmt.Printf("  Open issues: %d\n", h.OpenIssues)
+		fmt.Printf("  Archived: %t\n", h.Archived)
+	}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
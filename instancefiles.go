@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// validateInstancesDir checks -instances-dir, which only iterable wrappers
+// have instances to read from.
+func validateInstancesDir(opts generateOptions) error {
+	if opts.InstancesDir == "" {
+		return nil
+	}
+	if !opts.Iterable {
+		return errors.New("-instances-dir needs -iterable")
+	}
+	if !filepath.IsLocal(opts.InstancesDir) {
+		return fmt.Errorf("invalid -instances-dir %q: must be a relative path inside the wrapper", opts.InstancesDir)
+	}
+	return nil
+}
+
+// generateInstancesDirVariable declares var.instances_dir, which points a
+// caller at its own directory of instance files.
+func generateInstancesDirVariable(opts generateOptions) string {
+	return fmt.Sprintf(`variable "instances_dir" {
+  type        = string
+  description = %s
+  default     = null
+}
+`, hclString(fmt.Sprintf("Directory of per-instance config files, each configuring the instance named after it (default: %s/ in the wrapper)", filepath.ToSlash(opts.InstancesDir))))
+}
+
+// instanceFilesLocals returns the locals reading the instance files of
+// -instances-dir into local.file_instances, or "" without it. YAML decodes
+// JSON too, so every file goes through yamldecode().
+func instanceFilesLocals(opts generateOptions) string {
+	if opts.InstancesDir == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("  # Every file in the instances directory configures the instance named\n")
+	b.WriteString("  # after it, like an entry of \"instances\" in the config, which wins on a\n")
+	b.WriteString("  # clash\n")
+	dir := hclString(filepath.ToSlash(opts.InstancesDir))
+	fmt.Fprintf(&b, "  instances_dir = coalesce(var.instances_dir, \"${path.module}/%s\")\n", dir[1:len(dir)-1])
+	decoded := `yamldecode(file("${local.instances_dir}/${f}"))`
+	if opts.Envelope == "" {
+		fmt.Fprintf(&b, "  file_instances = { for f in fileset(local.instances_dir, \"*.{yaml,yml,json}\") : %s => %s }\n", instanceFileName, decoded)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "  instance_documents = { for f in fileset(local.instances_dir, \"*.{yaml,yml,json}\") : %s => %s }\n", instanceFileName, decoded)
+	fmt.Fprintf(&b, "  file_instances     = { for name, document in local.instance_documents : name => %s }\n", envelopeSpec("document", "jsonencode(document)"))
+	return b.String()
+}
+
+// instanceFileName is the instance name of an instance file: its name
+// without the extension.
+const instanceFileName = `replace(f, "/\\.(ya?ml|json)$/", "")`
+
+// instancesExpr returns the instances of the decoded config raw, including
+// the ones configured in instance files with -instances-dir.
+func instancesExpr(opts generateOptions, raw string) string {
+	instances := fmt.Sprintf("lookup(%s, \"instances\", {})", raw)
+	if opts.InstancesDir == "" {
+		return instances
+	}
+	return fmt.Sprintf("merge(local.file_instances, %s)", instances)
+}
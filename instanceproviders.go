@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// providersFileName declares the provider configurations a wrapper expects
+// from its caller, when it can't just inherit the default ones.
+const providersFileName = "providers.tf"
+
+// instanceProviderKey is the instance config key choosing which of the
+// -instance-providers sets an instance of an iterable wrapper is created with.
+const instanceProviderKey = "provider"
+
+// providerConfig is a provider the wrapped module requires, under its local
+// name: the source and the aliased configurations (configuration_aliases)
+// the module expects to be passed in addition to the default one.
+type providerConfig struct {
+	Source  string   `json:"source"`
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// moduleProviderConfigs reads the required_providers of the .tf files directly
+// in modulePath, keyed by local name. Providers only required by the local
+// modules it calls are inherited through the module's own.
+func moduleProviderConfigs(modulePath string) (map[string]providerConfig, error) {
+	files, err := filepath.Glob(filepath.Join(modulePath, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	parser := hclparse.NewParser()
+	configs := map[string]providerConfig{}
+	for _, path := range files {
+		file, diags := parser.ParseHCLFile(path)
+		if diags.HasErrors() {
+			// Reported when variables.tf is read, or by terraform itself
+			continue
+		}
+		content, _, _ := file.Body.PartialContent(&hcl.BodySchema{Blocks: []hcl.BlockHeaderSchema{{Type: "terraform"}}})
+		for _, terraform := range content.Blocks {
+			inner, _, _ := terraform.Body.PartialContent(&hcl.BodySchema{Blocks: []hcl.BlockHeaderSchema{{Type: "required_providers"}}})
+			for _, block := range inner.Blocks {
+				attrs, _ := block.Body.JustAttributes()
+				for name, attr := range attrs {
+					config := configs[name]
+					config.Source, config.Aliases = providerConfigOf(name, attr, config.Aliases)
+					configs[name] = config
+				}
+			}
+		}
+	}
+	return configs, nil
+}
+
+// providerConfigOf reads the source and configuration_aliases of a
+// required_providers entry, adding the aliases to aliases.
+func providerConfigOf(name string, attr *hcl.Attribute, aliases []string) (string, []string) {
+	pairs, diags := hcl.ExprMap(attr.Expr)
+	if diags.HasErrors() {
+		// The legacy string form is a constraint for a hashicorp provider
+		return providerSource(name, ""), aliases
+	}
+	var source string
+	for _, pair := range pairs {
+		switch hcl.ExprAsKeyword(pair.Key) {
+		case "source":
+			source = staticString(&hcl.Attribute{Expr: pair.Value})
+		case "configuration_aliases":
+			list, _ := hcl.ExprList(pair.Value)
+			for _, expr := range list {
+				traversal, diags := hcl.AbsTraversalForExpr(expr)
+				if diags.HasErrors() || len(traversal) != 2 {
+					continue
+				}
+				if attr, ok := traversal[1].(hcl.TraverseAttr); ok && !slices.Contains(aliases, attr.Name) {
+					aliases = append(aliases, attr.Name)
+				}
+			}
+		}
+	}
+	sort.Strings(aliases)
+	return providerSource(name, source), aliases
+}
+
+// wrapperProviderConfigs returns the provider configurations the wrapper
+// passes to the module, or nil when the module can just inherit the default
+// ones. A module with configuration_aliases needs them passed explicitly,
+// -instance-providers needs a set of every configuration per instance group,
+// and -fan-out a provider per target.
+func wrapperProviderConfigs(opts generateOptions, configs map[string]providerConfig, providers map[string]string) map[string]providerConfig {
+	aliased := false
+	for _, config := range configs {
+		aliased = aliased || len(config.Aliases) > 0
+	}
+	if !aliased && len(opts.InstanceProviders) == 0 && len(opts.FanOut) == 0 {
+		return nil
+	}
+	if len(configs) == 0 {
+		// Without required_providers, the module uses the providers it
+		// requires indirectly under their usual local names
+		sources := sortedKeys(providers)
+		for source, local := range providerLocalNames(sources) {
+			configs[local] = providerConfig{Source: source}
+		}
+	}
+	return configs
+}
+
+// validateInstanceProviders checks the -instance-providers sets: an iterable
+// wrapper is needed to group instances by set, and each set name becomes
+// part of provider aliases and module names.
+func validateInstanceProviders(opts generateOptions, contract map[string]contractVariable) error {
+	if len(opts.InstanceProviders) == 0 {
+		return nil
+	}
+	if !opts.Iterable {
+		return fmt.Errorf("-instance-providers requires -iterable")
+	}
+	if len(opts.RegionCheck) > 0 {
+		return fmt.Errorf("-instance-providers can't be combined with -region-check, each set of instances has its own provider")
+	}
+	if _, ok := contract[instanceProviderKey]; ok {
+		return fmt.Errorf("%w: -instance-providers: the module has a variable named %s", ErrUnsupportedFeature, instanceProviderKey)
+	}
+	for i, set := range opts.InstanceProviders {
+		if !hclsyntax.ValidIdentifier(set) {
+			return fmt.Errorf("invalid -instance-providers entry %q: must be a valid identifier", set)
+		}
+		if slices.Contains(opts.InstanceProviders[:i], set) {
+			return fmt.Errorf("-instance-providers lists %q twice", set)
+		}
+	}
+	return nil
+}
+
+// instanceProviderAlias names the wrapper's configuration of provider local,
+// or of its aliased configuration alias (if not ""), used by the instances
+// of set.
+func instanceProviderAlias(local, alias, set string) string {
+	if alias == "" {
+		return local + "." + set
+	}
+	return local + "." + alias + "_" + set
+}
+
+// generateProvidersTf declares the provider configurations the wrapper
+// expects from its caller: the module's aliased configurations, or with
+// -instance-providers one of every configuration per set.
+func generateProvidersTf(opts generateOptions, configs map[string]providerConfig) string {
+	var b strings.Builder
+	if locals := sortedKeys(configs); len(opts.InstanceProviders) > 0 && len(locals) > 0 {
+		fmt.Fprintf(&b, "# Each instance is created with the provider configurations of the set named\n")
+		fmt.Fprintf(&b, "# by its %q config key (default %q). Pass them all, e.g.\n", instanceProviderKey, opts.InstanceProviders[0])
+		fmt.Fprintf(&b, "#   providers = { %s = %s.<configuration>, ... }\n", instanceProviderAlias(locals[0], "", opts.InstanceProviders[0]), locals[0])
+	} else if len(opts.FanOut) > 0 {
+		b.WriteString("# The wrapper configures the aws provider of each -fan-out target itself,\n")
+		b.WriteString("# so it can't be called with count or for_each\n")
+	} else {
+		b.WriteString("# The module expects these aliased provider configurations from its caller,\n")
+		b.WriteString("# which passes them on to the wrapper with providers = { ... }\n")
+	}
+	b.WriteString("terraform {\n  required_providers {\n")
+	for _, local := range sortedKeys(configs) {
+		config := configs[local]
+		var aliases []string
+		if len(opts.InstanceProviders) > 0 {
+			for _, set := range opts.InstanceProviders {
+				aliases = append(aliases, instanceProviderAlias(local, "", set))
+				for _, alias := range config.Aliases {
+					aliases = append(aliases, instanceProviderAlias(local, alias, set))
+				}
+			}
+		} else if len(opts.FanOut) == 0 || config.Source != fanOutProvider {
+			for _, alias := range config.Aliases {
+				aliases = append(aliases, local+"."+alias)
+			}
+		}
+		fmt.Fprintf(&b, "    %s = {\n      source = %s\n", hclKey(local), hclString(config.Source))
+		if len(aliases) > 0 {
+			fmt.Fprintf(&b, "      configuration_aliases = [%s]\n", strings.Join(aliases, ", "))
+		}
+		b.WriteString("    }\n")
+	}
+	b.WriteString("  }\n}\n")
+	for _, local := range sortedKeys(configs) {
+		if len(opts.FanOut) > 0 && configs[local].Source == fanOutProvider {
+			b.WriteString(generateFanOutProviders(opts, local))
+		}
+	}
+	return string(hclwrite.Format([]byte(b.String())))
+}
+
+// writeProvidersArgument writes the providers argument of a module block,
+// passing each configuration the module expects, local or local.alias, the
+// configuration from returns for it.
+func writeProvidersArgument(b *strings.Builder, configs map[string]providerConfig, from func(local, alias string) string) {
+	b.WriteString("  providers = {\n")
+	for _, local := range sortedKeys(configs) {
+		fmt.Fprintf(b, "    %s = %s\n", local, from(local, ""))
+		for _, alias := range configs[local].Aliases {
+			fmt.Fprintf(b, "    %s.%s = %s\n", local, alias, from(local, alias))
+		}
+	}
+	b.WriteString("  }\n\n")
+}
+
+// moduleProviders returns which of the wrapper's provider configurations the
+// module block of set (see writeModuleBlock) passes for each one the module
+// expects: the configurations of the set with -instance-providers, of the
+// target with -fan-out, or else the ones the wrapper received.
+func moduleProviders(opts generateOptions, prov provenance, set string) func(local, alias string) string {
+	return func(local, alias string) string {
+		switch {
+		case len(opts.InstanceProviders) > 0:
+			return instanceProviderAlias(local, alias, set)
+		case len(opts.FanOut) > 0 && prov.ProviderConfigs[local].Source == fanOutProvider:
+			return local + "." + set
+		case alias != "":
+			return local + "." + alias
+		}
+		return local
+	}
+}
+
+// instanceSetFilter returns the for_each expression selecting the instances
+// of set, with instances naming no set belonging to the first one.
+func instanceSetFilter(opts generateOptions, set string) string {
+	instances := accessExpr(fixedAccessor(opts), "local.config", "instances", "{}")
+	return fmt.Sprintf("{ for key, instance in %s : key => instance if try(instance.%s, %s) == %s }",
+		instances, instanceProviderKey, hclString(opts.InstanceProviders[0]), hclString(set))
+}
+
+// generateInstanceProviderCheck warns at plan time about instances naming a
+// set of providers the wrapper doesn't have, which no module block creates.
+func generateInstanceProviderCheck(opts generateOptions) string {
+	instances := accessExpr(fixedAccessor(opts), "local.config", "instances", "{}")
+	sets := make([]string, len(opts.InstanceProviders))
+	for i, set := range opts.InstanceProviders {
+		sets[i] = hclString(set)
+	}
+	var b strings.Builder
+	b.WriteString("\n# Instances naming another set of providers aren't created\n")
+	b.WriteString("check \"instance_providers\" {\n  assert {\n")
+	fmt.Fprintf(&b, "    condition     = alltrue([for instance in values(%s) : contains([%s], try(instance.%s, %s))])\n",
+		instances, strings.Join(sets, ", "), instanceProviderKey, sets[0])
+	fmt.Fprintf(&b, "    error_message = %s\n", hclString(fmt.Sprintf("Config key %q must be one of %s, other instances aren't created.", instanceProviderKey, strings.Join(opts.InstanceProviders, ", "))))
+	b.WriteString("  }\n}\n")
+	return b.String()
+}
+
+// moduleRef is how the wrapper refers to the instances of the module: the
+// module "this" block, or with -instance-providers and -fan-out the local
+// merging the module block of each set or target.
+func moduleRef(opts generateOptions) string {
+	if len(opts.InstanceProviders) > 0 || len(opts.FanOut) > 0 {
+		return "local.this"
+	}
+	return "module.this"
+}
+
+// warnSharedProviders points out that every instance of an iterable wrapper
+// is created with the same provider configurations.
+func warnSharedProviders(opts generateOptions, configs map[string]providerConfig) {
+	if !opts.Iterable || len(opts.InstanceProviders) > 0 || configs == nil {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Warning: the module expects aliased provider configurations, every instance of the iterable wrapper shares the ones its caller passes. Use -instance-providers to give groups of instances their own\n")
+}
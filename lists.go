@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// listsFileName holds the normalization of list(object) variables of
+// iterable wrappers.
+const listsFileName = "lists.tf"
+
+// isListOfObjects reports whether a type constraint is a list of objects.
+func isListOfObjects(typ string) bool {
+	node := typeNode(typ)
+	return node.Kind == "list" && node.Elem != nil && node.Elem.Kind == "object"
+}
+
+// splitLists separates the list(object) variables lists.tf normalizes from
+// the rest. Only iterable wrappers normalize them, whose instances are
+// written by hand in JSON or YAML.
+func splitLists(opts generateOptions, vars []moduleVariable) (rest, lists []moduleVariable) {
+	if !opts.Iterable {
+		return vars, nil
+	}
+	for _, v := range vars {
+		if isListOfObjects(v.Type) {
+			lists = append(lists, v)
+		} else {
+			rest = append(rest, v)
+		}
+	}
+	return rest, lists
+}
+
+// generateListsTf renders lists.tf, holding the normalized arguments in a
+// local like an inputs_*.tf file does. A map of objects, the container
+// config authors often write instead of a list, is passed on as the list of
+// its values, in key order.
+func generateListsTf(opts generateOptions, vars []moduleVariable) string {
+	var b strings.Builder
+	b.WriteString("# Variables of type list(object) accept a map of objects too, whose values\n")
+	b.WriteString("# are passed on in key order, e.g. {\"a\": {...}, \"b\": {...}} as [{...}, {...}]\n")
+	b.WriteString("locals {\n")
+
+	// The config may hold either container, which coalesce() and try() in
+	// the accessor of the list would choke on
+	lookupOpts := opts
+	lookupOpts.Accessor = accessorLookup
+
+	fmt.Fprintf(&b, "  lists = { for name, instance in %s : name => {\n", accessExpr(fixedAccessor(opts), "local.config", "instances", "{}"))
+	for _, v := range vars {
+		value := lookupExpr(lookupOpts, "instance", v)
+		fmt.Fprintf(&b, "    %s = try(values(%s), %s)%s\n", hclKey(v.Name), value, value, annotation(opts, v))
+	}
+	b.WriteString("  } }\n")
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var layerKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateMergeLayers checks that layer keys can be used in local names and
+// don't clash with the keys the wrapper already uses.
+func validateMergeLayers(layers []string) error {
+	seen := map[string]bool{}
+	for _, layer := range layers {
+		switch {
+		case !layerKeyPattern.MatchString(layer):
+			return fmt.Errorf("invalid merge layer %q: must be a letter or underscore followed by letters, digits or underscores", layer)
+		case layer == "instances":
+			return fmt.Errorf("invalid merge layer %q: the key is used for iterable instances", layer)
+		case seen[layer]:
+			return fmt.Errorf("merge layer %q is listed twice", layer)
+		}
+		seen[layer] = true
+	}
+	return nil
+}
+
+// mergeExpr merges the objects a and b, with b winning. Nested objects are
+// merged one level deep; try() falls back to whichever side has the key
+// when the values can't be merged.
+func mergeExpr(a, b string) string {
+	return fmt.Sprintf("{ for k in distinct(concat(keys(%[1]s), keys(%[2]s))) : k => try(merge(%[1]s[k], %[2]s[k]), %[2]s[k], %[1]s[k]) }", a, b)
+}
+
+// generateLocalsTf decodes the config, unwrapping it with -envelope, adds the
+// instance files of -instances-dir and, when merge layers are configured,
+// merges them into the instance config.
+func generateLocalsTf(opts generateOptions) string {
+	decoded := fmt.Sprintf("jsondecode(%s)", configDocument(opts))
+	var envelope string
+	if opts.Envelope != "" {
+		envelope = fmt.Sprintf("  # Config documents may come in an envelope, holding the config in spec\n  document = %s\n\n", decoded)
+		decoded = envelopeSpec("local.document", configDocument(opts))
+	}
+	instanceFiles := instanceFilesLocals(opts)
+	if len(opts.MergeLayers) == 0 && instanceFiles == "" {
+		return fmt.Sprintf("locals {\n%s  config = %s\n}\n", envelope, decoded)
+	}
+
+	var b strings.Builder
+	b.WriteString("locals {\n")
+	b.WriteString(envelope)
+	fmt.Fprintf(&b, "  raw = %s\n\n", decoded)
+	if instanceFiles != "" {
+		b.WriteString(instanceFiles + "\n")
+	}
+	if len(opts.MergeLayers) == 0 {
+		fmt.Fprintf(&b, "  config = merge(local.raw, { instances = %s })\n}\n", instancesExpr(opts, "local.raw"))
+		return b.String()
+	}
+	fmt.Fprintf(&b, "  # Config is merged in layers, each overriding the one before it:\n")
+	fmt.Fprintf(&b, "  #   upstream module defaults <- %s <- instance\n", strings.Join(opts.MergeLayers, " <- "))
+	b.WriteString("  # Nested objects are merged one level deep, anything deeper is replaced.\n")
+
+	quoted := make([]string, len(opts.MergeLayers))
+	for i, layer := range opts.MergeLayers {
+		quoted[i] = fmt.Sprintf("%q", layer)
+		fmt.Fprintf(&b, "  layer_%s = lookup(local.raw, %q, {})\n", layer, layer)
+	}
+	b.WriteString("\n")
+
+	merged := "local.layer_" + opts.MergeLayers[0]
+	for _, layer := range opts.MergeLayers[1:] {
+		fmt.Fprintf(&b, "  merged_%s = %s\n", layer, mergeExpr(merged, "local.layer_"+layer))
+		merged = "local.merged_" + layer
+	}
+
+	if opts.Iterable {
+		fmt.Fprintf(&b, "  instances = { for name, instance in %s : name => %s }\n", instancesExpr(opts, "local.raw"), mergeExpr(merged, "instance"))
+		b.WriteString("  config    = merge(local.raw, { instances = local.instances })\n")
+	} else {
+		fmt.Fprintf(&b, "  instance = { for k, v in local.raw : k => v if !contains([%s], k) }\n", strings.Join(quoted, ", "))
+		fmt.Fprintf(&b, "  config   = %s\n", mergeExpr(merged, "local.instance"))
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
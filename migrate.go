@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// configMigration rewrites configs written for one wrapper contract to the
+// next.
+type configMigration struct {
+	// Renames maps former variable names to current ones
+	Renames map[string]string
+	Retyped map[string]typeChange
+	// Iterable configs hold their instances under "instances", and Layers
+	// are the merge layers, which may set instance keys too
+	Iterable bool
+	Layers   []string
+	// Envelope is the wrapper's envelope; enveloped documents of other
+	// kinds are left alone
+	Envelope *configEnvelope
+}
+
+func runMigrateConfig(args []string) {
+	fs := flag.NewFlagSet("migrate-config", flag.ExitOnError)
+	wrapperDir := fs.String("wrapper", ".", "Wrapper directory whose .tfwrapper.json has the renames and compat layer to apply")
+	from := fs.String("from", "", "JSON schema of the old contract, as written by tfwrapper schema (optional, with -to)")
+	to := fs.String("to", "", "JSON schema of the new contract (optional, with -from)")
+	dir := fs.String("dir", configsDir, "Directory of JSON and YAML configs to migrate")
+	binary := fs.String("binary", "terraform", "Binary used to decode YAML configs (terraform or tofu)")
+	dryRun := fs.Bool("dry-run", false, "Report the changes without rewriting any config")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tfwrapper migrate-config [flags] [<config>...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if (*from == "") != (*to == "") {
+		log.Fatal("Error: -from and -to must be given together")
+	}
+	var migration *configMigration
+	var err error
+	if *from != "" {
+		migration, err = schemaMigration(*from, *to)
+	} else {
+		migration, err = wrapperMigration(*wrapperDir)
+	}
+	if err != nil {
+		fatalError("Failed to work out the migration", err)
+	}
+	if len(migration.Renames)+len(migration.Retyped) == 0 && migration.Envelope == nil {
+		fmt.Println("Nothing to migrate: the contracts have no renamed or retyped variables")
+		return
+	}
+
+	paths := fs.Args()
+	if len(paths) == 0 {
+		if paths, err = findConfigFiles(*dir); err != nil {
+			log.Fatalf("Failed to find configs: %v", err)
+		}
+	}
+	sb, err := sandboxFor(".")
+	if err != nil {
+		fatalError("Failed to load workspace", err)
+	}
+
+	migrated := 0
+	for _, path := range paths {
+		config, err := decodeConfigFile(sb, path, *binary)
+		if err != nil {
+			fatalError("Failed to read config", err)
+		}
+		changes, problems := migration.apply(config)
+		for _, change := range changes {
+			fmt.Printf("%s: %s\n", path, change)
+		}
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %s, migrate it by hand\n", path, problem)
+		}
+		if len(changes) == 0 {
+			continue
+		}
+		migrated++
+		if *dryRun {
+			continue
+		}
+		if err := writeConfigFile(path, config); err != nil {
+			log.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	verb := "Migrated"
+	if *dryRun {
+		verb = "Would migrate"
+	}
+	fmt.Printf("%s %d of %d configs\n", verb, migrated, len(paths))
+}
+
+// wrapperMigration reads the renames and compat layer recorded for a
+// wrapper.
+func wrapperMigration(dir string) (*configMigration, error) {
+	prov, err := readMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	if prov == nil {
+		return nil, fmt.Errorf("%s not found in %s, use -from and -to", metadataFileName, dir)
+	}
+	migration := &configMigration{
+		Renames:  deprecatedKeys(prov.Options),
+		Iterable: prov.Options.Iterable,
+		Layers:   prov.Options.MergeLayers,
+		Envelope: envelopeFor(prov),
+	}
+	if prov.Compat != nil {
+		migration.Retyped = prov.Compat.Retyped
+	}
+	return migration, nil
+}
+
+// schemaMigration compares two config schemas written by tfwrapper schema.
+// Renames are detected like on regeneration, and type changes are kept when
+// they can be converted.
+func schemaMigration(fromPath, toPath string) (*configMigration, error) {
+	old, _, err := readSchemaContract(fromPath)
+	if err != nil {
+		return nil, err
+	}
+	next, iterable, err := readSchemaContract(toPath)
+	if err != nil {
+		return nil, err
+	}
+
+	migration := &configMigration{Renames: map[string]string{}, Retyped: map[string]typeChange{}, Iterable: iterable}
+	for _, rename := range detectRenames(old, next, nil) {
+		former, current, _ := strings.Cut(rename, "=")
+		fmt.Fprintf(os.Stderr, "Detected rename of variable %s to %s\n", former, current)
+		migration.Renames[former] = current
+	}
+	for _, name := range sortedKeys(next) {
+		previous, ok := old[name]
+		for former, current := range migration.Renames {
+			if current == name {
+				previous, ok = old[former], true
+			}
+		}
+		if !ok || previous.Type == next[name].Type {
+			continue
+		}
+		if _, ok := compatConversion(previous.Type, next[name].Type); ok {
+			migration.Retyped[name] = typeChange{From: previous.Type, To: next[name].Type}
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: %s changed from %s to %s, which can't be converted\n", name, displayType(previous.Type), displayType(next[name].Type))
+		}
+	}
+	return migration, nil
+}
+
+// readSchemaContract reads the instance keys of a JSON schema written by
+// tfwrapper schema as a variable contract. Deprecated keys, the former
+// names of renamed variables, are left out.
+func readSchemaContract(path string) (map[string]contractVariable, bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, false, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	node := jsonSchemaToNode(schema)
+	iterable := false
+	if instances, ok := node.Attrs["instances"]; ok && instances.Kind == "map" && instances.Elem.Kind == "object" {
+		node, iterable = instances.Elem, true
+	}
+	if node.Kind != "object" {
+		return nil, false, fmt.Errorf("%s doesn't describe a config object", path)
+	}
+
+	contract := map[string]contractVariable{}
+	for name, attr := range node.Attrs {
+		if !attr.Deprecated {
+			contract[name] = contractVariable{Type: nodeType(attr), Required: slices.Contains(node.Required, name)}
+		}
+	}
+	return contract, iterable, nil
+}
+
+// jsonSchemaToNode reads back the subset of JSON schema jsonSchemaNode
+// writes.
+func jsonSchemaToNode(schema map[string]any) *schemaNode {
+	deprecated, _ := schema["deprecated"].(bool)
+	node := &schemaNode{Kind: "any", Deprecated: deprecated}
+	switch schema["type"] {
+	case "string", "number":
+		node.Kind = schema["type"].(string)
+	case "boolean":
+		node.Kind = "bool"
+	case "array":
+		if items, ok := schema["prefixItems"].([]any); ok {
+			node.Kind = "tuple"
+			for _, item := range items {
+				itemSchema, _ := item.(map[string]any)
+				node.Items = append(node.Items, jsonSchemaToNode(itemSchema))
+			}
+			break
+		}
+		node.Kind = "list"
+		if unique, _ := schema["uniqueItems"].(bool); unique {
+			node.Kind = "set"
+		}
+		items, _ := schema["items"].(map[string]any)
+		node.Elem = jsonSchemaToNode(items)
+	case "object":
+		if properties, ok := schema["properties"].(map[string]any); ok {
+			node.Kind = "object"
+			node.Attrs = map[string]*schemaNode{}
+			for name, property := range properties {
+				propertySchema, _ := property.(map[string]any)
+				node.Attrs[name] = jsonSchemaToNode(propertySchema)
+			}
+			required, _ := schema["required"].([]any)
+			for _, name := range required {
+				if s, ok := name.(string); ok {
+					node.Required = append(node.Required, s)
+				}
+			}
+			break
+		}
+		node.Kind = "map"
+		elem, _ := schema["additionalProperties"].(map[string]any)
+		node.Elem = jsonSchemaToNode(elem)
+	}
+	return node
+}
+
+// nodeType renders node as a Terraform type constraint.
+func nodeType(node *schemaNode) string {
+	switch node.Kind {
+	case "string", "number", "bool", "any":
+		return node.Kind
+	case "list", "set", "map":
+		return node.Kind + "(" + nodeType(node.Elem) + ")"
+	case "tuple":
+		items := make([]string, len(node.Items))
+		for i, item := range node.Items {
+			items[i] = nodeType(item)
+		}
+		return "tuple([" + strings.Join(items, ", ") + "])"
+	case "object":
+		attrs := make([]string, 0, len(node.Attrs))
+		for _, name := range sortedKeys(node.Attrs) {
+			typ := nodeType(node.Attrs[name])
+			if !slices.Contains(node.Required, name) {
+				typ = "optional(" + typ + ")"
+			}
+			attrs = append(attrs, hclKey(name)+" = "+typ)
+		}
+		return "object({" + strings.Join(attrs, ", ") + "})"
+	}
+	return "any"
+}
+
+// apply migrates config in place, returning the changes made and what
+// couldn't be migrated.
+func (m *configMigration) apply(config map[string]any) (changes, problems []string) {
+	// Enveloped documents hold the config in spec, and are brought to the
+	// wrapper's apiVersion
+	if _, ok := config["apiVersion"]; ok {
+		if m.Envelope != nil {
+			if config["kind"] != m.Envelope.Kind {
+				return nil, nil
+			}
+			if config["apiVersion"] != m.Envelope.APIVersion {
+				config["apiVersion"] = m.Envelope.APIVersion
+				changes = append(changes, "updated apiVersion to "+m.Envelope.APIVersion)
+			}
+		}
+		spec, ok := config["spec"].(map[string]any)
+		if !ok {
+			return changes, nil
+		}
+		config = spec
+	}
+
+	objects := map[string]map[string]any{}
+	if m.Iterable {
+		instances, _ := config["instances"].(map[string]any)
+		for name, instance := range instances {
+			if object, ok := instance.(map[string]any); ok {
+				objects["instances."+name+"."] = object
+			}
+		}
+	} else {
+		objects[""] = config
+	}
+	for _, layer := range m.Layers {
+		if object, ok := config[layer].(map[string]any); ok {
+			objects[layer+"."] = object
+		}
+	}
+
+	for _, prefix := range sortedKeys(objects) {
+		object := objects[prefix]
+		for _, old := range sortedKeys(m.Renames) {
+			value, ok := object[old]
+			if !ok {
+				continue
+			}
+			delete(object, old)
+			if _, set := object[m.Renames[old]]; set {
+				changes = append(changes, fmt.Sprintf("removed %s%s, %s%s is already set", prefix, old, prefix, m.Renames[old]))
+				continue
+			}
+			object[m.Renames[old]] = value
+			changes = append(changes, fmt.Sprintf("renamed %s%s to %s", prefix, old, m.Renames[old]))
+		}
+		for _, name := range sortedKeys(m.Retyped) {
+			value, ok := object[name]
+			to := typeNode(m.Retyped[name].To)
+			if !ok || matchesType(to, value) {
+				continue
+			}
+			if converted, ok := convertValue(value, to); ok {
+				object[name] = converted
+				changes = append(changes, fmt.Sprintf("converted %s%s to %s", prefix, name, m.Retyped[name].To))
+			} else {
+				problems = append(problems, fmt.Sprintf("%s%s can't be converted to %s", prefix, name, m.Retyped[name].To))
+			}
+		}
+	}
+	return changes, problems
+}
+
+// convertValue converts a decoded JSON value to node the way compat.tf
+// does: between primitive types, wrapping a primitive in a list and
+// unwrapping a single element list.
+func convertValue(value any, node *schemaNode) (any, bool) {
+	switch node.Kind {
+	case "list", "set":
+		if _, ok := value.([]any); ok {
+			return value, true
+		}
+		elem, ok := convertValue(value, node.Elem)
+		return []any{elem}, ok
+	case "string", "number", "bool":
+		if items, ok := value.([]any); ok {
+			if len(items) != 1 {
+				return nil, false
+			}
+			value = items[0]
+		}
+		var text string
+		switch v := value.(type) {
+		case string:
+			text = v
+		case json.Number:
+			text = v.String()
+		case bool:
+			text = strconv.FormatBool(v)
+		default:
+			return nil, false
+		}
+		switch node.Kind {
+		case "number":
+			if _, err := strconv.ParseFloat(text, 64); err != nil {
+				return nil, false
+			}
+			return json.Number(text), true
+		case "bool":
+			b, err := strconv.ParseBool(text)
+			return b, err == nil
+		}
+		return text, true
+	case "any":
+		return value, true
+	}
+	return nil, false
+}
+
+// findConfigFiles returns the JSON and YAML files below dir.
+func findConfigFiles(dir string) ([]string, error) {
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		switch filepath.Ext(path) {
+		case ".json", ".yaml", ".yml":
+			if !d.IsDir() {
+				paths = append(paths, path)
+			}
+		}
+		return nil
+	})
+	return paths, err
+}
+
+// writeConfigFile rewrites a config in the format of its extension.
+// Comments and key order aren't kept.
+func writeConfigFile(path string, config map[string]any) error {
+	var content string
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		yaml, err := manifestYAML(config)
+		if err != nil {
+			return err
+		}
+		content = yaml
+	} else {
+		data, err := json.MarshalIndent(config, "", "  ")
+		if err != nil {
+			return err
+		}
+		content = string(data) + "\n"
+	}
+	return writeFileAtomic(path, []byte(content), 0644)
+}
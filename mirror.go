@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// providerMirrorFile is the CLI configuration written with -provider-mirror.
+const providerMirrorFile = "terraform.rc"
+
+// validateProviderMirror checks -provider-mirror: a directory for a
+// filesystem mirror, or the https URL of a network mirror.
+func validateProviderMirror(mirror string) error {
+	if strings.HasPrefix(mirror, "http://") {
+		return fmt.Errorf("invalid -provider-mirror %q: a network mirror must use https", mirror)
+	}
+	return nil
+}
+
+// providerAddress qualifies a provider source address with the registry
+// binary installs it from by default.
+func providerAddress(source, binary string) string {
+	if strings.Count(source, "/") > 1 {
+		return source
+	}
+	if binary == "tofu" {
+		return "registry.opentofu.org/" + source
+	}
+	return "registry.terraform.io/" + source
+}
+
+// generateProviderMirrorRc writes a CLI configuration installing the
+// providers the module requires from opts.ProviderMirror, and every other
+// provider from its registry as usual.
+func generateProviderMirrorRc(opts generateOptions, name string, providers map[string]string) string {
+	addresses := make([]string, 0, len(providers))
+	for _, source := range sortedKeys(providers) {
+		addresses = append(addresses, hclString(providerAddress(source, opts.Binary)))
+	}
+	include := "[" + strings.Join(addresses, ", ") + "]"
+	network := strings.HasPrefix(opts.ProviderMirror, "https://")
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Installs the providers of the %s wrapper from a mirror, for air-gapped use.\n", name)
+	if network {
+		fmt.Fprintf(&b, "# Populate a directory from a connected machine, in a root module calling the\n# wrapper, with\n#   %s providers mirror <dir>\n", opts.Binary)
+		fmt.Fprintf(&b, "# and serve it at %s,\n", opts.ProviderMirror)
+	} else {
+		fmt.Fprintf(&b, "# Populate the mirror from a connected machine, in a root module calling the\n# wrapper, with\n#   %s providers mirror %s\n", opts.Binary, opts.ProviderMirror)
+	}
+	b.WriteString("# then use this file with TF_CLI_CONFIG_FILE=<path to this file>, or merge its\n")
+	b.WriteString("# provider_installation block into your CLI configuration\n")
+	b.WriteString("provider_installation {\n")
+	if network {
+		// Network mirror URLs must end with a slash
+		url := strings.TrimSuffix(opts.ProviderMirror, "/") + "/"
+		fmt.Fprintf(&b, "  network_mirror {\n    url = %s\n    include = %s\n  }\n", hclString(url), include)
+	} else {
+		fmt.Fprintf(&b, "  filesystem_mirror {\n    path = %s\n    include = %s\n  }\n", hclString(opts.ProviderMirror), include)
+	}
+	fmt.Fprintf(&b, "  direct {\n    exclude = %s\n  }\n", include)
+	b.WriteString("}\n")
+	return string(hclwrite.Format([]byte(b.String())))
+}
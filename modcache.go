@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// moduleCache keeps downloaded repositories and parsed variables for the
+// lifetime of a batch run, so wrapping many submodules of one repository at
+// the same version only clones and parses it once.
+type moduleCache struct {
+	dir string
+
+	mu        sync.Mutex
+	downloads map[string]*cachedDownload
+	parsed    map[string][]moduleVariable
+}
+
+type cachedDownload struct {
+	once      sync.Once
+	repoDir   string
+	innerPath string
+	err       error
+}
+
+func newModuleCache() (*moduleCache, error) {
+	dir, err := os.MkdirTemp("", "tfwrapper-cache-")
+	if err != nil {
+		return nil, err
+	}
+	return &moduleCache{
+		dir:       dir,
+		downloads: map[string]*cachedDownload{},
+		parsed:    map[string][]moduleVariable{},
+	}, nil
+}
+
+// Close removes everything the cache downloaded.
+func (c *moduleCache) Close() error {
+	return os.RemoveAll(c.dir)
+}
+
+// fetch returns the download of moduleSource at version, fetching it the
+// first time. Failures are cached too, so a bad source fails fast for every
+// spec that uses it.
+func (c *moduleCache) fetch(sb sandbox, moduleSource, version string) (string, string, error) {
+	c.mu.Lock()
+	key := moduleSource + "@" + version
+	d, ok := c.downloads[key]
+	if !ok {
+		d = &cachedDownload{}
+		c.downloads[key] = d
+	}
+	destDir := filepath.Join(c.dir, fmt.Sprint(len(c.downloads)))
+	c.mu.Unlock()
+
+	d.once.Do(func() {
+		if d.err = os.Mkdir(destDir, 0755); d.err != nil {
+			return
+		}
+		d.repoDir, d.innerPath, d.err = fetchSource(sb, moduleSource, version, destDir)
+	})
+	return d.repoDir, d.innerPath, d.err
+}
+
+// variables parses the variables of the module at modulePath once per set
+// of files.
+func (c *moduleCache) variables(modulePath string, files []string) ([]moduleVariable, error) {
+	key := modulePath + "\x00" + strings.Join(files, "\x00")
+	c.mu.Lock()
+	vars, ok := c.parsed[key]
+	c.mu.Unlock()
+	if ok {
+		return vars, nil
+	}
+
+	vars, err := parseVariables(os.DirFS(modulePath), files...)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.parsed[key] = vars
+	c.mu.Unlock()
+	return vars, nil
+}
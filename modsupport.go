@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclparse"
+)
+
+// maxModuleDepth bounds how deep we follow local module calls.
+const maxModuleDepth = 16
+
+// moduleIssue is a construct in the upstream module that the wrapper pattern
+// can't support (Fatal) or that behaves differently once wrapped.
+type moduleIssue struct {
+	Where   string `json:"where"`
+	Message string `json:"message"`
+	Fatal   bool   `json:"fatal"`
+
+	provider bool
+}
+
+// moduleChecker walks a module and the local modules it calls. Along the
+// way it takes an inventory of the resource and data source types they
+// declare, their provider version constraints, and of the module's own
+// outputs.
+type moduleChecker struct {
+	repoDir  string
+	iterable bool
+	force    bool
+	parser   *hclparse.Parser
+	visited  map[string]bool
+	issues   []moduleIssue
+
+	resources   map[string]int
+	dataSources map[string]int
+	outputs     []moduleOutput
+	// providers maps provider source addresses to the distinct version
+	// constraints required for them
+	providers map[string][]string
+	// coreVersions are the distinct required_version constraints
+	coreVersions []string
+}
+
+func newModuleChecker(repoDir string, iterable, force bool) *moduleChecker {
+	return &moduleChecker{
+		repoDir:     repoDir,
+		iterable:    iterable,
+		force:       force,
+		parser:      hclparse.NewParser(),
+		visited:     map[string]bool{},
+		resources:   map[string]int{},
+		dataSources: map[string]int{},
+		outputs:     []moduleOutput{},
+		providers:   map[string][]string{},
+	}
+}
+
+// checkModuleSupport looks for constructs in the module at modulePath, and
+// every local module it calls, that would make the generated wrapper fail
+// at init or plan. Warnings are printed; fatal issues are returned together
+// as an ErrUnsupportedFeature. With force, provider blocks in an iterable
+// wrapper are only warned about. The provider version constraints and the
+// sorted outputs of the module are returned.
+func checkModuleSupport(repoDir, modulePath string, iterable, force bool) (map[string]string, []moduleOutput, error) {
+	c := newModuleChecker(repoDir, iterable, force)
+	c.check(modulePath, nil)
+
+	var fatal []string
+	providers := false
+	for _, issue := range c.issues {
+		providers = providers || issue.provider
+		if issue.Fatal {
+			fatal = append(fatal, fmt.Sprintf("%s: %s", issue.Where, issue.Message))
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", issue.Where, issue.Message)
+		}
+	}
+	if len(fatal) > 0 {
+		err := fmt.Errorf("%w: the module can't be wrapped:\n  %s", ErrUnsupportedFeature, strings.Join(fatal, "\n  "))
+		if providers && !force {
+			err = fmt.Errorf("%w\nTerraform only allows count and for_each on modules that receive their providers from the caller. "+
+				"Generate the wrapper without -iterable or -conditional and call it once per instance, "+
+				"or use -iterable=force to generate it anyway (it will fail at init until the provider blocks are removed upstream)", err)
+		}
+		return nil, nil, err
+	}
+	return c.providerConstraints(), c.sortedOutputs(), nil
+}
+
+// sortedOutputs returns the outputs of the module sorted by name.
+func (c *moduleChecker) sortedOutputs() []moduleOutput {
+	slices.SortFunc(c.outputs, func(a, b moduleOutput) int { return strings.Compare(a.Name, b.Name) })
+	return c.outputs
+}
+
+// providerConstraints joins the constraints required for each provider into
+// a single constraint.
+func (c *moduleChecker) providerConstraints() map[string]string {
+	constraints := make(map[string]string, len(c.providers))
+	for source, list := range c.providers {
+		constraints[source] = strings.Join(list, ", ")
+	}
+	return constraints
+}
+
+func (c *moduleChecker) add(rng hcl.Range, fatal bool, format string, args ...any) *moduleIssue {
+	where := rng.Filename
+	if rel, err := filepath.Rel(c.repoDir, rng.Filename); err == nil {
+		where = rel
+	}
+	c.issues = append(c.issues, moduleIssue{
+		Where:   fmt.Sprintf("%s:%d", filepath.ToSlash(where), rng.Start.Line),
+		Message: fmt.Sprintf(format, args...),
+		Fatal:   fatal,
+	})
+	return &c.issues[len(c.issues)-1]
+}
+
+// check inspects the .tf files directly in dir. chain holds the local module
+// directories leading here, to detect cycles.
+func (c *moduleChecker) check(dir string, chain []string) {
+	c.visited[dir] = true
+	chain = append(chain, dir)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.tf"))
+	sort.Strings(files)
+	for _, path := range files {
+		file, diags := c.parser.ParseHCLFile(path)
+		if diags.HasErrors() {
+			// Parse errors are reported when variables.tf is read, or
+			// by terraform itself for other files
+			continue
+		}
+		content, _, _ := file.Body.PartialContent(&hcl.BodySchema{
+			Blocks: []hcl.BlockHeaderSchema{
+				{Type: "provider", LabelNames: []string{"name"}},
+				{Type: "terraform"},
+				{Type: "module", LabelNames: []string{"name"}},
+				{Type: "resource", LabelNames: []string{"type", "name"}},
+				{Type: "data", LabelNames: []string{"type", "name"}},
+				{Type: "output", LabelNames: []string{"name"}},
+			},
+		})
+		for _, block := range content.Blocks {
+			switch block.Type {
+			case "provider":
+				if c.iterable {
+					issue := c.add(block.DefRange, !c.force, "provider %q is configured inside the module, so it can't be used with count or for_each", block.Labels[0])
+					issue.provider = true
+				}
+			case "terraform":
+				inner, _, _ := block.Body.PartialContent(&hcl.BodySchema{
+					Attributes: []hcl.AttributeSchema{{Name: "required_version"}},
+					Blocks:     []hcl.BlockHeaderSchema{{Type: "backend", LabelNames: []string{"type"}}, {Type: "cloud"}},
+				})
+				if constraint := staticString(inner.Attributes["required_version"]); constraint != "" && !slices.Contains(c.coreVersions, constraint) {
+					c.coreVersions = append(c.coreVersions, constraint)
+				}
+				for _, b := range inner.Blocks {
+					c.add(b.DefRange, false, "%s configuration in a child module is ignored by terraform", b.Type)
+				}
+				for source, constraint := range requiredProviders(block) {
+					list := c.providers[source]
+					if constraint != "" && !slices.Contains(list, constraint) {
+						list = append(list, constraint)
+					}
+					c.providers[source] = list
+				}
+			case "module":
+				c.checkModuleCall(dir, block, chain)
+			case "resource":
+				c.resources[block.Labels[0]]++
+			case "data":
+				c.dataSources[block.Labels[0]]++
+			case "output":
+				// Only the outputs of the wrapped module itself matter
+				if len(chain) == 1 {
+					c.outputs = append(c.outputs, moduleOutput{Name: block.Labels[0], Description: outputDescription(block)})
+				}
+			}
+		}
+	}
+}
+
+func (c *moduleChecker) checkModuleCall(dir string, block *hcl.Block, chain []string) {
+	attrs, _, _ := block.Body.PartialContent(&hcl.BodySchema{
+		Attributes: []hcl.AttributeSchema{{Name: "source"}},
+	})
+	source := staticString(attrs.Attributes["source"])
+	if !isLocalSource(source) {
+		return
+	}
+
+	target := filepath.Join(dir, filepath.FromSlash(source))
+	rel, err := filepath.Rel(c.repoDir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		c.add(block.DefRange, true, "module.%s source %q points outside of the downloaded module", block.Labels[0], source)
+		return
+	}
+	if _, err := os.Stat(target); err != nil {
+		c.add(block.DefRange, true, "module.%s source %q does not exist", block.Labels[0], source)
+		return
+	}
+	if err := ensureWithin(c.repoDir, target); err != nil {
+		c.add(block.DefRange, true, "module.%s source %q points outside of the downloaded module", block.Labels[0], source)
+		return
+	}
+	for _, d := range chain {
+		if d == target {
+			c.add(block.DefRange, true, "module.%s source %q calls a module that is already being called (cycle)", block.Labels[0], source)
+			return
+		}
+	}
+	if len(chain) >= maxModuleDepth {
+		c.add(block.DefRange, true, "module.%s: local modules are nested more than %d levels deep", block.Labels[0], maxModuleDepth)
+		return
+	}
+	if c.visited[target] {
+		return
+	}
+	c.check(target, chain)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+)
+
+// manifestFileName is written at the root of a monorepo and maps each
+// published wrapper to its latest tag.
+const manifestFileName = "wrappers.json"
+
+var semverTag = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(-[0-9A-Za-z.-]+)?$`)
+
+type manifest struct {
+	Wrappers map[string]manifestEntry `json:"wrappers"`
+}
+
+type manifestEntry struct {
+	Path   string `json:"path"`
+	Tag    string `json:"tag"`
+	Source string `json:"source"`
+}
+
+// monorepoTag returns the per-wrapper tag (`vpc/v1.3.0`) for version.
+func monorepoTag(name, version string) (string, error) {
+	if !semverTag.MatchString(version) {
+		return "", fmt.Errorf("%q is not a semantic version", version)
+	}
+	return name + "/" + version, nil
+}
+
+// updateManifest records tag as the latest release of the wrapper at
+// wrapperPath in the manifest at the root of repoDir.
+func updateManifest(repoDir, repo, name, wrapperPath, tag string) error {
+	manifestPath := filepath.Join(repoDir, manifestFileName)
+
+	m := manifest{Wrappers: map[string]manifestEntry{}}
+	data, err := os.ReadFile(manifestPath)
+	if err == nil {
+		if err := json.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("failed to parse %s: %w", manifestFileName, err)
+		}
+		if m.Wrappers == nil {
+			m.Wrappers = map[string]manifestEntry{}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	wrapperPath = path.Clean(filepath.ToSlash(wrapperPath))
+	m.Wrappers[name] = manifestEntry{
+		Path:   wrapperPath,
+		Tag:    tag,
+		Source: fmt.Sprintf("git::%s//%s?ref=%s", repo, wrapperPath, tag),
+	}
+
+	data, err = json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(manifestPath, append(data, '\n'), 0644)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// variableSchema selects the variable blocks of a .tf file.
+var variableSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{
+		{Type: "variable", LabelNames: []string{"name"}},
+	},
+}
+
+// newerFunctions are the functions of recent Terraform versions a default
+// copied into the wrapper may call, with the version introducing them.
+var newerFunctions = map[string]string{
+	"issensitive":     "1.8",
+	"templatestring":  "1.9",
+	"ephemeralasnull": "1.10",
+}
+
+// warnNewerFunctions warns about the calls in a default copied verbatim that
+// the wrapper may not be able to make: provider functions need the provider
+// in the wrapper's required_providers, newer functions a newer Terraform.
+func warnNewerFunctions(filename, name string, expr hcl.Expression) {
+	node, ok := expr.(hclsyntax.Node)
+	if !ok {
+		return
+	}
+	seen := map[string]bool{}
+	hclsyntax.VisitAll(node, func(n hclsyntax.Node) hcl.Diagnostics {
+		call, ok := n.(*hclsyntax.FunctionCallExpr)
+		if !ok || seen[call.Name] {
+			return nil
+		}
+		seen[call.Name] = true
+		if parts := strings.Split(call.Name, "::"); len(parts) == 3 && parts[0] == "provider" {
+			fmt.Fprintf(os.Stderr, "Warning: %s: the default of variable %q calls %s and is copied verbatim. The wrapper needs provider %q in its required_providers, and Terraform >= 1.8\n", filename, name, call.Name, parts[1])
+		} else if version, ok := newerFunctions[call.Name]; ok {
+			fmt.Fprintf(os.Stderr, "Warning: %s: the default of variable %q calls %s and is copied verbatim. The wrapper needs Terraform >= %s\n", filename, name, call.Name, version)
+		}
+		return nil
+	})
+}
+
+// recoveredBlock is a variable block read from a file that doesn't parse as
+// a whole, e.g. because it uses syntax of a newer Terraform version.
+type recoveredBlock struct {
+	*hcl.Block
+	// Unparsed maps the attributes that still don't parse to their source,
+	// so a default can be copied verbatim
+	Unparsed map[string]string
+}
+
+// recoverVariables reads the variable blocks of src one at a time, split up
+// with the lexer, which never fails. Body items of a block that don't parse
+// on their own, like a validation using new syntax, are blanked out, keeping
+// the positions of the rest. It reports false when a variable block can't be
+// read at all.
+func recoverVariables(src []byte, filename string) ([]recoveredBlock, bool) {
+	tokens, _ := hclsyntax.LexConfig(src, filename, hcl.InitialPos)
+	var blocks []recoveredBlock
+	for _, item := range splitBodyItems(tokens) {
+		if item[0].Type != hclsyntax.TokenIdent || string(item[0].Bytes) != "variable" {
+			continue
+		}
+		block, ok := recoverVariable(src, filename, item)
+		if !ok {
+			return nil, false
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks, true
+}
+
+func recoverVariable(src []byte, filename string, item hclsyntax.Tokens) (recoveredBlock, bool) {
+	rb := recoveredBlock{Unparsed: map[string]string{}}
+	open := slices.IndexFunc(item, func(t hclsyntax.Token) bool { return t.Type == hclsyntax.TokenOBrace })
+	if open < 0 || item[len(item)-1].Type != hclsyntax.TokenCBrace {
+		return rb, false
+	}
+
+	start, end := item[0].Range.Start, item[len(item)-1].Range.End
+	chunk := slices.Clone(src[start.Byte:end.Byte])
+	for _, attr := range splitBodyItems(item[open+1 : len(item)-1]) {
+		first, last := attr[0].Range.Start, attr[len(attr)-1].Range.End
+		if _, diags := hclsyntax.ParseConfig(src[first.Byte:last.Byte], filename, first); !diags.HasErrors() {
+			continue
+		}
+		if len(attr) > 2 && attr[0].Type == hclsyntax.TokenIdent && attr[1].Type == hclsyntax.TokenEqual {
+			rb.Unparsed[string(attr[0].Bytes)] = strings.TrimSpace(string(src[attr[2].Range.Start.Byte:last.Byte]))
+		}
+		for i := first.Byte - start.Byte; i < last.Byte-start.Byte; i++ {
+			if chunk[i] != '\n' {
+				chunk[i] = ' '
+			}
+		}
+	}
+
+	file, diags := hclsyntax.ParseConfig(chunk, filename, start)
+	if diags.HasErrors() {
+		return rb, false
+	}
+	content, _, diags := file.Body.PartialContent(variableSchema)
+	if diags.HasErrors() || len(content.Blocks) != 1 {
+		return rb, false
+	}
+	rb.Block = content.Blocks[0]
+	return rb, true
+}
+
+// splitBodyItems splits the tokens of a body into its attributes and blocks,
+// which end at a newline outside of any brackets. Comments are dropped from
+// the ends of each item.
+func splitBodyItems(tokens hclsyntax.Tokens) []hclsyntax.Tokens {
+	var items []hclsyntax.Tokens
+	depth, begin := 0, 0
+	flush := func(end int) {
+		item := tokens[begin:end]
+		for len(item) > 0 && item[0].Type == hclsyntax.TokenComment {
+			item = item[1:]
+		}
+		for len(item) > 0 && item[len(item)-1].Type == hclsyntax.TokenComment {
+			item = item[:len(item)-1]
+		}
+		if len(item) > 0 {
+			items = append(items, item)
+		}
+		begin = end + 1
+	}
+	for i, t := range tokens {
+		switch t.Type {
+		case hclsyntax.TokenOBrace, hclsyntax.TokenOBrack, hclsyntax.TokenOParen,
+			hclsyntax.TokenTemplateInterp, hclsyntax.TokenTemplateControl, hclsyntax.TokenOHeredoc:
+			depth++
+		case hclsyntax.TokenCBrace, hclsyntax.TokenCBrack, hclsyntax.TokenCParen,
+			hclsyntax.TokenTemplateSeqEnd, hclsyntax.TokenCHeredoc:
+			depth = max(depth-1, 0)
+		case hclsyntax.TokenNewline, hclsyntax.TokenEOF:
+			if depth == 0 {
+				flush(i)
+			}
+		case hclsyntax.TokenComment:
+			// Line comments include the newline ending the item
+			if depth == 0 && strings.HasSuffix(string(t.Bytes), "\n") {
+				flush(i)
+			}
+		}
+	}
+	if begin < len(tokens) {
+		flush(len(tokens))
+	}
+	return items
+}
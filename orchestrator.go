@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// orchestrators are the platforms -orchestrator writes stack definitions
+// for, with the Terraform provider managing them.
+var orchestrators = map[string]string{
+	"spacelift": "spacelift-io/spacelift",
+	"env0":      "env0/env0",
+}
+
+// validateOrchestrator checks the -orchestrator option. The stacks read the
+// configs/ skeletons, so environments are required.
+func validateOrchestrator(opts generateOptions) error {
+	if opts.Orchestrator == "" {
+		return nil
+	}
+	if _, ok := orchestrators[opts.Orchestrator]; !ok {
+		return fmt.Errorf("invalid -orchestrator %q: must be spacelift or env0", opts.Orchestrator)
+	}
+	if len(opts.Environments) == 0 {
+		return fmt.Errorf("-orchestrator needs -environments, one stack is defined per environment config")
+	}
+	if opts.ConfigFrom != "" {
+		return fmt.Errorf("-orchestrator can't be combined with -config-from, whose config doesn't come from %s/", configsDir)
+	}
+	return nil
+}
+
+// generateOrchestratorTf returns a Terraform module, in a directory named
+// after the orchestrator, defining one stack per environment. Each stack
+// runs the wrapper with var.config set to the common config merged with the
+// environment's, like tfvars does.
+func generateOrchestratorTf(opts generateOptions, name string) generatedFile {
+	format := opts.ConfigFormat
+	if format == "" {
+		format = "json"
+	}
+	decode := "jsondecode"
+	if format == "yaml" {
+		decode = "yamldecode"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s definitions of the %s wrapper, one per environment config under %s/.\n", opts.Orchestrator, name, configsDir)
+	b.WriteString("# Apply this from your administrative stack, and again whenever a config changes.\n\n")
+	fmt.Fprintf(&b, "terraform {\n  required_providers {\n    %s = {\n      source = %q\n    }\n  }\n}\n\n", opts.Orchestrator, orchestrators[opts.Orchestrator])
+	b.WriteString("variable \"repository\" {\n  description = \"Repository holding the wrapper\"\n  type        = string\n}\n\n")
+	b.WriteString("variable \"branch\" {\n  description = \"Branch the stacks track\"\n  type        = string\n  default     = \"main\"\n}\n\n")
+	fmt.Fprintf(&b, "variable \"project_root\" {\n  description = \"Path of the wrapper in the repository\"\n  type        = string\n  default     = %q\n}\n\n", name)
+
+	quoted := make([]string, len(opts.Environments))
+	for i, env := range opts.Environments {
+		quoted[i] = hclString(env)
+	}
+	locals := fmt.Sprintf("locals {\n  environments = toset([%s])\n}\n\n", strings.Join(quoted, ", "))
+	common := fmt.Sprintf("%s(file(\"${path.module}/../%s/%s.%s\"))", decode, configsDir, commonConfig, format)
+	env := fmt.Sprintf("%s(file(\"${path.module}/../%s/${each.key}.%s\"))", decode, configsDir, format)
+
+	switch opts.Orchestrator {
+	case "spacelift":
+		tool := "TERRAFORM_FOSS"
+		if opts.Binary == "tofu" {
+			tool = "OPEN_TOFU"
+		}
+		b.WriteString("variable \"space_id\" {\n  description = \"Space the stacks are created in\"\n  type        = string\n  default     = \"root\"\n}\n\n")
+		b.WriteString(locals)
+		fmt.Fprintf(&b, "resource \"spacelift_stack\" \"this\" {\n  for_each = local.environments\n\n")
+		fmt.Fprintf(&b, "  name                    = \"%s-${each.key}\"\n", name)
+		b.WriteString("  repository              = var.repository\n  branch                  = var.branch\n  project_root            = var.project_root\n  space_id                = var.space_id\n")
+		fmt.Fprintf(&b, "  terraform_workflow_tool = %q\n  labels                  = [\"tfwrapper\", %s]\n}\n\n", tool, hclString(name))
+		b.WriteString("resource \"spacelift_environment_variable\" \"config\" {\n  for_each = local.environments\n\n")
+		b.WriteString("  stack_id   = spacelift_stack.this[each.key].id\n  name       = \"TF_VAR_config\"\n")
+		fmt.Fprintf(&b, "  value      = jsonencode(merge(%s, %s))\n  write_only = false\n}\n", common, env)
+	case "env0":
+		typ := "terraform"
+		if opts.Binary == "tofu" {
+			typ = "opentofu"
+		}
+		b.WriteString("variable \"project_id\" {\n  description = \"env0 project the environments are created in\"\n  type        = string\n}\n\n")
+		b.WriteString(locals)
+		fmt.Fprintf(&b, "resource \"env0_template\" \"this\" {\n  name        = %s\n  description = %s\n", hclString(name), hclString("tfwrapper wrapper of "+opts.Source))
+		fmt.Fprintf(&b, "  repository  = var.repository\n  revision    = var.branch\n  path        = var.project_root\n  type        = %q\n}\n\n", typ)
+		b.WriteString("resource \"env0_template_project_assignment\" \"this\" {\n  template_id = env0_template.this.id\n  project_id  = var.project_id\n}\n\n")
+		b.WriteString("resource \"env0_environment\" \"this\" {\n  for_each = local.environments\n\n")
+		fmt.Fprintf(&b, "  name        = \"%s-${each.key}\"\n", name)
+		b.WriteString("  project_id  = var.project_id\n  template_id = env0_template_project_assignment.this.template_id\n\n")
+		b.WriteString("  configuration {\n    name  = \"config\"\n    type  = \"terraform\"\n")
+		fmt.Fprintf(&b, "    value = jsonencode(merge(%s, %s))\n  }\n}\n", common, env)
+	}
+	return generatedFile{path.Join(opts.Orchestrator, "main.tf"), string(hclwrite.Format([]byte(b.String())))}
+}
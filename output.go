@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// outputSink is where generated wrapper files are written. Generation only
+// writes through this interface, so the same code targets a directory, an
+// archive, memory (for -stdout, -diff and selftest) or a git branch.
+type outputSink interface {
+	MkdirAll(dir string) error
+	WriteFile(path string, data []byte, perm fs.FileMode) error
+	// Remove deletes a file an earlier generation wrote, if it is there.
+	Remove(path string) error
+	// Close finishes the output once everything has been written, e.g. by
+	// writing the archive or committing the branch.
+	Close() error
+}
+
+// persistentSink reports whether out writes somewhere that the previous
+// generation's metadata can be read back from.
+func persistentSink(out outputSink) bool {
+	switch out.(type) {
+	case dirSink, *gitSink, *diffSink:
+		return true
+	}
+	return false
+}
+
+// dirSink writes to the local filesystem.
+type dirSink struct{}
+
+func (dirSink) MkdirAll(dir string) error {
+	if err := checkWrite(dir); err != nil {
+		return err
+	}
+	return os.MkdirAll(dir, 0755)
+}
+
+func (dirSink) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	if err := checkWrite(path); err != nil {
+		return err
+	}
+	err := os.WriteFile(path, data, perm)
+	if os.IsPermission(err) {
+		// Read-only files (e.g. upstream snapshots) are replaced, not
+		// written over
+		if rmErr := os.Remove(path); rmErr == nil {
+			err = os.WriteFile(path, data, perm)
+		}
+	}
+	return err
+}
+
+func (dirSink) Remove(path string) error {
+	if err := checkWrite(path); err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (dirSink) Close() error {
+	return nil
+}
+
+// existingFiles lists the files below dir that out already has, relative to
+// dir, such as those an earlier generation wrote. Sinks starting out empty
+// have none. Directories named skip aren't listed.
+func existingFiles(out outputSink, dir string, skip ...string) ([]string, error) {
+	switch s := out.(type) {
+	case dirSink:
+	case *gitSink:
+		var err error
+		if dir, err = s.target(dir); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == dir {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && slices.Contains(skip, d.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	return files, err
+}
+
+// memSink keeps generated files in memory, keyed by slash-separated path.
+type memSink struct {
+	files map[string]memFile
+}
+
+type memFile struct {
+	Data []byte
+	Mode fs.FileMode
+}
+
+func newMemSink() *memSink {
+	return &memSink{files: make(map[string]memFile)}
+}
+
+func (m *memSink) MkdirAll(dir string) error {
+	return nil
+}
+
+func (m *memSink) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	m.files[filepath.ToSlash(filepath.Clean(path))] = memFile{Data: append([]byte(nil), data...), Mode: perm}
+	return nil
+}
+
+func (m *memSink) Remove(path string) error {
+	delete(m.files, filepath.ToSlash(filepath.Clean(path)))
+	return nil
+}
+
+func (m *memSink) Close() error {
+	return nil
+}
+
+// paths returns the paths of all files written, sorted.
+func (m *memSink) paths() []string {
+	paths := make([]string, 0, len(m.files))
+	for path := range m.files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// diffSink keeps generated files in memory like memSink, but generation
+// reads the previous metadata and kept files from disk as if it were writing
+// there, so the result can be compared with the wrapper on disk.
+type diffSink struct {
+	*memSink
+}
+
+func newDiffSink() *diffSink {
+	return &diffSink{newMemSink()}
+}
+
+// diff returns a unified diff from the files on disk to the ones generated.
+// Files that would be created are diffed against /dev/null.
+func (d *diffSink) diff() (string, error) {
+	var b strings.Builder
+	for _, path := range d.paths() {
+		aName := "a/" + path
+		old, err := os.ReadFile(filepath.FromSlash(path))
+		if os.IsNotExist(err) {
+			aName = "/dev/null"
+		} else if err != nil {
+			return "", err
+		}
+		b.WriteString(unifiedDiff(aName, "b/"+path, string(old), string(d.files[path].Data)))
+	}
+	return b.String(), nil
+}
+
+// archiveSink collects the files in memory and writes them to an archive
+// at path when closed.
+type archiveSink struct {
+	*memSink
+	path string
+}
+
+func newArchiveSink(path string) *archiveSink {
+	return &archiveSink{memSink: newMemSink(), path: path}
+}
+
+func (a *archiveSink) Close() error {
+	return writeArchive(a.path, a.memSink)
+}
+
+// gitSink writes onto a new branch of the git repository containing base,
+// through a temporary worktree, and commits the result when closed. The
+// checked out branch and working tree of the repository are left alone.
+type gitSink struct {
+	sb       sandbox
+	base     string
+	worktree string
+	prefix   string
+	repoDir  string
+	branch   string
+	message  string
+
+	// committed is set by Close when there was something to commit.
+	committed bool
+}
+
+// newGitSink creates branch from HEAD of the repository containing base.
+// Paths written must be under base and are mapped to the same place in the
+// branch.
+func newGitSink(sb sandbox, base, branch, message string) (*gitSink, error) {
+	base, err := filepath.Abs(base)
+	if err != nil {
+		return nil, err
+	}
+	out, err := sb.run(base, "git", "rev-parse", "--show-toplevel", "--show-prefix")
+	if err != nil {
+		return nil, fmt.Errorf("%s is not in a git repository: %w", base, err)
+	}
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	g := &gitSink{sb: sb, base: base, repoDir: lines[0], branch: branch, message: message}
+	if len(lines) > 1 {
+		g.prefix = filepath.FromSlash(lines[1])
+	}
+
+	if g.worktree, err = os.MkdirTemp("", "tfwrapper-branch-"); err != nil {
+		return nil, err
+	}
+	if _, err := sb.run(g.repoDir, "git", "worktree", "add", "--quiet", "-b", branch, g.worktree, "HEAD"); err != nil {
+		os.RemoveAll(g.worktree)
+		return nil, fmt.Errorf("failed to create branch %s: %w", branch, err)
+	}
+	return g, nil
+}
+
+// target maps a path under base into the worktree.
+func (g *gitSink) target(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(g.base, path)
+	if err != nil || !filepath.IsLocal(rel) && rel != "." {
+		return "", fmt.Errorf("%s is outside %s", path, g.base)
+	}
+	return filepath.Join(g.worktree, g.prefix, rel), nil
+}
+
+func (g *gitSink) MkdirAll(dir string) error {
+	target, err := g.target(dir)
+	if err != nil {
+		return err
+	}
+	return dirSink{}.MkdirAll(target)
+}
+
+func (g *gitSink) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	target, err := g.target(path)
+	if err != nil {
+		return err
+	}
+	return dirSink{}.WriteFile(target, data, perm)
+}
+
+func (g *gitSink) Remove(path string) error {
+	target, err := g.target(path)
+	if err != nil {
+		return err
+	}
+	return dirSink{}.Remove(target)
+}
+
+// Close commits everything written to the branch, if anything changed, and
+// removes the worktree. The branch itself is kept.
+func (g *gitSink) Close() error {
+	defer func() {
+		g.sb.run(g.repoDir, "git", "worktree", "remove", "--force", g.worktree)
+		os.RemoveAll(g.worktree)
+	}()
+
+	if _, err := g.sb.run(g.worktree, "git", "add", "-A"); err != nil {
+		return err
+	}
+	status, err := g.sb.run(g.worktree, "git", "status", "--porcelain")
+	if err != nil {
+		return err
+	}
+	if len(status) == 0 {
+		return nil
+	}
+	if _, err := g.sb.run(g.worktree, "git", "commit", "--quiet", "-m", g.message); err != nil {
+		return fmt.Errorf("failed to commit to %s: %w", g.branch, err)
+	}
+	g.committed = true
+	return nil
+}
+
+// readMetadata reads the metadata of a wrapper written to the branch, before
+// the sink is closed.
+func (g *gitSink) readMetadata(wrapperDir string) (*provenance, error) {
+	target, err := g.target(wrapperDir)
+	if err != nil {
+		return nil, err
+	}
+	return readMetadata(target)
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
+)
+
+// outputsContract declares which outputs of a wrapper each downstream
+// consumer may read:
+//
+//	consumer "network-stack" {
+//	  outputs = ["vpc_id", "private_subnets"]
+//	}
+//
+// A wrapper generated with -outputs-contract only exports their union.
+type outputsContract struct {
+	// Consumers maps each consumer to the outputs it reads
+	Consumers map[string][]string `json:"consumers"`
+}
+
+// outputsContractFile is the decoded form of an -outputs-contract file.
+type outputsContractFile struct {
+	Consumers []struct {
+		Name    string   `hcl:"name,label"`
+		Outputs []string `hcl:"outputs"`
+	} `hcl:"consumer,block"`
+}
+
+var outputsSchema = &hcl.BodySchema{
+	Blocks: []hcl.BlockHeaderSchema{{Type: "output", LabelNames: []string{"name"}}},
+}
+
+// loadOutputsContract reads an -outputs-contract file. It returns nil for an
+// empty path.
+func loadOutputsContract(path string) (*outputsContract, error) {
+	if path == "" {
+		return nil, nil
+	}
+	parser := hclparse.NewParser()
+	file, diags := parser.ParseHCLFile(path)
+	if diags.HasErrors() {
+		return nil, newParseError(diags, parser.Files())
+	}
+	var decoded outputsContractFile
+	if diags := gohcl.DecodeBody(file.Body, nil, &decoded); diags.HasErrors() {
+		return nil, newParseError(diags, parser.Files())
+	}
+	if len(decoded.Consumers) == 0 {
+		return nil, fmt.Errorf("%s declares no consumer", path)
+	}
+	contract := &outputsContract{Consumers: map[string][]string{}}
+	for _, consumer := range decoded.Consumers {
+		if _, ok := contract.Consumers[consumer.Name]; ok {
+			return nil, fmt.Errorf("%s: consumer %q is declared twice", path, consumer.Name)
+		}
+		outputs := slices.Clone(consumer.Outputs)
+		sort.Strings(outputs)
+		contract.Consumers[consumer.Name] = slices.Compact(outputs)
+	}
+	return contract, nil
+}
+
+// outputs returns the sorted outputs any consumer reads.
+func (c *outputsContract) outputs() []string {
+	var outputs []string
+	for _, list := range c.Consumers {
+		outputs = append(outputs, list...)
+	}
+	sort.Strings(outputs)
+	return slices.Compact(outputs)
+}
+
+// applyOutputsContract returns the upstream outputs the contract allows. An
+// output a consumer reads that upstream doesn't have is a breaking change,
+// only warned about with allowBreaking.
+func applyOutputsContract(contract *outputsContract, outputs []moduleOutput, source, version string, allowBreaking bool) ([]moduleOutput, error) {
+	if contract == nil {
+		return outputs, nil
+	}
+	allowed := contract.outputs()
+	var missing []string
+	for _, consumer := range sortedKeys(contract.Consumers) {
+		for _, name := range contract.Consumers[consumer] {
+			if !slices.ContainsFunc(outputs, func(o moduleOutput) bool { return o.Name == name }) {
+				missing = append(missing, fmt.Sprintf("%s (read by %s)", name, consumer))
+			}
+		}
+	}
+	if len(missing) > 0 {
+		if !allowBreaking {
+			return nil, fmt.Errorf("%w: %s %s doesn't have the outputs %s of the outputs contract. Update the consumers and the contract, or regenerate with -allow-breaking",
+				ErrBreakingChange, source, displayVersion(version), strings.Join(missing, ", "))
+		}
+		fmt.Fprintf(os.Stderr, "Warning: %s %s doesn't have the outputs %s of the outputs contract\n", source, displayVersion(version), strings.Join(missing, ", "))
+	}
+	return slices.DeleteFunc(slices.Clone(outputs), func(o moduleOutput) bool {
+		return !slices.Contains(allowed, o.Name)
+	}), nil
+}
+
+// legacyOutput reports whether the wrapper writes the legacy output object,
+// which an outputs contract rules out as it exposes every output.
+func legacyOutput(opts generateOptions) bool {
+	return !opts.NoLegacyOutput && opts.OutputsContract == ""
+}
+
+func runCheckOutputs(args []string) {
+	fs := flag.NewFlagSet("check-outputs", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory to look for wrappers in")
+	requireContract := fs.Bool("require-contract", false, "Also flag wrappers generated without an outputs contract")
+	fs.Parse(args)
+
+	wrappers, err := findWrapperDirs(*dir)
+	if err != nil {
+		log.Fatalf("Failed to find wrappers: %v", err)
+	}
+	flagged := 0
+	for _, w := range wrappers {
+		problems, err := checkWrapperOutputs(w, *requireContract)
+		if err != nil {
+			fatalError("Failed to check "+w, err)
+		}
+		for _, problem := range problems {
+			fmt.Printf("%s: %s\n", w, problem)
+		}
+		if len(problems) > 0 {
+			flagged++
+		}
+	}
+	fmt.Printf("Checked %d wrappers, %d exceed or miss their outputs contract\n", len(wrappers), flagged)
+	if flagged > 0 {
+		os.Exit(1)
+	}
+}
+
+// checkWrapperOutputs compares the outputs the wrapper in dir declares with
+// its outputs contract: the -outputs-contract file if it still exists, else
+// the contract recorded at generation, so hand-added outputs and contracts
+// tightened since are both caught.
+func checkWrapperOutputs(dir string, requireContract bool) ([]string, error) {
+	prov, err := readMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	contract := prov.OutputsContract
+	if path := prov.Options.OutputsContract; path != "" {
+		if _, err := os.Stat(path); err == nil {
+			if contract, err = loadOutputsContract(path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if contract == nil {
+		if requireContract {
+			return []string{"has no outputs contract"}, nil
+		}
+		return nil, nil
+	}
+
+	exposed, err := declaredOutputs(dir)
+	if err != nil {
+		return nil, err
+	}
+	allowed := contract.outputs()
+	var problems, extra, missing []string
+	for _, name := range exposed {
+		if name == legacyOutputName && !slices.Contains(allowed, name) {
+			problems = append(problems, fmt.Sprintf("the legacy %q object exposes every output, regenerate with -outputs-contract", legacyOutputName))
+		} else if !slices.Contains(allowed, name) {
+			extra = append(extra, name)
+		}
+	}
+	for _, name := range allowed {
+		if !slices.Contains(exposed, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(extra) > 0 {
+		problems = append(problems, "exposes outputs no consumer declares: "+strings.Join(extra, ", "))
+	}
+	if len(missing) > 0 {
+		problems = append(problems, "doesn't expose outputs its consumers declare: "+strings.Join(missing, ", "))
+	}
+	return problems, nil
+}
+
+// declaredOutputs returns the sorted names of the output blocks in the .tf
+// files of dir.
+func declaredOutputs(dir string) ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	parser := hclparse.NewParser()
+	var outputs []string
+	for _, path := range files {
+		file, diags := parser.ParseHCLFile(path)
+		if diags.HasErrors() {
+			return nil, newParseError(diags, parser.Files())
+		}
+		content, _, diags := file.Body.PartialContent(outputsSchema)
+		if diags.HasErrors() {
+			return nil, newParseError(diags, parser.Files())
+		}
+		for _, block := range content.Blocks {
+			outputs = append(outputs, block.Labels[0])
+		}
+	}
+	sort.Strings(outputs)
+	return outputs, nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// moduleOutput is an output of the wrapped module. Description is the
+// upstream description, or one synthesized from the value expression.
+type moduleOutput struct {
+	Name        string
+	Description string
+}
+
+// outputNames returns the names of outputs.
+func outputNames(outputs []moduleOutput) []string {
+	names := make([]string, len(outputs))
+	for i, o := range outputs {
+		names[i] = o.Name
+	}
+	return names
+}
+
+// outputDescriptions maps the names of outputs to their descriptions.
+func outputDescriptions(outputs []moduleOutput) map[string]string {
+	descriptions := make(map[string]string, len(outputs))
+	for _, o := range outputs {
+		descriptions[o.Name] = o.Description
+	}
+	return descriptions
+}
+
+// outputDescription returns the description of an upstream output block,
+// synthesizing one from its value expression when upstream has none, e.g.
+// "The id of aws_vpc.this" for aws_vpc.this.id.
+func outputDescription(block *hcl.Block) string {
+	attrs, _ := block.Body.JustAttributes()
+	if description := staticString(attrs["description"]); description != "" {
+		return description
+	}
+	if value, ok := attrs["value"]; ok {
+		if expr, ok := value.Expr.(hclsyntax.Expression); ok {
+			if description := describeExpr(expr); description != "" {
+				return description
+			}
+		}
+	}
+	return fmt.Sprintf("The %s output of the wrapped module", block.Labels[0])
+}
+
+// describeExpr describes what an output value refers to, looking through
+// the functions and conditionals usually wrapped around it. It returns ""
+// for anything else.
+func describeExpr(expr hclsyntax.Expression) string {
+	switch e := expr.(type) {
+	case *hclsyntax.ScopeTraversalExpr:
+		return describeTraversal(e.Traversal, false)
+	case *hclsyntax.RelativeTraversalExpr:
+		// aws_instance.this[count.index].id and the like
+		if inner, ok := e.Source.(*hclsyntax.IndexExpr); ok {
+			if source, ok := inner.Collection.(*hclsyntax.ScopeTraversalExpr); ok {
+				return describeTraversal(append(source.Traversal, e.Traversal...), false)
+			}
+		}
+	case *hclsyntax.SplatExpr:
+		// aws_subnet.private[*].id
+		source, ok := e.Source.(*hclsyntax.ScopeTraversalExpr)
+		if !ok {
+			return ""
+		}
+		var attrs hcl.Traversal
+		if each, ok := e.Each.(*hclsyntax.RelativeTraversalExpr); ok {
+			attrs = each.Traversal
+		}
+		return describeTraversal(append(source.Traversal, attrs...), true)
+	case *hclsyntax.IndexExpr:
+		return describeExpr(e.Collection)
+	case *hclsyntax.ParenthesesExpr:
+		return describeExpr(e.Expression)
+	case *hclsyntax.FunctionCallExpr:
+		// try(aws_vpc.this[0].id, null), one(aws_vpc.this[*].id), ...
+		if len(e.Args) > 0 {
+			return describeExpr(e.Args[0])
+		}
+	case *hclsyntax.ConditionalExpr:
+		if description := describeExpr(e.TrueResult); description != "" {
+			return description
+		}
+		return describeExpr(e.FalseResult)
+	}
+	return ""
+}
+
+// describeTraversal names what traversal refers to: an attribute of a
+// resource or data source, a module output, a variable or a local. each
+// describes a splat over every instance.
+func describeTraversal(traversal hcl.Traversal, each bool) string {
+	var names []string
+	for _, step := range traversal {
+		switch s := step.(type) {
+		case hcl.TraverseRoot:
+			names = append(names, s.Name)
+		case hcl.TraverseAttr:
+			names = append(names, s.Name)
+		}
+	}
+	// object is what the first skip names refer to, and attr the rest
+	describe := func(skip int, object string) string {
+		switch {
+		case len(names) < skip:
+			return ""
+		case len(names) == skip && each:
+			return "Every " + object
+		case len(names) == skip:
+			return "The " + object
+		case each:
+			return fmt.Sprintf("The %s of each %s", strings.Join(names[skip:], "."), object)
+		}
+		return fmt.Sprintf("The %s of %s", strings.Join(names[skip:], "."), object)
+	}
+
+	switch names[0] {
+	case "var":
+		if len(names) > 1 {
+			return fmt.Sprintf("The value of var.%s", strings.Join(names[1:], "."))
+		}
+	case "local":
+		if len(names) > 1 {
+			return fmt.Sprintf("The value of local.%s", strings.Join(names[1:], "."))
+		}
+	case "module":
+		if len(names) > 2 && !each {
+			return fmt.Sprintf("The %s output of module.%s", strings.Join(names[2:], "."), names[1])
+		}
+		if len(names) >= 2 {
+			return describe(2, "module."+names[1])
+		}
+	case "data":
+		if len(names) >= 3 {
+			return describe(3, fmt.Sprintf("data.%s.%s", names[1], names[2]))
+		}
+	case "path", "terraform", "count", "each", "self":
+	default:
+		if len(names) >= 2 {
+			return describe(2, fmt.Sprintf("%s.%s", names[0], names[1]))
+		}
+	}
+	return ""
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// rawSection is the config key that passes values straight through to
+// excluded upstream variables when -allow-raw-passthrough is set.
+const rawSection = "raw"
+
+// validateExclude makes sure every excluded variable exists upstream and
+// can be left out of the module call.
+func validateExclude(opts generateOptions, contract map[string]contractVariable) error {
+	for _, name := range opts.Exclude {
+		v, ok := contract[name]
+		switch {
+		case !ok:
+			return fmt.Errorf("can't exclude %q: the module has no such variable", name)
+		case v.Required:
+			return fmt.Errorf("can't exclude %q: the variable is required", name)
+		}
+	}
+	if _, ok := contract[rawSection]; ok && opts.AllowRawPassthrough {
+		return fmt.Errorf("%w: -allow-raw-passthrough can't be used, the module has a variable named %q", ErrUnsupportedFeature, rawSection)
+	}
+	return nil
+}
+
+func isExcluded(opts generateOptions, name string) bool {
+	for _, excluded := range opts.Exclude {
+		if excluded == name {
+			return true
+		}
+	}
+	return false
+}
+
+// generateVariablesTf declares var.config, or the variables locating the
+// config with -config-from, and var.instances_dir with -instances-dir.
+// var.config validates the envelope with -envelope and, with raw
+// passthrough, that the raw section only sets excluded variables.
+func generateVariablesTf(opts generateOptions, modName string, env *configEnvelope) string {
+	var instancesDir string
+	if opts.InstancesDir != "" {
+		instancesDir = "\n" + generateInstancesDirVariable(opts)
+	}
+	if opts.ConfigFrom != "" {
+		return generateRemoteConfigVariables(opts, modName) + instancesDir
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `variable "config" {
+  type        = any
+  description = "A JSON encoded object that contains the full %s config"
+  default     = "{}"
+`, modName)
+
+	if env != nil {
+		fmt.Fprintf(&b, `
+  validation {
+    condition     = %s
+    error_message = %s
+  }
+`, envelopeCondition(env, "jsondecode(var.config)"), envelopeMessage(env))
+	}
+	if condition := rawPassthroughCondition(opts, configExpr(opts, "jsondecode(var.config)", "var.config")); condition != "" {
+		fmt.Fprintf(&b, `
+  validation {
+    condition     = %s
+    error_message = %s
+  }
+`, condition, rawPassthroughMessage(opts))
+	}
+
+	b.WriteString("}\n")
+	b.WriteString(instancesDir)
+	return b.String()
+}
+
+// rawPassthroughCondition returns the condition that the raw section of the
+// decoded config only sets excluded variables, or "" without raw
+// passthrough.
+func rawPassthroughCondition(opts generateOptions, config string) string {
+	if !opts.AllowRawPassthrough || len(opts.Exclude) == 0 {
+		return ""
+	}
+	allowed := make([]string, len(opts.Exclude))
+	for i, name := range opts.Exclude {
+		allowed[i] = hclString(name)
+	}
+	rawKeys := func(config string) string {
+		return fmt.Sprintf("length(setsubtract(keys(lookup(%s, %s, {})), [%s])) == 0", config, hclString(rawSection), strings.Join(allowed, ", "))
+	}
+	if opts.Iterable {
+		return fmt.Sprintf("alltrue([for instance in values(lookup(%s, \"instances\", {})) : %s])", config, rawKeys("instance"))
+	}
+	return rawKeys(config)
+}
+
+func rawPassthroughMessage(opts generateOptions) string {
+	return hclString(fmt.Sprintf("The %s config section may only set: %s.", rawSection, strings.Join(opts.Exclude, ", ")))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+)
+
+// errPinMoved means a wrapper's pinned version resolves to another commit
+// than the one it was locked at.
+var errPinMoved = errors.New("pinned version moved upstream")
+
+// lockedCommit returns the commit the wrapper in dir was generated from: the
+// one in the workspace lock file, or its metadata outside a workspace.
+func lockedCommit(dir string, prev *provenance) (string, error) {
+	ws, err := findWorkspace(dir)
+	if err != nil || ws == nil {
+		return prev.Commit, err
+	}
+	key, err := ws.relPath(dir)
+	if err != nil {
+		return "", err
+	}
+	lock, err := ws.readLock()
+	if err != nil {
+		return "", err
+	}
+	if entry, ok := lock.Wrappers[key]; ok && entry.Commit != "" {
+		return entry.Commit, nil
+	}
+	return prev.Commit, nil
+}
+
+// verifyPin checks that the pinned version of a wrapper still resolves to the
+// commit it was locked at, reading the fresh commit from the metadata out
+// generated. A tag that moves was re-tagged or force-pushed upstream, so the
+// same version now means different code. Unpinned wrappers follow the latest
+// release, and sources without commits (archives) have nothing to compare.
+func verifyPin(dir string, prev *provenance, out *diffSink) error {
+	if prev.Version == "" {
+		return nil
+	}
+	locked, err := lockedCommit(dir, prev)
+	if err != nil || locked == "" {
+		return err
+	}
+	for _, p := range out.paths() {
+		if path.Base(p) != metadataFileName {
+			continue
+		}
+		var fresh provenance
+		if err := json.Unmarshal(out.files[p].Data, &fresh); err != nil {
+			return fmt.Errorf("failed to parse generated %s: %w", metadataFileName, err)
+		}
+		if fresh.Commit != "" && fresh.Commit != locked {
+			return fmt.Errorf("%w: %s %s now resolves to commit %s, but it was locked at %s, so the tag was moved or the history force-pushed. Review the upstream changes before accepting them with tfwrapper upgrade", errPinMoved, prev.Source, prev.Version, fresh.Commit, locked)
+		}
+	}
+	return nil
+}
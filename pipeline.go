@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// pipelineFile is the CI pipeline written with -pipeline.
+const pipelineFile = "pipeline.yml"
+
+// defaultDriftSchedule is the cron schedule of the drift check, weekday
+// mornings (UTC).
+const defaultDriftSchedule = "0 6 * * 1-5"
+
+// backendFile is the partial backend configuration written with -backend.
+const backendFile = "backend.tf"
+
+// backendsDir holds the backend settings of each environment, passed to
+// init with -backend-config.
+const backendsDir = "backends"
+
+// pipelineData is available to pipeline templates. Templates use [[ ]]
+// delimiters, leaving ${{ }} and $VAR to the CI system, and can render a
+// YAML flow sequence with [[ list .Environments ]].
+type pipelineData struct {
+	Name   string
+	Dir    string // wrapper directory, relative to the repository root
+	Binary string
+	// Environments are the -environments the jobs run for, or a single
+	// "default" running ConfigCommand on the example config
+	Environments  []string
+	ConfigFormat  string
+	ConfigCommand string // prints the JSON config of $ENVIRONMENT
+	DriftSchedule string
+	// Backend is the -backend type, "" when the wrapper keeps no state,
+	// and BackendConfig the settings file of $ENVIRONMENT
+	Backend       string
+	BackendConfig string
+}
+
+var pipelineTemplates = map[string]string{
+	"github": githubPipeline,
+	"gitlab": gitlabPipeline,
+}
+
+// githubPipeline is a GitHub Actions workflow. GitHub only runs workflows
+// under .github/workflows/, so it is copied there. Like gitlabPipeline it
+// only applies and checks drift with a -backend, since otherwise the state
+// wouldn't outlive the job.
+const githubPipeline = `name: [[ .Name ]]
+[[ if not .Backend ]]
+# Plans only: the wrapper has no backend, so applying it here would create
+# its resources again on every run. Generate it with -backend to also apply
+# on merge and check for drift.
+[[- end ]]
+on:
+  pull_request:
+    paths: ["[[ .Dir ]]/**"]
+[[- if .Backend ]]
+  push:
+    branches: [main]
+    paths: ["[[ .Dir ]]/**"]
+  schedule:
+    - cron: "[[ .DriftSchedule ]]"
+[[- end ]]
+
+jobs:
+  terraform:
+    runs-on: ubuntu-latest
+    strategy:
+      fail-fast: false
+      matrix:
+        environment: [[ list .Environments ]]
+    environment: ${{ matrix.environment }}
+    defaults:
+      run:
+        working-directory: [[ .Dir ]]
+    env:
+      ENVIRONMENT: ${{ matrix.environment }}
+    steps:
+      - uses: actions/checkout@v4
+[[- if eq .Binary "tofu" ]]
+      - uses: opentofu/setup-opentofu@v1
+[[- else ]]
+      - uses: hashicorp/setup-terraform@v3
+        with:
+          terraform_wrapper: false
+[[- end ]]
+      - run: [[ .Binary ]] init -input=false[[ if .Backend ]] -backend-config=[[ .BackendConfig ]][[ end ]]
+      - name: Plan
+        if: github.event_name == 'pull_request'
+        run: [[ .Binary ]] plan -input=false -lock=false -var "config=$([[ .ConfigCommand ]])"
+[[- if .Backend ]]
+      - name: Apply
+        if: github.event_name == 'push'
+        run: [[ .Binary ]] apply -input=false -auto-approve -var "config=$([[ .ConfigCommand ]])"
+      - name: Drift check
+        if: github.event_name == 'schedule'
+        run: [[ .Binary ]] plan -input=false -lock=false -detailed-exitcode -var "config=$([[ .ConfigCommand ]])"
+[[- end ]]
+`
+
+// gitlabPipeline is a GitLab CI child pipeline, included from the root
+// .gitlab-ci.yml.
+const gitlabPipeline = `[[ if not .Backend -]]
+# Plans only: the wrapper has no backend, so applying it here would create
+# its resources again on every run. Generate it with -backend to also apply
+# on merge and check for drift.
+[[ end -]]
+.[[ .Name ]]:
+  image:
+    name: [[ if eq .Binary "tofu" ]]ghcr.io/opentofu/opentofu:latest[[ else ]]hashicorp/terraform:latest[[ end ]]
+    entrypoint: [""]
+  parallel:
+    matrix:
+      - ENVIRONMENT: [[ list .Environments ]]
+  environment: $ENVIRONMENT
+  before_script:
+    - cd [[ .Dir ]]
+    - [[ .Binary ]] init -input=false[[ if .Backend ]] -backend-config=[[ .BackendConfig ]][[ end ]]
+
+[[ .Name ]]:plan:
+  extends: .[[ .Name ]]
+  rules:
+    - if: $CI_PIPELINE_SOURCE == "merge_request_event"
+      changes: ["[[ .Dir ]]/**/*"]
+  script:
+    - [[ .Binary ]] plan -input=false -lock=false -var "config=$([[ .ConfigCommand ]])"
+[[- if .Backend ]]
+
+[[ .Name ]]:apply:
+  extends: .[[ .Name ]]
+  rules:
+    - if: $CI_COMMIT_BRANCH == $CI_DEFAULT_BRANCH && $CI_PIPELINE_SOURCE == "push"
+      changes: ["[[ .Dir ]]/**/*"]
+  script:
+    - [[ .Binary ]] apply -input=false -auto-approve -var "config=$([[ .ConfigCommand ]])"
+
+# Schedule a pipeline with "[[ .DriftSchedule ]]" to check for drift
+[[ .Name ]]:drift:
+  extends: .[[ .Name ]]
+  rules:
+    - if: $CI_PIPELINE_SOURCE == "schedule"
+  script:
+    - [[ .Binary ]] plan -input=false -lock=false -detailed-exitcode -var "config=$([[ .ConfigCommand ]])"
+[[- end ]]
+`
+
+// validateBackend checks the -backend option. Local state wouldn't outlive
+// a CI job either.
+func validateBackend(backend string) error {
+	if backend == "local" || backend != "" && !hclsyntax.ValidIdentifier(backend) {
+		return fmt.Errorf("invalid -backend %q: must be the type of a remote backend, e.g. s3, gcs or azurerm", backend)
+	}
+	return nil
+}
+
+// generateBackendTf declares an empty backend block of type backend, which
+// init completes with the settings of an environment from backendsDir. A
+// module calling the wrapper ignores it.
+func generateBackendTf(backend string) string {
+	return fmt.Sprintf("# Settings of each environment are in %s/<environment>.hcl, passed to init\n# with -backend-config\nterraform {\n  backend %s {}\n}\n", backendsDir, hclString(backend))
+}
+
+// validatePipeline checks the -pipeline option.
+func validatePipeline(pipeline string) error {
+	if _, ok := pipelineTemplates[pipeline]; pipeline != "" && !ok {
+		return fmt.Errorf("invalid -pipeline %q: must be github or gitlab", pipeline)
+	}
+	return nil
+}
+
+// generatePipeline renders the pipeline of the wrapper in wrapperDir from
+// text, or the built-in template of opts.Pipeline when text is empty. root
+// is the repository (workspace) root the paths in the pipeline are relative
+// to, or "" for the current directory.
+func generatePipeline(opts generateOptions, text, name, wrapperDir, root string) (string, error) {
+	if text == "" {
+		text = pipelineTemplates[opts.Pipeline]
+	}
+	funcs := template.FuncMap{"list": func(items []string) string {
+		quoted := make([]string, len(items))
+		for i, item := range items {
+			quoted[i] = strconv.Quote(item)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	}}
+	tmpl, err := template.New("pipeline").Delims("[[", "]]").Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid pipeline template: %w", err)
+	}
+
+	dir := filepath.Clean(wrapperDir)
+	if root != "" {
+		abs, err := filepath.Abs(wrapperDir)
+		if err != nil {
+			return "", err
+		}
+		if dir, err = filepath.Rel(root, abs); err != nil {
+			return "", err
+		}
+	}
+	data := pipelineData{
+		Name:          name,
+		Dir:           filepath.ToSlash(dir),
+		Binary:        opts.Binary,
+		Environments:  opts.Environments,
+		ConfigFormat:  opts.ConfigFormat,
+		DriftSchedule: opts.DriftSchedule,
+		Backend:       opts.Backend,
+		BackendConfig: path.Join(backendsDir, "$ENVIRONMENT.hcl"),
+	}
+	if data.ConfigFormat == "" {
+		data.ConfigFormat = "json"
+	}
+	if data.DriftSchedule == "" {
+		data.DriftSchedule = defaultDriftSchedule
+	}
+	// The environment configs are merged like tfvars does
+	switch {
+	case len(opts.Environments) == 0:
+		data.Environments = []string{"default"}
+		data.ConfigCommand = "cat " + opts.ExampleConfig
+	case data.ConfigFormat == "yaml":
+		data.ConfigCommand = fmt.Sprintf("yq -o=json -I=0 ea '. as $c ireduce ({}; . + $c)' %s %s", path.Join(configsDir, commonConfig+".yaml"), path.Join(configsDir, "$ENVIRONMENT.yaml"))
+	default:
+		data.ConfigCommand = fmt.Sprintf("jq -cs add %s %s", path.Join(configsDir, commonConfig+".json"), path.Join(configsDir, "$ENVIRONMENT.json"))
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("failed to render pipeline: %w", err)
+	}
+	return buf.String(), nil
+}
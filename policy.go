@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// policyFileName is the OPA policy written with -policy opa and evaluated by
+// validate-config.
+const policyFileName = "policy.rego"
+
+// regionKeys are the variable names checked against allowed_regions, in
+// order of preference.
+var regionKeys = []string{"region", "location"}
+
+var regoPackagePattern = regexp.MustCompile(`(?m)^package\s+([A-Za-z_][\w.]*)`)
+
+func validatePolicy(policy string) error {
+	switch policy {
+	case "", "opa":
+		return nil
+	}
+	return fmt.Errorf("invalid -policy %q: must be opa", policy)
+}
+
+// regoPackage returns the package of a wrapper's policy, e.g. tfwrapper.vpc.
+func regoPackage(name string) string {
+	ident := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+	if ident == "" || ident[0] >= '0' && ident[0] <= '9' {
+		ident = "_" + ident
+	}
+	return "tfwrapper." + ident
+}
+
+// generatePolicyRego returns a policy stub that denies configs of the wrapper
+// missing required variables or tags, or using a region that isn't allowed.
+// The required tags and allowed regions are placeholders to be edited.
+func generatePolicyRego(opts generateOptions, name string, contract map[string]contractVariable) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Policy for configs of the %s wrapper, evaluated by\n", name)
+	b.WriteString("# \"tfwrapper validate-config\". Every message in deny fails validation.\n")
+	fmt.Fprintf(&b, "package %s\n\n", regoPackage(name))
+	b.WriteString("import rego.v1\n\n")
+
+	// The config document, unwrapped from its envelope like locals.tf
+	document := "input"
+	if opts.Envelope != "" {
+		b.WriteString("# Config documents may come in an envelope, holding the config in spec\n")
+		b.WriteString("document := object.get(input, \"spec\", {}) if \"apiVersion\" in object.keys(input)\n\n")
+		b.WriteString("default document := input\n\n")
+		document = "document"
+	}
+
+	// The config of each instance, after merging layers like locals.tf
+	config := document
+	if len(opts.MergeLayers) > 0 {
+		b.WriteString("layers := object.union_n([\n")
+		for _, layer := range opts.MergeLayers {
+			fmt.Fprintf(&b, "\tobject.get(%s, %q, {}),\n", document, layer)
+		}
+		b.WriteString("])\n\n")
+		config = "object.union(layers, " + document + ")"
+	}
+	b.WriteString("# The config of every instance created by the wrapper, by name\n")
+	switch {
+	case opts.Iterable:
+		merged := "instance"
+		if len(opts.MergeLayers) > 0 {
+			merged = "object.union(layers, instance)"
+		}
+		fmt.Fprintf(&b, "instances := {name: %s | some name, instance in object.get(%s, \"instances\", {})}\n\n", merged, document)
+	case opts.Conditional:
+		fmt.Fprintf(&b, "instances := {\"config\": %s} if object.get(%s, %q, true)\n\n", config, document, conditionalKey)
+		b.WriteString("default instances := {}\n\n")
+	default:
+		fmt.Fprintf(&b, "instances := {\"config\": %s}\n\n", config)
+	}
+
+	var required []string
+	for _, key := range sortedKeys(contract) {
+		if contract[key].Required && (opts.derived == nil || opts.derived.Expressions[key] == "") {
+			required = append(required, fmt.Sprintf("%q", key))
+		}
+	}
+	b.WriteString("# Variables of the module without a default, unless derived\n")
+	if len(required) == 0 {
+		b.WriteString("required_keys := set()\n\n")
+	} else {
+		fmt.Fprintf(&b, "required_keys := {%s}\n\n", strings.Join(required, ", "))
+	}
+	b.WriteString(`deny contains msg if {
+	some name, config in instances
+	some key in required_keys
+	not key in object.keys(config)
+	msg := sprintf("%s: %s is required", [name, key])
+}
+`)
+
+	if _, ok := contract[tagsVariable]; ok {
+		tags := `object.get(config, "tags", {})`
+		if opts.DefaultTags {
+			// The wrapper merges the tags over provider_default_tags
+			tags = fmt.Sprintf(`object.union_n([object.get(%s, %q, {}), object.get(config, %[2]q, {}), %s])`, document, defaultTagsKey, tags)
+		}
+		fmt.Fprintf(&b, `
+# Tags every instance must set
+required_tags := {"environment", "owner"}
+
+deny contains msg if {
+	some name, config in instances
+	some tag in required_tags
+	not tag in object.keys(%s)
+	msg := sprintf("%%s: tag %%q is required", [name, tag])
+}
+`, tags)
+	}
+
+	for _, key := range regionKeys {
+		if _, ok := contract[key]; !ok {
+			continue
+		}
+		fmt.Fprintf(&b, `
+# Regions instances may be created in
+allowed_regions := {"eu-west-1", "us-east-1"}
+
+deny contains msg if {
+	some name, config in instances
+	region := config[%q]
+	not region in allowed_regions
+	msg := sprintf("%%s: %s %%q is not allowed", [name, region])
+}
+`, key, key)
+		break
+	}
+	return b.String()
+}
+
+// policyPackage reads the package declared by a policy.
+func policyPackage(policy string) (string, error) {
+	m := regoPackagePattern.FindStringSubmatch(policy)
+	if m == nil {
+		return "", fmt.Errorf("no package declaration found")
+	}
+	return m[1], nil
+}
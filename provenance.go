@@ -0,0 +1,228 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// metadataFileName is written to every wrapper and records how it was
+// generated.
+const metadataFileName = ".tfwrapper.json"
+
+// provenance answers "how was this wrapper generated?". It is rendered as a
+// comment block at the top of main.tf and stored in .tfwrapper.json.
+type provenance struct {
+	ToolVersion string          `json:"tool_version"`
+	GeneratedAt string          `json:"generated_at,omitempty"`
+	Source      string          `json:"source"`
+	Version     string          `json:"version,omitempty"`
+	Commit      string          `json:"commit,omitempty"`
+	CommandLine string          `json:"command_line"`
+	Options     generateOptions `json:"options"`
+	// SourceOverride is the -source-overrides entry the wrapper was
+	// generated from and calls, instead of the source and version of
+	// Options.
+	SourceOverride *sourceOverride `json:"source_override,omitempty"`
+	// Patches are the -patch files applied to the module at Commit.
+	Patches []patchProvenance `json:"patches,omitempty"`
+	// Inputs are the digests of the template and other files generation
+	// read, see inputDigests.
+	Inputs map[string]string `json:"inputs,omitempty"`
+
+	// WrapperVersion is the wrapper's own semantic version, bumped on
+	// regeneration according to changes in Variables.
+	WrapperVersion string                      `json:"wrapper_version,omitempty"`
+	Variables      map[string]contractVariable `json:"variables,omitempty"`
+
+	// Providers maps the provider source addresses the module requires to
+	// their version constraints.
+	Providers map[string]string `json:"providers,omitempty"`
+	// ProviderConfigs are the provider configurations the wrapper passes
+	// to the module explicitly, by local name, when it can't inherit them.
+	ProviderConfigs map[string]providerConfig `json:"provider_configs,omitempty"`
+
+	// Outputs are the upstream outputs the wrapper exports, each under its
+	// own name and in the legacy "output" object.
+	Outputs []string `json:"outputs,omitempty"`
+	// OutputDescriptions maps the outputs to the descriptions they are
+	// exported with, upstream's or synthesized from the value.
+	OutputDescriptions map[string]string `json:"output_descriptions,omitempty"`
+	// OutputsContract is the -outputs-contract the outputs were limited to.
+	OutputsContract *outputsContract `json:"outputs_contract,omitempty"`
+
+	// Compat is the compat.tf layer of a -compat wrapper.
+	Compat *compatLayer `json:"compat,omitempty"`
+
+	// Features are the feature flags the wrapper was generated with, from
+	// the command line and the workspace, and the variables they set.
+	Features []featureFlag `json:"features,omitempty"`
+
+	// Derived are the expressions of the -derived file.
+	Derived *derivedKeys `json:"derived,omitempty"`
+}
+
+func newProvenance(opts generateOptions, commit, generatedAt string) provenance {
+	// Where the wrapper was written isn't part of how it was generated
+	opts.OutputDir = ""
+	return provenance{
+		ToolVersion: toolVersion,
+		GeneratedAt: generatedAt,
+		Source:      opts.Source,
+		Version:     opts.Version,
+		Commit:      commit,
+		CommandLine: opts.commandLine(),
+		Options:     opts,
+	}
+}
+
+// comment renders the provenance as "# Key: value" lines.
+func (p provenance) comment() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Module source: %s\n", p.Source)
+	if p.Version != "" {
+		fmt.Fprintf(&b, "# Version: %s\n", p.Version)
+	} else {
+		b.WriteString("# Version: latest (no version constraint specified)\n")
+	}
+	if p.Commit != "" {
+		fmt.Fprintf(&b, "# Commit: %s\n", p.Commit)
+	}
+	fmt.Fprintf(&b, "# Generated by: tfwrapper %s\n", p.ToolVersion)
+	if p.GeneratedAt != "" {
+		fmt.Fprintf(&b, "# Generated at: %s\n", p.GeneratedAt)
+	}
+	fmt.Fprintf(&b, "# Command: %s\n", p.CommandLine)
+	return b.String()
+}
+
+func (p provenance) metadata() (string, error) {
+	// Constraints such as ">= 5.0" stay readable
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(p); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// commandLine reconstructs the tfwrapper invocation equivalent to opts, so
+// wrappers generated by batch or upgrade record a reproducible command too.
+func (opts generateOptions) commandLine() string {
+	args := []string{"tfwrapper", "-source", opts.Source}
+	add := func(flag, value string) {
+		if value != "" {
+			args = append(args, flag, value)
+		}
+	}
+	add("-version", opts.Version)
+	add("-name", opts.Name)
+	if opts.ForceIterable {
+		args = append(args, "-iterable=force")
+	} else if opts.Iterable {
+		args = append(args, "-iterable")
+	}
+	if opts.Conditional {
+		args = append(args, "-conditional")
+	}
+	add("-task-runner", opts.TaskRunner)
+	if opts.TaskRunner != "" || opts.ProviderMirror != "" && opts.Binary != "terraform" {
+		add("-binary", opts.Binary)
+	}
+	if opts.TaskRunner != "" || opts.ExampleConfig != "config.example.json" {
+		add("-example-config", opts.ExampleConfig)
+	}
+	add("-from-example", opts.FromExample)
+	if opts.SnapshotUpstream {
+		args = append(args, "-snapshot-upstream")
+	}
+	add("-only", strings.Join(opts.Only, ","))
+	add("-skip", strings.Join(opts.Skip, ","))
+	add("-exclude", strings.Join(opts.Exclude, ","))
+	if opts.AllowRawPassthrough {
+		args = append(args, "-allow-raw-passthrough")
+	}
+	add("-rename", strings.Join(opts.Renames, ","))
+	if opts.Compat {
+		args = append(args, "-compat")
+	}
+	add("-group-by", opts.GroupBy)
+	if opts.Sort != "upstream" {
+		add("-sort", opts.Sort)
+	}
+	if opts.SplitInputs > 0 {
+		add("-split-inputs", strconv.Itoa(opts.SplitInputs))
+	}
+	if opts.Annotate {
+		args = append(args, "-annotate")
+	}
+	if opts.Comments != "all" {
+		add("-comments", opts.Comments)
+	}
+	if opts.Accessor != "auto" {
+		add("-accessor", opts.Accessor)
+	}
+	add("-environments", strings.Join(opts.Environments, ","))
+	if len(opts.Environments) > 0 && opts.ConfigFormat != "json" {
+		add("-config-format", opts.ConfigFormat)
+	}
+	add("-policy", opts.Policy)
+	add("-config-from", opts.ConfigFrom)
+	if opts.Format != "module" {
+		add("-format", opts.Format)
+	}
+	add("-orchestrator", opts.Orchestrator)
+	add("-pipeline", opts.Pipeline)
+	add("-pipeline-template", opts.PipelineTemplate)
+	add("-drift-schedule", opts.DriftSchedule)
+	add("-backend", opts.Backend)
+	add("-backstage", opts.Backstage)
+	if opts.DefaultTags {
+		args = append(args, "-default-tags")
+	}
+	add("-merge-layers", strings.Join(opts.MergeLayers, ","))
+	add("-features", strings.Join(opts.Features, ","))
+	add("-region-check", strings.Join(opts.RegionCheck, ","))
+	add("-instances-dir", opts.InstancesDir)
+	add("-instance-providers", strings.Join(opts.InstanceProviders, ","))
+	add("-fan-out", strings.Join(opts.FanOut, ","))
+	add("-fan-out-role", opts.FanOutRole)
+	add("-provider-mirror", opts.ProviderMirror)
+	add("-source-overrides", opts.SourceOverrides)
+	add("-patch", strings.Join(opts.Patches, ","))
+	add("-envelope", opts.Envelope)
+	add("-derived", opts.Derived)
+	add("-header", opts.Header)
+	add("-footer", opts.Footer)
+	if opts.NoLegacyOutput {
+		args = append(args, "-legacy-output=false")
+	}
+	add("-outputs-contract", opts.OutputsContract)
+	if opts.Timestamp {
+		args = append(args, "-timestamp")
+	}
+	add("-scan", opts.Scan)
+	if opts.FailOnFindings {
+		args = append(args, "-fail-on-findings")
+	}
+
+	for i, arg := range args {
+		if strings.ContainsAny(arg, " \t\n\"'$`\\") {
+			args[i] = strconv.Quote(arg)
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// gitHeadCommit returns the commit checked out in the git repository
+// containing dir, or "" if dir isn't in a git checkout.
+func gitHeadCommit(sb sandbox, dir string) string {
+	out, err := sb.run(dir, "git", "rev-parse", "HEAD")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func runPublish(args []string) {
+	fs := flag.NewFlagSet("publish", flag.ExitOnError)
+	repo := fs.String("repo", "", "Git repository to push the wrapper to")
+	branch := fs.String("branch", "main", "Branch to commit to (with -repo)")
+	subdir := fs.String("path", "", "Directory inside the repository to publish to (default: repository root)")
+	tag := fs.String("tag", "", "Tag to create for the published commit (default: the wrapper_version in "+metadataFileName+")")
+	monorepo := fs.Bool("monorepo", false, "Tag as <name>/<tag> and record the release in "+manifestFileName+" (with -repo)")
+	registryURL := fs.String("registry-url", "", "Module registry endpoint to upload a .tar.gz of the wrapper to")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tfwrapper publish (-repo <url> | -registry-url <url>) [-tag <version>] [flags] <wrapper-dir>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 || (*repo == "") == (*registryURL == "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dir := fs.Arg(0)
+
+	if *tag == "" {
+		prev, err := readMetadata(dir)
+		if err != nil {
+			log.Fatalf("Failed to read wrapper metadata: %v", err)
+		}
+		if prev == nil || prev.WrapperVersion == "" {
+			log.Fatalf("Failed to publish: -tag is required, %s has no wrapper_version", dir)
+		}
+		*tag = prev.WrapperVersion
+	}
+
+	sb, err := sandboxFor(dir)
+	if err != nil {
+		fatalError("Failed to load workspace", err)
+	}
+
+	if *repo != "" {
+		target := gitTarget{Repo: *repo, Branch: *branch, Path: *subdir, Tag: *tag}
+		if *monorepo {
+			name := filepath.Base(filepath.Clean(dir))
+			if target.Tag, err = monorepoTag(name, *tag); err != nil {
+				log.Fatalf("Failed to publish: %v", err)
+			}
+			if target.Path == "" {
+				target.Path = name
+			}
+			target.Manifest = true
+		}
+		if err := publishToGit(sb, dir, target); err != nil {
+			log.Fatalf("Failed to publish: %v", err)
+		}
+		if err := auditPublish(dir, *repo, target.Tag); err != nil {
+			log.Fatalf("Failed to write audit log: %v", err)
+		}
+		fmt.Printf("Published %s to %s as %s\n", dir, *repo, target.Tag)
+		return
+	}
+
+	if err := publishToRegistry(dir, *registryURL, *tag); err != nil {
+		log.Fatalf("Failed to publish: %v", err)
+	}
+	if err := auditPublish(dir, *registryURL, *tag); err != nil {
+		log.Fatalf("Failed to write audit log: %v", err)
+	}
+	fmt.Printf("Published %s to %s as %s\n", dir, *registryURL, *tag)
+}
+
+// gitTarget describes where in a git repository a wrapper is published.
+type gitTarget struct {
+	Repo     string
+	Branch   string
+	Path     string
+	Tag      string
+	Manifest bool
+}
+
+// publishToGit replaces the contents of t.Path in t.Repo with the wrapper,
+// commits, tags and pushes the result.
+func publishToGit(sb sandbox, dir string, t gitTarget) error {
+	if t.Path != "" {
+		if err := validateSubPath(t.Path); err != nil {
+			return err
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "tfwrapper-publish-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	cloneDir := filepath.Join(tmpDir, "repo")
+	if _, err := sb.run(tmpDir, "git", "clone", "--branch", t.Branch, t.Repo, cloneDir); err != nil {
+		return err
+	}
+
+	// Replace whatever was published before, keeping the repository itself
+	dest := filepath.Join(cloneDir, t.Path)
+	entries, err := os.ReadDir(dest)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Name() == ".git" {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dest, entry.Name())); err != nil {
+			return err
+		}
+	}
+	if err := copyDir(dir, dest); err != nil {
+		return err
+	}
+	if t.Manifest {
+		if err := updateManifest(cloneDir, t.Repo, filepath.Base(filepath.Clean(dir)), t.Path, t.Tag); err != nil {
+			return err
+		}
+	}
+
+	git := func(args ...string) ([]byte, error) {
+		return sb.run(cloneDir, "git", args...)
+	}
+	if _, err := git("add", "-A"); err != nil {
+		return err
+	}
+	status, err := git("status", "--porcelain")
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(status)) > 0 {
+		if _, err := git("commit", "-m", fmt.Sprintf("Publish %s %s", filepath.Base(dir), t.Tag)); err != nil {
+			return err
+		}
+	}
+	if _, err := git("tag", "-a", t.Tag, "-m", fmt.Sprintf("%s %s", filepath.Base(dir), t.Tag)); err != nil {
+		return err
+	}
+	_, err = git("push", "--atomic", "origin", t.Branch, "refs/tags/"+t.Tag)
+	return err
+}
+
+// publishToRegistry uploads a .tar.gz of the wrapper to a registry endpoint.
+// The version is sent in the X-Module-Version header and a bearer token is
+// taken from TFWRAPPER_REGISTRY_TOKEN when set.
+func publishToRegistry(dir, url, version string) error {
+	files, err := readTree(dir)
+	if err != nil {
+		return err
+	}
+	m := newMemSink()
+	for rel, content := range files {
+		m.WriteFile(rel, []byte(content), 0644)
+	}
+
+	tmp, err := os.CreateTemp("", "tfwrapper-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if err := writeArchive(tmp.Name(), m); err != nil {
+		return err
+	}
+	body, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("X-Module-Version", version)
+	if token := os.Getenv("TFWRAPPER_REGISTRY_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var msg bytes.Buffer
+		msg.ReadFrom(resp.Body)
+		return fmt.Errorf("registry responded %s: %s", resp.Status, strings.TrimSpace(msg.String()))
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// scpLikeRemote matches remotes such as git@github.com:acme/wrappers.git.
+var scpLikeRemote = regexp.MustCompile(`^[\w.-]+@([\w.-]+):(.+)$`)
+
+// pullRequest is opened on the GitHub or GitLab project behind a remote.
+type pullRequest struct {
+	Title string
+	Body  string
+	Head  string
+	Base  string
+}
+
+// parseRemote splits a git remote URL into its host and the project path
+// without the .git suffix.
+func parseRemote(remote string) (string, string, error) {
+	var host, path string
+	if m := scpLikeRemote.FindStringSubmatch(remote); m != nil && !strings.Contains(remote, "://") {
+		host, path = m[1], m[2]
+	} else {
+		u, err := url.Parse(remote)
+		if err != nil || u.Host == "" {
+			return "", "", fmt.Errorf("can't work out the project of remote %q", remote)
+		}
+		host, path = u.Hostname(), u.Path
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if !strings.Contains(path, "/") {
+		return "", "", fmt.Errorf("can't work out the project of remote %q", remote)
+	}
+	return host, path, nil
+}
+
+// openPullRequest opens pr on the project behind remote and returns its web
+// URL. github.com (or GITHUB_API_URL) is reached with GITHUB_TOKEN or
+// GH_TOKEN, hosts with "gitlab" in their name (or GITLAB_API_URL) with
+// GITLAB_TOKEN.
+func openPullRequest(remote string, pr pullRequest, timeout time.Duration) (string, error) {
+	host, project, err := parseRemote(remote)
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{Timeout: timeout}
+
+	switch {
+	case host == "github.com" || os.Getenv("GITHUB_API_URL") != "":
+		api := os.Getenv("GITHUB_API_URL")
+		if api == "" {
+			api = "https://api.github.com"
+		}
+		token := os.Getenv("GITHUB_TOKEN")
+		if token == "" {
+			token = os.Getenv("GH_TOKEN")
+		}
+		if token == "" {
+			return "", fmt.Errorf("GITHUB_TOKEN is required to open a pull request on %s", host)
+		}
+		var created struct {
+			HTMLURL string `json:"html_url"`
+		}
+		err := postJSON(client, strings.TrimSuffix(api, "/")+"/repos/"+project+"/pulls",
+			map[string]string{"Authorization": "Bearer " + token, "Accept": "application/vnd.github+json"},
+			map[string]string{"title": pr.Title, "body": pr.Body, "head": pr.Head, "base": pr.Base}, &created)
+		return created.HTMLURL, err
+
+	case strings.Contains(host, "gitlab") || os.Getenv("GITLAB_API_URL") != "":
+		api := os.Getenv("GITLAB_API_URL")
+		if api == "" {
+			api = "https://" + host + "/api/v4"
+		}
+		token := os.Getenv("GITLAB_TOKEN")
+		if token == "" {
+			return "", fmt.Errorf("GITLAB_TOKEN is required to open a merge request on %s", host)
+		}
+		var created struct {
+			WebURL string `json:"web_url"`
+		}
+		err := postJSON(client, strings.TrimSuffix(api, "/")+"/projects/"+url.PathEscape(project)+"/merge_requests",
+			map[string]string{"PRIVATE-TOKEN": token},
+			map[string]string{"title": pr.Title, "description": pr.Body, "source_branch": pr.Head, "target_branch": pr.Base}, &created)
+		return created.WebURL, err
+	}
+	return "", fmt.Errorf("%s is not a GitHub or GitLab host (set GITHUB_API_URL or GITLAB_API_URL)", host)
+}
+
+// postJSON sends body as JSON and decodes the response into v.
+func postJSON(client *http.Client, url string, headers map[string]string, body, v any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var msg bytes.Buffer
+		msg.ReadFrom(resp.Body)
+		return fmt.Errorf("%s responded %s: %s", url, resp.Status, strings.TrimSpace(msg.String()))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode %s: %w", url, err)
+	}
+	return nil
+}
+
+// upgradeChangelog describes an upgrade for a pull request body, listing the
+// changes to the wrapper's variable contract.
+func upgradeChangelog(name string, prev, next *provenance) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Upgrades `%s` (`%s`) from `%s` to `%s`.\n\n", name, next.Source, displayVersion(prev.Version), displayVersion(next.Version))
+	if prev.WrapperVersion != "" && next.WrapperVersion != prev.WrapperVersion {
+		fmt.Fprintf(&b, "Wrapper version: `%s` -> `%s`\n\n", prev.WrapperVersion, next.WrapperVersion)
+	}
+
+	b.WriteString("### Variable changes\n\n")
+	if prev.Variables == nil {
+		b.WriteString("No variable contract was recorded for the previous version.\n")
+		return b.String()
+	}
+	_, changes := versionBump(renameContract(prev.Variables, next.Options), next.Variables)
+	renamed := deprecatedKeys(next.Options)
+	for _, old := range sortedKeys(renamed) {
+		if _, ok := prev.Variables[old]; ok {
+			changes = append(changes, fmt.Sprintf("renamed %s to %s, configs setting %s keep working", old, renamed[old], old))
+		}
+	}
+	if len(changes) == 0 {
+		b.WriteString("None.\n")
+	}
+	for _, change := range changes {
+		fmt.Fprintf(&b, "- %s\n", change)
+	}
+
+	if prev.Outputs != nil {
+		b.WriteString("\n### Output changes\n\n")
+		removed, added := outputChanges(prev.Outputs, next.Outputs)
+		if len(removed)+len(added) == 0 {
+			b.WriteString("None.\n")
+		}
+		for _, name := range removed {
+			fmt.Fprintf(&b, "- removed %s\n", name)
+		}
+		for _, name := range added {
+			fmt.Fprintf(&b, "- added %s\n", name)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// The global -no-exec and -no-write flags restrict tfwrapper to fetching,
+// parsing and printing, so it can run in analysis sandboxes that forbid
+// spawning processes or writing outside the temporary directory.
+var (
+	noExec  bool
+	noWrite bool
+)
+
+var (
+	errExecDisabled  = errors.New("running external commands is disabled by -no-exec")
+	errWriteDisabled = errors.New("writing outside the temporary directory is disabled by -no-write")
+)
+
+// parseGlobalFlags consumes -no-exec and -no-write (or --no-exec and
+// --no-write) in front of the command and returns the remaining arguments.
+func parseGlobalFlags(args []string) []string {
+	for len(args) > 0 {
+		switch args[0] {
+		case "-no-exec", "--no-exec":
+			noExec = true
+		case "-no-write", "--no-write":
+			noWrite = true
+		default:
+			return args
+		}
+		args = args[1:]
+	}
+	return args
+}
+
+// checkExec refuses to run name under -no-exec.
+func checkExec(name string) error {
+	if noExec {
+		return fmt.Errorf("%w: %s", errExecDisabled, name)
+	}
+	return nil
+}
+
+// checkWrite refuses to write path under -no-write, unless it is inside the
+// temporary directory, where downloads are extracted.
+func checkWrite(path string) error {
+	if !noWrite {
+		return nil
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		if rel, err := filepath.Rel(os.TempDir(), abs); err == nil && filepath.IsLocal(rel) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", errWriteDisabled, path)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+func runRegenerate(args []string) {
+	fs := flag.NewFlagSet("regenerate", flag.ExitOnError)
+	check := fs.Bool("check", false, "Only verify that the files on disk are what regenerating writes, printing a diff and exiting 1 if they aren't")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tfwrapper regenerate [-check] <wrapper-dir>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dir := filepath.Clean(fs.Arg(0))
+
+	prev, err := readMetadata(dir)
+	if err != nil {
+		fatalError("Failed to read wrapper", err)
+	}
+	if prev == nil {
+		log.Fatalf("Error: %s has no %s recording how it was generated", dir, metadataFileName)
+	}
+	if prev.ToolVersion != toolVersion {
+		log.Fatalf("Error: %s was generated by tfwrapper %s, this is %s. Regenerate it with %s, or with upgrade to accept the differences", dir, prev.ToolVersion, toolVersion, prev.ToolVersion)
+	}
+	opts := prev.Options
+	opts.OutputDir = filepath.Dir(dir)
+	opts.operation = "regenerate"
+	if name := wrapperName(opts); name != filepath.Base(dir) {
+		log.Fatalf("Error: %s was generated as %s, move it back to regenerate it", dir, name)
+	}
+
+	// Generate in memory first, so nothing is written unless the recorded
+	// generation is reproduced
+	if opts.cache, err = newModuleCache(); err != nil {
+		log.Fatalf("Failed to create module cache: %v", err)
+	}
+	defer opts.cache.Close()
+	out := newDiffSink()
+	if _, err := generateWrapperTo(out, opts); err != nil {
+		fatalError("Error", err)
+	}
+	var next provenance
+	if err := json.Unmarshal(out.files[filepath.ToSlash(filepath.Join(dir, metadataFileName))].Data, &next); err != nil {
+		log.Fatalf("Failed to read regenerated metadata: %v", err)
+	}
+	if differences := reproductionDifferences(prev, &next); len(differences) > 0 {
+		log.Fatalf("Error: %s can't be reproduced from its recorded options:\n  %s", dir, strings.Join(differences, "\n  "))
+	}
+
+	if *check {
+		changes, err := generatedDiff(out)
+		if err != nil {
+			log.Fatalf("Failed to compare with %s: %v", dir, err)
+		}
+		if changes != "" {
+			fmt.Print(changes)
+			fmt.Fprintf(os.Stderr, "%s differs from its recorded generation\n", dir)
+			os.Exit(1)
+		}
+		fmt.Printf("%s matches its recorded generation\n", dir)
+		return
+	}
+	if _, err := generateWrapper(opts); err != nil {
+		fatalError("Error", err)
+	}
+	fmt.Printf("Regenerated %s from its recorded options\n", dir)
+}
+
+// reproductionDifferences lists what makes next, regenerated from the
+// options of prev, not a reproduction of it: another upstream commit, other
+// feature flags (the workspace's may have changed) or changed input files.
+func reproductionDifferences(prev, next *provenance) []string {
+	var differences []string
+	if next.Commit != prev.Commit {
+		differences = append(differences, fmt.Sprintf("%s %s is now commit %s, it was %s", next.Source, displayVersion(next.Version), next.Commit, prev.Commit))
+	}
+	if next.CommandLine != prev.CommandLine {
+		differences = append(differences, fmt.Sprintf("the options now amount to %q", next.CommandLine))
+	}
+	if !slices.EqualFunc(next.Patches, prev.Patches, func(a, b patchProvenance) bool { return a.File == b.File && a.SHA256 == b.SHA256 }) {
+		differences = append(differences, "the patches changed since generation")
+	}
+	if !slices.Equal(next.Features, prev.Features) {
+		differences = append(differences, "the feature flags changed, check those of the workspace")
+	}
+	// Wrappers generated before inputs were recorded can't be checked
+	if prev.Inputs == nil {
+		if len(next.Inputs) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: no digests of the files generation read are recorded, %s can't be checked\n", strings.Join(sortedKeys(next.Inputs), ", "))
+		}
+		return differences
+	}
+	for _, input := range sortedKeys(prev.Inputs) {
+		if next.Inputs[input] != prev.Inputs[input] {
+			differences = append(differences, fmt.Sprintf("the %s file changed since generation", input))
+		}
+	}
+	for _, input := range sortedKeys(next.Inputs) {
+		if _, ok := prev.Inputs[input]; !ok {
+			differences = append(differences, fmt.Sprintf("the %s file wasn't read at generation", input))
+		}
+	}
+	return differences
+}
+
+// inputDigests returns the digests of the template and other files
+// generation reads, keyed by the option naming them, so regenerate can tell
+// when one changed. pipelineTemplate and sourceOverrides are the paths of
+// -pipeline-template and -source-overrides, resolved like generation does.
+func inputDigests(opts generateOptions, pipelineTemplate, sourceOverrides string) (map[string]string, error) {
+	paths := map[string]string{"derived": opts.Derived, "outputs_contract": opts.OutputsContract, "source_overrides": sourceOverrides}
+	for input, value := range map[string]string{"header": opts.Header, "footer": opts.Footer, "pipeline_template": pipelineTemplate} {
+		if path, ok := strings.CutPrefix(value, "@"); ok {
+			paths[input] = path
+		}
+	}
+	digests := map[string]string{}
+	for input, path := range paths {
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s file: %w", input, err)
+		}
+		digests[input] = digest(data)
+	}
+	if len(digests) == 0 {
+		return nil, nil
+	}
+	return digests, nil
+}
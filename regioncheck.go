@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// regionSource is the data source reading the region a provider is
+// configured with.
+type regionSource struct {
+	provider, dataType, attr string
+}
+
+// regionSources are the providers -region-check supports, in order of
+// preference.
+var regionSources = []regionSource{
+	{"hashicorp/aws", "aws_region", "name"},
+	{"hashicorp/google", "google_client_config", "region"},
+	{"hashicorp/google-beta", "google_client_config", "region"},
+}
+
+// regionSourceFor returns the region data source of the first supported
+// provider the module requires.
+func regionSourceFor(providers map[string]string) (regionSource, bool) {
+	for _, source := range regionSources {
+		if _, ok := providers[source.provider]; ok {
+			return source, true
+		}
+	}
+	return regionSource{}, false
+}
+
+// validateRegionCheck checks that every -region-check key is an upstream
+// variable and that the module uses a provider whose region can be read.
+func validateRegionCheck(opts generateOptions, contract map[string]contractVariable, providers map[string]string) error {
+	if len(opts.RegionCheck) == 0 {
+		return nil
+	}
+	for _, key := range opts.RegionCheck {
+		if _, ok := contract[key]; !ok {
+			return fmt.Errorf("invalid -region-check %q: the module has no such variable", key)
+		}
+	}
+	if _, ok := regionSourceFor(providers); !ok {
+		names := make([]string, len(regionSources))
+		for i, source := range regionSources {
+			names[i] = source.provider
+		}
+		return fmt.Errorf("%w: -region-check: the module requires none of the providers whose region can be checked (%s)", ErrUnsupportedFeature, strings.Join(names, ", "))
+	}
+	return nil
+}
+
+// generateRegionCheck returns a check block comparing the regions the config
+// sets with -region-check keys against the region of the provider the
+// wrapper inherits, which the module's resources are created in. Checks only
+// warn, so a mismatch shows up in the plan without blocking it.
+func generateRegionCheck(opts generateOptions, providers map[string]string) string {
+	source, ok := regionSourceFor(providers)
+	if len(opts.RegionCheck) == 0 || !ok {
+		return ""
+	}
+	region := fmt.Sprintf("data.%s.current.%s", source.dataType, source.attr)
+
+	var b strings.Builder
+	b.WriteString("\n# Regions set in the config must be the region of the provider the wrapper\n")
+	b.WriteString("# inherits, which the resources are created in\n")
+	b.WriteString("check \"provider_region\" {\n")
+	fmt.Fprintf(&b, "  data %q \"current\" {}\n", source.dataType)
+	for _, key := range opts.RegionCheck {
+		matches := func(config string) string {
+			return fmt.Sprintf("coalesce(lookup(%s, %s, null), %s) == %s", config, hclString(key), region, region)
+		}
+		b.WriteString("\n  assert {\n")
+		if opts.Iterable {
+			instances := accessExpr(fixedAccessor(opts), "local.config", "instances", "{}")
+			fmt.Fprintf(&b, "    condition     = alltrue([for instance in values(%s) : %s])\n", instances, matches("instance"))
+		} else {
+			fmt.Fprintf(&b, "    condition     = %s\n", matches("local.config"))
+		}
+		message := hclString(fmt.Sprintf("Config key %q must be the region of the %s provider, ", key, strings.TrimPrefix(source.provider, "hashicorp/")))
+		fmt.Fprintf(&b, "    error_message = %s${%s}.\"\n", strings.TrimSuffix(message, `"`), region)
+		b.WriteString("  }\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
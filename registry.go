@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// registryAddress matches private registry module addresses such as
+// app.terraform.io/acme/vpc/aws.
+var registryAddress = regexp.MustCompile(`^([a-zA-Z0-9-]+(?:\.[a-zA-Z0-9-]+)+(?::[0-9]+)?)/([^/]+)/([^/]+)/([^/]+)$`)
+
+func isRegistryAddress(source string) bool {
+	m := registryAddress.FindStringSubmatch(source)
+	return m != nil && m[1] != "github.com"
+}
+
+// resolveRegistrySource asks a module registry where the module can be
+// downloaded from, using the token configured for the registry host.
+// Without a version the latest published version is used.
+func resolveRegistrySource(sb sandbox, source, version string) (string, error) {
+	client, modulesURL, err := registryModuleURL(sb, source)
+	if err != nil {
+		return "", err
+	}
+
+	if version == "" {
+		versions, err := registryVersions(client, modulesURL)
+		if err != nil {
+			return "", err
+		}
+		for _, v := range versions {
+			if version == "" || compareVersions(v, version) > 0 {
+				version = v
+			}
+		}
+		if version == "" {
+			return "", fmt.Errorf("%w: %s has no published versions", ErrVersionNotFound, source)
+		}
+	}
+
+	downloadURL := modulesURL + "/" + url.PathEscape(strings.TrimPrefix(version, "v")) + "/download"
+	return defaultResolver().location(downloadURL, func() (string, error) {
+		resp, err := client.get(downloadURL, nil)
+		if err != nil {
+			return "", err
+		}
+		location := resp.Header.Get("X-Terraform-Get")
+		if location == "" {
+			return "", fmt.Errorf("registry did not return a download location for %s %s", source, version)
+		}
+		// Relative locations are relative to the download URL
+		if strings.HasPrefix(location, "/") || strings.HasPrefix(location, "./") || strings.HasPrefix(location, "../") {
+			ref, err := url.Parse(location)
+			if err != nil {
+				return "", err
+			}
+			u, _ := url.Parse(downloadURL)
+			location = u.ResolveReference(ref).String()
+		}
+		return location, nil
+	})
+}
+
+// registryModuleURL discovers the module API of the registry hosting source
+// and returns a client for it along with the module's base URL.
+func registryModuleURL(sb sandbox, source string) (*registryClient, string, error) {
+	m := registryAddress.FindStringSubmatch(source)
+	host, module := m[1], strings.Join(m[2:], "/")
+	client := &registryClient{
+		http:  &http.Client{Timeout: sb.Timeout},
+		token: hostToken(host),
+	}
+
+	modulesPath, err := defaultResolver().discovery(host, func() (string, error) {
+		var discovery map[string]any
+		if _, err := client.get(fmt.Sprintf("https://%s/.well-known/terraform.json", host), &discovery); err != nil {
+			return "", fmt.Errorf("failed to discover registry %s: %w", host, err)
+		}
+		modulesPath, ok := discovery["modules.v1"].(string)
+		if !ok {
+			return "", fmt.Errorf("%w: %s does not provide a module registry", ErrSourceNotFound, host)
+		}
+		return modulesPath, nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	base, err := url.Parse(fmt.Sprintf("https://%s/", host))
+	if err != nil {
+		return nil, "", err
+	}
+	modulesURL := base.ResolveReference(&url.URL{Path: modulesPath}).String()
+	return client, strings.TrimSuffix(modulesURL, "/") + "/" + module, nil
+}
+
+// registryVersions lists the published versions of a registry module.
+func registryVersions(client *registryClient, modulesURL string) ([]string, error) {
+	return defaultResolver().versions(modulesURL, func() ([]string, error) {
+		var response struct {
+			Modules []struct {
+				Versions []struct {
+					Version string `json:"version"`
+				} `json:"versions"`
+			} `json:"modules"`
+		}
+		if _, err := client.get(modulesURL+"/versions", &response); err != nil {
+			return nil, err
+		}
+		var versions []string
+		for _, mod := range response.Modules {
+			for _, v := range mod.Versions {
+				versions = append(versions, v.Version)
+			}
+		}
+		return versions, nil
+	})
+}
+
+type registryClient struct {
+	http  *http.Client
+	token string
+}
+
+// get fetches url, decoding a JSON body into v when it isn't nil.
+func (c *registryClient) get(url string, v any) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%w: %s", ErrVersionNotFound, url)
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return nil, fmt.Errorf("%s: %s (set %s or add credentials to your terraform CLI config)", url, resp.Status, tokenEnvName(strings.ToLower(req.URL.Hostname())))
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			return nil, fmt.Errorf("failed to decode %s: %w", url, err)
+		}
+	}
+	return resp, nil
+}
+
+// compareVersions compares two semantic versions numerically, treating
+// anything unparseable as lower than a valid version.
+func compareVersions(a, b string) int {
+	am, bm := semverTag.FindStringSubmatch(a), semverTag.FindStringSubmatch(b)
+	switch {
+	case am == nil && bm == nil:
+		return strings.Compare(a, b)
+	case am == nil:
+		return -1
+	case bm == nil:
+		return 1
+	}
+	for i := 1; i <= 3; i++ {
+		x, _ := strconv.Atoi(am[i])
+		y, _ := strconv.Atoi(bm[i])
+		if x != y {
+			return x - y
+		}
+	}
+	// A pre-release sorts before the release itself
+	switch {
+	case am[4] == bm[4]:
+		return 0
+	case am[4] == "":
+		return 1
+	case bm[4] == "":
+		return -1
+	}
+	return strings.Compare(am[4], bm[4])
+}
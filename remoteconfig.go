@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// remoteConfigFile holds the data source reading the config with
+// -config-from.
+const remoteConfigFile = "config.tf"
+
+// remoteConfigSource is a location -config-from can read the JSON encoded
+// config from: the variables locating it, the data source reading it and
+// the attribute of the data source holding the document.
+type remoteConfigSource struct {
+	// variables are name and description pairs; %s in a description is
+	// replaced with the wrapper name
+	variables [][2]string
+	dataType  string
+	body      string
+	document  string
+}
+
+var remoteConfigSources = map[string]remoteConfigSource{
+	"ssm": {
+		variables: [][2]string{{"config_parameter", "Name of the SSM parameter holding the JSON encoded %s config"}},
+		dataType:  "aws_ssm_parameter",
+		body:      "name            = var.config_parameter\nwith_decryption = true\n",
+		document:  "value",
+	},
+	"s3": {
+		variables: [][2]string{
+			{"config_bucket", "S3 bucket holding the JSON encoded %s config"},
+			{"config_key", "Key of the S3 object holding the JSON encoded %s config"},
+		},
+		dataType: "aws_s3_object",
+		body:     "bucket = var.config_bucket\nkey    = var.config_key\n",
+		document: "body",
+	},
+	"consul": {
+		variables: [][2]string{{"config_path", "Consul KV path holding the JSON encoded %s config"}},
+		dataType:  "consul_keys",
+		body:      "key {\n  name = \"config\"\n  path = var.config_path\n}\n",
+		document:  "var.config",
+	},
+	"http": {
+		variables: [][2]string{{"config_url", "URL serving the JSON encoded %s config"}},
+		dataType:  "http",
+		body:      "url = var.config_url\n\nrequest_headers = {\n  Accept = \"application/json\"\n}\n",
+		document:  "response_body",
+	},
+}
+
+// validateConfigFrom checks -config-from. The task runner's plan target
+// passes a local config file, which a wrapper reading its config remotely
+// has no variable for.
+func validateConfigFrom(opts generateOptions) error {
+	if opts.ConfigFrom == "" {
+		return nil
+	}
+	if _, ok := remoteConfigSources[opts.ConfigFrom]; !ok {
+		names := make([]string, 0, len(remoteConfigSources))
+		for name := range remoteConfigSources {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("invalid -config-from %q: must be one of %s", opts.ConfigFrom, strings.Join(names, ", "))
+	}
+	if opts.TaskRunner != "" {
+		return fmt.Errorf("-config-from can't be combined with -task-runner, whose plan target passes a local config")
+	}
+	return nil
+}
+
+// configDocument returns the expression holding the wrapper's JSON encoded
+// config: var.config, or the data source reading it with -config-from.
+func configDocument(opts generateOptions) string {
+	source, ok := remoteConfigSources[opts.ConfigFrom]
+	if !ok {
+		return "var.config"
+	}
+	return fmt.Sprintf("data.%s.config.%s", source.dataType, source.document)
+}
+
+// generateRemoteConfigVariables declares the variables locating the config
+// with -config-from.
+func generateRemoteConfigVariables(opts generateOptions, modName string) string {
+	var b strings.Builder
+	for i, v := range remoteConfigSources[opts.ConfigFrom].variables {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "variable %q {\n  type        = string\n  description = %s\n}\n", v[0], hclString(fmt.Sprintf(v[1], modName)))
+	}
+	return b.String()
+}
+
+// generateRemoteConfigTf reads the config with the -config-from data source.
+// The checks var.config would have as validations, of the envelope and the
+// raw section, are postconditions of the data source, since validations
+// can't refer to it.
+func generateRemoteConfigTf(opts generateOptions, env *configEnvelope) string {
+	source := remoteConfigSources[opts.ConfigFrom]
+	document := fmt.Sprintf("jsondecode(self.%s)", source.document)
+	var conditions [][2]string
+	if env != nil {
+		conditions = append(conditions, [2]string{envelopeCondition(env, document), envelopeMessage(env)})
+	}
+	if condition := rawPassthroughCondition(opts, configExpr(opts, document, "self."+source.document)); condition != "" {
+		conditions = append(conditions, [2]string{condition, rawPassthroughMessage(opts)})
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# The JSON encoded config, read from %s\n", opts.ConfigFrom)
+	fmt.Fprintf(&b, "data %q \"config\" {\n%s", source.dataType, source.body)
+	if len(conditions) > 0 {
+		b.WriteString("\nlifecycle {\n")
+		for _, c := range conditions {
+			fmt.Fprintf(&b, `  postcondition {
+    condition     = %s
+    error_message = %s
+  }
+`, c[0], c[1])
+		}
+		b.WriteString("}\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// parseRenames parses the -rename entries, "old=new" each, into a map from
+// old to new variable names. An empty new name records that old was removed
+// rather than renamed, so it isn't detected as a rename again.
+func parseRenames(entries []string) (map[string]string, error) {
+	renames := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		old, new, ok := strings.Cut(entry, "=")
+		if !ok || old == "" {
+			return nil, fmt.Errorf("invalid -rename %q: must be old=new", entry)
+		}
+		if _, dup := renames[old]; dup {
+			return nil, fmt.Errorf("invalid -rename: %s is renamed twice", old)
+		}
+		renames[old] = new
+	}
+	return renames, nil
+}
+
+// validateRenames checks that every -rename leads to an upstream variable,
+// directly or through later renames, and that no old name is still an
+// upstream variable whose key it would shadow.
+func validateRenames(opts generateOptions, contract map[string]contractVariable) error {
+	renames, err := parseRenames(opts.Renames)
+	if err != nil {
+		return err
+	}
+	for _, old := range sortedKeys(renames) {
+		if _, ok := contract[old]; ok {
+			return fmt.Errorf("invalid -rename %s=%s: %s is still an upstream variable", old, renames[old], old)
+		}
+		if renames[old] == "" {
+			continue
+		}
+		if _, ok := contract[renameTarget(renames, old)]; !ok {
+			return fmt.Errorf("invalid -rename %s=%s: the module has no variable %q", old, renames[old], renameTarget(renames, old))
+		}
+	}
+	return nil
+}
+
+// renameTarget follows the renames of old to the current variable name, or
+// "" if it was removed.
+func renameTarget(renames map[string]string, old string) string {
+	name := old
+	for seen := 0; seen <= len(renames); seen++ {
+		next, ok := renames[name]
+		if !ok {
+			return name
+		}
+		name = next
+	}
+	return "" // a cycle
+}
+
+// renamedFrom returns the former names of the variable name, most recent
+// first, so configs written for any earlier version keep working.
+func renamedFrom(opts generateOptions, name string) []string {
+	renames, err := parseRenames(opts.Renames)
+	if err != nil {
+		return nil
+	}
+	var olds []string
+	for pending := []string{name}; len(pending) > 0; {
+		current := pending[0]
+		pending = pending[1:]
+		var previous []string
+		for _, old := range sortedKeys(renames) {
+			if renames[old] == current && old != name && !slices.Contains(olds, old) {
+				previous = append(previous, old)
+			}
+		}
+		olds = append(olds, previous...)
+		pending = append(pending, previous...)
+	}
+	return olds
+}
+
+// deprecatedKeys maps the former variable names of a wrapper that configs
+// may still use to the current ones.
+func deprecatedKeys(opts generateOptions) map[string]string {
+	renames, err := parseRenames(opts.Renames)
+	if err != nil {
+		return nil
+	}
+	keys := map[string]string{}
+	for old := range renames {
+		if target := renameTarget(renames, old); target != "" {
+			keys[old] = target
+		}
+	}
+	return keys
+}
+
+// detectRenames guesses which variables upstream renamed between the
+// previous contract and the next: a removed variable is taken to be renamed
+// when exactly one added variable has the same type and a name containing
+// all the words of its name, or the other way around, like cidr and
+// vpc_cidr_block. The renames are returned as -rename entries. Variables
+// already in renames are left alone.
+func detectRenames(prev, next map[string]contractVariable, renames []string) []string {
+	known, err := parseRenames(renames)
+	if err != nil {
+		return nil
+	}
+	targets := map[string]bool{}
+	for _, new := range known {
+		targets[new] = true
+	}
+
+	candidates := map[string][]string{}
+	claims := map[string]int{}
+	for _, old := range sortedKeys(prev) {
+		if _, ok := next[old]; ok {
+			continue
+		}
+		if _, ok := known[old]; ok {
+			continue
+		}
+		for _, new := range sortedKeys(next) {
+			if _, ok := prev[new]; ok || targets[new] || next[new].Type != prev[old].Type {
+				continue
+			}
+			if containsWords(new, old) || containsWords(old, new) {
+				candidates[old] = append(candidates[old], new)
+				claims[new]++
+			}
+		}
+	}
+
+	var detected []string
+	for _, old := range sortedKeys(candidates) {
+		if list := candidates[old]; len(list) == 1 && claims[list[0]] == 1 {
+			detected = append(detected, old+"="+list[0])
+		}
+	}
+	return detected
+}
+
+// containsWords reports whether every "_" separated word of sub is a word
+// of name.
+func containsWords(name, sub string) bool {
+	words := strings.Split(name, "_")
+	for _, word := range strings.Split(sub, "_") {
+		if !slices.Contains(words, word) {
+			return false
+		}
+	}
+	return true
+}
+
+// renameContract returns prev with the variables renamed since carried over
+// to their new names, so a rename isn't reported as a removal.
+func renameContract(prev map[string]contractVariable, opts generateOptions) map[string]contractVariable {
+	keys := deprecatedKeys(opts)
+	contract := make(map[string]contractVariable, len(prev))
+	for name, v := range prev {
+		if target, ok := keys[name]; ok {
+			name = target
+		}
+		contract[name] = v
+	}
+	return contract
+}
+
+// generateDeprecationChecks returns a check block warning, at plan time, about
+// configs that still set a renamed variable by its former name. Checks only
+// warn, so the plan goes ahead with the value passed on.
+func generateDeprecationChecks(opts generateOptions) string {
+	keys := deprecatedKeys(opts)
+	if len(keys) == 0 {
+		return ""
+	}
+	olds := sortedKeys(keys)
+
+	var b strings.Builder
+	b.WriteString("\n# Configs setting a variable by the name it had before upstream renamed it\n")
+	b.WriteString("# still work, but get a warning at plan time\n")
+	b.WriteString("check \"deprecated_config_keys\" {\n")
+	for i, old := range olds {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("  assert {\n")
+		if opts.Iterable {
+			instances := accessExpr(fixedAccessor(opts), "local.config", "instances", "{}")
+			fmt.Fprintf(&b, "    condition     = alltrue([for instance in values(%s) : !contains(keys(instance), %s)])\n", instances, hclString(old))
+		} else {
+			fmt.Fprintf(&b, "    condition     = !contains(keys(local.config), %s)\n", hclString(old))
+		}
+		fmt.Fprintf(&b, "    error_message = %s\n", hclString(fmt.Sprintf("Config key %q is deprecated, it was renamed to %q upstream.", old, keys[old])))
+		b.WriteString("  }\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
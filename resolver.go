@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// resolverMemoTTL is how long discovery documents and version lists are
+// reused from the on-disk memo before being looked up again.
+const resolverMemoTTL = 15 * time.Minute
+
+// healthMemoTTL is how long the health signals of a repository are reused,
+// as they change slowly and host APIs are rate limited.
+const healthMemoTTL = 24 * time.Hour
+
+// resolver memoizes version resolution: registry discovery documents,
+// published versions, download locations and git tags. Everything is
+// remembered for the rest of the process, so upgrade and batch never repeat
+// an identical lookup. Discovery documents and version lists are also kept
+// on disk for resolverMemoTTL to share them between runs, and repository
+// health signals for healthMemoTTL. Download locations stay in memory only,
+// as registries may return short-lived signed URLs.
+type resolver struct {
+	memoPath string
+
+	mu        sync.Mutex
+	memo      resolverMemo
+	locations map[string]string
+}
+
+type resolverMemo struct {
+	Discovery map[string]memoEntry `json:"discovery,omitempty"`
+	Versions  map[string]memoEntry `json:"versions,omitempty"`
+	// Health holds the JSON encoded moduleHealth of repositories
+	Health map[string]memoEntry `json:"health,omitempty"`
+}
+
+type memoEntry struct {
+	Fetched time.Time `json:"fetched"`
+	Values  []string  `json:"values"`
+}
+
+// defaultResolver is shared by every command in the process.
+var defaultResolver = sync.OnceValue(newResolver)
+
+// newResolver loads the on-disk memo from TFWRAPPER_CACHE_DIR, or the user
+// cache directory. Without either the memo is kept in memory only.
+func newResolver() *resolver {
+	r := &resolver{
+		memo: resolverMemo{
+			Discovery: map[string]memoEntry{},
+			Versions:  map[string]memoEntry{},
+			Health:    map[string]memoEntry{},
+		},
+		locations: map[string]string{},
+	}
+	dir := os.Getenv("TFWRAPPER_CACHE_DIR")
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return r
+		}
+		dir = filepath.Join(userDir, "tfwrapper")
+	}
+	r.memoPath = filepath.Join(dir, "resolver.json")
+
+	// A missing or corrupt memo just means starting from scratch
+	r.merge(readResolverMemo(r.memoPath))
+	return r
+}
+
+// discovery returns the module API path advertised by a registry host.
+func (r *resolver) discovery(host string, lookup func() (string, error)) (string, error) {
+	values, err := r.remember(r.memo.Discovery, host, func() ([]string, error) {
+		path, err := lookup()
+		return []string{path}, err
+	})
+	if err != nil {
+		return "", err
+	}
+	return values[0], nil
+}
+
+// versions returns the versions published for key, which is a registry
+// module URL or a git clone URL.
+func (r *resolver) versions(key string, lookup func() ([]string, error)) ([]string, error) {
+	return r.remember(r.memo.Versions, key, lookup)
+}
+
+// health returns the health signals of the repository at key, JSON encoded.
+func (r *resolver) health(key string, lookup func() (string, error)) (string, error) {
+	values, err := r.remember(r.memo.Health, key, func() ([]string, error) {
+		encoded, err := lookup()
+		return []string{encoded}, err
+	})
+	if err != nil {
+		return "", err
+	}
+	return values[0], nil
+}
+
+// location returns the download location of a registry module version.
+func (r *resolver) location(downloadURL string, lookup func() (string, error)) (string, error) {
+	r.mu.Lock()
+	location, ok := r.locations[downloadURL]
+	r.mu.Unlock()
+	if ok {
+		return location, nil
+	}
+
+	location, err := lookup()
+	if err != nil {
+		return "", err
+	}
+	r.mu.Lock()
+	r.locations[downloadURL] = location
+	r.mu.Unlock()
+	return location, nil
+}
+
+// remember returns the memoized values for key, calling lookup and saving
+// the memo when there are none. Failures are not remembered.
+func (r *resolver) remember(memo map[string]memoEntry, key string, lookup func() ([]string, error)) ([]string, error) {
+	r.mu.Lock()
+	entry, ok := memo[key]
+	r.mu.Unlock()
+	if ok {
+		return entry.Values, nil
+	}
+
+	values, err := lookup()
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	memo[key] = memoEntry{Fetched: time.Now(), Values: values}
+	r.save()
+	return values, nil
+}
+
+// readResolverMemo reads the on-disk memo at path, which is empty when it is
+// missing or corrupt.
+func readResolverMemo(path string) resolverMemo {
+	var memo resolverMemo
+	if data, err := os.ReadFile(path); err != nil || json.Unmarshal(data, &memo) != nil {
+		return resolverMemo{}
+	}
+	return memo
+}
+
+// merge adds the entries of memo that are still fresh and newer than those
+// of r.
+func (r *resolver) merge(memo resolverMemo) {
+	for _, m := range []struct {
+		dst, src map[string]memoEntry
+		ttl      time.Duration
+	}{
+		{r.memo.Discovery, memo.Discovery, resolverMemoTTL},
+		{r.memo.Versions, memo.Versions, resolverMemoTTL},
+		{r.memo.Health, memo.Health, healthMemoTTL},
+	} {
+		for key, entry := range m.src {
+			if time.Since(entry.Fetched) < m.ttl && entry.Fetched.After(m.dst[key].Fetched) {
+				m.dst[key] = entry
+			}
+		}
+	}
+}
+
+// save writes the memo atomically, holding an advisory lock while merging
+// in what other tfwrapper processes saved since it was read, so concurrent
+// runs don't drop each other's entries. The memo is only an optimization, so
+// failing to write it is not an error.
+func (r *resolver) save() {
+	if r.memoPath == "" || checkWrite(r.memoPath) != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(r.memoPath), 0700); err != nil {
+		return
+	}
+	fl, err := acquireFileLock(r.memoPath, defaultLockTimeout)
+	if err != nil {
+		return
+	}
+	defer fl.release()
+
+	r.merge(readResolverMemo(r.memoPath))
+	data, err := json.Marshal(r.memo)
+	if err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(r.memoPath), ".resolver-*.json")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || os.Rename(tmp.Name(), r.memoPath) != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// gitTags lists the tags of a git repository.
+func gitTags(sb sandbox, cloneURL string) ([]string, error) {
+	return defaultResolver().versions(cloneURL, func() ([]string, error) {
+		out, err := sb.withGitHeader(gitCloneHeader(cloneURL)).run(os.TempDir(), "git", "ls-remote", "--tags", "--refs", cloneURL)
+		if err != nil {
+			return nil, classifyCloneError(err)
+		}
+		var tags []string
+		for _, line := range strings.Split(string(out), "\n") {
+			if _, ref, ok := strings.Cut(line, "\t"); ok {
+				tags = append(tags, strings.TrimPrefix(ref, "refs/tags/"))
+			}
+		}
+		return tags, nil
+	})
+}
+
+// latestVersion returns the highest released version of source, or "" when
+// the source has no notion of versions (archives, mercurial) or no semantic
+// version tags.
+func latestVersion(sb sandbox, source string) (string, error) {
+	versions, err := releasedVersions(sb, source)
+	if err != nil || len(versions) == 0 {
+		return "", err
+	}
+	return versions[0], nil
+}
+
+// releasedVersions returns the semantic versions released for source,
+// highest first. Pre-releases are left out.
+func releasedVersions(sb sandbox, source string) ([]string, error) {
+	source, _ = splitSourceSubPath(source)
+	source, _, _ = strings.Cut(source, "?")
+
+	var versions []string
+	var err error
+	switch {
+	case strings.HasPrefix(source, "s3::"), strings.HasPrefix(source, "gcs::"),
+		strings.HasPrefix(source, "hg::"), archiveFormat(source) != "":
+		return nil, nil
+	case isRegistryAddress(source):
+		client, modulesURL, err := registryModuleURL(sb, source)
+		if err != nil {
+			return nil, err
+		}
+		versions, err = registryVersions(client, modulesURL)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		cloneURL, _ := gitCloneURL(strings.TrimPrefix(source, "git::"))
+		if versions, err = gitTags(sb, cloneURL); err != nil {
+			return nil, err
+		}
+	}
+
+	var released []string
+	for _, v := range versions {
+		if m := semverTag.FindStringSubmatch(v); m != nil && m[4] == "" {
+			released = append(released, v)
+		}
+	}
+	sort.SliceStable(released, func(i, j int) bool { return compareVersions(released[i], released[j]) > 0 })
+	return released, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// validateSubPath rejects //subpath values that could point outside the
+// downloaded module, such as "../../etc" or "/etc".
+func validateSubPath(subPath string) error {
+	if filepath.IsAbs(subPath) || strings.HasPrefix(subPath, "/") || strings.HasPrefix(subPath, `\`) {
+		return fmt.Errorf("invalid module subpath %q: must be relative", subPath)
+	}
+	for _, part := range strings.FieldsFunc(subPath, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return fmt.Errorf("invalid module subpath %q: must not contain \"..\"", subPath)
+		}
+	}
+	return nil
+}
+
+// validateWrapperName makes sure a wrapper name (explicit or derived from the
+// source) is a single directory name, so it can't escape the output directory.
+func validateWrapperName(name string) error {
+	switch {
+	case name == "" || name == "." || name == "..":
+		return fmt.Errorf("invalid wrapper name %q", name)
+	case strings.ContainsAny(name, `/\`) || strings.ContainsRune(name, 0):
+		return fmt.Errorf("invalid wrapper name %q: must not contain path separators", name)
+	}
+	return nil
+}
+
+// ensureWithin returns an error if path, after resolving symlinks, is not
+// inside root. It is used before reading or copying upstream files so that a
+// symlink in a module can't expose files from outside the download.
+func ensureWithin(root, path string) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(realRoot, realPath)
+	if err != nil {
+		return err
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return fmt.Errorf("%s resolves to %s, outside of %s", path, realPath, root)
+	}
+	return nil
+}
+
+// within reports whether path is root or inside it.
+func within(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && (rel == "." || filepath.IsLocal(rel))
+}
+
+// walkTree calls fn for the tree at root like filepath.WalkDir, with the
+// path of each entry, its path relative to root and the info of what it
+// resolves to. Symlinks are followed only if they resolve to somewhere inside
+// root, and those to directories are walked like directories, unless they
+// lead back to one being walked.
+func walkTree(root string, fn func(path, rel string, info fs.FileInfo) error) error {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return err
+	}
+	return walkTreeFrom(root, root, ".", []string{realRoot}, fn)
+}
+
+// walkTreeFrom walks dir, at relDir in the tree at root, as walkTree. walking
+// holds the real paths of the directories it was reached through.
+func walkTreeFrom(root, dir, relDir string, walking []string, fn func(path, rel string, info fs.FileInfo) error) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if path == dir && relDir != "." {
+			// The symlink to it was walked already
+			return nil
+		}
+		rel = filepath.Join(relDir, rel)
+		link := d.Type()&fs.ModeSymlink != 0
+		if link {
+			if err := ensureWithin(root, path); err != nil {
+				return err
+			}
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		err = fn(path, rel, info)
+		if !link || !info.IsDir() {
+			return err
+		}
+		// WalkDir doesn't descend into symlinks, and skipping one mustn't
+		// skip the rest of its directory
+		if err == filepath.SkipDir {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return err
+		}
+		realParent, err := filepath.EvalSymlinks(filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		for _, walked := range append(walking, realParent) {
+			if within(target, walked) {
+				return fmt.Errorf("%s links to %s, a directory it is in or reached through, which would loop", path, target)
+			}
+		}
+		return walkTreeFrom(root, target, rel, append(slices.Clip(walking), target), fn)
+	})
+}
+
+// copyDir copies the directory tree at src to dst. Symlinks are followed only
+// if they resolve to somewhere inside src.
+func copyDir(src, dst string) error {
+	return walkTree(src, func(path, rel string, info fs.FileInfo) error {
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			if info.Name() == ".git" || info.Name() == ".terraform" {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, 0755)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, info.Mode().Perm())
+	})
+}
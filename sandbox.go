@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// defaultAllowedEnv is passed through to external commands. Everything else
+// in our environment is scrubbed unless allowed in the exec block of
+// tfwrapper.hcl.
+var defaultAllowedEnv = []string{
+	"PATH", "HOME", "USER", "LANG", "LC_*", "TMPDIR", "TEMP", "TMP",
+	"SSH_AUTH_SOCK", "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY",
+	"http_proxy", "https_proxy", "no_proxy", "XDG_CONFIG_HOME",
+	"SYSTEMROOT", "APPDATA", "USERPROFILE",
+}
+
+const (
+	defaultExecTimeout   = 10 * time.Minute
+	defaultExecMaxOutput = 16 << 20
+)
+
+// execBlock configures how external commands (git, terraform, hooks) are
+// run. It is the exec block of tfwrapper.hcl.
+type execBlock struct {
+	Timeout    string   `hcl:"timeout,optional"`
+	MaxOutput  int      `hcl:"max_output,optional"`
+	AllowedEnv []string `hcl:"allowed_env,optional"`
+}
+
+// sandbox runs external commands with a scrubbed environment, an explicit
+// working directory, a timeout and capped output.
+type sandbox struct {
+	Timeout    time.Duration
+	MaxOutput  int
+	AllowedEnv []string
+	// ExtraEnv is set on top of the scrubbed environment, for values we
+	// compute ourselves such as credentials.
+	ExtraEnv []string
+	// GitConfig holds key=value settings passed to git commands through
+	// the environment.
+	GitConfig []string
+}
+
+func defaultSandbox() sandbox {
+	return sandbox{
+		Timeout:    defaultExecTimeout,
+		MaxOutput:  defaultExecMaxOutput,
+		AllowedEnv: defaultAllowedEnv,
+	}
+}
+
+// newSandbox applies the settings of an exec block on top of the defaults.
+// Extra allowed_env entries add to the default list rather than replace it.
+func newSandbox(cfg *execBlock) (sandbox, error) {
+	sb := defaultSandbox()
+	if cfg == nil {
+		return sb, nil
+	}
+	if cfg.Timeout != "" {
+		d, err := time.ParseDuration(cfg.Timeout)
+		if err != nil {
+			return sb, fmt.Errorf("invalid exec timeout: %w", err)
+		}
+		sb.Timeout = d
+	}
+	if cfg.MaxOutput > 0 {
+		sb.MaxOutput = cfg.MaxOutput
+	}
+	sb.AllowedEnv = append(append([]string{}, defaultAllowedEnv...), cfg.AllowedEnv...)
+	return sb, nil
+}
+
+// env returns the allowed subset of our environment. Patterns ending in "*"
+// match by prefix.
+func (sb sandbox) env() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		for _, pattern := range sb.AllowedEnv {
+			prefix, isGlob := strings.CutSuffix(pattern, "*")
+			if name == pattern || isGlob && strings.HasPrefix(name, prefix) {
+				env = append(env, kv)
+				break
+			}
+		}
+	}
+	env = append(env, sb.ExtraEnv...)
+	if len(sb.GitConfig) > 0 {
+		env = append(env, fmt.Sprintf("GIT_CONFIG_COUNT=%d", len(sb.GitConfig)))
+		for i, kv := range sb.GitConfig {
+			key, value, _ := strings.Cut(kv, "=")
+			env = append(env, fmt.Sprintf("GIT_CONFIG_KEY_%d=%s", i, key), fmt.Sprintf("GIT_CONFIG_VALUE_%d=%s", i, value))
+		}
+	}
+	// Never let git block waiting for credentials on a terminal
+	return append(env, "GIT_TERMINAL_PROMPT=0")
+}
+
+// run executes name in dir and returns its stdout. dir is required so that
+// commands never inherit our working directory. On failure the error includes
+// the (capped) stderr output, and whatever was written to stdout is still
+// returned, like exec.Cmd.Output.
+func (sb sandbox) run(dir, name string, args ...string) ([]byte, error) {
+	return sb.runInput(dir, nil, name, args...)
+}
+
+// runInput is run with stdin fed from input.
+func (sb sandbox) runInput(dir string, input []byte, name string, args ...string) ([]byte, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("refusing to run %s without a working directory", name)
+	}
+	if err := checkExec(name); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), sb.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Env = sb.env()
+	if input != nil {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+	// Don't wait forever for grandchildren that keep our pipes open after
+	// the command itself has been killed
+	cmd.WaitDelay = 5 * time.Second
+	stdout := &cappedBuffer{limit: sb.MaxOutput}
+	stderr := &cappedBuffer{limit: sb.MaxOutput}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		return nil, fmt.Errorf("%s %s timed out after %s", name, strings.Join(args, " "), sb.Timeout)
+	case err != nil:
+		return stdout.Bytes(), fmt.Errorf("%s %s failed: %w\n%s", name, strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
+	case stdout.truncated:
+		return nil, fmt.Errorf("%s %s produced more than %d bytes of output", name, strings.Join(args, " "), sb.MaxOutput)
+	}
+	return stdout.Bytes(), nil
+}
+
+// cappedBuffer keeps at most limit bytes and silently discards the rest, so
+// a runaway command can't exhaust our memory.
+type cappedBuffer struct {
+	bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
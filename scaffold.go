@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+const scaffoldWorkspace = `# tfwrapper workspace configuration.
+# Wrappers generated anywhere below this directory are recorded in
+# tfwrapper.lock.json.
+workspace {
+  wrappers_dir = "wrappers"
+  stacks_dir   = "stacks"
+  schemas_dir  = "schemas"
+
+  # How long to wait for other tfwrapper processes to release the lock file
+  lock_timeout = "30s"
+}
+
+# Limits for external commands (git, terraform). Only a small set of
+# environment variables is passed through unless listed in allowed_env.
+exec {
+  timeout     = "10m"
+  max_output  = 16777216
+  allowed_env = ["AWS_*", "TF_*"]
+}
+
+# Defaults applied to every wrapper generated in this workspace
+# generate {
+#   header = "GENERATED BY tfwrapper {{.ToolVersion}} from {{.Source}}@{{.Version}} - do not edit"
+# }
+`
+
+const scaffoldGitignore = `.terraform/
+*.tfplan
+*.flock
+`
+
+const scaffoldMakefile = `# Generated by tfwrapper scaffold-repo
+TF ?= %s
+WRAPPERS := $(wildcard wrappers/*)
+
+.PHONY: wrapper fmt validate
+
+# Generate a wrapper: make wrapper SOURCE=terraform-aws-modules/vpc/aws VERSION=5.1.0 NAME=vpc
+wrapper:
+	cd wrappers && tfwrapper -source $(SOURCE) $(if $(VERSION),-version $(VERSION)) $(if $(NAME),-name $(NAME))
+
+fmt:
+	$(TF) fmt -recursive
+
+validate:
+	@for dir in $(WRAPPERS); do \
+		echo "==> $$dir"; \
+		(cd $$dir && $(TF) init -backend=false -input=false >/dev/null && $(TF) validate) || exit 1; \
+	done
+`
+
+func runScaffoldRepo(args []string) {
+	fs := flag.NewFlagSet("scaffold-repo", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Directory to lay out the wrappers repository in")
+	binary := fs.String("binary", "terraform", "Terraform binary used by the Makefile targets (terraform or tofu)")
+	fs.Parse(args)
+	if err := checkWrite(*dir); err != nil {
+		log.Fatalf("Failed to scaffold: %v", err)
+	}
+
+	// Create the standard directory layout
+	for _, sub := range []string{"wrappers", "stacks", "schemas"} {
+		path := filepath.Join(*dir, sub)
+		if err := os.MkdirAll(path, 0755); err != nil {
+			log.Fatalf("Failed to create directory: %v", err)
+		}
+		scaffoldFile(path, ".gitkeep", "")
+	}
+
+	scaffoldFile(*dir, workspaceFileName, scaffoldWorkspace)
+	scaffoldFile(*dir, lockFileName, "{\n  \"wrappers\": {}\n}\n")
+	scaffoldFile(*dir, "Makefile", fmt.Sprintf(scaffoldMakefile, *binary))
+	scaffoldFile(*dir, ".gitignore", scaffoldGitignore)
+
+	fmt.Printf("Wrappers repository scaffolded in %s\n", *dir)
+}
+
+// scaffoldFile writes content to dir/name unless the file already exists, so
+// re-running scaffold-repo never clobbers an existing workspace.
+func scaffoldFile(dir, name, content string) {
+	path := filepath.Join(dir, name)
+	if _, err := os.Stat(path); err == nil {
+		fmt.Printf("Skipping %s (already exists)\n", path)
+		return
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", name, err)
+	}
+}
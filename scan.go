@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// scanModule runs a scanner (trivy, checkov, a custom script, ...) against
+// the downloaded module. The command is split on whitespace and "{dir}" is
+// replaced with the module directory; it also runs inside that directory.
+//
+// Scanners signal findings with a non-zero exit status. Those are reported
+// as warnings, or returned as an error when failOnFindings is set.
+func scanModule(sb sandbox, command, modulePath string, failOnFindings bool) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil
+	}
+	for i, arg := range args {
+		args[i] = strings.ReplaceAll(arg, "{dir}", modulePath)
+	}
+
+	out, err := sb.run(modulePath, args[0], args[1:]...)
+	if len(out) > 0 {
+		os.Stderr.Write(out)
+	}
+	if err == nil {
+		return nil
+	}
+	if failOnFindings {
+		return fmt.Errorf("module scan reported findings: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "Warning: module scan reported findings: %v\n", err)
+	return nil
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"log"
+	"maps"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/ext/typeexpr"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func runSchema(args []string) {
+	fs := flag.NewFlagSet("schema", flag.ExitOnError)
+	wrapperDir := fs.String("wrapper", ".", "Wrapper directory whose .tfwrapper.json describes the config")
+	schemaFormat := fs.String("format", "jsonschema", "Schema to write: jsonschema, pulumi, typescript or go")
+	output := fs.String("o", "", "Write the schema to this file instead of stdout")
+	fs.Parse(args)
+
+	prov, err := readMetadata(*wrapperDir)
+	if err != nil {
+		fatalError("Failed to read wrapper metadata", err)
+	}
+	if prov == nil {
+		log.Fatalf("Error: %s not found in %s", metadataFileName, *wrapperDir)
+	}
+	name := wrapperName(prov.Options)
+
+	config := configSchema(prov)
+	var content string
+	switch *schemaFormat {
+	case "jsonschema":
+		content, err = jsonSchema(name, config)
+	case "pulumi":
+		content, err = pulumiSchema(name, prov.WrapperVersion, config)
+	case "typescript":
+		content = typeScriptSchema(name, config)
+	case "go":
+		content, err = goSchema(name, config)
+	default:
+		log.Fatalf("Error: invalid -format %q: must be jsonschema, pulumi, typescript or go", *schemaFormat)
+	}
+	if err != nil {
+		log.Fatalf("Failed to write schema: %v", err)
+	}
+
+	if *output == "" {
+		fmt.Print(content)
+		return
+	}
+	if err := checkWrite(*output); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+	if err := os.WriteFile(*output, []byte(content), 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+}
+
+// schemaNode is a config type in a form every schema format can be written
+// from. Kind is "string", "number", "bool", "any", "list", "set", "map",
+// "object" or "tuple".
+type schemaNode struct {
+	Kind  string
+	Elem  *schemaNode            // list, set and map
+	Attrs map[string]*schemaNode // object
+	// Required are the object attributes that must be set
+	Required []string
+	Items    []*schemaNode // tuple
+	// Deprecated marks the former name of a renamed variable
+	Deprecated bool
+}
+
+// typeNode parses a variable's type constraint. Anything that can't be
+// parsed is "any".
+func typeNode(typ string) *schemaNode {
+	if typ == "" {
+		return &schemaNode{Kind: "any"}
+	}
+	expr, diags := parseTypeExpr(typ)
+	if diags.HasErrors() {
+		return &schemaNode{Kind: "any"}
+	}
+	ty, _, diags := typeexpr.TypeConstraintWithDefaults(expr)
+	if diags.HasErrors() {
+		return &schemaNode{Kind: "any"}
+	}
+	return ctyTypeNode(ty)
+}
+
+// joinedAttrPattern finds the object type attributes that were on lines of
+// their own before parseVariables joined the type constraint into one line.
+var joinedAttrPattern = regexp.MustCompile(`([^\s{,(]) ([A-Za-z_][\w-]* = )`)
+
+// parseTypeExpr parses a variable's type constraint, splitting the
+// attributes of multi-line object types onto their own lines again.
+func parseTypeExpr(typ string) (hclsyntax.Expression, hcl.Diagnostics) {
+	expr, diags := hclsyntax.ParseExpression([]byte(typ), "type", hcl.InitialPos)
+	if diags.HasErrors() {
+		split := joinedAttrPattern.ReplaceAllString(typ, "$1\n$2")
+		if retried, retryDiags := hclsyntax.ParseExpression([]byte(split), "type", hcl.InitialPos); !retryDiags.HasErrors() {
+			return retried, nil
+		}
+	}
+	return expr, diags
+}
+
+func ctyTypeNode(ty cty.Type) *schemaNode {
+	switch {
+	case ty == cty.String:
+		return &schemaNode{Kind: "string"}
+	case ty == cty.Number:
+		return &schemaNode{Kind: "number"}
+	case ty == cty.Bool:
+		return &schemaNode{Kind: "bool"}
+	case ty.IsListType():
+		return &schemaNode{Kind: "list", Elem: ctyTypeNode(ty.ElementType())}
+	case ty.IsSetType():
+		return &schemaNode{Kind: "set", Elem: ctyTypeNode(ty.ElementType())}
+	case ty.IsMapType():
+		return &schemaNode{Kind: "map", Elem: ctyTypeNode(ty.ElementType())}
+	case ty.IsObjectType():
+		node := &schemaNode{Kind: "object", Attrs: map[string]*schemaNode{}}
+		for name, attr := range ty.AttributeTypes() {
+			node.Attrs[name] = ctyTypeNode(attr)
+			if !ty.AttributeOptional(name) {
+				node.Required = append(node.Required, name)
+			}
+		}
+		slices.Sort(node.Required)
+		return node
+	case ty.IsTupleType():
+		node := &schemaNode{Kind: "tuple"}
+		for _, elem := range ty.TupleElementTypes() {
+			node.Items = append(node.Items, ctyTypeNode(elem))
+		}
+		return node
+	}
+	return &schemaNode{Kind: "any"}
+}
+
+// configSchema returns the type of the wrapper's whole config: the instance
+// keys from the variable contract, under "instances" for iterable wrappers,
+// plus the keys the wrapper itself adds.
+func configSchema(prov *provenance) *schemaNode {
+	opts := prov.Options
+	deprecated := deprecatedKeys(opts)
+	instance := &schemaNode{Kind: "object", Attrs: map[string]*schemaNode{}}
+	raw := &schemaNode{Kind: "object", Attrs: map[string]*schemaNode{}}
+	for _, key := range sortedKeys(prov.Variables) {
+		v := prov.Variables[key]
+		if slices.Contains(opts.Exclude, key) {
+			raw.Attrs[key] = typeNode(v.Type)
+			continue
+		}
+		instance.Attrs[key] = typeNode(v.Type)
+		// With merge layers a required key may be set by a layer instead,
+		// a renamed one by its former name, and a derived one not at all
+		derived := prov.Derived != nil && prov.Derived.Expressions[key] != ""
+		if v.Required && !derived && len(opts.MergeLayers) == 0 && len(renamedFrom(opts, key)) == 0 {
+			instance.Required = append(instance.Required, key)
+		}
+	}
+	for _, old := range sortedKeys(deprecated) {
+		if v, ok := prov.Variables[deprecated[old]]; ok && instance.Attrs[deprecated[old]] != nil {
+			node := typeNode(v.Type)
+			node.Deprecated = true
+			instance.Attrs[old] = node
+		}
+	}
+	if prov.Derived != nil {
+		for _, key := range prov.Derived.Inputs {
+			instance.Attrs[key] = typeNode("")
+		}
+	}
+	if opts.AllowRawPassthrough && len(raw.Attrs) > 0 {
+		instance.Attrs[rawSection] = raw
+	}
+	if opts.Conditional {
+		instance.Attrs[conditionalKey] = &schemaNode{Kind: "bool"}
+	}
+	if len(opts.InstanceProviders) > 0 {
+		instance.Attrs[instanceProviderKey] = &schemaNode{Kind: "string"}
+	}
+
+	config := instance
+	if opts.Iterable {
+		config = &schemaNode{Kind: "object", Attrs: map[string]*schemaNode{
+			"instances": {Kind: "map", Elem: instance},
+		}}
+	}
+	if len(opts.FanOut) > 0 {
+		// An override may set any instance key, but none is required there
+		override := &schemaNode{Kind: "object", Attrs: maps.Clone(instance.Attrs)}
+		config.Attrs[fanOutTargetsKey] = &schemaNode{Kind: "list", Elem: &schemaNode{Kind: "string"}}
+		config.Attrs[fanOutOverridesKey] = &schemaNode{Kind: "map", Elem: override}
+	}
+	if opts.DefaultTags {
+		config.Attrs[defaultTagsKey] = &schemaNode{Kind: "map", Elem: &schemaNode{Kind: "string"}}
+	}
+	// A layer may set any instance key, but none is required there
+	for _, layer := range opts.MergeLayers {
+		config.Attrs[layer] = &schemaNode{Kind: "object", Attrs: instance.Attrs}
+	}
+	return config
+}
+
+// jsonSchema writes config as a JSON Schema (draft 2020-12) that rejects
+// unknown keys.
+func jsonSchema(name string, config *schemaNode) (string, error) {
+	schema := jsonSchemaNode(config)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = name + " config"
+	return marshalSchema(schema)
+}
+
+func jsonSchemaNode(node *schemaNode) map[string]any {
+	schema := jsonSchemaType(node)
+	if node.Deprecated {
+		schema["deprecated"] = true
+	}
+	return schema
+}
+
+func jsonSchemaType(node *schemaNode) map[string]any {
+	switch node.Kind {
+	case "string", "number":
+		return map[string]any{"type": node.Kind}
+	case "bool":
+		return map[string]any{"type": "boolean"}
+	case "list", "set":
+		schema := map[string]any{"type": "array", "items": jsonSchemaNode(node.Elem)}
+		if node.Kind == "set" {
+			schema["uniqueItems"] = true
+		}
+		return schema
+	case "map":
+		return map[string]any{"type": "object", "additionalProperties": jsonSchemaNode(node.Elem)}
+	case "object":
+		properties := map[string]any{}
+		for key, attr := range node.Attrs {
+			properties[key] = jsonSchemaNode(attr)
+		}
+		schema := map[string]any{"type": "object", "properties": properties, "additionalProperties": false}
+		if len(node.Required) > 0 {
+			schema["required"] = node.Required
+		}
+		return schema
+	case "tuple":
+		items := make([]any, len(node.Items))
+		for i, item := range node.Items {
+			items[i] = jsonSchemaNode(item)
+		}
+		return map[string]any{"type": "array", "prefixItems": items, "items": false}
+	}
+	return map[string]any{}
+}
+
+// pulumiSchema writes config as the types of a Pulumi package schema.
+// Pulumi has no inline object types, so every object becomes a named type
+// referenced from its parent.
+func pulumiSchema(name, version string, config *schemaNode) (string, error) {
+	types := map[string]any{}
+	var property func(node *schemaNode, typeName string) map[string]any
+	objectType := func(node *schemaNode, typeName string) string {
+		token := name + ":index:" + typeName
+		properties := map[string]any{}
+		for key, attr := range node.Attrs {
+			properties[key] = property(attr, typeName+exportedName(key))
+		}
+		spec := map[string]any{"type": "object", "properties": properties}
+		if len(node.Required) > 0 {
+			spec["required"] = node.Required
+		}
+		types[token] = spec
+		return token
+	}
+	property = func(node *schemaNode, typeName string) map[string]any {
+		switch node.Kind {
+		case "string", "number":
+			return map[string]any{"type": node.Kind}
+		case "bool":
+			return map[string]any{"type": "boolean"}
+		case "list", "set":
+			return map[string]any{"type": "array", "items": property(node.Elem, typeName)}
+		case "map":
+			return map[string]any{"type": "object", "additionalProperties": property(node.Elem, typeName)}
+		case "object":
+			return map[string]any{"$ref": "#/types/" + objectType(node, typeName)}
+		case "tuple":
+			return map[string]any{"type": "array", "items": map[string]any{"$ref": "pulumi.json#/Any"}}
+		}
+		return map[string]any{"$ref": "pulumi.json#/Any"}
+	}
+	objectType(config, "Config")
+
+	schema := map[string]any{"name": name, "types": types}
+	if version != "" {
+		schema["version"] = strings.TrimPrefix(version, "v")
+	}
+	return marshalSchema(schema)
+}
+
+func marshalSchema(schema map[string]any) (string, error) {
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(schema); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// typeScriptSchema writes config as a TypeScript interface.
+func typeScriptSchema(name string, config *schemaNode) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Config of the %s wrapper, passed JSON encoded as var.config.\n", name)
+	fmt.Fprintf(&b, "export interface %sConfig %s\n", exportedName(name), typeScriptType(config, ""))
+	return b.String()
+}
+
+func typeScriptType(node *schemaNode, indent string) string {
+	switch node.Kind {
+	case "string", "number":
+		return node.Kind
+	case "bool":
+		return "boolean"
+	case "list", "set":
+		elem := typeScriptType(node.Elem, indent)
+		if strings.ContainsAny(elem, " |") {
+			elem = "(" + elem + ")"
+		}
+		return elem + "[]"
+	case "map":
+		return "Record<string, " + typeScriptType(node.Elem, indent) + ">"
+	case "object":
+		var b strings.Builder
+		b.WriteString("{\n")
+		for _, key := range sortedKeys(node.Attrs) {
+			field := key
+			if !isIdentifier(key) {
+				field = fmt.Sprintf("%q", key)
+			}
+			if !slices.Contains(node.Required, key) {
+				field += "?"
+			}
+			fmt.Fprintf(&b, "%s  %s: %s;\n", indent, field, typeScriptType(node.Attrs[key], indent+"  "))
+		}
+		b.WriteString(indent + "}")
+		return b.String()
+	case "tuple":
+		items := make([]string, len(node.Items))
+		for i, item := range node.Items {
+			items[i] = typeScriptType(item, indent)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return "unknown"
+}
+
+// goSchema writes config as a Go struct to json.Marshal the config with.
+func goSchema(name string, config *schemaNode) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "// Package %s holds the config of the %s wrapper.\n", goPackageName(name), name)
+	fmt.Fprintf(&b, "package %s\n\n", goPackageName(name))
+	fmt.Fprintf(&b, "// Config of the %s wrapper, passed JSON encoded as var.config.\n", name)
+	fmt.Fprintf(&b, "type Config %s\n", goType(config))
+	src, err := format.Source([]byte(b.String()))
+	if err != nil {
+		return "", err
+	}
+	return string(src), nil
+}
+
+func goType(node *schemaNode) string {
+	switch node.Kind {
+	case "string", "bool":
+		return node.Kind
+	case "number":
+		return "float64"
+	case "list", "set":
+		return "[]" + goType(node.Elem)
+	case "map":
+		return "map[string]" + goType(node.Elem)
+	case "object":
+		var b strings.Builder
+		b.WriteString("struct {\n")
+		for _, key := range sortedKeys(node.Attrs) {
+			attr := node.Attrs[key]
+			typ, tag := goType(attr), key
+			if !slices.Contains(node.Required, key) {
+				// Optional values are left out rather than sent as zero
+				// values, which would override the upstream defaults
+				if attr.Kind != "list" && attr.Kind != "set" && attr.Kind != "map" && attr.Kind != "any" {
+					typ = "*" + typ
+				}
+				tag += ",omitempty"
+			}
+			fmt.Fprintf(&b, "%s %s `json:%q`\n", exportedName(key), typ, tag)
+		}
+		b.WriteString("}")
+		return b.String()
+	case "tuple":
+		return "[]any"
+	}
+	return "any"
+}
+
+// exportedName turns a snake_case or kebab-case key into CamelCase.
+func exportedName(key string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range key {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	name := b.String()
+	if name == "" || unicode.IsDigit(rune(name[0])) {
+		name = "X" + name
+	}
+	return name
+}
+
+func goPackageName(name string) string {
+	pkg := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+	if pkg == "" || unicode.IsDigit(rune(pkg[0])) {
+		pkg = "config" + pkg
+	}
+	return pkg
+}
+
+func isIdentifier(s string) bool {
+	for i, r := range s {
+		if !(unicode.IsLetter(r) || r == '_' || r == '$' || i > 0 && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A selftest fixture is a directory containing:
+//
+//	fixture.json  generation options (generateOptions field names)
+//	module/       a recorded copy of the upstream module
+//	expected/     the golden wrapper files
+func runSelftest(args []string) {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	fixturesDir := fs.String("fixtures", "fixtures", "Directory containing one subdirectory per fixture")
+	update := fs.Bool("update", false, "Rewrite the golden files with the current output instead of comparing")
+	fs.Parse(args)
+
+	entries, err := os.ReadDir(*fixturesDir)
+	if err != nil {
+		log.Fatalf("Failed to read fixtures: %v", err)
+	}
+
+	// Golden files must not depend on the build that produced them
+	toolVersion = "selftest"
+
+	failed, ran := 0, 0
+	for _, entry := range entries {
+		dir := filepath.Join(*fixturesDir, entry.Name())
+		if _, err := os.Stat(filepath.Join(dir, "fixture.json")); !entry.IsDir() || err != nil {
+			continue
+		}
+		ran++
+
+		diff, err := runFixture(dir, entry.Name(), *update)
+		switch {
+		case err != nil:
+			failed++
+			fmt.Printf("FAIL %s: %v\n", entry.Name(), err)
+		case diff != "":
+			failed++
+			fmt.Printf("FAIL %s\n%s", entry.Name(), diff)
+		case *update:
+			fmt.Printf("UPDATED %s\n", entry.Name())
+		default:
+			fmt.Printf("ok   %s\n", entry.Name())
+		}
+	}
+
+	if ran == 0 {
+		log.Fatalf("No fixtures found in %s", *fixturesDir)
+	}
+	if failed > 0 {
+		fmt.Printf("%d of %d fixtures failed\n", failed, ran)
+		os.Exit(1)
+	}
+}
+
+// runFixture regenerates a fixture in memory and returns a diff against its
+// golden files, or rewrites them when update is set.
+func runFixture(dir, name string, update bool) (string, error) {
+	src, err := os.ReadFile(filepath.Join(dir, "fixture.json"))
+	if err != nil {
+		return "", err
+	}
+	var opts generateOptions
+	if err := json.Unmarshal(src, &opts); err != nil {
+		return "", fmt.Errorf("invalid fixture.json: %w", err)
+	}
+	if opts.Name == "" {
+		opts.Name = name
+	}
+	opts.OutputDir = ""
+	opts.ModuleDir = filepath.Join(dir, "module")
+	opts.IgnoreWorkspace = true
+
+	out := newMemSink()
+	if _, err := generateWrapperTo(out, opts); err != nil {
+		return "", err
+	}
+	actual := make(map[string]string)
+	for _, path := range out.paths() {
+		actual[strings.TrimPrefix(path, opts.Name+"/")] = string(out.files[path].Data)
+	}
+
+	expectedDir := filepath.Join(dir, "expected")
+	if update {
+		if err := checkWrite(expectedDir); err != nil {
+			return "", err
+		}
+		if err := os.RemoveAll(expectedDir); err != nil {
+			return "", err
+		}
+		for rel, content := range actual {
+			path := filepath.Join(expectedDir, filepath.FromSlash(rel))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return "", err
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				return "", err
+			}
+		}
+		return "", nil
+	}
+
+	expected, err := readTree(expectedDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read golden files: %w", err)
+	}
+	return diffTrees(expected, actual), nil
+}
+
+// readTree reads every file below dir, keyed by slash-separated relative path.
+func readTree(dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	return files, err
+}
+
+// diffTrees returns unified diffs for every file that differs between two
+// trees, including files missing from either side.
+func diffTrees(expected, actual map[string]string) string {
+	names := make(map[string]bool)
+	for name := range expected {
+		names[name] = true
+	}
+	for name := range actual {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var b strings.Builder
+	for _, name := range sorted {
+		exp, inExpected := expected[name]
+		act, inActual := actual[name]
+		aName, bName := "expected/"+name, "actual/"+name
+		if !inExpected {
+			aName = "/dev/null"
+		}
+		if !inActual {
+			bName = "/dev/null"
+		}
+		b.WriteString(unifiedDiff(aName, bName, exp, act))
+	}
+	return b.String()
+}
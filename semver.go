@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// initialWrapperVersion is given to a wrapper the first time it's generated.
+const initialWrapperVersion = "v0.1.0"
+
+// contractVariable is the part of an upstream variable that callers of the
+// wrapper depend on.
+type contractVariable struct {
+	Type     string `json:"type,omitempty"`
+	Required bool   `json:"required,omitempty"`
+}
+
+// variableContract returns the type and required-ness of every variable.
+func variableContract(vars []moduleVariable) map[string]contractVariable {
+	contract := make(map[string]contractVariable, len(vars))
+	for _, v := range vars {
+		contract[v.Name] = contractVariable{Type: v.Type, Required: v.DefaultKind == "required"}
+	}
+	return contract
+}
+
+// versionBump classifies the difference between two variable contracts:
+// "major" when keys are removed, retyped or become required, "minor" when
+// optional keys are added and "patch" otherwise. Every change is listed,
+// breaking ones first.
+func versionBump(old, new map[string]contractVariable) (string, []string) {
+	var major, minor []string
+	for name, o := range old {
+		n, ok := new[name]
+		switch {
+		case !ok:
+			major = append(major, "removed "+name)
+		case n.Type != o.Type:
+			major = append(major, fmt.Sprintf("retyped %s (%s -> %s)", name, displayType(o.Type), displayType(n.Type)))
+		case n.Required && !o.Required:
+			major = append(major, name+" is now required")
+		}
+	}
+	for name, n := range new {
+		if _, ok := old[name]; ok {
+			continue
+		}
+		if n.Required {
+			major = append(major, "added required "+name)
+		} else {
+			minor = append(minor, "added "+name)
+		}
+	}
+	sort.Strings(major)
+	sort.Strings(minor)
+
+	switch {
+	case len(major) > 0:
+		return "major", append(major, minor...)
+	case len(minor) > 0:
+		return "minor", minor
+	}
+	return "patch", nil
+}
+
+func displayType(t string) string {
+	if t == "" {
+		return "any"
+	}
+	return t
+}
+
+// bumpVersion increments the given part of a vMAJOR.MINOR.PATCH version.
+func bumpVersion(version, part string) (string, error) {
+	m := semverTag.FindStringSubmatch(version)
+	if m == nil {
+		return "", fmt.Errorf("%q is not a semantic version", version)
+	}
+	nums := make([]int, 3)
+	for i := range nums {
+		nums[i], _ = strconv.Atoi(m[i+1])
+	}
+	switch part {
+	case "major":
+		nums = []int{nums[0] + 1, 0, 0}
+	case "minor":
+		nums = []int{nums[0], nums[1] + 1, 0}
+	default:
+		nums[2]++
+	}
+	prefix := ""
+	if strings.HasPrefix(version, "v") {
+		prefix = "v"
+	}
+	return fmt.Sprintf("%s%d.%d.%d", prefix, nums[0], nums[1], nums[2]), nil
+}
+
+// readMetadata loads the .tfwrapper.json of an existing wrapper, returning
+// nil if there isn't one.
+func readMetadata(wrapperDir string) (*provenance, error) {
+	data, err := os.ReadFile(filepath.Join(wrapperDir, metadataFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var prev provenance
+	if err := json.Unmarshal(data, &prev); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", metadataFileName, err)
+	}
+	return &prev, nil
+}
+
+// nextWrapperVersion works out the wrapper's own version from the previous
+// generation, if any, and explains the bump on stderr.
+func nextWrapperVersion(prev *provenance, next provenance) (string, error) {
+	if prev == nil || prev.WrapperVersion == "" {
+		return initialWrapperVersion, nil
+	}
+	if prev.Source == next.Source && prev.Version == next.Version && prev.Commit == next.Commit &&
+		prev.ToolVersion == next.ToolVersion && prev.CommandLine == next.CommandLine {
+		return prev.WrapperVersion, nil
+	}
+
+	part, reasons := "minor", []string{"no variable contract recorded for the previous version"}
+	if prev.Variables != nil {
+		part, reasons = versionBump(renameContract(prev.Variables, next.Options), next.Variables)
+	}
+	// Metadata written before outputs were recorded has none to compare
+	if prev.Outputs != nil {
+		removed, added := outputChanges(prev.Outputs, next.Outputs)
+		switch {
+		case len(removed) > 0:
+			part = "major"
+		case len(added) > 0 && part == "patch":
+			part = "minor"
+		}
+		for _, name := range removed {
+			reasons = append(reasons, "removed output "+name)
+		}
+		for _, name := range added {
+			reasons = append(reasons, "added output "+name)
+		}
+	}
+	version, err := bumpVersion(prev.WrapperVersion, part)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(os.Stderr, "Suggested wrapper version: %s -> %s (%s)\n", prev.WrapperVersion, version, part)
+	for _, reason := range reasons {
+		fmt.Fprintf(os.Stderr, "  %s\n", reason)
+	}
+	return version, nil
+}
+
+// outputChanges returns the outputs only in old and only in new.
+func outputChanges(old, new []string) (removed, added []string) {
+	for _, name := range old {
+		if !slices.Contains(new, name) {
+			removed = append(removed, name)
+		}
+	}
+	for _, name := range new {
+		if !slices.Contains(old, name) {
+			added = append(added, name)
+		}
+	}
+	return removed, added
+}
+
+// checkOutputContract refuses to regenerate a wrapper whose upstream removed
+// outputs the previous generation exported, unless allowBreaking is set.
+// Consumers read them as module.<wrapper>.<name> (or .output.<name>), which
+// only fails at their next plan.
+func checkOutputContract(prev *provenance, next provenance, allowBreaking bool) error {
+	if prev == nil || prev.Outputs == nil {
+		return nil
+	}
+	removed, _ := outputChanges(prev.Outputs, next.Outputs)
+	if len(removed) == 0 {
+		return nil
+	}
+	// Either upstream removed them or the outputs contract no longer
+	// allows them
+	what := fmt.Sprintf("%s %s no longer has", next.Source, displayVersion(next.Version))
+	if next.OutputsContract != nil {
+		what = fmt.Sprintf("%s %s or its outputs contract no longer has", next.Source, displayVersion(next.Version))
+	}
+	if allowBreaking {
+		fmt.Fprintf(os.Stderr, "Warning: %s the outputs %s, update the wrapper's consumers\n", what, strings.Join(removed, ", "))
+		return nil
+	}
+	return fmt.Errorf("%w: %s the outputs %s, which consumers of the wrapper may reference. Update them, then regenerate with -allow-breaking",
+		ErrBreakingChange, what, strings.Join(removed, ", "))
+}
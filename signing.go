@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// commitBlock sets the identity and signing of the commits and tags
+// tfwrapper writes itself (publish, generate -git-branch, upgrade -open-pr).
+// It is the commit block of tfwrapper.hcl; TFWRAPPER_COMMIT_* environment
+// variables take precedence.
+type commitBlock struct {
+	AuthorName  string `hcl:"author_name,optional"`
+	AuthorEmail string `hcl:"author_email,optional"`
+	// Sign is "gpg", "ssh" or "x509". Empty leaves signing to the user's
+	// git configuration.
+	Sign       string `hcl:"sign,optional"`
+	SigningKey string `hcl:"signing_key,optional"`
+}
+
+// signingFormats maps the sign setting to git's gpg.format.
+var signingFormats = map[string]string{
+	"gpg":  "openpgp",
+	"ssh":  "ssh",
+	"x509": "x509",
+}
+
+// withCommitConfig returns a copy of sb whose git commands commit and tag
+// with the identity and signing configured in cfg, which may be nil.
+func (sb sandbox) withCommitConfig(cfg *commitBlock) (sandbox, error) {
+	var c commitBlock
+	if cfg != nil {
+		c = *cfg
+	}
+	for env, field := range map[string]*string{
+		"TFWRAPPER_COMMIT_AUTHOR_NAME":  &c.AuthorName,
+		"TFWRAPPER_COMMIT_AUTHOR_EMAIL": &c.AuthorEmail,
+		"TFWRAPPER_COMMIT_SIGN":         &c.Sign,
+		"TFWRAPPER_COMMIT_SIGNING_KEY":  &c.SigningKey,
+	} {
+		if value := os.Getenv(env); value != "" {
+			*field = value
+		}
+	}
+
+	var config []string
+	if c.AuthorName != "" {
+		config = append(config, "user.name="+c.AuthorName)
+	}
+	if c.AuthorEmail != "" {
+		config = append(config, "user.email="+c.AuthorEmail)
+	}
+	if c.Sign != "" {
+		format, ok := signingFormats[c.Sign]
+		if !ok {
+			return sb, fmt.Errorf("invalid commit sign %q (expected gpg, ssh or x509)", c.Sign)
+		}
+		config = append(config, "commit.gpgSign=true", "tag.gpgSign=true", "gpg.format="+format)
+	}
+	if c.SigningKey != "" {
+		if c.Sign == "" {
+			return sb, fmt.Errorf("commit signing_key requires sign to be set")
+		}
+		config = append(config, "user.signingKey="+c.SigningKey)
+	}
+	sb.GitConfig = append(append([]string{}, sb.GitConfig...), config...)
+	return sb, nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// upstreamSnapshotDir is where -snapshot-upstream keeps copies of the upstream
+// module's contract files, relative to the wrapper directory.
+const upstreamSnapshotDir = ".tfwrapper/upstream"
+
+// snapshotUpstream copies the upstream variables.tf and outputs.tf into the
+// wrapper as read-only files, replacing any previous snapshot. Files that are
+// symlinks to somewhere outside downloadDir are refused. Files matched by
+// the wrapper's .tfwrapperignore patterns in ignore are left alone.
+func snapshotUpstream(out outputSink, downloadDir, modulePath, wrapperDir string, ignore []string) error {
+	destDir := filepath.Join(wrapperDir, upstreamSnapshotDir)
+	if err := out.MkdirAll(destDir); err != nil {
+		return err
+	}
+
+	for _, name := range []string{"variables.tf", "outputs.tf"} {
+		if ignored(ignore, upstreamSnapshotDir+"/"+name) {
+			continue
+		}
+		path := filepath.Join(modulePath, name)
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			continue
+		}
+		if err := ensureWithin(downloadDir, path); err != nil {
+			return err
+		}
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := out.WriteFile(filepath.Join(destDir, name), src, 0444); err != nil {
+			return err
+		}
+	}
+	return nil
+}
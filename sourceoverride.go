@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
+)
+
+// sourceOverride is the source, and optionally the version, a wrapper calls
+// instead of its upstream source, e.g. a patched fork during an incident:
+//
+//	source_override "terraform-aws-modules/vpc/aws" {
+//	  source  = "git::https://github.com/acme/terraform-aws-vpc.git"
+//	  version = "v5.1.0-hotfix"
+//	}
+//
+// Wrappers generated with -source-overrides use the entry of their -source.
+type sourceOverride struct {
+	Source  string `json:"source"`
+	Version string `json:"version,omitempty"`
+}
+
+// sourceOverridesFile is the decoded form of a -source-overrides file.
+type sourceOverridesFile struct {
+	Overrides []struct {
+		Upstream string `hcl:"upstream,label"`
+		Source   string `hcl:"source"`
+		Version  string `hcl:"version,optional"`
+	} `hcl:"source_override,block"`
+}
+
+// loadSourceOverride returns the override of source in the -source-overrides
+// file at path, or nil when there is none.
+func loadSourceOverride(path, source string) (*sourceOverride, error) {
+	if path == "" {
+		return nil, nil
+	}
+	parser := hclparse.NewParser()
+	file, diags := parser.ParseHCLFile(path)
+	if diags.HasErrors() {
+		return nil, newParseError(diags, parser.Files())
+	}
+	var decoded sourceOverridesFile
+	if diags := gohcl.DecodeBody(file.Body, nil, &decoded); diags.HasErrors() {
+		return nil, newParseError(diags, parser.Files())
+	}
+	var override *sourceOverride
+	seen := map[string]bool{}
+	for _, entry := range decoded.Overrides {
+		if seen[entry.Upstream] {
+			return nil, fmt.Errorf("%s: %q is overridden twice", path, entry.Upstream)
+		}
+		seen[entry.Upstream] = true
+		if entry.Source == "" {
+			return nil, fmt.Errorf("%s: the override of %q has an empty source", path, entry.Upstream)
+		}
+		if entry.Upstream == source {
+			override = &sourceOverride{Source: entry.Source, Version: entry.Version}
+		}
+	}
+	return override, nil
+}
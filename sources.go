@@ -0,0 +1,284 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxArchiveSize caps how much we download or extract for archive sources.
+const maxArchiveSize = 512 << 20
+
+// archiveExtensions are the archive formats accepted as module sources, as
+// with Terraform's "archive" query parameter.
+var archiveExtensions = []string{".tar.gz", ".tgz", ".tar", ".zip"}
+
+// splitSourceSubPath splits "source//sub/dir?query" into the source (with
+// its query) and the sub directory, ignoring the "//" of a URL scheme.
+func splitSourceSubPath(source string) (string, string) {
+	offset := 0
+	if i := strings.Index(source, "::"); i >= 0 {
+		offset = i + 2
+	}
+	if i := strings.Index(source[offset:], "://"); i >= 0 {
+		offset += i + 3
+	}
+	i := strings.Index(source[offset:], "//")
+	if i < 0 {
+		return source, ""
+	}
+	base, sub := source[:offset+i], source[offset+i+2:]
+	if sub, query, ok := strings.Cut(sub, "?"); ok {
+		return base + "?" + query, sub
+	}
+	return base, sub
+}
+
+// archiveFormat returns the archive extension of source, or "" if it isn't an
+// archive.
+func archiveFormat(source string) string {
+	source = strings.TrimPrefix(source, "file://")
+	path, query, _ := strings.Cut(source, "?")
+	if values, err := url.ParseQuery(query); err == nil && values.Get("archive") != "" {
+		return "." + values.Get("archive")
+	}
+	for _, ext := range archiveExtensions {
+		if strings.HasSuffix(path, ext) {
+			return ext
+		}
+	}
+	return ""
+}
+
+// fetchArchive downloads (for http(s) URLs) or opens a module archive and
+// extracts it into repoDir.
+func fetchArchive(sb sandbox, source, format, destDir, repoDir string) error {
+	location, _, _ := strings.Cut(strings.TrimPrefix(source, "file://"), "?")
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		archivePath := filepath.Join(destDir, "module"+format)
+		if err := downloadFile(sb, location, archivePath); err != nil {
+			return err
+		}
+		location = archivePath
+	}
+
+	switch format {
+	case ".zip":
+		return extractZip(location, repoDir)
+	case ".tar.gz", ".tgz", ".tar":
+		f, err := os.Open(location)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("%w: %s", ErrSourceNotFound, location)
+		} else if err != nil {
+			return err
+		}
+		defer f.Close()
+		var r io.Reader = f
+		if format != ".tar" {
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				return err
+			}
+			defer gz.Close()
+			r = gz
+		}
+		return extractTar(r, repoDir)
+	}
+	return fmt.Errorf("%w: archive format %q", ErrUnsupportedFeature, format)
+}
+
+func downloadFile(sb sandbox, url, dest string) error {
+	client := &http.Client{Timeout: sb.Timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", ErrSourceNotFound, url)
+	} else if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := io.Copy(f, io.LimitReader(resp.Body, maxArchiveSize+1))
+	if err != nil {
+		return err
+	}
+	if n > maxArchiveSize {
+		return fmt.Errorf("%s is larger than %d bytes", url, maxArchiveSize)
+	}
+	return nil
+}
+
+// archiveTarget returns where an archive entry should be extracted, refusing
+// entries that would land outside dir.
+func archiveTarget(dir, name string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return "", fmt.Errorf("archive entry %q is outside of the archive root", name)
+	}
+	return filepath.Join(dir, filepath.FromSlash(name)), nil
+}
+
+func writeExtracted(path string, r io.Reader, written *int64) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n, err := io.Copy(f, io.LimitReader(r, maxArchiveSize-*written+1))
+	*written += n
+	if err != nil {
+		return err
+	}
+	if *written > maxArchiveSize {
+		return fmt.Errorf("archive expands to more than %d bytes", maxArchiveSize)
+	}
+	return nil
+}
+
+// extractTar extracts regular files and directories. Links are skipped so an
+// archive can't point us at files outside of it.
+func extractTar(r io.Reader, dir string) error {
+	var written int64
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir {
+			continue
+		}
+		target, err := archiveTarget(dir, hdr.Name)
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeExtracted(target, tr, &written); err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(path, dir string) error {
+	zr, err := zip.OpenReader(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrSourceNotFound, path)
+	} else if err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	defer zr.Close()
+
+	var written int64
+	for _, f := range zr.File {
+		if !f.Mode().IsRegular() && !f.Mode().IsDir() {
+			continue
+		}
+		target, err := archiveTarget(dir, f.Name)
+		if err != nil {
+			return err
+		}
+		if f.Mode().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = writeExtracted(target, rc, &written)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bucketObject converts an s3:: or gcs:: source into the object URL used by
+// the aws/gcloud CLIs, along with the CLI arguments to copy it to dest.
+func bucketObject(source, dest string) (string, []string, error) {
+	scheme, rawURL, _ := strings.Cut(source, "::")
+	rawURL, _, _ = strings.Cut(rawURL, "?")
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "", nil, fmt.Errorf("invalid %s source %q", scheme, source)
+	}
+	path := strings.TrimPrefix(u.Path, "/")
+
+	switch scheme {
+	case "s3":
+		// Path style (s3.eu-west-1.amazonaws.com/bucket/key) or virtual
+		// hosted style (bucket.s3.eu-west-1.amazonaws.com/key)
+		var bucket, rest string
+		if strings.HasPrefix(u.Host, "s3.") || strings.HasPrefix(u.Host, "s3-") {
+			bucket, path, _ = strings.Cut(path, "/")
+			rest = strings.TrimPrefix(u.Host, "s3")
+		} else {
+			bucket, rest, _ = strings.Cut(u.Host, ".s3")
+		}
+		// What's left is "", ".amazonaws.com" or ".eu-west-1.amazonaws.com"
+		region := strings.TrimSuffix(strings.TrimSuffix(rest, "amazonaws.com"), ".")
+		region = strings.TrimLeft(region, ".-")
+		object := fmt.Sprintf("s3://%s/%s", bucket, path)
+		args := []string{"s3", "cp", "--only-show-errors", object, dest}
+		if region != "" {
+			args = append(args, "--region", region)
+		}
+		return object, args, nil
+
+	case "gcs":
+		// https://www.googleapis.com/storage/v1/bucket/path/to/module.zip
+		object := "gs://" + strings.TrimPrefix(path, "storage/v1/")
+		return object, []string{"storage", "cp", object, dest}, nil
+	}
+	return "", nil, fmt.Errorf("%w: %s sources", ErrUnsupportedFeature, scheme)
+}
+
+// fetchBucketArchive copies a module archive out of S3 or GCS with the aws or
+// gcloud CLI, so the ambient credential chain is used, then extracts it.
+func fetchBucketArchive(sb sandbox, source, destDir, repoDir string) error {
+	format := archiveFormat(source)
+	if format == "" {
+		return fmt.Errorf("%w: %s must point at a .zip, .tar.gz, .tgz or .tar archive", ErrUnsupportedFeature, source)
+	}
+	archivePath := filepath.Join(destDir, "module"+format)
+	object, args, err := bucketObject(source, archivePath)
+	if err != nil {
+		return err
+	}
+
+	cli, credentials := "aws", []string{"AWS_*"}
+	if strings.HasPrefix(source, "gcs::") {
+		cli, credentials = "gcloud", []string{"GOOGLE_*", "CLOUDSDK_*"}
+	}
+	sb.AllowedEnv = append(append([]string{}, sb.AllowedEnv...), credentials...)
+	if _, err := sb.run(destDir, cli, args...); err != nil {
+		return fmt.Errorf("failed to copy %s: %w", object, err)
+	}
+	return fetchArchive(sb, archivePath, format, destDir, repoDir)
+}
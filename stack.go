@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// Terraform Stacks configuration written next to the wrapper with
+// -format stack.
+const (
+	stackComponentFile   = "components.tfcomponent.hcl"
+	stackDeploymentsFile = "deployments.tfdeploy.hcl"
+)
+
+// validateFormat checks the -format option.
+func validateFormat(format string) error {
+	switch format {
+	case "", "module", "stack":
+		return nil
+	}
+	return fmt.Errorf("invalid -format %q: must be module or stack", format)
+}
+
+// wrapperInputs returns the variables of the wrapper: var.config, or the
+// variables locating the config with -config-from.
+func wrapperInputs(opts generateOptions) []string {
+	source, ok := remoteConfigSources[opts.ConfigFrom]
+	if !ok {
+		return []string{"config"}
+	}
+	names := make([]string, len(source.variables))
+	for i, v := range source.variables {
+		names[i] = v[0]
+	}
+	return names
+}
+
+// generateStackComponent returns a Terraform Stacks component calling the
+// wrapper in the same directory, with the wrapper's inputs as stack
+// variables and a provider configuration for every provider the module
+// requires. Its output is the wrapper's legacy output object, or an object of
+// the wrapper's outputs without one.
+func generateStackComponent(opts generateOptions, name string, providers map[string]string, outputs []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Terraform Stacks component for the %s wrapper\n\n", name)
+	for _, input := range wrapperInputs(opts) {
+		fmt.Fprintf(&b, "variable %q {\n  type = string\n", input)
+		if input == "config" {
+			b.WriteString("  default = \"{}\"\n")
+		}
+		b.WriteString("}\n\n")
+	}
+
+	sources := sortedKeys(providers)
+	locals := providerLocalNames(sources)
+	if len(sources) > 0 {
+		b.WriteString("required_providers {\n")
+		for _, source := range sources {
+			fmt.Fprintf(&b, "  %s = {\n    source = %s\n", hclKey(locals[source]), hclString(source))
+			if providers[source] != "" {
+				fmt.Fprintf(&b, "    version = %s\n", hclString(providers[source]))
+			}
+			b.WriteString("  }\n")
+		}
+		b.WriteString("}\n\n")
+		for _, source := range sources {
+			fmt.Fprintf(&b, "# Configure the provider here, e.g. with a region variable or an identity token\nprovider %q \"this\" {\n  config {}\n}\n\n", locals[source])
+		}
+	}
+
+	fmt.Fprintf(&b, "component %q {\n  source = \"./\"\n\n  inputs = {\n", name)
+	for _, input := range wrapperInputs(opts) {
+		fmt.Fprintf(&b, "    %s = var.%s\n", input, input)
+	}
+	b.WriteString("  }\n")
+	if len(sources) > 0 {
+		b.WriteString("\n  providers = {\n")
+		for _, source := range sources {
+			fmt.Fprintf(&b, "    %s = provider.%s.this\n", locals[source], locals[source])
+		}
+		b.WriteString("  }\n")
+	}
+	b.WriteString("}\n\n")
+
+	if legacyOutput(opts) {
+		fmt.Fprintf(&b, "output %q {\n  type  = any\n  value = component.%s.%s\n}\n", name, name, legacyOutputName)
+	} else {
+		fmt.Fprintf(&b, "output %q {\n  type = any\n  value = {\n", name)
+		for _, output := range outputs {
+			fmt.Fprintf(&b, "    %s = component.%s.%s\n", hclKey(output), name, output)
+		}
+		b.WriteString("  }\n}\n")
+	}
+	return string(hclwrite.Format([]byte(b.String())))
+}
+
+// generateStackDeployments returns a deployments stub with one deployment
+// per -environments entry, or a single "default" one.
+func generateStackDeployments(opts generateOptions, name string) string {
+	envs := opts.Environments
+	if len(envs) == 0 {
+		envs = []string{"default"}
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Deployments of the %s stack component, one per environment. Fill in\n", name)
+	b.WriteString("# each deployment's inputs; the config is JSON encoded like var.config.\n")
+	for _, env := range envs {
+		fmt.Fprintf(&b, "\ndeployment %q {\n  inputs = {\n", env)
+		for _, input := range wrapperInputs(opts) {
+			if input == "config" {
+				b.WriteString("    config = jsonencode({})\n")
+			} else {
+				fmt.Fprintf(&b, "    %s = \"\"\n", input)
+			}
+		}
+		b.WriteString("  }\n}\n")
+	}
+	return string(hclwrite.Format([]byte(b.String())))
+}
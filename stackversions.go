@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// stackVersionsMarker starts every versions.tf written by stack-versions, so
+// it is only ever overwritten by us.
+const stackVersionsMarker = "# Generated by tfwrapper stack-versions"
+
+func runStackVersions(args []string) {
+	fs := flag.NewFlagSet("stack-versions", flag.ExitOnError)
+	dir := fs.String("dir", ".", "Root module (stack) directory calling the wrappers")
+	output := fs.String("o", "versions.tf", "File to write, relative to -dir, or - for stdout")
+	fs.Parse(args)
+
+	required, err := stackProviders(*dir)
+	if err != nil {
+		fatalError("Failed to read stack", err)
+	}
+	if conflicts := providerConflicts(required); len(conflicts) > 0 {
+		for _, conflict := range conflicts {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", conflict)
+		}
+		os.Exit(1)
+	}
+	content := generateStackVersionsTf(required)
+
+	if *output == "-" {
+		fmt.Print(content)
+		return
+	}
+	target := filepath.Join(*dir, *output)
+	if existing, err := os.ReadFile(target); err == nil && !strings.HasPrefix(string(existing), stackVersionsMarker) {
+		log.Fatalf("Error: %s exists and wasn't generated by tfwrapper stack-versions; move its terraform block elsewhere or choose another file with -o", target)
+	}
+	if err := checkWrite(target); err != nil {
+		log.Fatalf("Failed to write %s: %v", target, err)
+	}
+	if err := os.WriteFile(target, []byte(content), 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", target, err)
+	}
+	fmt.Printf("Provider constraints of %d wrapper(s) written to %s\n", len(required), target)
+}
+
+// stackProviders returns the provider constraints of every wrapper called by
+// the root module in dir, keyed by module call. Calls to modules that aren't
+// wrappers are warned about, since their constraints aren't recorded.
+func stackProviders(dir string) (map[string]map[string]string, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	required := map[string]map[string]string{}
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		parsed, diags := hclsyntax.ParseConfig(src, file, hcl.InitialPos)
+		if diags.HasErrors() {
+			return nil, newParseError(diags, map[string]*hcl.File{file: parsed})
+		}
+		for _, block := range parsed.Body.(*hclsyntax.Body).Blocks {
+			if block.Type != "module" || len(block.Labels) != 1 {
+				continue
+			}
+			name := "module." + block.Labels[0]
+			source := syntaxAttrString(block.Body, "source")
+			var prov *provenance
+			if isLocalSource(source) {
+				if prov, err = readMetadata(filepath.Join(dir, filepath.FromSlash(source))); err != nil {
+					return nil, err
+				}
+			}
+			if prov == nil {
+				fmt.Fprintf(os.Stderr, "Warning: %s: %s (%s) is not a tfwrapper wrapper, its provider constraints are not included\n", file, name, source)
+				continue
+			}
+			required[name] = prov.Providers
+		}
+	}
+	return required, nil
+}
+
+// generateStackVersionsTf renders the required_providers of a stack, each
+// constrained to the versions every wrapper accepts.
+func generateStackVersionsTf(required map[string]map[string]string) string {
+	ranges := map[string]versionRange{}
+	for _, providers := range required {
+		for source, constraint := range providers {
+			r, err := parseVersionConstraint(constraint)
+			if err != nil {
+				// Already warned about by providerConflicts
+				r = versionRange{}
+			}
+			ranges[source] = ranges[source].intersect(r)
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(stackVersionsMarker + " from the provider constraints\n")
+	b.WriteString("# of the wrappers this stack calls. Regenerate it rather than editing it.\n")
+	b.WriteString("terraform {\n  required_providers {\n")
+	locals := providerLocalNames(sortedKeys(ranges))
+	for _, source := range sortedKeys(ranges) {
+		fmt.Fprintf(&b, "    %s = {\n      source = %s\n", hclKey(locals[source]), hclString(source))
+		if constraint := ranges[source].String(); constraint != "" {
+			fmt.Fprintf(&b, "      version = %s\n", hclString(constraint))
+		}
+		b.WriteString("    }\n")
+	}
+	b.WriteString("  }\n}\n")
+
+	return string(hclwrite.Format([]byte(b.String())))
+}
+
+// providerLocalNames gives each of the sorted provider sources a local name:
+// the provider type, qualified by its namespace when two providers share a
+// type.
+func providerLocalNames(sources []string) map[string]string {
+	locals := map[string]string{}
+	used := map[string]bool{}
+	for _, source := range sources {
+		local := path.Base(source)
+		if used[local] {
+			local = strings.ReplaceAll(path.Dir(source), "/", "_") + "_" + local
+		}
+		used[local] = true
+		locals[source] = local
+	}
+	return locals
+}
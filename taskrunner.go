@@ -0,0 +1,70 @@
+package main
+
+import "fmt"
+
+const wrapperMakefile = `# Generated by tfwrapper
+TF     ?= %[1]s
+CONFIG ?= %[2]s
+
+.PHONY: init plan validate test docs
+
+init:
+	$(TF) init -input=false
+
+plan: init
+	$(TF) plan -input=false -var "config=$$(cat $(CONFIG))"
+
+validate: init
+	$(TF) validate
+
+test: init
+	$(TF) test
+
+docs:
+	terraform-docs markdown table --output-file README.md .
+`
+
+const wrapperTaskfile = `# Generated by tfwrapper
+version: "3"
+
+vars:
+  TF: '{{.TF | default "%[1]s"}}'
+  CONFIG: '{{.CONFIG | default "%[2]s"}}'
+
+tasks:
+  init:
+    cmds:
+      - "{{.TF}} init -input=false"
+
+  plan:
+    deps: [init]
+    cmds:
+      - '{{.TF}} plan -input=false -var "config=$(cat {{.CONFIG}})"'
+
+  validate:
+    deps: [init]
+    cmds:
+      - "{{.TF}} validate"
+
+  test:
+    deps: [init]
+    cmds:
+      - "{{.TF}} test"
+
+  docs:
+    cmds:
+      - terraform-docs markdown table --output-file README.md .
+`
+
+// generateTaskRunner renders the Makefile or Taskfile for a wrapper, returning
+// the file name to write and its content.
+func generateTaskRunner(runner, binary, exampleConfig string) (string, string, error) {
+	switch runner {
+	case "make":
+		return "Makefile", fmt.Sprintf(wrapperMakefile, binary, exampleConfig), nil
+	case "task":
+		return "Taskfile.yml", fmt.Sprintf(wrapperTaskfile, binary, exampleConfig), nil
+	default:
+		return "", "", fmt.Errorf("unknown task runner %q (expected make or task)", runner)
+	}
+}
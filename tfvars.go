@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func runTfvars(args []string) {
+	fs := flag.NewFlagSet("tfvars", flag.ExitOnError)
+	wrapperDir := fs.String("wrapper", ".", "Wrapper directory the config is for")
+	config := fs.String("config", "", "Config file, or comma separated files merged in order, e.g. configs/common.json,configs/prod.json (required)")
+	binary := fs.String("binary", "terraform", "Binary used to decode YAML configs (terraform or tofu)")
+	output := fs.String("o", "", "Write the tfvars to this file instead of stdout")
+	fs.Parse(args)
+
+	if *config == "" {
+		log.Fatal("Error: -config is required")
+	}
+
+	sb, err := sandboxFor(*wrapperDir)
+	if err != nil {
+		fatalError("Failed to load workspace", err)
+	}
+	configs := splitList(*config)
+	for _, path := range configs {
+		if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+			sb = sb.withPinnedVersion(*wrapperDir, *binary)
+			break
+		}
+	}
+	merged := map[string]any{}
+	for _, path := range configs {
+		values, err := decodeConfigFile(sb, path, *binary)
+		if err != nil {
+			fatalError("Failed to read config", err)
+		}
+		for k, v := range values {
+			merged[k] = v
+		}
+	}
+
+	// Problems are reported, but the tfvars are still written so the
+	// wrapper can be planned with exactly these inputs
+	prov, err := readMetadata(*wrapperDir)
+	if err != nil {
+		fatalError("Failed to read wrapper metadata", err)
+	}
+	if prov == nil {
+		fmt.Fprintf(os.Stderr, "Warning: %s not found in %s, the config was not checked\n", metadataFileName, *wrapperDir)
+	} else {
+		if prov.Options.ConfigFrom != "" {
+			fmt.Fprintf(os.Stderr, "Warning: %s reads its config from %s rather than var.config, upload the config there instead\n", *wrapperDir, prov.Options.ConfigFrom)
+		}
+		problems, migrations := checkConfigContract(prov, merged)
+		for _, problem := range problems {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", problem)
+		}
+		for _, migration := range migrations {
+			fmt.Fprintf(os.Stderr, "Warning: migrate: %s\n", migration)
+		}
+	}
+
+	tfvars, err := configTfvars(merged)
+	if err != nil {
+		log.Fatalf("Failed to encode tfvars: %v", err)
+	}
+	if *output == "" {
+		fmt.Print(tfvars)
+		return
+	}
+	if err := checkWrite(*output); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+	if err := os.WriteFile(*output, []byte(tfvars), 0644); err != nil {
+		log.Fatalf("Failed to write %s: %v", *output, err)
+	}
+}
+
+// decodeConfigFile reads a JSON or YAML config object. YAML is decoded by
+// terraform's (or tofu's) own yamldecode, so the result is exactly what a
+// wrapper called with yamldecode(file(...)) receives.
+func decodeConfigFile(sb sandbox, path, binary string) (map[string]any, error) {
+	var data []byte
+	var err error
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		data, err = yamlToJSON(sb, path, binary)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Numbers are kept as written rather than rounded through float64
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var values map[string]any
+	if err := dec.Decode(&values); err != nil {
+		return nil, fmt.Errorf("failed to parse %s as an object: %w", path, err)
+	}
+	return values, nil
+}
+
+func yamlToJSON(sb sandbox, path, binary string) ([]byte, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp("", "tfwrapper-tfvars-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	expr := fmt.Sprintf("jsonencode(yamldecode(file(%s)))\n", hclString(filepath.ToSlash(path)))
+	out, err := sb.runInput(dir, []byte(expr), binary, "console")
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+
+	// The console prints the JSON as a quoted HCL string
+	parsed, diags := hclsyntax.ParseExpression(bytes.TrimSpace(out), "console", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, fmt.Errorf("unexpected %s console output: %s", binary, strings.TrimSpace(string(out)))
+	}
+	val, diags := parsed.Value(nil)
+	if diags.HasErrors() || val.Type() != cty.String || val.IsNull() {
+		return nil, fmt.Errorf("unexpected %s console output: %s", binary, strings.TrimSpace(string(out)))
+	}
+	return []byte(val.AsString()), nil
+}
+
+// configTfvars renders config as a .tfvars.json setting the wrapper's config
+// variable to its JSON encoding.
+func configTfvars(config map[string]any) (string, error) {
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(map[string]string{"config": string(encoded)}, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
@@ -3,12 +3,17 @@ package main
 import (
 	"flag"
 	"fmt"
+	"io/fs"
 	"log"
+	"net/url"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
-	"sort"
+	"runtime/pprof"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/hashicorp/hcl/v2"
 	"github.com/hashicorp/hcl/v2/hclparse"
@@ -16,109 +21,1236 @@ import (
 	"github.com/zclconf/go-cty/cty"
 )
 
+// commands maps subcommand names to their entry points. Anything that isn't a
+// known subcommand falls through to wrapper generation.
+var commands = map[string]func(args []string){
+	"batch":           runBatch,
+	"adopt":           runAdopt,
+	"adopt-wrapper":   runAdoptWrapper,
+	"convert-call":    runConvertCall,
+	"check-outputs":   runCheckOutputs,
+	"crossplane":      runCrossplane,
+	"discover":        runDiscover,
+	"fork":            runFork,
+	"graph":           runGraph,
+	"hook":            runHook,
+	"index":           runIndex,
+	"inspect":         runInspect,
+	"migrate-config":  runMigrateConfig,
+	"publish":         runPublish,
+	"regenerate":      runRegenerate,
+	"scaffold-repo":   runScaffoldRepo,
+	"schema":          runSchema,
+	"selftest":        runSelftest,
+	"stack-versions":  runStackVersions,
+	"tfvars":          runTfvars,
+	"upgrade":         runUpgrade,
+	"validate-config": runValidateConfig,
+	"versions":        runVersions,
+}
+
 func main() {
-	source := flag.String("source", "", "Terraform module source (required)")
-	version := flag.String("version", "", "Module version (optional)")
-	name := flag.String("name", "", "Wrapper module name (optional)")
-	iterable := flag.Bool("iterable", false, "Set to true to create a module that iterates over a map of resources")
-	flag.Parse()
+	args := parseGlobalFlags(os.Args[1:])
+	if len(args) > 0 {
+		if cmd, ok := commands[args[0]]; ok {
+			cmd(args[1:])
+			return
+		}
+	}
+	runGenerate(args)
+}
+
+// generateOptions holds everything needed to generate a single wrapper.
+type generateOptions struct {
+	Source   string `json:"source"`
+	Version  string `json:"version,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Iterable bool   `json:"iterable,omitempty"`
+	// ForceIterable generates an iterable wrapper even though the module
+	// configures providers, which terraform won't accept with for_each.
+	ForceIterable bool `json:"force_iterable,omitempty"`
+	// Conditional creates the module with count, only when the config's
+	// "create" key is true (the default).
+	Conditional   bool   `json:"conditional,omitempty"`
+	TaskRunner    string `json:"task_runner,omitempty"`
+	Binary        string `json:"binary,omitempty"`
+	ExampleConfig string `json:"example_config,omitempty"`
+	// FromExample writes ExampleConfig from the module call in this upstream
+	// example, or "auto" to pick one.
+	FromExample string `json:"from_example,omitempty"`
+
+	// SnapshotUpstream keeps read-only copies of the upstream variables.tf
+	// and outputs.tf under .tfwrapper/upstream/ in the wrapper.
+	SnapshotUpstream bool `json:"snapshot_upstream,omitempty"`
+
+	// Only and Skip restrict which files are (re)generated, by base name
+	// without extension (e.g. "main", "outputs", "makefile").
+	Only []string `json:"only,omitempty"`
+	Skip []string `json:"skip,omitempty"`
+
+	// Exclude leaves upstream variables out of the module call. With
+	// AllowRawPassthrough they can still be set from the "raw" config
+	// section.
+	Exclude             []string `json:"exclude,omitempty"`
+	AllowRawPassthrough bool     `json:"allow_raw_passthrough,omitempty"`
+
+	// Renames are "old=new" variable renames between upstream versions.
+	// Configs may keep setting old, which main.tf reads when new isn't set.
+	Renames []string `json:"renames,omitempty"`
+	// Compat writes compat.tf, translating configs written for the
+	// previous upstream version to changed variable types
+	Compat bool `json:"compat,omitempty"`
+
+	// GroupBy groups the lookup lines in main.tf by upstream "file" or
+	// banner comment "section". Empty keeps the upstream order.
+	GroupBy string `json:"group_by,omitempty"`
+
+	// Sort orders the arguments in main.tf: "upstream" (the default),
+	// "alpha" or "required-first".
+	Sort string `json:"sort,omitempty"`
+
+	// SplitInputs moves the lookups into inputs_*.tf files of at most this
+	// many arguments, grouped like main.tf, when the module has more.
+	SplitInputs int `json:"split_inputs,omitempty"`
+
+	// Annotate adds the upstream type and where the default came from as
+	// a comment on every lookup line in main.tf.
+	Annotate bool `json:"annotate,omitempty"`
+
+	// Comments selects what is written above each lookup: "all" (the
+	// default) copies upstream comments without commented-out HCL,
+	// "description-only" uses the variable descriptions and "off" nothing.
+	Comments string `json:"comments,omitempty"`
+
+	// Accessor is how main.tf reads config keys: "auto" (the default) picks
+	// lookup(), try() or coalesce() per variable from its upstream type,
+	// anything else uses that accessor throughout.
+	Accessor string `json:"accessor,omitempty"`
+
+	// Environments writes config skeletons for these environments under
+	// configs/, in ConfigFormat ("json" or "yaml").
+	Environments []string `json:"environments,omitempty"`
+	ConfigFormat string   `json:"config_format,omitempty"`
+
+	// Policy writes a policy stub for validate-config: "opa" (policy.rego).
+	Policy string `json:"policy,omitempty"`
+
+	// Format "stack" also writes a Terraform Stacks component calling the
+	// wrapper, and a deployments stub.
+	Format string `json:"format,omitempty"`
+
+	// ConfigFrom reads the config from a data source instead of
+	// var.config: "ssm", "s3", "consul" or "http".
+	ConfigFrom string `json:"config_from,omitempty"`
+
+	// Orchestrator writes stack definitions running the wrapper with each
+	// environment config: "spacelift" or "env0".
+	Orchestrator string `json:"orchestrator,omitempty"`
+
+	// Pipeline writes a CI pipeline for the wrapper, "github" or "gitlab",
+	// from PipelineTemplate or the built-in template. "@path" reads the
+	// template from a file.
+	Pipeline         string `json:"pipeline,omitempty"`
+	PipelineTemplate string `json:"pipeline_template,omitempty"`
+	DriftSchedule    string `json:"drift_schedule,omitempty"`
+	// Backend is the type of the partial backend block written to
+	// backend.tf, which the pipeline configures per environment. Without
+	// it the built-in pipelines only plan.
+	Backend string `json:"backend,omitempty"`
+
+	// Backstage writes catalog-info.yaml and a scaffolder template owned by
+	// this entity reference.
+	Backstage string `json:"backstage,omitempty"`
+
+	// DefaultTags merges the config's provider_default_tags underneath the
+	// upstream tags variable.
+	DefaultTags bool `json:"default_tags,omitempty"`
+
+	// MergeLayers are config keys merged, in order, underneath the
+	// instance config, e.g. ["global", "environment"].
+	MergeLayers []string `json:"merge_layers,omitempty"`
+
+	// Features are the -features flags, "name=value" each; the workspace's
+	// are added on generation, see featureFlag.
+	Features []string `json:"features,omitempty"`
+
+	// RegionCheck are the config keys holding a provider region, checked
+	// at plan time against the region of the inherited provider.
+	RegionCheck []string `json:"region_check,omitempty"`
+
+	// InstancesDir is the directory, relative to the wrapper, of the
+	// per-instance config files of an iterable wrapper.
+	InstancesDir string `json:"instances_dir,omitempty"`
+
+	// InstanceProviders names the sets of provider configurations the
+	// instances of an iterable wrapper are grouped by, each set creating
+	// its instances in a module block of its own.
+	InstanceProviders []string `json:"instance_providers,omitempty"`
+
+	// FanOut are the AWS regions, or account/region pairs, a fan-out
+	// wrapper creates the module in, each with a provider configuration
+	// of its own. FanOutRole is the role assumed in the accounts.
+	FanOut     []string `json:"fan_out,omitempty"`
+	FanOutRole string   `json:"fan_out_role,omitempty"`
+
+	// ProviderMirror is the directory or https URL of a provider mirror,
+	// written as terraform.rc for air-gapped use of the wrapper.
+	ProviderMirror string `json:"provider_mirror,omitempty"`
+
+	// SourceOverrides is the file of sources the wrapper calls instead of
+	// its upstream source, see sourceOverride.
+	SourceOverrides string `json:"source_overrides,omitempty"`
+
+	// Patches are patch files applied to the upstream module, which is
+	// then vendored into the wrapper and called from there.
+	Patches []string `json:"patches,omitempty"`
+
+	// Envelope is the API group of the Kubernetes style envelope config
+	// documents may come in, see configEnvelope.
+	Envelope string `json:"envelope,omitempty"`
+
+	// Derived is the file of expressions deriving upstream variables from
+	// other config keys, see derivedKeys.
+	Derived string `json:"derived,omitempty"`
+
+	// Header and Footer are text/template strings added as comments to
+	// every generated file. "@path" reads the template from a file.
+	Header string `json:"header,omitempty"`
+	Footer string `json:"footer,omitempty"`
+
+	// NoLegacyOutput drops the deprecated "output" object of the whole
+	// module, leaving only the per-output blocks of outputs.tf.
+	NoLegacyOutput bool `json:"no_legacy_output,omitempty"`
+
+	// OutputsContract is the file declaring the outputs each consumer
+	// reads, see outputsContract. Only those are exported.
+	OutputsContract string `json:"outputs_contract,omitempty"`
+
+	// Timestamp records the generation time in the provenance comment and
+	// metadata. Off by default so regenerating is reproducible.
+	Timestamp bool `json:"timestamp,omitempty"`
+
+	// Scan is a command run against the downloaded module before
+	// generation, e.g. "trivy config --exit-code 1 {dir}".
+	Scan           string `json:"scan,omitempty"`
+	FailOnFindings bool   `json:"fail_on_findings,omitempty"`
+
+	// OutputDir is the directory the wrapper directory is created in.
+	OutputDir string `json:"output_dir,omitempty"`
+
+	// ModuleDir, when set, is a local copy of the module to use instead of
+	// downloading Source (e.g. selftest fixtures).
+	ModuleDir string `json:"-"`
+
+	// cache, when set, shares downloads and parsed variables between the
+	// wrappers generated by one batch run.
+	cache *moduleCache
+
+	// derived holds the expressions of the Derived file while generating.
+	derived *derivedKeys
+
+	// forkSource is the source of the patched module main.tf calls, with
+	// Patches.
+	forkSource string
+
+	// operation names the command generating, as recorded in the audit
+	// log. It defaults to "generate".
+	operation string
+
+	// IgnoreWorkspace skips workspace defaults and lock file registration,
+	// so output only depends on the options given.
+	IgnoreWorkspace bool `json:"-"`
+
+	// AllowBreaking regenerates even though upstream removed outputs the
+	// previous generation exported. It only applies to one run, so it
+	// isn't recorded.
+	AllowBreaking bool `json:"-"`
+}
+
+// generatedFile is a single file of a generated wrapper.
+type generatedFile struct {
+	Name    string
+	Content string
+}
+
+// fileKey is the name used to refer to a generated file in -only and -skip.
+func (f generatedFile) fileKey() string {
+	// Files in subdirectories are selected together, e.g. "configs"
+	if dir, _, ok := strings.Cut(f.Name, "/"); ok {
+		return dir
+	}
+	name := strings.TrimSuffix(f.Name, filepath.Ext(f.Name))
+	// Stacks files are named by their kind, e.g. components.tfcomponent.hcl
+	if ext := filepath.Ext(name); ext == ".tfcomponent" || ext == ".tfdeploy" {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return strings.ToLower(name)
+}
+
+// selectFiles filters files down to those named in only (if set) and not
+// named in skip. Unknown names are an error so typos don't go unnoticed.
+func selectFiles(files []generatedFile, only, skip []string) ([]generatedFile, error) {
+	known := make(map[string]bool)
+	for _, f := range files {
+		known[f.fileKey()] = true
+	}
+	want := make(map[string]bool)
+	for _, key := range only {
+		if !known[key] {
+			return nil, fmt.Errorf("unknown file %q in -only", key)
+		}
+		want[key] = true
+	}
+	unwanted := make(map[string]bool)
+	for _, key := range skip {
+		if !known[key] {
+			return nil, fmt.Errorf("unknown file %q in -skip", key)
+		}
+		unwanted[key] = true
+	}
+
+	var selected []generatedFile
+	for _, f := range files {
+		if len(want) > 0 && !want[f.fileKey()] || unwanted[f.fileKey()] {
+			continue
+		}
+		selected = append(selected, f)
+	}
+	return selected, nil
+}
+
+// iterableFlag is a boolean flag that also accepts "force", as in
+// -iterable=force.
+type iterableFlag struct {
+	Iterable bool
+	Force    bool
+}
+
+func (f *iterableFlag) String() string {
+	if f == nil || !f.Iterable {
+		return "false"
+	} else if f.Force {
+		return "force"
+	}
+	return "true"
+}
+
+func (f *iterableFlag) Set(value string) error {
+	if value == "force" {
+		f.Iterable, f.Force = true, true
+		return nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("must be true, false or force")
+	}
+	f.Iterable, f.Force = b, false
+	return nil
+}
+
+func (f *iterableFlag) IsBoolFlag() bool { return true }
+
+// splitList splits a comma separated flag value, ignoring empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func runGenerate(args []string) {
+	fs := flag.NewFlagSet("tfwrapper", flag.ExitOnError)
+	source := fs.String("source", "", "Terraform module source (required)")
+	version := fs.String("version", "", "Module version (optional)")
+	name := fs.String("name", "", "Wrapper module name (optional)")
+	var iterable iterableFlag
+	fs.Var(&iterable, "iterable", "Set to true to create a module that iterates over a map of resources, or force to do so even if the module configures providers")
+	conditional := fs.Bool("conditional", false, "Create the module with count, only when the config's \""+conditionalKey+"\" key is true (the default)")
+	taskRunner := fs.String("task-runner", "", "Also generate a Makefile (make) or Taskfile.yml (task) with init/plan/validate/test/docs targets (optional)")
+	binary := fs.String("binary", "terraform", "Terraform binary used by the generated task runner targets and the -provider-mirror instructions (terraform or tofu)")
+	exampleConfig := fs.String("example-config", "config.example.json", "Config file used by the generated plan target")
+	fromExample := fs.String("from-example", "", "Write the example config from the module call in this upstream examples/ directory, or auto (optional)")
+	snapshotUpstream := fs.Bool("snapshot-upstream", false, "Keep read-only copies of the upstream variables.tf and outputs.tf under .tfwrapper/upstream/")
+	only := fs.String("only", "", "Comma separated list of files to generate, e.g. main,outputs (optional)")
+	skip := fs.String("skip", "", "Comma separated list of files not to generate, e.g. locals (optional)")
+	exclude := fs.String("exclude", "", "Comma separated upstream variables to leave out of the wrapper (optional)")
+	rename := fs.String("rename", "", "Comma separated old=new variables upstream renamed, so configs setting the old name keep working (optional)")
+	compat := fs.Bool("compat", false, "On regeneration, write compat.tf translating configs written for the previous upstream version to changed variable types, for one release cycle")
+	allowRawPassthrough := fs.Bool("allow-raw-passthrough", false, "Let excluded variables be set from the \"raw\" config section")
+	groupBy := fs.String("group-by", "", "Group the arguments in main.tf by upstream file or banner comment section (file or section, optional)")
+	sortOrder := fs.String("sort", "upstream", "Order of the arguments in main.tf: upstream, alpha or required-first")
+	splitInputsFlag := fs.Int("split-inputs", 0, "Move the lookups into inputs_*.tf files of at most this many arguments when the module has more (optional)")
+	cpuProfile := fs.String("cpuprofile", "", "Write a CPU profile of the run to this file")
+	annotate := fs.Bool("annotate", false, "Comment every lookup in main.tf with the upstream type and where its default came from")
+	comments := fs.String("comments", "all", "Comments written above each lookup: all (upstream comments), description-only or off")
+	accessor := fs.String("accessor", "auto", "How main.tf reads config keys: auto (by upstream type), lookup, try or coalesce")
+	environments := fs.String("environments", "", "Comma separated environments to generate config skeletons for under configs/, e.g. dev,staging,prod (optional)")
+	configFormat := fs.String("config-format", "json", "Format of the -environments config skeletons: json or yaml")
+	policy := fs.String("policy", "", "Also generate a policy stub evaluated by validate-config (opa, optional)")
+	format := fs.String("format", "module", "What to generate: module, or stack to also write a Terraform Stacks component and deployments stub")
+	configFrom := fs.String("config-from", "", "Read the config from a data source instead of var.config: ssm, s3, consul or http (optional)")
+	orchestrator := fs.String("orchestrator", "", "Also write stack definitions running the wrapper with each -environments config: spacelift or env0 (optional)")
+	pipeline := fs.String("pipeline", "", "Also write a CI pipeline planning on pull requests and, with -backend, applying on merge and checking drift: github or gitlab (optional)")
+	pipelineTemplate := fs.String("pipeline-template", "", "Template of the -pipeline file, or @file (default: built-in template)")
+	driftSchedule := fs.String("drift-schedule", "", "Cron schedule of the -pipeline drift check (default \""+defaultDriftSchedule+"\")")
+	backend := fs.String("backend", "", "Also write backend.tf with a partial backend block of this type, e.g. s3, configured per environment from backends/<environment>.hcl (optional)")
+	backstage := fs.String("backstage", "", "Also write catalog-info.yaml and a Backstage scaffolder template, owned by this entity reference, e.g. group:default/platform (optional)")
+	defaultTags := fs.Bool("default-tags", false, "Accept a provider_default_tags config key merged underneath the upstream tags variable")
+	mergeLayers := fs.String("merge-layers", "", "Comma separated config keys merged underneath the instance config, lowest first, e.g. global,environment (optional)")
+	features := fs.String("features", "", "Comma separated feature flags, name=value, overriding the generate block of tfwrapper.hcl, e.g. enable_deletion_protection_default=true (optional)")
+	regionCheck := fs.String("region-check", "", "Comma separated config keys holding a region, e.g. region, checked at plan time against the region of the provider the wrapper inherits (optional)")
+	instancesDir := fs.String("instances-dir", "", "With -iterable, also read instances from the per-instance YAML or JSON files of this directory of the wrapper, each named after its instance (optional)")
+	instanceProviders := fs.String("instance-providers", "", "With -iterable, comma separated names of provider configuration sets, e.g. primary,secondary; each instance picks one with its \"provider\" key and the caller passes aws.<set> and the like (optional)")
+	fanOut := fs.String("fan-out", "", "Comma separated AWS regions, or account/region pairs, to create the module in, each with its own aws provider configuration, e.g. eu-west-1,111111111111/us-east-1 (optional)")
+	fanOutRole := fs.String("fan-out-role", "", "Role assumed in the accounts of -fan-out targets (default \""+defaultFanOutRole+"\")")
+	providerMirror := fs.String("provider-mirror", "", "Directory or https URL of a provider mirror; writes a terraform.rc CLI configuration installing the module's providers from it, for air-gapped use (optional)")
+	patch := fs.String("patch", "", "Comma separated patch files to apply to the module before vendoring it into the wrapper, relative to the current directory (or the workspace root); they are copied into .tfwrapper/patches/ (optional)")
+	sourceOverrides := fs.String("source-overrides", "", "HCL file of source_override blocks mapping upstream sources to the source and version to call instead, e.g. a patched fork (optional)")
+	envelope := fs.String("envelope", "", "Also accept config documents in a Kubernetes style apiVersion/kind/metadata/spec envelope of this API group, e.g. wrappers.example.com (optional)")
+	derived := fs.String("derived", "", "File of HCL attributes deriving upstream variables from other config keys, e.g. bucket_name = \"${org}-${env}-${name}\", used unless the config sets them (optional)")
+	header := fs.String("header", "", "Header template added to every generated file, or @file (optional)")
+	footer := fs.String("footer", "", "Footer template added to every generated file, or @file (optional)")
+	legacyOutput := fs.Bool("legacy-output", true, "Also write the deprecated \"output\" object of the whole module, which consumers of earlier wrappers read; -legacy-output=false drops it")
+	outputsContract := fs.String("outputs-contract", "", "HCL file of consumer blocks declaring the outputs each consumer reads; only those are exported (optional)")
+	timestamp := fs.Bool("timestamp", false, "Record the generation time in main.tf and .tfwrapper.json")
+	scan := fs.String("scan", "", "Command to scan the downloaded module with before generating, {dir} is replaced with its path (optional)")
+	failOnFindings := fs.Bool("fail-on-findings", false, "Abort generation if the -scan command exits non-zero")
+	allowBreaking := fs.Bool("allow-breaking", false, "Regenerate even though upstream removed outputs the existing wrapper exports")
+	stdout := fs.Bool("stdout", false, "Print the generated files instead of writing them to disk")
+	archive := fs.String("archive", "", "Write the generated files to this .tar.gz, .tgz, .tar or .zip archive instead of a directory")
+	infracost := fs.Bool("infracost", false, "Estimate the cost of the wrapper with infracost, using the -example-config, after writing it to disk")
+	gitBranch := fs.String("git-branch", "", "Commit the generated files to this new branch of the current git repository instead of the working tree")
+	fs.Parse(args)
 
 	if *source == "" {
 		log.Fatal("Error: -source is required")
 	}
 
+	if *cpuProfile != "" {
+		if err := checkWrite(*cpuProfile); err != nil {
+			log.Fatalf("Failed to create CPU profile: %v", err)
+		}
+		f, err := os.Create(*cpuProfile)
+		if err != nil {
+			log.Fatalf("Failed to create CPU profile: %v", err)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			log.Fatalf("Failed to start CPU profile: %v", err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	opts := generateOptions{
+		Source:        *source,
+		Version:       *version,
+		Name:          *name,
+		Iterable:      iterable.Iterable,
+		ForceIterable: iterable.Force,
+		Conditional:   *conditional,
+		TaskRunner:    *taskRunner,
+		Binary:        *binary,
+		ExampleConfig: *exampleConfig,
+		FromExample:   *fromExample,
+
+		SnapshotUpstream: *snapshotUpstream,
+		Only:             splitList(*only),
+		Skip:             splitList(*skip),
+
+		Exclude:             splitList(*exclude),
+		AllowRawPassthrough: *allowRawPassthrough,
+		Renames:             splitList(*rename),
+		Compat:              *compat,
+		MergeLayers:         splitList(*mergeLayers),
+		Features:            splitList(*features),
+		RegionCheck:         splitList(*regionCheck),
+		InstancesDir:        *instancesDir,
+		InstanceProviders:   splitList(*instanceProviders),
+		FanOut:              splitList(*fanOut),
+		FanOutRole:          *fanOutRole,
+		ProviderMirror:      *providerMirror,
+		SourceOverrides:     *sourceOverrides,
+		Patches:             splitList(*patch),
+		Envelope:            *envelope,
+		Derived:             *derived,
+		GroupBy:             *groupBy,
+		Sort:                *sortOrder,
+		SplitInputs:         *splitInputsFlag,
+		Annotate:            *annotate,
+		Comments:            *comments,
+		Accessor:            *accessor,
+		Environments:        splitList(*environments),
+		ConfigFormat:        *configFormat,
+		Policy:              *policy,
+		DefaultTags:         *defaultTags,
+		ConfigFrom:          *configFrom,
+		Format:              *format,
+		Orchestrator:        *orchestrator,
+		Pipeline:            *pipeline,
+		PipelineTemplate:    *pipelineTemplate,
+		DriftSchedule:       *driftSchedule,
+		Backend:             *backend,
+		Backstage:           *backstage,
+
+		Header:          *header,
+		Footer:          *footer,
+		NoLegacyOutput:  !*legacyOutput,
+		OutputsContract: *outputsContract,
+		Timestamp:       *timestamp,
+		Scan:            *scan,
+		FailOnFindings:  *failOnFindings,
+		AllowBreaking:   *allowBreaking,
+	}
+
+	var out outputSink = dirSink{}
+	switch {
+	case *archive != "":
+		out = newArchiveSink(*archive)
+	case *stdout:
+		out = newMemSink()
+	case *gitBranch != "":
+		sb, err := sandboxFor(".")
+		if err != nil {
+			fatalError("Failed to load workspace", err)
+		}
+		message := fmt.Sprintf("Generate %s wrapper from %s %s", wrapperName(opts), opts.Source, displayVersion(opts.Version))
+		if out, err = newGitSink(sb, ".", *gitBranch, message); err != nil {
+			log.Fatalf("Failed to create branch: %v", err)
+		}
+	}
+
+	dir, err := generateWrapperTo(out, opts)
+	if err != nil {
+		out.Close()
+		fatalError("Error", err)
+	}
+	if err := out.Close(); err != nil {
+		log.Fatalf("Failed to write output: %v", err)
+	}
+
+	switch out := out.(type) {
+	case *memSink:
+		for _, path := range out.paths() {
+			fmt.Printf("==> %s <==\n%s\n", path, out.files[path].Data)
+		}
+	case *archiveSink:
+		fmt.Printf("Wrapper module archived in %s\n", out.path)
+	case *gitSink:
+		fmt.Printf("Wrapper module committed to branch %s\n", out.branch)
+	default:
+		fmt.Printf("Wrapper module created in %s\n", dir)
+		if *infracost {
+			printCostEstimate(dir, opts.ExampleConfig)
+		}
+	}
+}
+
+// wrapperName returns the wrapper directory name for opts, derived from the
+// source when no explicit name was given.
+func wrapperName(opts generateOptions) string {
+	if opts.Name != "" {
+		return opts.Name
+	}
+	source := opts.Source
+	if m := registryAddress.FindStringSubmatch(source); m != nil && isRegistryAddress(source) {
+		return m[3]
+	}
+	if cloneURL, ok := hostedGitURL(source); ok {
+		source = cloneURL
+	}
+	source, _, _ = strings.Cut(source, "?")
+	parts := strings.Split(strings.Trim(source, "/"), "/")
+	name := parts[len(parts)-1]
+	for _, ext := range append(archiveExtensions, ".git") {
+		name = strings.TrimSuffix(name, ext)
+	}
+	return name
+}
+
+// generateWrapper downloads the module described by opts and writes the
+// wrapper files to disk, returning the wrapper directory.
+func generateWrapper(opts generateOptions) (string, error) {
+	return generateWrapperTo(dirSink{}, opts)
+}
+
+// generateWrapperTo is generateWrapper writing through out, which the caller
+// closes. The workspace lock file is only updated when writing to disk.
+func generateWrapperTo(out outputSink, opts generateOptions) (string, error) {
+	if opts.Binary == "" {
+		opts.Binary = "terraform"
+	}
+	if opts.ExampleConfig == "" {
+		opts.ExampleConfig = "config.example.json"
+	}
+
+	var runnerFile, runnerContent string
+	if opts.TaskRunner != "" {
+		var err error
+		runnerFile, runnerContent, err = generateTaskRunner(opts.TaskRunner, opts.Binary, opts.ExampleConfig)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	// Determine module name
-	modName := *name
-	if modName == "" {
-		parts := strings.Split(strings.Trim(*source, "/"), "/")
-		modName = parts[len(parts)-1]
-		modName = strings.TrimSuffix(modName, ".git")
+	modName := wrapperName(opts)
+	if err := validateWrapperName(modName); err != nil {
+		return "", err
+	}
+	wrapperDir := filepath.Join(opts.OutputDir, modName)
+	if opts.OutputDir == "" {
+		wrapperDir = "./" + modName
+	}
+
+	// Workspace settings provide defaults for anything not set explicitly
+	var ws *workspace
+	if !opts.IgnoreWorkspace {
+		var err error
+		if ws, err = findWorkspace(wrapperDir); err != nil {
+			return "", fmt.Errorf("failed to load workspace: %w", err)
+		}
+	}
+	sb := defaultSandbox()
+	if ws != nil {
+		sb = ws.Sandbox
+		if opts.Header == "" {
+			opts.Header = ws.Config.Generate.Header
+		}
+		if len(opts.MergeLayers) == 0 {
+			opts.MergeLayers = ws.Config.Generate.MergeLayers
+		}
+		if opts.Footer == "" {
+			opts.Footer = ws.Config.Generate.Footer
+		}
+		if opts.Pipeline == "" {
+			opts.Pipeline = ws.Config.Generate.Pipeline
+		}
+		if opts.PipelineTemplate == "" {
+			opts.PipelineTemplate = ws.Config.Generate.PipelineTemplate
+		}
+		if opts.DriftSchedule == "" {
+			opts.DriftSchedule = ws.Config.Generate.DriftSchedule
+		}
+		if opts.Backend == "" {
+			opts.Backend = ws.Config.Generate.Backend
+		}
+		if opts.SourceOverrides == "" {
+			opts.SourceOverrides = ws.Config.Generate.SourceOverrides
+		}
+		if opts.Scan == "" {
+			opts.Scan = ws.Config.Generate.Scan
+			opts.FailOnFindings = opts.FailOnFindings || ws.Config.Generate.FailOnFindings
+		}
+	}
+	if err := validateMergeLayers(opts.MergeLayers); err != nil {
+		return "", err
+	}
+	if err := validateConditional(opts); err != nil {
+		return "", err
+	}
+	if err := validateInstancesDir(opts); err != nil {
+		return "", err
+	}
+	if err := validatePolicy(opts.Policy); err != nil {
+		return "", err
+	}
+	if err := validateAccessor(opts.Accessor); err != nil {
+		return "", err
+	}
+	if err := validateConfigFrom(opts); err != nil {
+		return "", err
+	}
+	if err := validateFormat(opts.Format); err != nil {
+		return "", err
+	}
+	if err := validateOrchestrator(opts); err != nil {
+		return "", err
+	}
+	if err := validatePipeline(opts.Pipeline); err != nil {
+		return "", err
+	}
+	if err := validateBackend(opts.Backend); err != nil {
+		return "", err
+	}
+	if opts.Pipeline != "" && opts.Backend == "" {
+		fmt.Fprintf(os.Stderr, "Warning: the -pipeline only plans, as the wrapper has no -backend to keep the state of an apply\n")
+	}
+	if err := validateEnvironments(opts.Environments, opts.ConfigFormat); err != nil {
+		return "", err
+	}
+	if err := validateProviderMirror(opts.ProviderMirror); err != nil {
+		return "", err
+	}
+	header, err := loadBanner(opts.Header)
+	if err != nil {
+		return "", fmt.Errorf("failed to read header: %w", err)
+	}
+	footer, err := loadBanner(opts.Footer)
+	if err != nil {
+		return "", fmt.Errorf("failed to read footer: %w", err)
+	}
+	// Template files are kept in the workspace, so @path is relative to its
+	// root there
+	pipelineTemplateFile := opts.PipelineTemplate
+	if path, ok := strings.CutPrefix(pipelineTemplateFile, "@"); ok && ws != nil && !filepath.IsAbs(path) {
+		pipelineTemplateFile = "@" + filepath.Join(ws.Root, path)
+	}
+	pipelineTemplate, err := loadBanner(pipelineTemplateFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read pipeline template: %w", err)
+	}
+	sourceOverridesFile := opts.SourceOverrides
+	if sourceOverridesFile != "" && ws != nil && !filepath.IsAbs(sourceOverridesFile) {
+		sourceOverridesFile = filepath.Join(ws.Root, sourceOverridesFile)
+	}
+	// The wrapper is generated from, and calls, the override of its source
+	upstream := opts
+	override, err := loadSourceOverride(sourceOverridesFile, opts.Source)
+	if err != nil {
+		return "", fmt.Errorf("failed to read source overrides: %w", err)
+	}
+	if override != nil {
+		fmt.Fprintf(os.Stderr, "Using %s %s instead of %s, as %s overrides it\n", override.Source, displayVersion(override.Version), opts.Source, opts.SourceOverrides)
+		opts.Source, opts.Version = override.Source, override.Version
 	}
 
 	// Create a temporary directory to download the module
 	tmpDir, err := os.MkdirTemp("", "tfwrapper-")
 	if err != nil {
-		log.Fatalf("Failed to create temp dir: %v", err)
+		return "", fmt.Errorf("failed to create temp dir: %w", err)
 	}
 	defer os.RemoveAll(tmpDir)
 
-	// Download the module using 'tofu get'
-	modulePath, err := downloadModule(*source, *version, tmpDir)
+	// Download the module, unless we were given a local copy
+	var repoDir, modulePath string
+	if opts.ModuleDir != "" {
+		repoDir = filepath.Join(tmpDir, "repo")
+		modulePath = repoDir
+		if err := copyDir(opts.ModuleDir, modulePath); err != nil {
+			return "", fmt.Errorf("failed to copy module: %w", err)
+		}
+	} else {
+		repoDir, modulePath, err = downloadModule(sb, opts.cache, opts.Source, opts.Version, tmpDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to download module: %w", err)
+		}
+	}
+
+	// Block known-bad upstream versions before we wrap them
+	if opts.Scan != "" {
+		if err := scanModule(sb, opts.Scan, modulePath, opts.FailOnFindings); err != nil {
+			return "", err
+		}
+	}
+
+	// Patch the module before anything is read from it, keeping the commit
+	// the patches were applied to
+	commit := gitHeadCommit(sb, modulePath)
+	var patches []patchProvenance
+	if len(opts.Patches) > 0 {
+		root := ""
+		if ws != nil {
+			root = ws.Root
+		}
+		files := make([]string, len(opts.Patches))
+		for i, file := range opts.Patches {
+			files[i] = patchFile(file, wrapperDir, root)
+		}
+		if repoDir, modulePath, patches, err = patchModule(sb, opts, files, tmpDir, repoDir, modulePath); err != nil {
+			return "", err
+		}
+		opts.forkSource = forkSource(repoDir, modulePath)
+		// The wrapper records, and is regenerated from, its own copies
+		opts.Patches = make([]string, len(patches))
+		for i, p := range patches {
+			opts.Patches[i] = p.File
+		}
+	}
+
+	// Refuse modules the wrapper pattern can't support before generating
+	// something that fails at init
+	providers, outputs, err := checkModuleSupport(repoDir, modulePath, opts.Iterable || opts.Conditional, opts.ForceIterable)
 	if err != nil {
-		log.Fatalf("Failed to download module: %v", err)
+		return "", err
+	}
+	contract, err := loadOutputsContract(opts.OutputsContract)
+	if err != nil {
+		return "", err
+	}
+	if outputs, err = applyOutputsContract(contract, outputs, opts.Source, opts.Version, opts.AllowBreaking); err != nil {
+		return "", err
+	}
+
+	var generatedAt string
+	if opts.Timestamp {
+		generatedAt = time.Now().UTC().Format(time.RFC3339)
+	}
+	prov := newProvenance(opts, commit, generatedAt)
+	prov.Patches = patches
+	if override != nil {
+		// The options stay those of the upstream source, so dropping the
+		// override switches back to it
+		prov.Options.Source, prov.Options.Version = upstream.Source, upstream.Version
+		prov.CommandLine = prov.Options.commandLine()
+		prov.SourceOverride = override
+	}
+	prov.Providers = providers
+	prov.Outputs = outputNames(outputs)
+	prov.OutputDescriptions = outputDescriptions(outputs)
+	prov.OutputsContract = contract
+	if prov.Inputs, err = inputDigests(opts, pipelineTemplateFile, sourceOverridesFile); err != nil {
+		return "", err
 	}
 
 	// Parse variables.tf
-	vars, varOrder, varComments, err := parseVariables(filepath.Join(modulePath, "variables.tf"))
+	moduleFS := os.DirFS(modulePath)
+	if err := validateSort(opts.Sort); err != nil {
+		return "", err
+	}
+	if err := validateComments(opts.Comments); err != nil {
+		return "", err
+	}
+	varFiles, err := variableFiles(moduleFS, opts.GroupBy)
+	if err != nil {
+		return "", err
+	}
+	var vars []moduleVariable
+	if opts.cache != nil {
+		vars, err = opts.cache.variables(modulePath, varFiles)
+	} else {
+		vars, err = parseVariables(moduleFS, varFiles...)
+	}
 	if err != nil {
-		log.Fatalf("Failed to parse variables.tf: %v", err)
+		return "", fmt.Errorf("failed to parse variables.tf: %w", err)
+	}
+	if err := validateVariableNames(vars); err != nil {
+		return "", err
+	}
+	// The cache may share the parsed variables between wrappers
+	vars = slices.Clone(vars)
+	if prov.Features, err = resolveFeatures(opts, ws); err != nil {
+		return "", err
+	}
+	if err := applyFeatures(prov.Features, vars); err != nil {
+		return "", err
+	}
+	prov.Variables = variableContract(vars)
+	if opts.Comments == "" || opts.Comments == "all" {
+		warnCommentConflicts(vars)
+	}
+	if err := validateExclude(opts, prov.Variables); err != nil {
+		return "", err
+	}
+	if err := validateRenames(opts, prov.Variables); err != nil {
+		return "", err
+	}
+	if err := validateDefaultTags(opts, prov.Variables); err != nil {
+		return "", err
+	}
+	if err := validateEnvelope(opts, prov.Variables); err != nil {
+		return "", err
+	}
+	if err := validateRegionCheck(opts, prov.Variables, prov.Providers); err != nil {
+		return "", err
+	}
+	if err := validateInstanceProviders(opts, prov.Variables); err != nil {
+		return "", err
+	}
+	if err := validateFanOut(opts, prov.Variables, prov.Providers); err != nil {
+		return "", err
+	}
+	moduleProviders, err := moduleProviderConfigs(modulePath)
+	if err != nil {
+		return "", err
+	}
+	prov.ProviderConfigs = wrapperProviderConfigs(opts, moduleProviders, prov.Providers)
+	warnSharedProviders(opts, prov.ProviderConfigs)
+	if opts.derived, err = loadDerived(opts, prov.Variables); err != nil {
+		return "", err
+	}
+	prov.Derived = opts.derived
+
+	// Suggest the wrapper's next version from how its contract changed.
+	// A git branch is cut from the checked out tree, which has the
+	// previous metadata
+	if persistentSink(out) {
+		prev, err := readMetadata(wrapperDir)
+		if err != nil {
+			return "", err
+		}
+		if err := checkOutputContract(prev, prov, opts.AllowBreaking); err != nil {
+			return "", err
+		}
+		// Renames are recorded, so the compatible lookups stay
+		if prev != nil && prev.Variables != nil {
+			if detected := detectRenames(prev.Variables, prov.Variables, opts.Renames); len(detected) > 0 {
+				for _, rename := range detected {
+					old, new, _ := strings.Cut(rename, "=")
+					fmt.Fprintf(os.Stderr, "Detected rename of variable %s to %s, configs setting %s keep working (record another mapping with -rename %s=...)\n", old, new, old, old)
+				}
+				opts.Renames = append(slices.Clip(opts.Renames), detected...)
+				prov.Options.Renames = opts.Renames
+				prov.CommandLine = prov.Options.commandLine()
+			}
+		}
+		if opts.Compat {
+			prov.Compat = compatFor(prev, prov)
+		}
+		if prov.WrapperVersion, err = nextWrapperVersion(prev, prov); err != nil {
+			return "", err
+		}
 	}
 
 	// Create wrapper directory
-	if err := os.Mkdir(modName, 0755); err != nil && !os.IsExist(err) {
-		log.Fatalf("Failed to create directory: %v", err)
+	if err := out.MkdirAll(wrapperDir); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
 	}
 
-	// Write locals.tf
-	locals := `locals {
-  config = jsondecode(var.config)
-}
-`
-	writeFile(modName, "locals.tf", locals)
-
-	// Write variables.tf
-	variables := fmt.Sprintf(`variable "config" {
-  type        = any
-  description = "A JSON encoded object that contains the full %s config"
-  default     = "{}"
-}
-`, modName)
-	writeFile(modName, "variables.tf", variables)
+	// compat.tf and lists.tf hold their arguments in locals like the inputs_*.tf
+	// files
+	rest, translated := splitCompat(opts, prov.Compat, orderedVariables(opts, vars))
+	rest, lists := splitLists(opts, rest)
+	inputs := splitInputs(opts, rest)
+	locals := slices.Clip(inputs)
+	if len(translated) > 0 {
+		locals = append(locals, inputFile{Name: compatFileName, Local: "compat", Vars: translated})
+	}
+	if len(lists) > 0 {
+		locals = append(locals, inputFile{Name: listsFileName, Local: "lists", Vars: lists})
+	}
+	env := envelopeFor(&prov)
+	files := []generatedFile{
+		{"locals.tf", generateLocalsTf(opts)},
+		{"variables.tf", generateVariablesTf(opts, modName, env)},
+		{"main.tf", generateMainTf(opts, prov, vars, locals)},
+		{"outputs.tf", generateOutputsTf(opts, outputs)},
+	}
+	if prov.ProviderConfigs != nil {
+		files = append(files, generatedFile{providersFileName, generateProvidersTf(opts, prov.ProviderConfigs)})
+	}
+	if opts.ProviderMirror != "" {
+		if len(prov.Providers) > 0 {
+			files = append(files, generatedFile{providerMirrorFile, generateProviderMirrorRc(opts, modName, prov.Providers)})
+		} else {
+			fmt.Fprintf(os.Stderr, "Warning: the module requires no providers, %s not written\n", providerMirrorFile)
+		}
+	}
+	if len(translated) > 0 {
+		files = append(files, generatedFile{compatFileName, generateCompatTf(opts, prov.Compat, translated)})
+	}
+	if len(lists) > 0 {
+		files = append(files, generatedFile{listsFileName, generateListsTf(opts, lists)})
+	}
+	if opts.derived != nil {
+		files = append(files, generatedFile{derivedFileName, generateDerivedTf(opts, opts.derived, vars)})
+	}
+	if opts.ConfigFrom != "" {
+		files = append(files, generatedFile{remoteConfigFile, generateRemoteConfigTf(opts, env)})
+	}
+	for _, f := range inputs {
+		files = append(files, generatedFile{f.Name, generateInputsTf(opts, f)})
+	}
+	if runnerFile != "" {
+		files = append(files, generatedFile{runnerFile, runnerContent})
+	}
+	if opts.Format == "stack" {
+		files = append(files, generatedFile{stackComponentFile, generateStackComponent(opts, modName, prov.Providers, prov.Outputs)})
+	}
+	if opts.Orchestrator != "" {
+		files = append(files, generateOrchestratorTf(opts, modName))
+	}
+	if opts.Pipeline != "" {
+		var root string
+		if ws != nil {
+			root = ws.Root
+		}
+		pipeline, err := generatePipeline(opts, pipelineTemplate, modName, wrapperDir, root)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, generatedFile{pipelineFile, pipeline})
+	}
+	if opts.Backend != "" {
+		files = append(files, generatedFile{backendFile, generateBackendTf(opts.Backend)})
+	}
+	if opts.Backstage != "" {
+		backstage, err := generateBackstageFiles(opts, &prov, modName)
+		if err != nil {
+			return "", err
+		}
+		files = append(files, backstage...)
+	}
+	// The policy and configs are meant to be edited, so existing ones are
+	// kept
+	var stubs []generatedFile
+	if opts.Policy != "" {
+		stubs = append(stubs, generatedFile{policyFileName, generatePolicyRego(opts, modName, prov.Variables)})
+	}
+	if opts.Format == "stack" {
+		stubs = append(stubs, generatedFile{stackDeploymentsFile, generateStackDeployments(opts, modName)})
+	}
+	if opts.FromExample != "" {
+		example, err := generateExampleConfig(opts, repoDir, modulePath)
+		if err != nil {
+			return "", err
+		}
+		stubs = append(stubs, example)
+	}
+	if len(opts.Environments) > 0 {
+		configs, err := generateEnvironmentConfigs(opts, modName, prov.Variables)
+		if err != nil {
+			return "", err
+		}
+		stubs = append(stubs, configs...)
+	}
+	files, err = selectFiles(append(files, stubs...), opts.Only, opts.Skip)
+	if err != nil {
+		return "", err
+	}
+	var ignore []string
+	if persistentSink(out) {
+		files = slices.DeleteFunc(files, func(f generatedFile) bool {
+			_, err := os.Stat(filepath.Join(wrapperDir, f.Name))
+			return slices.Contains(stubs, f) && err == nil
+		})
+		if ignore, err = readIgnoreFile(wrapperDir); err != nil {
+			return "", err
+		}
+		files = slices.DeleteFunc(files, func(f generatedFile) bool {
+			return ignored(ignore, f.Name)
+		})
+	}
+	files, err = applyBanners(files, header, footer, bannerData{
+		ToolVersion: toolVersion,
+		Source:      opts.Source,
+		Version:     displayVersion(opts.Version),
+		Name:        modName,
+	})
+	if err != nil {
+		return "", err
+	}
 
-	// Write main.tf
-	mainTf := generateMainTf(*source, *version, *iterable, vars, varOrder, varComments)
-	writeFile(modName, "main.tf", mainTf)
+	// The metadata is always refreshed, whatever -only/-skip selected
+	metadata, err := prov.metadata()
+	if err != nil {
+		return "", err
+	}
+	files = append(files, generatedFile{metadataFileName, metadata})
 
-	// Write outputs.tf
-	outputs := `output "output" {
-  value = module.this
-}
-`
-	writeFile(modName, "outputs.tf", outputs)
+	digests := make(map[string]string, len(files))
+	for _, f := range files {
+		data, err := writeFile(out, wrapperDir, f.Name, f.Content)
+		if err != nil {
+			return "", err
+		}
+		digests[filepath.ToSlash(f.Name)] = digest(data)
+	}
+
+	if opts.forkSource != "" {
+		if err := vendorFork(out, repoDir, wrapperDir, ignore); err != nil {
+			return "", fmt.Errorf("failed to vendor the patched module: %w", err)
+		}
+		if err := vendorPatches(out, patches, wrapperDir); err != nil {
+			return "", fmt.Errorf("failed to copy the patches into the wrapper: %w", err)
+		}
+	}
+	if opts.SnapshotUpstream {
+		if err := snapshotUpstream(out, repoDir, modulePath, wrapperDir, ignore); err != nil {
+			return "", fmt.Errorf("failed to snapshot upstream files: %w", err)
+		}
+	}
 
-	fmt.Printf("Wrapper module created in ./%s\n", modName)
+	// Register the wrapper in the workspace lock file, if we're inside one
+	if _, onDisk := out.(dirSink); onDisk && ws != nil {
+		entry := lockEntry{Source: opts.Source, Version: opts.Version, Commit: prov.Commit, Iterable: opts.Iterable}
+		if err := ws.recordWrapper(wrapperDir, entry, &prov); err != nil {
+			return "", fmt.Errorf("failed to update lock file: %w", err)
+		}
+	}
+
+	// Record the write for change management, unless only comparing
+	if _, comparing := out.(*diffSink); persistentSink(out) && !comparing {
+		operation := opts.operation
+		if operation == "" {
+			operation = "generate"
+		}
+		event := newAuditEvent(operation, wrapperDir, &prov)
+		event.Files = digests
+		if err := writeAudit(ws, event); err != nil {
+			return "", fmt.Errorf("failed to write audit log: %w", err)
+		}
+	}
+
+	return wrapperDir, nil
 }
 
-func writeFile(dir, name, content string) {
+// writeFile writes content to name in dir, formatting .tf files, and returns
+// the data written.
+func writeFile(out outputSink, dir, name, content string) ([]byte, error) {
 	path := filepath.Join(dir, name)
-	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
-		log.Fatalf("Failed to write %s: %v", name, err)
+	data := []byte(content)
+	if filepath.Dir(path) != filepath.Clean(dir) {
+		if err := out.MkdirAll(filepath.Dir(path)); err != nil {
+			return nil, err
+		}
 	}
 
 	// Attempt to format the file if it's a .tf file
 	if strings.HasSuffix(name, ".tf") {
-		src, err := os.ReadFile(path)
-		if err == nil {
-			doc, diag := hclwrite.ParseConfig(src, path, hcl.InitialPos)
-			if diag == nil || !diag.HasErrors() {
-				// Overwrite with formatted content
-				_ = os.WriteFile(path, doc.Bytes(), 0644)
-			}
+		doc, diag := hclwrite.ParseConfig(data, path, hcl.InitialPos)
+		if diag == nil || !diag.HasErrors() {
+			data = doc.Bytes()
 		}
 	}
+
+	if err := out.WriteFile(path, data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return data, nil
 }
 
-func downloadModule(source, version, destDir string) (string, error) {
+// downloadModule fetches source into destDir and returns the repository
+// root and the module directory inside it. With a cache, repositories are
+// only fetched once per source and version.
+func downloadModule(sb sandbox, cache *moduleCache, source, version, destDir string) (string, string, error) {
 	// Parse the module source to handle submodule paths
-	parts := strings.SplitN(source, "//", 2)
-	moduleSource := parts[0]
-	subPath := ""
-	if len(parts) > 1 {
-		subPath = parts[1]
+	moduleSource, subPath := splitSourceSubPath(source)
+	if subPath != "" {
+		if err := validateSubPath(subPath); err != nil {
+			return "", "", err
+		}
+	}
+
+	var repoDir, innerPath string
+	var err error
+	if cache != nil {
+		repoDir, innerPath, err = cache.fetch(sb, moduleSource, version)
+	} else {
+		repoDir, innerPath, err = fetchSource(sb, moduleSource, version, destDir)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	// Determine the final module path
+	modulePath := filepath.Join(repoDir, filepath.FromSlash(innerPath), filepath.FromSlash(subPath))
+
+	// Verify the module directory exists and contains variables.tf
+	variablesPath := filepath.Join(modulePath, "variables.tf")
+	if _, err := os.Stat(variablesPath); os.IsNotExist(err) {
+		return "", "", fmt.Errorf("%w: variables.tf not found in module path %s", ErrSourceNotFound, modulePath)
+	}
+
+	// Refuse modules whose files are symlinks pointing outside the repository
+	if err := ensureWithin(repoDir, variablesPath); err != nil {
+		return "", "", err
+	}
+
+	return repoDir, modulePath, nil
+}
+
+// fetchSource downloads moduleSource (without a //subpath) into
+// destDir/repo. Registry addresses can resolve to a location with a subpath
+// of its own, which is returned alongside the repository directory.
+func fetchSource(sb sandbox, moduleSource, version, destDir string) (string, string, error) {
+	repoDir := filepath.Join(destDir, "repo")
+
+	switch {
+	case strings.HasPrefix(moduleSource, "s3::") || strings.HasPrefix(moduleSource, "gcs::"):
+		if version != "" {
+			return "", "", fmt.Errorf("%w: -version can't be used with archive source %s", ErrUnsupportedFeature, moduleSource)
+		}
+		if err := fetchBucketArchive(sb, moduleSource, destDir, repoDir); err != nil {
+			return "", "", fmt.Errorf("failed to fetch archive %s: %w", moduleSource, err)
+		}
+
+	case archiveFormat(moduleSource) != "":
+		if version != "" {
+			return "", "", fmt.Errorf("%w: -version can't be used with archive source %s", ErrUnsupportedFeature, moduleSource)
+		}
+		if err := fetchArchive(sb, moduleSource, archiveFormat(moduleSource), destDir, repoDir); err != nil {
+			return "", "", fmt.Errorf("failed to fetch archive %s: %w", moduleSource, err)
+		}
+
+	case strings.HasPrefix(moduleSource, "hg::"):
+		moduleSource = strings.TrimPrefix(moduleSource, "hg::")
+		args := []string{"clone"}
+		if version != "" {
+			args = append(args, "--updaterev", version)
+		}
+		args = append(args, moduleSource, repoDir)
+		if _, err := sb.run(destDir, "hg", args...); err != nil {
+			return "", "", fmt.Errorf("failed to clone repository %s: %w", moduleSource, err)
+		}
+
+	case isRegistryAddress(moduleSource):
+		location, err := resolveRegistrySource(sb, moduleSource, version)
+		if err != nil {
+			return "", "", err
+		}
+		if isRegistryAddress(location) {
+			return "", "", fmt.Errorf("registry returned another registry address %s for %s", location, moduleSource)
+		}
+		location, locationPath := splitSourceSubPath(location)
+		if err := validateSubPath(locationPath); locationPath != "" && err != nil {
+			return "", "", err
+		}
+		repoDir, innerPath, err := fetchSource(sb, location, "", destDir)
+		return repoDir, path.Join(locationPath, innerPath), err
+
+	default:
+		if err := cloneGitModule(sb, strings.TrimPrefix(moduleSource, "git::"), version, destDir, repoDir); err != nil {
+			return "", "", err
+		}
+	}
+
+	return repoDir, "", nil
+}
+
+// cloneGitModule clones a git, GitHub or registry module source into repoDir.
+func cloneGitModule(sb sandbox, moduleSource, version, destDir, repoDir string) error {
+	moduleSource, ref := gitCloneURL(moduleSource)
+	// A ?ref= query selects the version, as in Terraform
+	if version == "" {
+		version = ref
+	}
+	if noExec {
+		if ok, err := fetchGitHubTarball(sb, moduleSource, version, destDir, repoDir); ok {
+			return err
+		}
+	}
+
+	// Clone the repository
+	args := []string{"clone", "--depth=1", moduleSource, repoDir}
+	if version != "" {
+		// For tagged versions, we need to fetch the specific tag
+		args = []string{"clone", "--depth=1", "--branch", version, moduleSource, repoDir}
+	}
+
+	if _, err := sb.withGitHeader(gitCloneHeader(moduleSource)).run(destDir, "git", args...); err != nil {
+		return fmt.Errorf("failed to clone repository %s: %w", moduleSource, classifyCloneError(err))
+	}
+	return nil
+}
+
+// gitCloneURL converts a git, GitHub or registry module source into the URL
+// to clone, returning the ref selected by a ?ref= query alongside it.
+func gitCloneURL(moduleSource string) (string, string) {
+	ref := ""
+	if base, query, ok := strings.Cut(moduleSource, "?"); ok {
+		if values, err := url.ParseQuery(query); err == nil {
+			ref = values.Get("ref")
+		}
+		moduleSource = base
 	}
 
 	// Convert registry modules to GitHub URLs
-	if !strings.Contains(moduleSource, "://") && !strings.HasPrefix(moduleSource, "github.com/") {
+	if cloneURL, ok := hostedGitURL(moduleSource); ok {
+		moduleSource = cloneURL
+	} else if !strings.Contains(moduleSource, "://") && !strings.HasPrefix(moduleSource, "github.com/") {
 		// This looks like a registry module (e.g., "terraform-aws-modules/iam/aws")
 		// Convert to GitHub URL - remove the "/aws" provider suffix for GitHub
 		sourceParts := strings.Split(moduleSource, "/")
@@ -135,93 +1267,149 @@ func downloadModule(source, version, destDir string) (string, error) {
 		// Add https:// prefix
 		moduleSource = "https://" + moduleSource + ".git"
 	}
+	return moduleSource, ref
+}
 
-	// Clone the repository
-	repoDir := filepath.Join(destDir, "repo")
-	cmd := exec.Command("git", "clone", "--depth=1", moduleSource, repoDir)
-	if version != "" {
-		// For tagged versions, we need to fetch the specific tag
-		cmd = exec.Command("git", "clone", "--depth=1", "--branch", version, moduleSource, repoDir)
+// gitCloneHeader returns the HTTP auth header for cloning cloneURL, if we
+// have credentials for its host.
+func gitCloneHeader(cloneURL string) string {
+	if header := gitAuthHeader(cloneURL); header != "" {
+		return header
 	}
+	return hostTokenHeader(cloneURL)
+}
 
-	if err := cmd.Run(); err != nil {
-		return "", fmt.Errorf("failed to clone repository %s: %w", moduleSource, err)
-	}
+// moduleVariable is an upstream variable as it is passed through in main.tf.
+type moduleVariable struct {
+	Name string
+	// Default is the HCL expression used as the lookup default
+	Default string
+	// DefaultKind is "evaluated", "verbatim" (the expression couldn't be
+	// evaluated statically, so its source is copied), "resolved" (it
+	// referenced other variables or locals, which could be evaluated),
+	// "unresolved" (they couldn't, so the default is null), "collapsed"
+	// (it evaluated to a collection or object ctyValueToString can't
+	// render, so the default is null rather than an empty value that may
+	// not convert to the type), "feature" (set by a feature flag) or
+	// "required"
+	DefaultKind string
+	// Type is the source of the type constraint, "" if there is none
+	Type string
+	// Comment is the comment block above the variable block, and
+	// Description its description if it is a static string
+	Comment     string
+	Description string
+	// File is the upstream file the variable is declared in, and Section
+	// the title of the last banner comment ("#### Logging ####") before it
+	File    string
+	Section string
+}
 
-	// Determine the final module path
-	modulePath := repoDir
-	if subPath != "" {
-		modulePath = filepath.Join(repoDir, subPath)
-	}
+func parseVariables(fsys fs.FS, filePaths ...string) ([]moduleVariable, error) {
+	parser := hclparse.NewParser()
+	var vars []moduleVariable
+	references := map[string]hcl.Expression{}
+	declared := map[string]variableDeclaration{}
+	var duplicates hcl.Diagnostics
 
-	// Verify the module directory exists and contains variables.tf
-	variablesPath := filepath.Join(modulePath, "variables.tf")
-	if _, err := os.Stat(variablesPath); os.IsNotExist(err) {
-		return "", fmt.Errorf("variables.tf not found in module path %s", modulePath)
-	}
+	for _, filePath := range filePaths {
+		src, err := fs.ReadFile(fsys, filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read variables file: %w", err)
+		}
 
-	return modulePath, nil
-}
+		var blocks []recoveredBlock
+		file, diags := parser.ParseHCL(src, path.Base(filePath))
+		if diags.HasErrors() {
+			// Syntax we can't parse, e.g. of a newer Terraform version,
+			// only costs the attributes using it
+			var ok bool
+			if blocks, ok = recoverVariables(src, path.Base(filePath)); !ok {
+				return nil, newParseError(diags, parser.Files())
+			}
+			first := diags.Errs()[0].(*hcl.Diagnostic)
+			fmt.Fprintf(os.Stderr, "Warning: %s: %s, reading the variables of %s one at a time\n", first.Subject, first.Summary, filePath)
+		} else {
+			content, _, diags := file.Body.PartialContent(variableSchema)
+			if diags.HasErrors() {
+				return nil, newParseError(diags, parser.Files())
+			}
+			for _, block := range content.Blocks {
+				blocks = append(blocks, recoveredBlock{Block: block})
+			}
+		}
 
-func parseVariables(filePath string) (map[string]string, []string, map[string]string, error) {
-	src, err := os.ReadFile(filePath)
-	if err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to read variables file: %w", err)
-	}
+		// Parse the source to extract comments above variable blocks
+		lines := strings.Split(string(src), "\n")
+		section := ""
 
-	parser := hclparse.NewParser()
-	file, diags := parser.ParseHCL(src, filepath.Base(filePath))
-	if diags.HasErrors() {
-		return nil, nil, nil, fmt.Errorf("failed to parse HCL: %s", diags.Error())
-	}
+		for _, rb := range blocks {
+			block := rb.Block
+			if block.Type == "variable" {
+				v := moduleVariable{Name: block.Labels[0], File: filePath}
+				if first, ok := declared[v.Name]; ok {
+					duplicates = append(duplicates, duplicateVariable(fsys, v.Name, first, variableDeclaration{filePath, block.DefRange}))
+					continue
+				}
+				declared[v.Name] = variableDeclaration{filePath, block.DefRange}
 
-	content, _, diags := file.Body.PartialContent(&hcl.BodySchema{
-		Blocks: []hcl.BlockHeaderSchema{
-			{Type: "variable", LabelNames: []string{"name"}},
-		},
-	})
-	if diags.HasErrors() {
-		return nil, nil, nil, fmt.Errorf("failed to decode HCL: %s", diags.Error())
-	}
-
-	vars := make(map[string]string)
-	varOrder := make([]string, 0)
-	varComments := make(map[string]string)
-
-	// Parse the source to extract comments above variable blocks
-	lines := strings.Split(string(src), "\n")
-
-	for _, block := range content.Blocks {
-		if block.Type == "variable" {
-			varName := block.Labels[0]
-			varOrder = append(varOrder, varName)
-
-			attrs, _ := block.Body.JustAttributes()
-			var defaultValue string
-			if defAttr, ok := attrs["default"]; ok {
-				val, diags := defAttr.Expr.Value(nil)
-				if diags.HasErrors() {
-					// Could not statically evaluate, use the expression as a string
-					start := defAttr.Expr.Range().Start.Byte
-					end := defAttr.Expr.Range().End.Byte
-					defaultValue = string(src[start:end])
+				attrs, _ := block.Body.JustAttributes()
+				if def, ok := rb.Unparsed["default"]; ok {
+					v.Default = def
+					v.DefaultKind = "verbatim"
+					fmt.Fprintf(os.Stderr, "Warning: %s: the default of variable %q can't be parsed and is copied verbatim\n", filePath, v.Name)
+				} else if defAttr, ok := attrs["default"]; ok {
+					val, diags := defAttr.Expr.Value(nil)
+					if diags.HasErrors() {
+						// Could not statically evaluate, use the expression as a string
+						v.Default = string(defAttr.Expr.Range().SliceBytes(src))
+						v.DefaultKind = "verbatim"
+						if referencesModule(defAttr.Expr) {
+							references[v.Name] = defAttr.Expr
+						}
+						warnNewerFunctions(filePath, v.Name, defAttr.Expr)
+					} else {
+						v.Default = ctyValueToString(val)
+						v.DefaultKind = "evaluated"
+						if collapsedValue(val) {
+							v.Default = "null"
+							v.DefaultKind = "collapsed"
+						}
+					}
 				} else {
-					defaultValue = ctyValueToString(val)
+					v.Default = "null" // No default value
+					v.DefaultKind = "required"
 				}
-			} else {
-				defaultValue = "null" // No default value
-			}
-			vars[varName] = defaultValue
+				if descAttr, ok := attrs["description"]; ok {
+					if val, diags := descAttr.Expr.Value(nil); !diags.HasErrors() && val.Type() == cty.String && val.IsKnown() && !val.IsNull() {
+						v.Description = val.AsString()
+					}
+				}
+				if typeAttr, ok := attrs["type"]; ok {
+					v.Type = strings.Join(strings.Fields(string(typeAttr.Expr.Range().SliceBytes(src))), " ")
+				} else if typ, ok := rb.Unparsed["type"]; ok {
+					v.Type = strings.Join(strings.Fields(typ), " ")
+				}
+
+				// Extract comments before this variable block
+				startLine := block.DefRange.Start.Line - 1 // Convert to 0-based
+				v.Comment = extractCommentAboveVariable(lines, startLine)
 
-			// Extract comments before this variable block
-			startLine := block.DefRange.Start.Line - 1 // Convert to 0-based
-			comment := extractCommentAboveVariable(lines, startLine)
-			if comment != "" {
-				varComments[varName] = comment
+				// A banner comment starts a section that lasts until the next one
+				if title := bannerTitle(v.Comment); title != "" {
+					section = title
+				}
+				v.Section = section
+
+				vars = append(vars, v)
 			}
 		}
 	}
-	return vars, varOrder, varComments, nil
+	if duplicates.HasErrors() {
+		return nil, newParseError(duplicates, parser.Files())
+	}
+	resolveDefaultReferences(fsys, vars, references)
+	return vars, nil
 }
 
 func extractCommentAboveVariable(lines []string, varStartLine int) string {
@@ -261,7 +1449,7 @@ func ctyValueToString(val cty.Value) string {
 	if val.Type().IsPrimitiveType() {
 		switch val.Type().FriendlyName() {
 		case "string":
-			return fmt.Sprintf("\"%s\"", val.AsString())
+			return hclString(val.AsString())
 		case "number":
 			return fmt.Sprintf("%v", val.AsBigFloat())
 		case "bool":
@@ -282,66 +1470,187 @@ func ctyValueToString(val cty.Value) string {
 	return "null"
 }
 
-func generateMainTf(source, version string, iterable bool, vars map[string]string, varOrder []string, varComments map[string]string) string {
+// collapsedValue reports whether ctyValueToString loses val, rendering a
+// non-empty collection or object as empty, or a set as null.
+func collapsedValue(val cty.Value) bool {
+	if val.IsNull() || val.Type().IsPrimitiveType() {
+		return false
+	}
+	if !val.Type().IsObjectType() && !val.Type().IsMapType() && !val.Type().IsTupleType() && !val.Type().IsListType() {
+		return true
+	}
+	return val.LengthInt() > 0
+}
+
+// orderedVariables returns the variables passed through in main.tf, in the
+// order they are written.
+func orderedVariables(opts generateOptions, vars []moduleVariable) []moduleVariable {
+	var included []moduleVariable
+	for _, v := range groupVariables(sortVariables(vars, opts.Sort), opts.GroupBy) {
+		if !isExcluded(opts, v.Name) {
+			included = append(included, v)
+		}
+	}
+	return included
+}
+
+func generateMainTf(opts generateOptions, prov provenance, vars []moduleVariable, inputs []inputFile) string {
 	var builder strings.Builder
 
-	// Add header comment with version info
-	if version != "" {
-		builder.WriteString(fmt.Sprintf("# Module source: %s\n# Version: %s\n\n", source, version))
-	} else {
-		builder.WriteString(fmt.Sprintf("# Module source: %s\n# Version: latest (no version constraint specified)\n\n", source))
+	// Add header comment with provenance info
+	builder.WriteString(prov.comment())
+	builder.WriteString("\n")
+
+	switch {
+	case len(opts.FanOut) > 0:
+		// One module block per target, each with the provider of the
+		// target
+		targets, _ := parseFanOut(opts.FanOut)
+		refs := make([]string, len(targets))
+		for i, t := range targets {
+			if i > 0 {
+				builder.WriteString("\n")
+			}
+			writeModuleBlock(&builder, opts, prov, vars, inputs, "this_"+t.alias(), t.alias())
+			refs[i] = "module.this_" + t.alias()
+		}
+		builder.WriteString("\n# Every target the config deploys to\n")
+		fmt.Fprintf(&builder, "locals {\n  this = merge(%s)\n}\n", strings.Join(refs, ", "))
+		builder.WriteString(generateFanOutLocals(opts))
+	case len(opts.InstanceProviders) == 0:
+		writeModuleBlock(&builder, opts, prov, vars, inputs, "this", "")
+	default:
+		// One module block per set of providers, as Terraform can't pick
+		// the providers of a module per instance
+		refs := make([]string, len(opts.InstanceProviders))
+		for i, set := range opts.InstanceProviders {
+			if i > 0 {
+				builder.WriteString("\n")
+			}
+			writeModuleBlock(&builder, opts, prov, vars, inputs, "this_"+set, set)
+			refs[i] = "module.this_" + set
+		}
+		builder.WriteString("\n# Every instance, whichever set of providers it was created with\n")
+		fmt.Fprintf(&builder, "locals {\n  this = merge(%s)\n}\n", strings.Join(refs, ", "))
+		builder.WriteString(generateInstanceProviderCheck(opts))
+	}
+	builder.WriteString(generateDeprecationChecks(opts))
+	builder.WriteString(generateRegionCheck(opts, prov.Providers))
+	return builder.String()
+}
+
+// writeModuleBlock writes the module block calling the wrapped module. With
+// -instance-providers, set is the set of providers whose instances it
+// creates, and with -fan-out the alias of its target.
+func writeModuleBlock(builder *strings.Builder, opts generateOptions, prov provenance, vars []moduleVariable, inputs []inputFile, name, set string) {
+	source, version, iterable := opts.Source, opts.Version, opts.Iterable
+	if opts.forkSource != "" {
+		source, version = opts.forkSource, ""
 	}
 
-	builder.WriteString("module \"this\" {\n")
-	builder.WriteString(fmt.Sprintf("  source = \"%s\"\n", source))
+	fmt.Fprintf(builder, "module %q {\n", name)
+	builder.WriteString(fmt.Sprintf("  source = %s\n", hclString(source)))
 	if version != "" {
-		builder.WriteString(fmt.Sprintf("  version = \"%s\"\n", version))
+		builder.WriteString(fmt.Sprintf("  version = %s\n", hclString(version)))
 	}
 
 	// Add empty line before variables
 	builder.WriteString("\n")
 
 	var configSource string
-	if iterable {
-		builder.WriteString("  for_each = lookup(local.config, \"instances\", {})\n\n")
+	switch {
+	case len(opts.FanOut) > 0:
+		builder.WriteString(fmt.Sprintf("  for_each = %s\n\n", fanOutForEach(opts, set)))
 		configSource = "each.value"
-	} else {
+	case iterable && set != "":
+		builder.WriteString(fmt.Sprintf("  for_each = %s\n\n", instanceSetFilter(opts, set)))
+		configSource = "each.value"
+	case iterable:
+		builder.WriteString(fmt.Sprintf("  for_each = %s\n\n", accessExpr(fixedAccessor(opts), "local.config", "instances", "{}")))
+		configSource = "each.value"
+	case opts.Conditional:
+		builder.WriteString(fmt.Sprintf("  count = %s ? 1 : 0\n\n", accessExpr(fixedAccessor(opts), "local.config", conditionalKey, "true")))
+		configSource = "local.config"
+	default:
 		configSource = "local.config"
 	}
+	if prov.ProviderConfigs != nil {
+		writeProvidersArgument(builder, prov.ProviderConfigs, moduleProviders(opts, prov, set))
+	}
 
-	// Use original order if available, otherwise sort alphabetically
-	var varNames []string
-	if len(varOrder) > 0 {
-		varNames = varOrder
-	} else {
-		// Fallback to alphabetical sorting if no order was preserved
-		varNames = make([]string, 0, len(vars))
-		for name := range vars {
-			varNames = append(varNames, name)
+	// Add variables with their comments, in upstream order or grouped.
+	// Arguments split into inputs_*.tf files just refer to their local.
+	locals := inputLocals(inputs)
+	group, started := "", false
+	for _, v := range orderedVariables(opts, vars) {
+		if title := groupTitle(v, opts.GroupBy); opts.GroupBy != "" && (!started || title != group) {
+			if started {
+				builder.WriteString("\n")
+			}
+			if title != "" {
+				builder.WriteString(fmt.Sprintf("  #### %s ####\n", title))
+			}
+			group = title
 		}
-		sort.Strings(varNames)
-	}
+		started = true
 
-	// Add variables with their comments
-	for _, name := range varNames {
-		def := vars[name]
+		if local, ok := locals[v.Name]; ok {
+			if iterable {
+				builder.WriteString(fmt.Sprintf("  %s = local.%s[each.key].%s\n", v.Name, local, v.Name))
+			} else {
+				builder.WriteString(fmt.Sprintf("  %s = local.%s.%s\n", v.Name, local, v.Name))
+			}
+			continue
+		}
 
 		// Add comment if it exists
-		if comment, exists := varComments[name]; exists {
-			// Add the comment with proper indentation
-			commentLines := strings.Split(comment, "\n")
-			for _, line := range commentLines {
-				if strings.TrimSpace(line) == "" {
-					builder.WriteString("\n")
-				} else {
-					builder.WriteString(fmt.Sprintf("  %s\n", line))
-				}
-			}
+		writeVariableComment(builder, "  ", variableComment(opts, v))
+		if opts.DefaultTags && v.Name == tagsVariable {
+			writeVariableComment(builder, "  ", defaultTagsComment)
 		}
 
-		builder.WriteString(fmt.Sprintf("  %s = lookup(%s, \"%s\", %s)\n", name, configSource, name, def))
+		builder.WriteString(fmt.Sprintf("  %s = %s%s\n", v.Name, lookupExpr(opts, configSource, v), annotation(opts, v)))
+	}
+
+	// Excluded variables can only be set through the raw section
+	if opts.AllowRawPassthrough && len(opts.Exclude) > 0 {
+		builder.WriteString("\n  # Excluded from the wrapper, only settable through the \"" + rawSection + "\" config section\n")
+		for _, v := range vars {
+			if isExcluded(opts, v.Name) {
+				builder.WriteString(fmt.Sprintf("  %s = %s%s\n", v.Name, rawAccessExpr(opts, configSource, v), annotation(opts, v)))
+			}
+		}
 	}
 
 	builder.WriteString("}\n")
-	return builder.String()
+}
+
+// annotation returns the trailing comment explaining a lookup line when
+// -annotate is set.
+func annotation(opts generateOptions, v moduleVariable) string {
+	if !opts.Annotate {
+		return ""
+	}
+	typ := v.Type
+	if typ == "" {
+		typ = "any"
+	}
+	if opts.derived != nil && opts.derived.Expressions[v.Name] != "" {
+		return fmt.Sprintf(" # type: %s; default derived from other config keys", typ)
+	}
+	switch v.DefaultKind {
+	case "required":
+		return fmt.Sprintf(" # type: %s; required (no default)", typ)
+	case "verbatim":
+		return fmt.Sprintf(" # type: %s; default copied verbatim", typ)
+	case "resolved":
+		return fmt.Sprintf(" # type: %s; default resolved from upstream variables and locals", typ)
+	case "unresolved":
+		return fmt.Sprintf(" # type: %s; default couldn't be resolved, null", typ)
+	case "feature":
+		return fmt.Sprintf(" # type: %s; default set by a feature flag", typ)
+	case "collapsed":
+		return fmt.Sprintf(" # type: %s; default too complex to copy, null", typ)
+	}
+	return fmt.Sprintf(" # type: %s; default evaluated", typ)
 }
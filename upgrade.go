@@ -0,0 +1,423 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+func runUpgrade(args []string) {
+	fs := flag.NewFlagSet("upgrade", flag.ExitOnError)
+	version := fs.String("version", "", "Version to upgrade to (default: latest)")
+	plan := fs.Bool("plan", false, "Run a speculative plan of the wrapper's example/ root before and after regenerating and summarize the differences")
+	binary := fs.String("binary", "terraform", "Binary used for -plan (terraform or tofu)")
+	only := fs.String("only", "", "Comma separated list of files to regenerate, e.g. main (optional)")
+	skip := fs.String("skip", "", "Comma separated list of files to leave untouched, e.g. outputs (optional)")
+	openPR := fs.Bool("open-pr", false, "Commit the regenerated wrapper to a new branch, push it and open a GitHub pull request or GitLab merge request")
+	branch := fs.String("branch", "", "Branch to commit to with -open-pr (default: tfwrapper/upgrade-<name>-<version>)")
+	base := fs.String("base", "", "Branch the pull request targets with -open-pr (default: the checked out branch)")
+	diff := fs.Bool("diff", false, "Print a unified diff of what regenerating would change instead of writing anything")
+	compatibleWith := fs.String("compatible-with", "", "Only upgrade to a release whose required_version allows this terraform version, e.g. 1.5.x (optional)")
+	rename := fs.String("rename", "", "Comma separated old=new variables the new version renamed, added to the wrapper's recorded renames (optional)")
+	compat := fs.Bool("compat", false, "Write compat.tf translating configs written for the current version to the variable types of the new one")
+	allowBreaking := fs.Bool("allow-breaking", false, "Upgrade even though the new version removed outputs the wrapper exports")
+	infracost := fs.Bool("infracost", false, "Estimate the cost of the upgraded wrapper with infracost, and add it to the -open-pr description")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: tfwrapper upgrade [flags] <wrapper-dir>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	dir := filepath.Clean(fs.Arg(0))
+	if *openPR && *plan {
+		log.Fatal("Error: -plan can't be used with -open-pr, the working tree isn't regenerated")
+	}
+	if *diff && (*openPR || *plan || *infracost) {
+		log.Fatal("Error: -diff can't be used with -open-pr, -plan or -infracost, nothing is written")
+	}
+
+	current, err := readWrapperOptions(dir)
+	if err != nil {
+		fatalError("Failed to read wrapper", err)
+	}
+
+	// Only wrappers with an example root module can be planned
+	exampleDir := filepath.Join(dir, "example")
+	if *plan {
+		if _, err := os.Stat(exampleDir); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %s has no example/ root module, skipping plan\n", dir)
+			*plan = false
+		}
+	}
+
+	sb, err := sandboxFor(dir)
+	if err != nil {
+		fatalError("Failed to load workspace", err)
+	}
+
+	var before map[string]string
+	if *plan {
+		sb = sb.withPinnedVersion(exampleDir, *binary)
+		if before, err = planResourceChanges(sb, exampleDir, *binary); err != nil {
+			log.Fatalf("Failed to plan before upgrade: %v", err)
+		}
+	}
+
+	next := current
+	next.operation = "upgrade"
+	if *compatibleWith != "" {
+		if next.cache, err = compatibleUpgrade(sb, current.Source, version, *compatibleWith); err != nil {
+			fatalError("Failed to resolve a compatible version", err)
+		}
+		defer next.cache.Close()
+	}
+
+	// Pin the latest release rather than whatever the default branch has
+	if *version == "" {
+		if *version, err = latestVersion(sb, current.Source); err != nil {
+			fatalError("Failed to resolve latest version", err)
+		}
+	}
+
+	next.Version = *version
+	next.Only = splitList(*only)
+	next.Skip = splitList(*skip)
+	next.AllowBreaking = *allowBreaking
+	next.Compat = next.Compat || *compat
+	next.Renames = append(slices.Clip(current.Renames), splitList(*rename)...)
+	if *openPR {
+		if *branch == "" {
+			*branch = fmt.Sprintf("tfwrapper/upgrade-%s-%s", next.Name, displayVersion(next.Version))
+		}
+		url, err := upgradePullRequest(sb, dir, current, next, *branch, *base, *infracost)
+		switch {
+		case err != nil:
+			fatalError("Failed to open pull request", err)
+		case url == "":
+			fmt.Printf("%s is already up to date with %s, no pull request opened\n", dir, displayVersion(next.Version))
+		default:
+			fmt.Printf("Opened %s to upgrade %s to %s\n", url, dir, displayVersion(next.Version))
+		}
+		return
+	}
+	if *diff {
+		out := newDiffSink()
+		if _, err := generateWrapperTo(out, next); err != nil {
+			fatalError("Error", err)
+		}
+		changes, err := out.diff()
+		if err != nil {
+			log.Fatalf("Failed to compare with %s: %v", dir, err)
+		}
+		if isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == "" {
+			changes = colorizeDiff(changes)
+		}
+		fmt.Print(changes)
+		return
+	}
+	if _, err := generateWrapper(next); err != nil {
+		fatalError("Error", err)
+	}
+	fmt.Printf("Upgraded %s from %s to %s\n", dir, displayVersion(current.Version), displayVersion(next.Version))
+	if *infracost {
+		printCostEstimate(dir, next.ExampleConfig)
+	}
+
+	if *plan {
+		after, err := planResourceChanges(sb, exampleDir, *binary)
+		if err != nil {
+			log.Fatalf("Failed to plan after upgrade: %v", err)
+		}
+		printPlanDiff(before, after)
+	}
+}
+
+// compatibleUpgrade checks that *version, or else picks the highest release,
+// allows the terraform version the wrapper's callers run (-compatible-with).
+// The downloads are kept in the returned cache so the chosen release isn't
+// fetched again.
+func compatibleUpgrade(sb sandbox, source string, version *string, compatibleWith string) (*moduleCache, error) {
+	core, err := parseCoreVersion(compatibleWith)
+	if err != nil {
+		return nil, err
+	}
+	cache, err := newModuleCache()
+	if err != nil {
+		return nil, err
+	}
+
+	if *version != "" {
+		constraint, err := moduleCoreVersion(sb, cache, source, *version)
+		if err == nil {
+			var ok bool
+			if ok, err = coreCompatible(core, constraint); err == nil && !ok {
+				err = fmt.Errorf("%s requires terraform %s, which excludes %s", displayVersion(*version), constraint, compatibleWith)
+			}
+		}
+		if err != nil {
+			cache.Close()
+			return nil, err
+		}
+		return cache, nil
+	}
+
+	versions, err := releasedVersions(sb, source)
+	if err == nil && len(versions) == 0 {
+		err = fmt.Errorf("%w: %s has no releases to choose from", ErrVersionNotFound, source)
+	}
+	if err == nil {
+		*version, err = compatibleVersion(sb, cache, source, versions, core)
+	}
+	if err != nil {
+		cache.Close()
+		return nil, err
+	}
+	return cache, nil
+}
+
+// upgradePullRequest regenerates the wrapper in dir onto a new branch, pushes
+// it to origin and opens a pull request with the variable changes as its
+// description, along with the cost estimate when infracost is set. It
+// returns the pull request URL, or "" when regenerating changed nothing.
+func upgradePullRequest(sb sandbox, dir string, current, next generateOptions, branch, base string, infracost bool) (string, error) {
+	prev, err := readMetadata(dir)
+	if err != nil {
+		return "", err
+	}
+	if prev == nil {
+		prev = &provenance{Source: current.Source, Version: current.Version}
+	}
+
+	title := fmt.Sprintf("Upgrade %s from %s to %s", next.Name, displayVersion(current.Version), displayVersion(next.Version))
+	out, err := newGitSink(sb, next.OutputDir, branch, title)
+	if err != nil {
+		return "", err
+	}
+	wrapperDir, err := generateWrapperTo(out, next)
+	if err != nil {
+		out.Close()
+		return "", err
+	}
+	upgraded, err := out.readMetadata(wrapperDir)
+	if err != nil {
+		out.Close()
+		return "", err
+	}
+	body := upgradeChangelog(next.Name, prev, upgraded)
+	if infracost {
+		body += costSection(sb, out, wrapperDir, next.ExampleConfig)
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	if !out.committed {
+		sb.run(out.repoDir, "git", "branch", "-D", branch)
+		return "", nil
+	}
+
+	if base == "" {
+		head, err := sb.run(out.repoDir, "git", "rev-parse", "--abbrev-ref", "HEAD")
+		if err != nil {
+			return "", err
+		}
+		base = strings.TrimSpace(string(head))
+	}
+	remote, err := sb.run(out.repoDir, "git", "config", "--get", "remote.origin.url")
+	if err != nil {
+		return "", err
+	}
+	if _, err := sb.run(out.repoDir, "git", "push", "--set-upstream", "origin", branch); err != nil {
+		return "", fmt.Errorf("failed to push %s: %w", branch, err)
+	}
+	return openPullRequest(strings.TrimSpace(string(remote)), pullRequest{
+		Title: title,
+		Body:  body,
+		Head:  branch,
+		Base:  base,
+	}, sb.Timeout)
+}
+
+func displayVersion(version string) string {
+	if version == "" {
+		return "latest"
+	}
+	return version
+}
+
+// readWrapperOptions recovers the generation options of an existing wrapper
+// from the module "this" block in its main.tf.
+func readWrapperOptions(dir string) (generateOptions, error) {
+	parser := hclparse.NewParser()
+	file, diags := parser.ParseHCLFile(filepath.Join(dir, "main.tf"))
+	if diags.HasErrors() {
+		return generateOptions{}, newParseError(diags, parser.Files())
+	}
+	for _, block := range file.Body.(*hclsyntax.Body).Blocks {
+		if block.Type != "module" || len(block.Labels) != 1 || block.Labels[0] != "this" {
+			continue
+		}
+		_, iterable := block.Body.Attributes["for_each"]
+		_, conditional := block.Body.Attributes["count"]
+		_, err := os.Stat(filepath.Join(dir, upstreamSnapshotDir))
+		opts := generateOptions{
+			Source:           syntaxAttrString(block.Body, "source"),
+			Version:          syntaxAttrString(block.Body, "version"),
+			Name:             filepath.Base(dir),
+			Iterable:         iterable,
+			Conditional:      conditional,
+			OutputDir:        filepath.Dir(dir),
+			SnapshotUpstream: err == nil,
+		}
+		// Options that can't be read back from main.tf come from the
+		// metadata; a forced iterable wrapper stays forced
+		if prev, err := readMetadata(dir); err == nil && prev != nil {
+			opts.ForceIterable = iterable && prev.Options.ForceIterable
+			opts.MergeLayers = prev.Options.MergeLayers
+			opts.Features = prev.Options.Features
+			opts.RegionCheck = prev.Options.RegionCheck
+			opts.InstancesDir = prev.Options.InstancesDir
+			opts.InstanceProviders = prev.Options.InstanceProviders
+			opts.FanOut = prev.Options.FanOut
+			opts.FanOutRole = prev.Options.FanOutRole
+			opts.ProviderMirror = prev.Options.ProviderMirror
+			opts.SourceOverrides = prev.Options.SourceOverrides
+			opts.Patches = prev.Options.Patches
+			// main.tf calls the override or the patched copy, the
+			// upstream source is recorded
+			if prev.SourceOverride != nil || len(prev.Options.Patches) > 0 {
+				opts.Source, opts.Version = prev.Options.Source, prev.Options.Version
+			}
+			opts.Envelope = prev.Options.Envelope
+			opts.Derived = prev.Options.Derived
+			opts.Annotate = prev.Options.Annotate
+			opts.Comments = prev.Options.Comments
+			opts.Accessor = prev.Options.Accessor
+			opts.Policy = prev.Options.Policy
+			opts.DefaultTags = prev.Options.DefaultTags
+			opts.ConfigFrom = prev.Options.ConfigFrom
+			opts.Format = prev.Options.Format
+			opts.Orchestrator = prev.Options.Orchestrator
+			opts.Pipeline = prev.Options.Pipeline
+			opts.PipelineTemplate = prev.Options.PipelineTemplate
+			opts.DriftSchedule = prev.Options.DriftSchedule
+			opts.Backend = prev.Options.Backend
+			opts.Backstage = prev.Options.Backstage
+			opts.FromExample = prev.Options.FromExample
+			opts.ExampleConfig = prev.Options.ExampleConfig
+			opts.Environments = prev.Options.Environments
+			opts.ConfigFormat = prev.Options.ConfigFormat
+			opts.GroupBy = prev.Options.GroupBy
+			opts.Sort = prev.Options.Sort
+			opts.SplitInputs = prev.Options.SplitInputs
+			opts.Exclude = prev.Options.Exclude
+			opts.AllowRawPassthrough = prev.Options.AllowRawPassthrough
+			opts.Renames = prev.Options.Renames
+			opts.Compat = prev.Options.Compat
+			opts.NoLegacyOutput = prev.Options.NoLegacyOutput
+			opts.OutputsContract = prev.Options.OutputsContract
+		}
+		return opts, nil
+	}
+	// A hand-written wrapper brought in with adopt-wrapper is regenerated
+	// from its metadata
+	if prev, err := readMetadata(dir); err == nil && prev != nil {
+		opts := prev.Options
+		opts.Name = filepath.Base(dir)
+		opts.OutputDir = filepath.Dir(dir)
+		return opts, nil
+	}
+	return generateOptions{}, fmt.Errorf("module \"this\" not found in %s", dir)
+}
+
+// planResourceChanges runs a speculative plan in dir and returns the planned
+// actions keyed by resource address.
+func planResourceChanges(sb sandbox, dir, binary string) (map[string]string, error) {
+	planFile := "tfwrapper.tfplan"
+	defer os.Remove(filepath.Join(dir, planFile))
+
+	steps := [][]string{
+		{"init", "-input=false", "-upgrade"},
+		{"plan", "-input=false", "-lock=false", "-out=" + planFile},
+	}
+	for _, step := range steps {
+		if _, err := sb.run(dir, binary, step...); err != nil {
+			return nil, err
+		}
+	}
+
+	out, err := sb.run(dir, binary, "show", "-json", planFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan struct {
+		ResourceChanges []struct {
+			Address string `json:"address"`
+			Change  struct {
+				Actions []string `json:"actions"`
+			} `json:"change"`
+		} `json:"resource_changes"`
+	}
+	if err := json.Unmarshal(out, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan JSON: %w", err)
+	}
+
+	changes := make(map[string]string)
+	for _, rc := range plan.ResourceChanges {
+		changes[rc.Address] = strings.Join(rc.Change.Actions, ",")
+	}
+	return changes, nil
+}
+
+// printPlanDiff summarizes resources whose planned actions differ between two
+// plans of the same root module.
+func printPlanDiff(before, after map[string]string) {
+	addresses := make(map[string]bool)
+	for addr := range before {
+		addresses[addr] = true
+	}
+	for addr := range after {
+		addresses[addr] = true
+	}
+	sorted := make([]string, 0, len(addresses))
+	for addr := range addresses {
+		sorted = append(sorted, addr)
+	}
+	sort.Strings(sorted)
+
+	changed := 0
+	for _, addr := range sorted {
+		b, inBefore := before[addr]
+		a, inAfter := after[addr]
+		switch {
+		case !inBefore:
+			fmt.Printf("  + %s (%s)\n", addr, a)
+		case !inAfter:
+			fmt.Printf("  - %s (was %s)\n", addr, b)
+		case a != b:
+			fmt.Printf("  ~ %s (%s -> %s)\n", addr, b, a)
+		default:
+			continue
+		}
+		changed++
+	}
+
+	if changed == 0 {
+		fmt.Println("Plan impact: no resource-level differences")
+	} else {
+		fmt.Printf("Plan impact: %d resource(s) differ\n", changed)
+	}
+}
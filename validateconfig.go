@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+func runValidateConfig(args []string) {
+	fs := flag.NewFlagSet("validate-config", flag.ExitOnError)
+	wrapperDir := fs.String("wrapper", ".", "Wrapper directory whose .tfwrapper.json describes the config")
+	instance := fs.Bool("instance", false, "Check the configs as instance files of an iterable wrapper's -instances-dir, each configuring the instance named after it")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		log.Fatal("Usage: tfwrapper validate-config [-wrapper <WRAPPER_DIR>] [-instance] <CONFIG.json>...")
+	}
+
+	prov, err := readMetadata(*wrapperDir)
+	if err != nil {
+		fatalError("Failed to read wrapper metadata", err)
+	}
+	if prov == nil {
+		log.Fatalf("Error: %s not found in %s", metadataFileName, *wrapperDir)
+	}
+	if *instance && !prov.Options.Iterable {
+		log.Fatalf("Error: -instance needs an iterable wrapper, %s isn't", *wrapperDir)
+	}
+	sb, err := sandboxFor(*wrapperDir)
+	if err != nil {
+		fatalError("Failed to load workspace", err)
+	}
+
+	failed := false
+	var migrate []string
+	for _, path := range fs.Args() {
+		problems, migrations, err := validateConfigFile(sb, *wrapperDir, prov, path, *instance)
+		if errors.Is(err, errOtherKind) {
+			fmt.Printf("%s: skipped, %v\n", path, err)
+			continue
+		}
+		if err != nil {
+			fatalError("Failed to validate "+path, err)
+		}
+		for _, problem := range problems {
+			fmt.Printf("%s: %s\n", path, problem)
+		}
+		for _, migration := range migrations {
+			fmt.Printf("%s: migrate: %s\n", path, migration)
+		}
+		if len(migrations) > 0 {
+			migrate = append(migrate, path)
+		}
+		if len(problems) > 0 {
+			failed = true
+		} else {
+			fmt.Printf("%s: ok\n", path)
+		}
+	}
+	// Configs still relying on the compat layer or former names work, but
+	// not for long
+	if len(migrate) > 0 {
+		fmt.Printf("%d of %d configs must migrate before the wrapper drops its compatibility: %s\n", len(migrate), fs.NArg(), strings.Join(migrate, ", "))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// validateConfigFile checks a JSON config against the wrapper's variable
+// contract and, when the wrapper has one, its policy. It also returns the
+// migrations the config needs, see configMigrations. An instance file is
+// checked as the config of the instance named after it.
+func validateConfigFile(sb sandbox, wrapperDir string, prov *provenance, path string, instance bool) (problems, migrations []string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var config map[string]any
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s as a JSON object: %w", path, err)
+	}
+	if err := otherKind(prov, config); err != nil {
+		return nil, nil, err
+	}
+	if instance {
+		var spec map[string]any
+		spec, problems, migrations = unwrapEnvelope(prov, config)
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		config = map[string]any{"instances": map[string]any{name: spec}}
+	}
+	contractProblems, contractMigrations := checkConfigContract(prov, config)
+	problems = append(problems, contractProblems...)
+	migrations = append(migrations, contractMigrations...)
+
+	policyPath := filepath.Join(wrapperDir, policyFileName)
+	if _, err := os.Stat(policyPath); err == nil {
+		// The policy sees an instance file the way the wrapper does
+		input := path
+		if instance {
+			if input, err = writeTempJSON(config); err != nil {
+				return nil, nil, err
+			}
+			defer os.Remove(input)
+		}
+		denials, err := evaluatePolicy(sb, policyPath, input)
+		if errors.Is(err, exec.ErrNotFound) {
+			fmt.Fprintf(os.Stderr, "Warning: opa not found, %s was not evaluated\n", policyPath)
+		} else if err != nil {
+			return nil, nil, err
+		}
+		problems = append(problems, denials...)
+	}
+	return problems, migrations, nil
+}
+
+// checkConfigContract reports unknown keys and missing required variables in
+// every instance of config, unwrapped from its envelope, after merging the
+// layers underneath them, and the migrations each instance needs.
+func checkConfigContract(prov *provenance, config map[string]any) (problems, migrations []string) {
+	opts := prov.Options
+	config, problems, migrations = unwrapEnvelope(prov, config)
+	deprecated := deprecatedKeys(opts)
+
+	layered := map[string]any{}
+	for _, layer := range opts.MergeLayers {
+		values, ok := config[layer].(map[string]any)
+		if config[layer] != nil && !ok {
+			problems = append(problems, fmt.Sprintf("%s must be an object", layer))
+		}
+		for k, v := range values {
+			layered[k] = v
+		}
+	}
+
+	instances := map[string]map[string]any{}
+	if opts.Iterable {
+		for _, key := range sortedKeys(config) {
+			if key != "instances" && !slices.Contains(opts.MergeLayers, key) && !(opts.DefaultTags && key == defaultTagsKey) {
+				problems = append(problems, fmt.Sprintf("unknown key %q, instances are configured under \"instances\"", key))
+			}
+		}
+		raw, _ := config["instances"].(map[string]any)
+		for name, value := range raw {
+			instance, ok := value.(map[string]any)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("instances.%s must be an object", name))
+				continue
+			}
+			instances["instances."+name] = instance
+		}
+	} else {
+		instance := map[string]any{}
+		for k, v := range config {
+			if !slices.Contains(opts.MergeLayers, k) {
+				instance[k] = v
+			}
+		}
+		if len(opts.FanOut) > 0 {
+			problems = append(problems, checkFanOutKeys(prov, instance)...)
+			delete(instance, fanOutTargetsKey)
+			delete(instance, fanOutOverridesKey)
+		}
+		instances[""] = instance
+	}
+
+	for _, name := range sortedKeys(instances) {
+		prefix := ""
+		if name != "" {
+			prefix = name + ": "
+		}
+		merged := map[string]any{}
+		for k, v := range layered {
+			merged[k] = v
+		}
+		for k, v := range instances[name] {
+			merged[k] = v
+		}
+
+		for _, key := range sortedKeys(merged) {
+			switch {
+			case opts.AllowRawPassthrough && key == rawSection:
+				section, _ := merged[key].(map[string]any)
+				for _, rawKey := range sortedKeys(section) {
+					if !slices.Contains(opts.Exclude, rawKey) {
+						problems = append(problems, fmt.Sprintf("%s%s.%s is not an excluded variable", prefix, rawSection, rawKey))
+					}
+				}
+			case opts.Conditional && key == conditionalKey:
+			case len(opts.InstanceProviders) > 0 && key == instanceProviderKey:
+				if set, _ := merged[key].(string); !slices.Contains(opts.InstanceProviders, set) {
+					problems = append(problems, fmt.Sprintf("%s%s must be one of %s", prefix, key, strings.Join(opts.InstanceProviders, ", ")))
+				}
+			case opts.DefaultTags && key == defaultTagsKey:
+				if _, ok := merged[key].(map[string]any); !ok {
+					problems = append(problems, fmt.Sprintf("%s%s must be an object", prefix, key))
+				}
+			case slices.Contains(opts.Exclude, key):
+				problems = append(problems, fmt.Sprintf("%s%s is excluded from the wrapper", prefix, key))
+			case deprecated[key] != "":
+			case prov.Derived != nil && slices.Contains(prov.Derived.Inputs, key):
+			default:
+				if _, ok := prov.Variables[key]; !ok {
+					problems = append(problems, fmt.Sprintf("%sunknown key %q", prefix, key))
+				}
+			}
+		}
+		for _, key := range sortedKeys(prov.Variables) {
+			if prov.Derived != nil && prov.Derived.Expressions[key] != "" {
+				continue
+			}
+			if _, ok := merged[key]; prov.Variables[key].Required && !ok && !setByFormerName(opts, key, merged) {
+				problems = append(problems, fmt.Sprintf("%s%s is required", prefix, key))
+			}
+		}
+		for _, migration := range configMigrations(prov, merged) {
+			migrations = append(migrations, prefix+migration)
+		}
+	}
+	return problems, migrations
+}
+
+// setByFormerName reports whether config sets the renamed variable name by
+// one of its former names.
+func setByFormerName(opts generateOptions, name string, config map[string]any) bool {
+	for _, old := range renamedFrom(opts, name) {
+		if _, ok := config[old]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTempJSON writes config to a temporary file for opa to read.
+func writeTempJSON(config map[string]any) (string, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "tfwrapper-config-*.json")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// evaluatePolicy runs opa against a config and returns the deny messages.
+func evaluatePolicy(sb sandbox, policyPath, configPath string) ([]string, error) {
+	policy, err := os.ReadFile(policyPath)
+	if err != nil {
+		return nil, err
+	}
+	pkg, err := policyPackage(string(policy))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", policyPath, err)
+	}
+	policyPath, err = filepath.Abs(policyPath)
+	if err != nil {
+		return nil, err
+	}
+	configPath, err = filepath.Abs(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := sb.run(filepath.Dir(policyPath), "opa", "eval", "--format", "json",
+		"--data", policyPath, "--input", configPath, "data."+pkg+".deny")
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Result []struct {
+			Expressions []struct {
+				Value []string `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse opa output: %w", err)
+	}
+	var denials []string
+	for _, r := range result.Result {
+		for _, e := range r.Expressions {
+			denials = append(denials, e.Value...)
+		}
+	}
+	return denials, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// versionPinFiles are the tfenv and tofuenv version files for each binary,
+// and the environment variable that selects the version in their shims.
+var versionPinFiles = map[string]struct{ file, env string }{
+	"terraform": {".terraform-version", "TFENV_TERRAFORM_VERSION"},
+	"tofu":      {".opentofu-version", "TOFUENV_TOFU_VERSION"},
+}
+
+// findVersionPin looks for one of the named version files from dir upwards,
+// like tfenv and tofuenv do, and returns its path and the version it pins.
+func findVersionPin(dir string, names ...string) (string, string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	for {
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			data, err := os.ReadFile(path)
+			if err == nil {
+				return path, strings.TrimSpace(string(data)), nil
+			} else if !os.IsNotExist(err) {
+				return "", "", err
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", "", nil
+		}
+		dir = parent
+	}
+}
+
+// withPinnedVersion returns a copy of sb that runs binary at the version
+// pinned for dir, when there is a pin for it. tfenv and tofuenv shims read
+// the version from the environment, since commands may run in temporary
+// directories outside the workspace. A pin for the other binary, or an
+// installed version that doesn't match an exact pin, is warned about.
+func (sb sandbox) withPinnedVersion(dir, binary string) sandbox {
+	pinFile, known := versionPinFiles[filepath.Base(binary)]
+	if !known {
+		return sb
+	}
+	// Either file is looked for, so a pin for the other binary is noticed
+	path, pin, err := findVersionPin(dir, pinFile.file, ".terraform-version", ".opentofu-version")
+	switch {
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "Warning: failed to read version pin: %v\n", err)
+		return sb
+	case path == "" || pin == "":
+		return sb
+	case filepath.Base(path) != pinFile.file:
+		fmt.Fprintf(os.Stderr, "Warning: %s pins a version for the other binary, but %s is used\n", path, binary)
+		return sb
+	}
+
+	sb.AllowedEnv = append(append([]string{}, sb.AllowedEnv...), "TFENV_*", "TOFUENV_*")
+	sb.ExtraEnv = append(append([]string{}, sb.ExtraEnv...), pinFile.env+"="+pin)
+
+	// Only exact versions can be checked; tfenv also accepts "latest",
+	// "min-required" and the like
+	want := strings.TrimPrefix(pin, "v")
+	if !semverTag.MatchString(want) {
+		return sb
+	}
+	if got, err := binaryVersion(sb, dir, binary); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to check the %s version pinned by %s: %v\n", binary, path, err)
+	} else if got != want {
+		fmt.Fprintf(os.Stderr, "Warning: %s pins %s %s, but %s is installed\n", path, binary, want, got)
+	}
+	return sb
+}
+
+// binaryVersion returns the version reported by terraform or tofu.
+func binaryVersion(sb sandbox, dir, binary string) (string, error) {
+	out, err := sb.run(dir, binary, "version", "-json")
+	if err != nil {
+		return "", err
+	}
+	var v struct {
+		Version string `json:"terraform_version"`
+	}
+	if err := json.Unmarshal(out, &v); err != nil {
+		return "", fmt.Errorf("failed to parse %s version: %w", binary, err)
+	}
+	return strings.TrimPrefix(v.Version, "v"), nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hashicorp/hcl/v2/gohcl"
+	"github.com/hashicorp/hcl/v2/hclparse"
+	"github.com/zclconf/go-cty/cty"
+)
+
+const (
+	workspaceFileName = "tfwrapper.hcl"
+	lockFileName      = "tfwrapper.lock.json"
+)
+
+// workspaceConfig is the decoded form of tfwrapper.hcl at the workspace root.
+type workspaceConfig struct {
+	Workspace *workspaceBlock `hcl:"workspace,block"`
+	Generate  *generateBlock  `hcl:"generate,block"`
+	Exec      *execBlock      `hcl:"exec,block"`
+	Commit    *commitBlock    `hcl:"commit,block"`
+}
+
+// generateBlock holds defaults applied to every wrapper generated inside the
+// workspace. Command line flags take precedence.
+type generateBlock struct {
+	Header         string   `hcl:"header,optional"`
+	Footer         string   `hcl:"footer,optional"`
+	Scan           string   `hcl:"scan,optional"`
+	FailOnFindings bool     `hcl:"fail_on_findings,optional"`
+	MergeLayers    []string `hcl:"merge_layers,optional"`
+	// Features are feature flags for every wrapper, see featureFlag
+	Features cty.Value `hcl:"features,optional"`
+
+	Pipeline         string `hcl:"pipeline,optional"`
+	PipelineTemplate string `hcl:"pipeline_template,optional"`
+	DriftSchedule    string `hcl:"drift_schedule,optional"`
+	Backend          string `hcl:"backend,optional"`
+
+	// SourceOverrides is a -source-overrides file, relative to the
+	// workspace root
+	SourceOverrides string `hcl:"source_overrides,optional"`
+}
+
+type workspaceBlock struct {
+	WrappersDir string `hcl:"wrappers_dir,optional"`
+	StacksDir   string `hcl:"stacks_dir,optional"`
+	SchemasDir  string `hcl:"schemas_dir,optional"`
+
+	// LockTimeout bounds how long we wait for other tfwrapper processes
+	// (e.g. parallel CI jobs) to release the lock file, as a Go duration.
+	LockTimeout string `hcl:"lock_timeout,optional"`
+
+	// AuditLog is the file, relative to the workspace root, every write
+	// and publish of a wrapper is recorded in, see auditEvent.
+	AuditLog string `hcl:"audit_log,optional"`
+}
+
+// workspace is a wrappers repository registered with tfwrapper scaffold-repo.
+type workspace struct {
+	Root        string
+	Config      workspaceConfig
+	LockTimeout time.Duration
+	Sandbox     sandbox
+}
+
+// lockFile records every wrapper generated inside a workspace, keyed by the
+// wrapper directory relative to the workspace root.
+type lockFile struct {
+	Wrappers map[string]lockEntry `json:"wrappers"`
+}
+
+type lockEntry struct {
+	Source   string `json:"source"`
+	Version  string `json:"version,omitempty"`
+	Commit   string `json:"commit,omitempty"`
+	Iterable bool   `json:"iterable,omitempty"`
+}
+
+// findWorkspace walks up from dir looking for tfwrapper.hcl. It returns nil
+// without an error when dir isn't inside a workspace.
+func findWorkspace(dir string) (*workspace, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, workspaceFileName)); err == nil {
+			return loadWorkspace(dir)
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, nil
+		}
+		dir = parent
+	}
+}
+
+// sandboxFor returns the sandbox settings of the workspace containing dir, or
+// the defaults outside a workspace.
+func sandboxFor(dir string) (sandbox, error) {
+	ws, err := findWorkspace(dir)
+	switch {
+	case err != nil:
+		return defaultSandbox(), err
+	case ws == nil:
+		return defaultSandbox().withCommitConfig(nil)
+	}
+	return ws.Sandbox, nil
+}
+
+func loadWorkspace(root string) (*workspace, error) {
+	path := filepath.Join(root, workspaceFileName)
+	parser := hclparse.NewParser()
+	file, diags := parser.ParseHCLFile(path)
+	if diags.HasErrors() {
+		return nil, newParseError(diags, parser.Files())
+	}
+
+	ws := &workspace{Root: root}
+	if diags := gohcl.DecodeBody(file.Body, nil, &ws.Config); diags.HasErrors() {
+		return nil, newParseError(diags, parser.Files())
+	}
+	if ws.Config.Workspace == nil {
+		ws.Config.Workspace = &workspaceBlock{}
+	}
+	if ws.Config.Generate == nil {
+		ws.Config.Generate = &generateBlock{}
+	}
+	if ws.Config.Workspace.WrappersDir == "" {
+		ws.Config.Workspace.WrappersDir = "wrappers"
+	}
+	if ws.Config.Workspace.StacksDir == "" {
+		ws.Config.Workspace.StacksDir = "stacks"
+	}
+	if ws.Config.Workspace.SchemasDir == "" {
+		ws.Config.Workspace.SchemasDir = "schemas"
+	}
+	ws.LockTimeout = defaultLockTimeout
+	if t := ws.Config.Workspace.LockTimeout; t != "" {
+		d, err := time.ParseDuration(t)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lock_timeout in %s: %w", path, err)
+		}
+		ws.LockTimeout = d
+	}
+	sb, err := newSandbox(ws.Config.Exec)
+	if err == nil {
+		sb, err = sb.withCommitConfig(ws.Config.Commit)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	ws.Sandbox = sb
+	return ws, nil
+}
+
+func (ws *workspace) lockPath() string {
+	return filepath.Join(ws.Root, lockFileName)
+}
+
+func (ws *workspace) readLock() (*lockFile, error) {
+	lock := &lockFile{Wrappers: make(map[string]lockEntry)}
+	src, err := os.ReadFile(ws.lockPath())
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(src, lock); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", ws.lockPath(), err)
+	}
+	if lock.Wrappers == nil {
+		lock.Wrappers = make(map[string]lockEntry)
+	}
+	return lock, nil
+}
+
+func (ws *workspace) writeLock(lock *lockFile) error {
+	data, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(ws.lockPath(), append(data, '\n'), 0644)
+}
+
+// updateLock applies fn to the lock file while holding an advisory lock, so
+// concurrent tfwrapper processes in the same checkout don't lose updates.
+func (ws *workspace) updateLock(fn func(lock *lockFile) error) error {
+	fl, err := acquireFileLock(ws.lockPath(), ws.LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer fl.release()
+
+	lock, err := ws.readLock()
+	if err != nil {
+		return err
+	}
+	if err := fn(lock); err != nil {
+		return err
+	}
+	return ws.writeLock(lock)
+}
+
+// recordWrapper adds or replaces the lock and index entries for the wrapper
+// in dir.
+func (ws *workspace) recordWrapper(dir string, entry lockEntry, prov *provenance) error {
+	key, err := ws.relPath(dir)
+	if err != nil {
+		return err
+	}
+	return ws.updateLock(func(lock *lockFile) error {
+		lock.Wrappers[key] = entry
+		index, err := ws.readIndex()
+		if err != nil {
+			return err
+		}
+		index.Wrappers[key] = newIndexEntry(prov)
+		return ws.writeIndex(index)
+	})
+}
+
+// relPath returns dir relative to the workspace root, using forward slashes so
+// lock files are portable between platforms.
+func (ws *workspace) relPath(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(ws.Root, abs)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
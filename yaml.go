@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zclconf/go-cty/cty"
+)
+
+// marshalYAML renders a known cty value as a YAML document. Only the subset
+// of YAML needed for config files is produced: block mappings and sequences,
+// double-quoted strings, numbers, booleans and null.
+func marshalYAML(val cty.Value) string {
+	var b strings.Builder
+	writeYAML(&b, val, 0)
+	return b.String()
+}
+
+func writeYAML(b *strings.Builder, val cty.Value, indent int) {
+	pad := strings.Repeat("  ", indent)
+	ty := val.Type()
+
+	switch {
+	case ty.IsObjectType() || ty.IsMapType():
+		if val.IsNull() || val.LengthInt() == 0 {
+			b.WriteString(pad + "{}\n")
+			return
+		}
+		elems := val.AsValueMap()
+		keys := make([]string, 0, len(elems))
+		for k := range elems {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			writeYAMLEntry(b, pad+yamlKey(k)+":", elems[k], indent)
+		}
+	case ty.IsListType() || ty.IsTupleType() || ty.IsSetType():
+		if val.IsNull() || val.LengthInt() == 0 {
+			b.WriteString(pad + "[]\n")
+			return
+		}
+		for it := val.ElementIterator(); it.Next(); {
+			_, elem := it.Element()
+			writeYAMLEntry(b, pad+"-", elem, indent)
+		}
+	default:
+		b.WriteString(pad + yamlScalar(val) + "\n")
+	}
+}
+
+// writeYAMLEntry writes a mapping key or sequence dash followed by elem,
+// inline for scalars and empty collections, nested otherwise.
+func writeYAMLEntry(b *strings.Builder, prefix string, elem cty.Value, indent int) {
+	ty := elem.Type()
+	isCollection := ty.IsObjectType() || ty.IsMapType() || ty.IsListType() || ty.IsTupleType() || ty.IsSetType()
+	if !isCollection || elem.IsNull() || elem.LengthInt() == 0 {
+		var inline strings.Builder
+		writeYAML(&inline, elem, 0)
+		b.WriteString(prefix + " " + inline.String())
+		return
+	}
+	b.WriteString(prefix + "\n")
+	writeYAML(b, elem, indent+1)
+}
+
+func yamlScalar(val cty.Value) string {
+	if val.IsNull() {
+		return "null"
+	}
+	switch val.Type() {
+	case cty.String:
+		return strconv.Quote(val.AsString())
+	case cty.Number:
+		return val.AsBigFloat().Text('f', -1)
+	case cty.Bool:
+		return fmt.Sprintf("%v", val.True())
+	default:
+		return "null"
+	}
+}
+
+// yamlKey quotes mapping keys that aren't plain identifiers.
+func yamlKey(k string) string {
+	for _, r := range k {
+		if !(r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return strconv.Quote(k)
+		}
+	}
+	if k == "" {
+		return `""`
+	}
+	return k
+}